@@ -10,6 +10,10 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rancher/k3s/pkg/cli/cmds"
+	clidatadir "github.com/rancher/k3s/pkg/cli/datadir"
+	clidiskusage "github.com/rancher/k3s/pkg/cli/diskusage"
+	"github.com/rancher/k3s/pkg/cli/generate"
+	"github.com/rancher/k3s/pkg/configfilearg"
 	"github.com/rancher/k3s/pkg/data"
 	"github.com/rancher/k3s/pkg/datadir"
 	"github.com/rancher/k3s/pkg/untar"
@@ -29,9 +33,25 @@ func main() {
 		cmds.NewKubectlCommand(externalCLIAction("kubectl")),
 		cmds.NewCRICTL(externalCLIAction("crictl")),
 		cmds.NewCtrCommand(externalCLIAction("ctr")),
+		cmds.NewGenerateCommand(generate.Run),
+		cmds.NewNodeCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewClusterCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewTunnelCommand(wrap("k3s-server", os.Args)),
+		cmds.NewCheckCommand(wrap("k3s-server", os.Args)),
+		cmds.NewRestartLockCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewApplyCommand(wrap("k3s-server", os.Args)),
+		cmds.NewImageCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewEtcdSnapshotCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewDatastoreCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		// run from the wrapper, as the wrapped commands run from the data dir
+		cmds.NewDataDirCommand(clidatadir.Migrate),
+		cmds.NewDiskUsageCommand(clidiskusage.Run),
+		cmds.NewSecretsEncryptCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewCertCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
+		cmds.NewTokenCommand(wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args), wrap("k3s-server", os.Args)),
 	}
 
-	err := app.Run(os.Args)
+	err := app.Run(configfilearg.MustParse(os.Args))
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -7,11 +7,26 @@ import (
 	"github.com/docker/docker/pkg/reexec"
 	crictl2 "github.com/kubernetes-sigs/cri-tools/cmd/crictl"
 	"github.com/rancher/k3s/pkg/cli/agent"
+	"github.com/rancher/k3s/pkg/cli/apply"
+	"github.com/rancher/k3s/pkg/cli/cert"
+	"github.com/rancher/k3s/pkg/cli/check"
+	"github.com/rancher/k3s/pkg/cli/cluster"
 	"github.com/rancher/k3s/pkg/cli/cmds"
 	"github.com/rancher/k3s/pkg/cli/crictl"
 	"github.com/rancher/k3s/pkg/cli/ctr"
+	"github.com/rancher/k3s/pkg/cli/datadir"
+	"github.com/rancher/k3s/pkg/cli/datastore"
+	"github.com/rancher/k3s/pkg/cli/diskusage"
+	"github.com/rancher/k3s/pkg/cli/etcdsnapshot"
+	"github.com/rancher/k3s/pkg/cli/generate"
+	"github.com/rancher/k3s/pkg/cli/image"
 	"github.com/rancher/k3s/pkg/cli/kubectl"
+	"github.com/rancher/k3s/pkg/cli/node"
+	"github.com/rancher/k3s/pkg/cli/secretsencrypt"
 	"github.com/rancher/k3s/pkg/cli/server"
+	"github.com/rancher/k3s/pkg/cli/token"
+	"github.com/rancher/k3s/pkg/cli/tunnel"
+	"github.com/rancher/k3s/pkg/configfilearg"
 	"github.com/rancher/k3s/pkg/containerd"
 	ctr2 "github.com/rancher/k3s/pkg/ctr"
 	kubectl2 "github.com/rancher/k3s/pkg/kubectl"
@@ -40,10 +55,25 @@ func main() {
 		cmds.NewAgentCommand(agent.Run),
 		cmds.NewKubectlCommand(kubectl.Run),
 		cmds.NewCRICTL(crictl.Run),
+		cmds.NewGenerateCommand(generate.Run),
+		cmds.NewNodeCommand(node.Checkpoint, node.Evacuate, node.Logs, node.Maintenance),
+		cmds.NewClusterCommand(cluster.Hibernate, cluster.Resume),
+		cmds.NewTunnelCommand(tunnel.PortForward),
+		cmds.NewCheckCommand(check.Network),
+		cmds.NewRestartLockCommand(cluster.AcquireRestartLock, cluster.ReleaseRestartLock, cluster.RestartLockStatus),
+		cmds.NewApplyCommand(apply.Run),
+		cmds.NewImageCommand(image.List, image.Pull, image.Remove, image.Tag, image.Save, image.Load),
+		cmds.NewEtcdSnapshotCommand(etcdsnapshot.Save, etcdsnapshot.List, etcdsnapshot.Prune, etcdsnapshot.Delete),
+		cmds.NewDatastoreCommand(datastore.Migrate, datastore.Relocate),
+		cmds.NewDataDirCommand(datadir.Migrate),
+		cmds.NewDiskUsageCommand(diskusage.Run),
+		cmds.NewSecretsEncryptCommand(secretsencrypt.Status, secretsencrypt.Prepare, secretsencrypt.Rotate, secretsencrypt.Reencrypt, secretsencrypt.DropOldKeys),
+		cmds.NewCertCommand(cert.Rotate, cert.RotateCA),
+		cmds.NewTokenCommand(token.Create, token.List, token.Delete, token.Rotate),
 		cmds.NewCtrCommand(ctr.Run),
 	}
 
-	err := app.Run(os.Args)
+	err := app.Run(configfilearg.MustParse(os.Args))
 	if err != nil {
 		logrus.Fatal(err)
 	}
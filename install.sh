@@ -61,6 +61,13 @@ set -e
 #   - INSTALL_K3S_TYPE
 #     Type of systemd service to create, will default from the k3s exec command
 #     if not specified.
+#
+#   - INSTALL_K3S_RESTART_LOCK
+#     If set to true a server gets a ${SYSTEM_NAME}-restart.sh script, which
+#     waits for the restart lock before restarting the server, so that only one
+#     server restarts at a time and only while the datastore is healthy. It
+#     fails without restarting if the lock is not acquired in time. The server
+#     releases the lock once it is back.
 
 GITHUB_URL=https://github.com/rancher/k3s/releases
 
@@ -160,6 +167,7 @@ setup_env() {
     SERVICE_K3S=${SYSTEM_NAME}.service
     UNINSTALL_K3S_SH=${SYSTEM_NAME}-uninstall.sh
     KILLALL_K3S_SH=k3s-killall.sh
+    RESTART_K3S_SH=${SYSTEM_NAME}-restart.sh
 
     # --- use sudo if we are not already root ---
     SUDO=sudo
@@ -459,6 +467,7 @@ fi
 
 rm -f ${FILE_K3S_SERVICE}
 rm -f ${FILE_K3S_ENV}
+rm -f ${BIN_DIR}/${RESTART_K3S_SH}
 
 remove_uninstall() {
     rm -f ${BIN_DIR}/${UNINSTALL_K3S_SH}
@@ -486,6 +495,34 @@ EOF
     $SUDO chown root:root ${BIN_DIR}/${UNINSTALL_K3S_SH}
 }
 
+# --- create restart script that waits for the restart lock ---
+create_restart() {
+    [ "${INSTALL_K3S_BIN_DIR_READ_ONLY}" = "true" ] && return
+    [ "${INSTALL_K3S_RESTART_LOCK}" = "true" ] && [ "${CMD_K3S}" = "server" ] || return 0
+    if [ "${HAS_SYSTEMD}" = "true" ]; then
+        RESTART_CMD="systemctl restart ${SYSTEM_NAME}"
+    else
+        RESTART_CMD="rc-service ${SYSTEM_NAME} restart"
+    fi
+    info "Creating restart script ${BIN_DIR}/${RESTART_K3S_SH}"
+    $SUDO tee ${BIN_DIR}/${RESTART_K3S_SH} >/dev/null << EOF
+#!/bin/sh
+set -e
+[ \`id -u\` = 0 ] || exec sudo \$0 \$@
+
+# --- fail without restarting if the lock is not acquired in time ---
+${BIN_DIR}/k3s restart-lock acquire --wait 5m
+
+# --- the server releases the lock once it is back, release it here if it is not restarted ---
+if ! ${RESTART_CMD}; then
+    ${BIN_DIR}/k3s restart-lock release
+    exit 1
+fi
+EOF
+    $SUDO chmod 755 ${BIN_DIR}/${RESTART_K3S_SH}
+    $SUDO chown root:root ${BIN_DIR}/${RESTART_K3S_SH}
+}
+
 # --- disable current service if loaded --
 systemd_disable() {
     $SUDO rm -f /etc/systemd/system/${SERVICE_K3S} || true
@@ -514,11 +551,8 @@ After=network-online.target
 [Service]
 Type=${SYSTEMD_TYPE}
 EnvironmentFile=${FILE_K3S_ENV}
-ExecStartPre=-/sbin/modprobe br_netfilter
-ExecStartPre=-/sbin/modprobe overlay
 ExecStart=${BIN_DIR}/k3s \\
     ${CMD_K3S_EXEC}
-
 KillMode=process
 Delegate=yes
 LimitNOFILE=infinity
@@ -639,6 +673,7 @@ eval set -- $(escape "${INSTALL_K3S_EXEC}") $(quote "$@")
     create_symlinks
     create_killall
     create_uninstall
+    create_restart
     systemd_disable
     create_env_file
     create_service_file
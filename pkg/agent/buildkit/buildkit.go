@@ -0,0 +1,88 @@
+package buildkit
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/natefinch/lumberjack"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// namespace is the containerd namespace used by the CRI plugin, building
+	// into it makes images available to pods without pushing them
+	namespace = "k8s.io"
+
+	restartDelay = 5 * time.Second
+)
+
+// Run starts buildkitd with a worker using the embedded containerd, restarting
+// it if it exits.
+func Run(ctx context.Context, cfg *config.Node) error {
+	if cfg.Docker || cfg.ContainerRuntimeEndpoint != "" {
+		return errors.New("--enable-buildkit requires the embedded containerd")
+	}
+
+	path, err := exec.LookPath("buildkitd")
+	if err != nil {
+		return errors.Wrap(err, "--enable-buildkit requires buildkitd")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Buildkit.Address), 0711); err != nil {
+		return err
+	}
+
+	args := []string{
+		"--addr", "unix://" + cfg.Buildkit.Address,
+		"--root", cfg.Buildkit.Root,
+		"--oci-worker=false",
+		"--containerd-worker=true",
+		"--containerd-worker-addr", cfg.Containerd.Address,
+		"--containerd-worker-namespace", namespace,
+		"--containerd-worker-snapshotter", cfg.Containerd.Snapshotter,
+	}
+	if cfg.Containerd.SocketGID != "" {
+		args = append(args, "--group", cfg.Containerd.SocketGID)
+	}
+
+	out := io.Writer(os.Stderr)
+	if cfg.Buildkit.Log != "" {
+		logrus.Infof("Logging buildkitd to %s", cfg.Buildkit.Log)
+		out = &lumberjack.Logger{
+			Filename:   cfg.Buildkit.Log,
+			MaxSize:    50,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+		}
+	}
+
+	go func() {
+		for {
+			logrus.Infof("Running buildkitd %s", config.ArgString(args))
+			cmd := exec.CommandContext(ctx, path, args...)
+			cmd.Stdout = out
+			cmd.Stderr = out
+			cmd.SysProcAttr = &syscall.SysProcAttr{
+				Pdeathsig: syscall.SIGKILL,
+			}
+			err := cmd.Run()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(restartDelay):
+			}
+			logrus.Errorf("buildkitd exited, restarting: %v", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package clock
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/clientaccess"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/node"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	serverTimeHeader = "K3s-Server-Time"
+	interval         = time.Minute
+
+	// offsets are reported with this precision, so that jitter in the
+	// measurement does not cause an update to the node on every check
+	resolution = 100 * time.Millisecond
+)
+
+// Run periodically measures the offset of the local clock from the server clock
+// and reports it as an annotation on this node.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	// the node identity of the kubelet may only modify this node
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigKubelet)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	r := &reporter{
+		client:     client,
+		httpClient: clientaccess.GetHTTPClient(nodeConfig.CACerts),
+		url:        fmt.Sprintf("https://%s/ping", nodeConfig.ServerAddress),
+		nodeName:   nodeConfig.AgentConfig.NodeName,
+	}
+
+	go func() {
+		for {
+			if err := r.report(); err != nil {
+				logrus.Debugf("Failed to report clock offset: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return nil
+}
+
+type reporter struct {
+	client     kubernetes.Interface
+	httpClient *http.Client
+	url        string
+	nodeName   string
+	reported   string
+}
+
+func (r *reporter) report() error {
+	offset, err := r.measure()
+	if err != nil {
+		return err
+	}
+
+	value := offset.Round(resolution).String()
+	if value == r.reported {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, node.ClockOffsetAnnotation, value)
+	if _, err := r.client.CoreV1().Nodes().Patch(r.nodeName, types.MergePatchType, []byte(patch)); err != nil {
+		return err
+	}
+
+	if offset > node.ClockSkewThreshold || offset < -node.ClockSkewThreshold {
+		logrus.Warnf("Node clock is offset %s from the server clock", value)
+	}
+	r.reported = value
+	return nil
+}
+
+// measure returns how far the local clock is ahead of the server clock, assuming
+// the server read its clock halfway through the round trip.
+func (r *reporter) measure() (time.Duration, error) {
+	start := time.Now()
+	resp, err := r.httpClient.Get(r.url)
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", r.url, resp.Status)
+	}
+
+	serverTime, err := time.Parse(time.RFC3339Nano, resp.Header.Get(serverTimeHeader))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s header from %s", serverTimeHeader, r.url)
+	}
+
+	local := start.Add(end.Sub(start) / 2)
+	return local.Sub(serverTime), nil
+}
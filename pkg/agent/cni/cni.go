@@ -0,0 +1,161 @@
+package cni
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/rancher/k3s/pkg/agent/util"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// BinDir and ConfDir are where CNI plugins install themselves by
+	// default, and where the container runtime looks for them without
+	// the embedded flannel.
+	BinDir  = "/opt/cni/bin"
+	ConfDir = "/etc/cni/net.d"
+
+	// exampleConf is a minimal network for the pod CIDR of the node, using
+	// the reference bridge, host-local and portmap plugins.
+	exampleConf = `{
+  "name": "example",
+  "cniVersion": "0.3.1",
+  "plugins": [
+    {
+      "type": "bridge",
+      "bridge": "cni0",
+      "isGateway": true,
+      "ipMasq": true,
+      "ipam": {
+        "type": "host-local",
+        "ranges": [[{"subnet": "%POD_CIDR%"}]],
+        "routes": [{"dst": "0.0.0.0/0"}]
+      }
+    },
+    {
+      "type": "portmap",
+      "capabilities": {"portMappings": true}
+    }
+  ]
+}
+`
+)
+
+// Run waits for an external CNI plugin when the embedded flannel is disabled.
+// Once the node has a pod CIDR it writes an example network config for it,
+// then reports whether the network config the container runtime uses and
+// the plugins it needs are installed. The kubelet keeps the node NotReady
+// until they are.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	confDir := nodeConfig.AgentConfig.CNIConfDir
+	binDir := nodeConfig.AgentConfig.CNIBinDir
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return err
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigNode)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		podCIDR, err := waitForPodCIDR(ctx, client, nodeConfig.AgentConfig.NodeName)
+		if err != nil {
+			return
+		}
+		if err := util.WriteFile(nodeConfig.CNIExample, strings.Replace(exampleConf, "%POD_CIDR%", podCIDR, -1)); err != nil {
+			logrus.Warnf("Failed to write the example CNI config: %v", err)
+		}
+		logrus.Infof("Flannel is disabled, a CNI plugin must install its network config in %s and its plugins in %s, see %s for an example", confDir, binDir, nodeConfig.CNIExample)
+
+		var reported string
+		for {
+			status := "ready"
+			network, err := checkNetwork(confDir, binDir)
+			if err != nil {
+				status = err.Error()
+			}
+			if status != reported {
+				if err != nil {
+					logrus.Warnf("CNI is not ready: %v", err)
+				} else {
+					logrus.Infof("CNI network %s is ready", network)
+				}
+				reported = status
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func waitForPodCIDR(ctx context.Context, client kubernetes.Interface, nodeName string) (string, error) {
+	for {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err == nil && node.Spec.PodCIDR != "" {
+			return node.Spec.PodCIDR, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// checkNetwork loads the network config the container runtime would use, the
+// first in lexical order, and checks that its plugins are installed.
+func checkNetwork(confDir, binDir string) (string, error) {
+	files, err := libcni.ConfFiles(confDir, []string{".conf", ".conflist", ".json"})
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no network config in %s", confDir)
+	}
+	sort.Strings(files)
+
+	var list *libcni.NetworkConfigList
+	if filepath.Ext(files[0]) == ".conflist" {
+		list, err = libcni.ConfListFromFile(files[0])
+	} else {
+		var conf *libcni.NetworkConfig
+		if conf, err = libcni.ConfFromFile(files[0]); err == nil {
+			list, err = libcni.ConfListFromConf(conf)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid network config %s: %v", files[0], err)
+	}
+
+	var missing []string
+	for _, plugin := range list.Plugins {
+		if _, err := os.Stat(filepath.Join(binDir, plugin.Network.Type)); err != nil {
+			missing = append(missing, plugin.Network.Type)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("network %s from %s needs the plugins %s, which are missing from %s", list.Name, files[0], strings.Join(missing, ", "), binDir)
+	}
+	return list.Name, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/pem"
@@ -14,16 +15,21 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/agent/cni"
+	"github.com/rancher/k3s/pkg/agent/flannel"
 	"github.com/rancher/k3s/pkg/cli/cmds"
 	"github.com/rancher/k3s/pkg/clientaccess"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/k3s/pkg/images"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/apimachinery/pkg/util/net"
@@ -31,6 +37,18 @@ import (
 	"k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
+// defaultPauseImage matches the sandbox image containerd uses by default
+const defaultPauseImage = "k8s.gcr.io/pause:3.1"
+
+// nodeIDSources are tried in order; the SMBIOS UUID survives re-imaging, machine-id does not
+var nodeIDSources = []string{"/sys/class/dmi/id/product_uuid", "/etc/machine-id"}
+
+// nodeIDFile holds the random secret a machine started with --with-node-id
+// reclaims its node with. It is kept outside the data dir so that it can be
+// provisioned along with the machine, re-imaging does not preserve it and a
+// new secret cannot reclaim the node.
+const nodeIDFile = "/etc/rancher/node/node-id"
+
 func Get(ctx context.Context, agent cmds.Agent) *config.Node {
 	for {
 		agentConfig, err := get(&agent)
@@ -59,7 +77,7 @@ func Request(path string, info *clientaccess.Info, requester HTTPRequester) ([]b
 	return requester(u.String(), clientaccess.GetHTTPClient(info.CACerts), username, password)
 }
 
-func getNodeNamedCrt(nodeName, nodePasswordFile string) HTTPRequester {
+func getNodeNamedCrt(nodeName, nodeID, nodePasswordFile string) HTTPRequester {
 	return func(u string, client *http.Client, username, password string) ([]byte, error) {
 		req, err := http.NewRequest(http.MethodGet, u, nil)
 		if err != nil {
@@ -71,6 +89,9 @@ func getNodeNamedCrt(nodeName, nodePasswordFile string) HTTPRequester {
 		}
 
 		req.Header.Set("K3s-Node-Name", nodeName)
+		if nodeID != "" {
+			req.Header.Set("K3s-Node-ID", nodeID)
+		}
 		nodePassword, err := ensureNodePassword(nodePasswordFile)
 		if err != nil {
 			return nil, err
@@ -109,8 +130,27 @@ func ensureNodePassword(nodePasswordFile string) (string, error) {
 	return nodePassword, ioutil.WriteFile(nodePasswordFile, []byte(nodePassword+"\n"), 0600)
 }
 
-func getServingCert(nodeName, servingCertFile, servingKeyFile, nodePasswordFile string, info *clientaccess.Info) (*tls.Certificate, error) {
-	servingCert, err := Request("/v1-k3s/serving-kubelet.crt", info, getNodeNamedCrt(nodeName, nodePasswordFile))
+// getMachineID returns a stable id for this machine, used only to name the
+// node. The source is hashed so the raw hardware UUID is never sent to the
+// server.
+func getMachineID() (string, error) {
+	for _, source := range nodeIDSources {
+		b, err := ioutil.ReadFile(source)
+		if err != nil {
+			continue
+		}
+		id := strings.ToLower(strings.TrimSpace(string(b)))
+		if id == "" {
+			continue
+		}
+		digest := sha256.Sum256([]byte(id))
+		return hex.EncodeToString(digest[:]), nil
+	}
+	return "", fmt.Errorf("unable to determine node id from %s", strings.Join(nodeIDSources, ", "))
+}
+
+func getServingCert(nodeName, nodeID, servingCertFile, servingKeyFile, nodePasswordFile string, info *clientaccess.Info) (*tls.Certificate, error) {
+	servingCert, err := Request("/v1-k3s/serving-kubelet.crt", info, getNodeNamedCrt(nodeName, nodeID, nodePasswordFile))
 	if err != nil {
 		return nil, err
 	}
@@ -145,9 +185,9 @@ func getHostFile(filename string, info *clientaccess.Info) error {
 	return nil
 }
 
-func getNodeNamedHostFile(filename, nodeName, nodePasswordFile string, info *clientaccess.Info) error {
+func getNodeNamedHostFile(filename, nodeName, nodeID, nodePasswordFile string, info *clientaccess.Info) error {
 	basename := filepath.Base(filename)
-	fileBytes, err := Request("/v1-k3s/"+basename, info, getNodeNamedCrt(nodeName, nodePasswordFile))
+	fileBytes, err := Request("/v1-k3s/"+basename, info, getNodeNamedCrt(nodeName, nodeID, nodePasswordFile))
 	if err != nil {
 		return err
 	}
@@ -266,13 +306,41 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 		return nil, err
 	}
 
-	hostLocal, err := exec.LookPath("host-local")
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to find host-local")
+	var nodeID string
+	if envInfo.WithNodeID {
+		machineID, err := getMachineID()
+		if err != nil {
+			return nil, err
+		}
+		nodeName += "-" + machineID[:8]
+		// the id proving the machine owns the node is a random secret, host
+		// identifiers are readable by anyone on the machine
+		if err := os.MkdirAll(filepath.Dir(nodeIDFile), 0700); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(nodeIDFile); os.IsNotExist(err) {
+			logrus.Warnf("Generating a new node id in %s. If node %s was registered before, it cannot be reclaimed with the new id, "+
+				"restore the node id file the machine registered with, or delete the node and its password from the server", nodeIDFile, nodeName)
+		}
+		nodeID, err = ensureNodePassword(nodeIDFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// an external CNI plugin installs its own binaries, only flannel needs
+	// the plugins shipped with k3s
+	noFlannel := envInfo.NoFlannel || controlConfig.FlannelBackend == flannel.NoneBackend
+	var hostLocal string
+	if !noFlannel {
+		hostLocal, err = exec.LookPath("host-local")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find host-local")
+		}
 	}
 
 	var flannelIface *sysnet.Interface
-	if !envInfo.NoFlannel && len(envInfo.FlannelIface) > 0 {
+	if !noFlannel && len(envInfo.FlannelIface) > 0 {
 		flannelIface, err = sysnet.InterfaceByName(envInfo.FlannelIface)
 		if err != nil {
 			return nil, errors.Wrapf(err, "unable to find interface")
@@ -292,7 +360,7 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 	servingKubeletCert := filepath.Join(envInfo.DataDir, "serving-kubelet.crt")
 	servingKubeletKey := filepath.Join(envInfo.DataDir, "serving-kubelet.key")
 	nodePasswordFile := filepath.Join(envInfo.DataDir, "node-password.txt")
-	servingCert, err := getServingCert(nodeName, servingKubeletCert, servingKubeletKey, nodePasswordFile, info)
+	servingCert, err := getServingCert(nodeName, nodeID, servingKubeletCert, servingKubeletKey, nodePasswordFile, info)
 	if err != nil {
 		return nil, err
 	}
@@ -303,7 +371,7 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 	}
 
 	clientKubeletCert := filepath.Join(envInfo.DataDir, "client-kubelet.crt")
-	if err := getNodeNamedHostFile(clientKubeletCert, nodeName, nodePasswordFile, info); err != nil {
+	if err := getNodeNamedHostFile(clientKubeletCert, nodeName, nodeID, nodePasswordFile, info); err != nil {
 		return nil, err
 	}
 
@@ -334,12 +402,14 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 
 	nodeConfig := &config.Node{
 		Docker:                   envInfo.Docker,
-		NoFlannel:                envInfo.NoFlannel,
+		NoFlannel:                noFlannel,
 		ContainerRuntimeEndpoint: envInfo.ContainerRuntimeEndpoint,
 	}
 	nodeConfig.FlannelIface = flannelIface
+	nodeConfig.FlannelMTU = envInfo.FlannelMTU
 	nodeConfig.LocalAddress = localAddress(controlConfig)
 	nodeConfig.Images = filepath.Join(envInfo.DataDir, "images")
+	nodeConfig.VerifyImages = envInfo.VerifyImages
 	nodeConfig.AgentConfig.NodeIP = nodeIP
 	nodeConfig.AgentConfig.NodeName = nodeName
 	nodeConfig.AgentConfig.ServingKubeletCert = servingKubeletCert
@@ -347,29 +417,87 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 	nodeConfig.AgentConfig.ClusterDNS = controlConfig.ClusterDNS
 	nodeConfig.AgentConfig.ClusterDomain = controlConfig.ClusterDomain
 	nodeConfig.AgentConfig.ResolvConf = locateOrGenerateResolvConf(envInfo)
+	nodeConfig.AutoReboot = envInfo.AutoReboot
+	nodeConfig.Firewalld = envInfo.Firewalld
+	nodeConfig.HealConfigDrift = envInfo.HealConfigDrift
+	nodeConfig.LogFile = envInfo.LogFile
+	nodeConfig.DataDir = filepath.Dir(envInfo.DataDir)
+	nodeConfig.SnapshotDir = envInfo.SnapshotDir
+	nodeConfig.DiskUsageThreshold = envInfo.DiskUsageThreshold
+	if envInfo.LocalResolver {
+		// the local resolver runs in the host network namespace, so loopback upstreams are usable
+		nodeConfig.LocalResolver = true
+		nodeConfig.FallbackResolvConf = nodeConfig.AgentConfig.ResolvConf
+		nodeConfig.UpstreamResolvConf = envInfo.ResolvConf
+		if nodeConfig.UpstreamResolvConf == "" {
+			nodeConfig.UpstreamResolvConf = "/etc/resolv.conf"
+		}
+		nodeConfig.AgentConfig.ResolvConf = filepath.Join(envInfo.DataDir, "etc", "resolv.conf")
+	}
 	nodeConfig.AgentConfig.ClientCA = clientCAFile
 	nodeConfig.AgentConfig.ListenAddress = "0.0.0.0"
 	nodeConfig.AgentConfig.KubeConfigNode = kubeconfigNode
 	nodeConfig.AgentConfig.KubeConfigKubelet = kubeconfigKubelet
 	nodeConfig.AgentConfig.KubeConfigKubeProxy = kubeconfigKubeproxy
-	nodeConfig.AgentConfig.RootDir = filepath.Join(envInfo.DataDir, "kubelet")
+	nodeConfig.AgentConfig.RootDir, err = realDir(envInfo.KubeletRootDir, filepath.Join(envInfo.DataDir, "kubelet"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --kubelet-root-dir")
+	}
 	nodeConfig.AgentConfig.PauseImage = envInfo.PauseImage
+	if nodeConfig.AgentConfig.PauseImage == "" {
+		nodeConfig.AgentConfig.PauseImage = images.Reference(envInfo.SystemDefaultRegistry, defaultPauseImage)
+	}
+	nodeConfig.AgentConfig.ContainerLogMaxSize = envInfo.ContainerLogMaxSize
+	nodeConfig.AgentConfig.ContainerLogMaxFiles = envInfo.ContainerLogMaxFiles
+	nodeConfig.LogForwardURL = envInfo.LogForwardURL
 	nodeConfig.CACerts = info.CACerts
 	nodeConfig.Containerd.Config = filepath.Join(envInfo.DataDir, "etc/containerd/config.toml")
-	nodeConfig.Containerd.Root = filepath.Join(envInfo.DataDir, "containerd")
+	nodeConfig.Containerd.Root, err = realDir(envInfo.ContainerdRoot, filepath.Join(envInfo.DataDir, "containerd"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --containerd-root")
+	}
 	nodeConfig.Containerd.Opt = filepath.Join(envInfo.DataDir, "containerd")
 	if !envInfo.Debug {
 		nodeConfig.Containerd.Log = filepath.Join(envInfo.DataDir, "containerd/containerd.log")
 	}
-	nodeConfig.Containerd.State = "/run/k3s/containerd"
-	nodeConfig.Containerd.Address = filepath.Join(nodeConfig.Containerd.State, "containerd.sock")
+	nodeConfig.Containerd.State, err = realDir(envInfo.ContainerdState, "/run/k3s/containerd")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --containerd-state")
+	}
+	// the socket stays where k3s ctr and crictl look for it by default
+	nodeConfig.Containerd.Address = "/run/k3s/containerd/containerd.sock"
 	nodeConfig.Containerd.Template = filepath.Join(envInfo.DataDir, "etc/containerd/config.toml.tmpl")
+	nodeConfig.Containerd.Registry = envInfo.PrivateRegistry
+	nodeConfig.Containerd.Snapshotter = envInfo.Snapshotter
+	if envInfo.EnableBuildkit {
+		nodeConfig.Buildkit.Enabled = true
+		nodeConfig.Buildkit.Address = "/run/k3s/buildkit/buildkitd.sock"
+		nodeConfig.Buildkit.Root = filepath.Join(envInfo.DataDir, "buildkit")
+		if !envInfo.Debug {
+			nodeConfig.Buildkit.Log = filepath.Join(envInfo.DataDir, "buildkit/buildkitd.log")
+		}
+	}
+	if envInfo.CRISocketGroup != "" {
+		gid, err := lookupGroup(envInfo.CRISocketGroup)
+		if err != nil {
+			return nil, err
+		}
+		nodeConfig.Containerd.SocketGID = gid
+	}
+	nodeConfig.TunnelAddresses, err = tunnelAddresses(envInfo.TunnelPorts)
+	if err != nil {
+		return nil, err
+	}
 	nodeConfig.ServerAddress = serverURLParsed.Host
 	nodeConfig.Certificate = servingCert
 	if !nodeConfig.NoFlannel {
 		nodeConfig.FlannelConf = filepath.Join(envInfo.DataDir, "etc/flannel/net-conf.json")
 		nodeConfig.AgentConfig.CNIBinDir = filepath.Dir(hostLocal)
 		nodeConfig.AgentConfig.CNIConfDir = filepath.Join(envInfo.DataDir, "etc/cni/net.d")
+	} else {
+		nodeConfig.AgentConfig.CNIBinDir = cni.BinDir
+		nodeConfig.AgentConfig.CNIConfDir = cni.ConfDir
+		nodeConfig.CNIExample = filepath.Join(envInfo.DataDir, "etc/cni/10-example.conflist.example")
 	}
 	if !nodeConfig.Docker && nodeConfig.ContainerRuntimeEndpoint == "" {
 		nodeConfig.AgentConfig.RuntimeSocket = "unix://" + nodeConfig.Containerd.Address
@@ -379,12 +507,34 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 	if controlConfig.ClusterIPRange != nil {
 		nodeConfig.AgentConfig.ClusterCIDR = *controlConfig.ClusterIPRange
 	}
+	if controlConfig.ServiceIPRange != nil {
+		nodeConfig.AgentConfig.ServiceCIDR = *controlConfig.ServiceIPRange
+	}
+	nodeConfig.FlannelBackend = controlConfig.FlannelBackend
+	nodeConfig.Multus = controlConfig.Multus
+	nodeConfig.DisableNetworkPolicy = controlConfig.DisableNetworkPolicy
+	if controlConfig.FlannelExternalIP && !nodeConfig.NoFlannel {
+		if envInfo.NodeExternalIP == "" {
+			return nil, fmt.Errorf("the server uses --flannel-external-ip, --node-external-ip must be set on every node")
+		}
+		nodeConfig.FlannelExternalIP = sysnet.ParseIP(envInfo.NodeExternalIP).To4()
+		if nodeConfig.FlannelExternalIP == nil {
+			return nil, fmt.Errorf("invalid --node-external-ip %s, an IPv4 address is required", envInfo.NodeExternalIP)
+		}
+	}
 
 	os.Setenv("NODE_NAME", nodeConfig.AgentConfig.NodeName)
-	v1beta1.KubeletSocket = filepath.Join(envInfo.DataDir, "kubelet/device-plugins/kubelet.sock")
+	v1beta1.KubeletSocket = filepath.Join(nodeConfig.AgentConfig.RootDir, "device-plugins/kubelet.sock")
 
 	nodeConfig.AgentConfig.ExtraKubeletArgs = envInfo.ExtraKubeletArgs
+	nodeConfig.AgentConfig.KubeletConfigFile = envInfo.KubeletConfigFile
+	nodeConfig.AgentConfig.KubeletConfigDir = envInfo.KubeletConfigDir
+	nodeConfig.AgentConfig.KubeletConfig = filepath.Join(envInfo.DataDir, "etc", "kubelet.yaml")
 	nodeConfig.AgentConfig.ExtraKubeProxyArgs = envInfo.ExtraKubeProxyArgs
+	nodeConfig.AgentConfig.KubeProxyConfigFile = envInfo.KubeProxyConfigFile
+	nodeConfig.AgentConfig.DisableKubeProxy = controlConfig.DisableKubeProxy
+	nodeConfig.AgentConfig.KubeProxyConfig = filepath.Join(envInfo.DataDir, "etc", "kube-proxy.yaml")
+	nodeConfig.AgentConfig.FeatureGates = envInfo.FeatureGates
 
 	nodeConfig.AgentConfig.NodeTaints = envInfo.Taints
 	nodeConfig.AgentConfig.NodeLabels = envInfo.Labels
@@ -392,6 +542,31 @@ func get(envInfo *cmds.Agent) (*config.Node, error) {
 	return nodeConfig, nil
 }
 
+// realDir creates dir, or def if it is not set, and returns its absolute path
+// with symlinks resolved. The kubelet and containerd compare the paths of
+// their directories with mount points, which breaks when they are symlinks to
+// another filesystem.
+func realDir(dir, def string) (string, error) {
+	if dir == "" {
+		dir = def
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if real != dir {
+		logrus.Infof("Using %s for %s, which is a symlink", real, dir)
+	}
+	return real, nil
+}
+
 func getConfig(info *clientaccess.Info) (*config.Control, error) {
 	data, err := clientaccess.Get("/v1-k3s/config", info)
 	if err != nil {
@@ -417,3 +592,35 @@ func HostnameCheck(cfg cmds.Agent) error {
 	}
 	return fmt.Errorf("Timed out waiting for hostname %s to be resolvable: %v", hostname, err)
 }
+
+// lookupGroup returns the id of the group with the given name or id.
+func lookupGroup(group string) (string, error) {
+	if g, err := user.LookupGroup(group); err == nil {
+		return g.Gid, nil
+	}
+	g, err := user.LookupGroupId(group)
+	if err != nil {
+		return "", errors.Wrapf(err, "unknown --cri-socket-group %s", group)
+	}
+	return g.Gid, nil
+}
+
+// tunnelAddresses normalizes --tunnel-port values to host:port, defaulting
+// bare ports to the loopback address.
+func tunnelAddresses(ports []string) ([]string, error) {
+	var addresses []string
+	for _, port := range ports {
+		if !strings.Contains(port, ":") {
+			port = "127.0.0.1:" + port
+		}
+		host, p, err := sysnet.SplitHostPort(port)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid --tunnel-port %s", port)
+		}
+		if _, err := strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid --tunnel-port %s", port)
+		}
+		addresses = append(addresses, port)
+	}
+	return addresses, nil
+}
@@ -0,0 +1,45 @@
+package containerd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+// criuFile is written next to the containerd socket with the path of the criu
+// containerd checkpoints containers with, so that k3s node checkpoint checks
+// the environment of containerd rather than its own.
+const criuFile = "criu"
+
+// CRIUPath returns the criu the containerd listening on address checkpoints
+// containers with, or why it cannot checkpoint them.
+func CRIUPath(address string) (string, error) {
+	path, err := ioutil.ReadFile(filepath.Join(filepath.Dir(address), criuFile))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("containerd at %s cannot checkpoint containers, install criu on the PATH of the k3s agent and restart it", address)
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(path)), nil
+}
+
+// recordCRIU records whether containerd, which runs with the environment of
+// the agent, can find criu.
+func recordCRIU(cfg *config.Node) error {
+	file := filepath.Join(filepath.Dir(cfg.Containerd.Address), criuFile)
+	path, err := exec.LookPath("criu")
+	if err != nil {
+		logrus.Debugf("criu not found, containers cannot be checkpointed: %v", err)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(path+"\n"), 0600)
+}
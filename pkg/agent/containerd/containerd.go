@@ -2,6 +2,7 @@ package containerd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,16 +13,25 @@ import (
 	"time"
 
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	containerdimages "github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
 	"github.com/natefinch/lumberjack"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/pkg/errors"
 	"github.com/rancher/k3s/pkg/agent/templates"
 	util2 "github.com/rancher/k3s/pkg/agent/util"
+	"github.com/rancher/k3s/pkg/chaos"
 	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/images"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	runtimeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 	"k8s.io/kubernetes/pkg/kubelet/util"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -37,9 +47,17 @@ func Run(ctx context.Context, cfg *config.Node) error {
 		"--root", cfg.Containerd.Root,
 	}
 
-	if err := setupContainerdConfig(ctx, cfg); err != nil {
+	setupSnapshotter(cfg)
+	if cfg.Containerd.Snapshotter == stargzSnapshotter {
+		if err := runStargz(ctx, cfg); err != nil {
+			return err
+		}
+	}
+	runtimes := findContainerRuntimes()
+	if err := setupContainerdConfig(ctx, cfg, runtimes); err != nil {
 		return err
 	}
+	go labelRuntimes(ctx, cfg, runtimes)
 
 	if os.Getenv("CONTAINERD_LOG_LEVEL") != "" {
 		args = append(args, "-l", os.Getenv("CONTAINERD_LOG_LEVEL"))
@@ -68,7 +86,12 @@ func Run(ctx context.Context, cfg *config.Node) error {
 		cmd.SysProcAttr = &syscall.SysProcAttr{
 			Pdeathsig: syscall.SIGKILL,
 		}
-		if err := cmd.Run(); err != nil {
+		err := cmd.Start()
+		if err == nil {
+			chaos.RegisterComponent("containerd", cmd.Process.Kill)
+			err = cmd.Wait()
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "containerd: %s\n", err)
 		}
 		os.Exit(1)
@@ -105,7 +128,18 @@ func Run(ctx context.Context, cfg *config.Node) error {
 		}
 	}
 
-	return preloadImages(cfg)
+	if err := recordCRIU(cfg); err != nil {
+		return err
+	}
+
+	if err := preloadImages(cfg); err != nil {
+		return err
+	}
+
+	if cfg.VerifyImages {
+		return verifyImages(cfg)
+	}
+	return nil
 }
 
 func preloadImages(cfg *config.Node) error {
@@ -157,11 +191,88 @@ func preloadImages(cfg *config.Node) error {
 	return nil
 }
 
-func setupContainerdConfig(ctx context.Context, cfg *config.Node) error {
+// verifyImages compares the packaged component images present in containerd
+// against the digests pinned in the binary, warning about any that differ.
+func verifyImages(cfg *config.Node) error {
+	pinned := images.Pinned()
+	if len(pinned) == 0 {
+		logrus.Warn("Image verification requested but no image digests were pinned at build time")
+		return nil
+	}
+
+	client, err := containerd.New(cfg.Containerd.Address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctxContainerD := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	for name, digest := range pinned {
+		image, err := client.GetImage(ctxContainerD, name)
+		if errdefs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			logrus.Errorf("Unable to verify image %s: %v", name, err)
+			continue
+		}
+
+		// the pinned digest may be that of the image index or of the manifest
+		// for this platform, depending on how the image was imported
+		manifest, err := platformManifest(ctxContainerD, client.ContentStore(), image.Target())
+		if err != nil {
+			logrus.Errorf("Unable to verify image %s: %v", name, err)
+			continue
+		}
+
+		if actual := image.Target().Digest.String(); actual != digest && manifest.Digest.String() != digest {
+			logrus.Warnf("Image %s has digest %s, expected %s", name, manifest.Digest, digest)
+			images.DigestMismatch.WithLabelValues(name).Set(1)
+		} else {
+			images.DigestMismatch.WithLabelValues(name).Set(0)
+		}
+	}
+	return nil
+}
+
+// platformManifest resolves an image index to the manifest for the default
+// platform, other descriptors are returned as they are.
+func platformManifest(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case containerdimages.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return desc, nil
+	}
+
+	p, err := content.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return desc, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(p, &index); err != nil {
+		return desc, err
+	}
+
+	matcher := platforms.Default()
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil || matcher.Match(*manifest.Platform) {
+			return platformManifest(ctx, provider, manifest)
+		}
+	}
+	return desc, fmt.Errorf("no manifest for %s in index %s", platforms.DefaultString(), desc.Digest)
+}
+
+func setupContainerdConfig(ctx context.Context, cfg *config.Node, runtimes map[string]templates.ContainerdRuntimeConfig) error {
 	var containerdTemplate string
+	privRegistries, err := getPrivateRegistries(cfg.Containerd.Registry)
+	if err != nil {
+		return err
+	}
 	containerdConfig := templates.ContainerdConfig{
-		NodeConfig:        cfg,
-		IsRunningInUserNS: system.RunningInUserNS(),
+		NodeConfig:            cfg,
+		IsRunningInUserNS:     system.RunningInUserNS(),
+		PrivateRegistryConfig: privRegistries,
+		ExtraRuntimes:         runtimes,
 	}
 
 	containerdTemplateBytes, err := ioutil.ReadFile(cfg.Containerd.Template)
@@ -175,8 +286,34 @@ func setupContainerdConfig(ctx context.Context, cfg *config.Node) error {
 	}
 	parsedTemplate, err := templates.ParseTemplateFromConfig(containerdTemplate, containerdConfig)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "failed to render containerd config from %s", cfg.Containerd.Template)
 	}
 
 	return util2.WriteFile(cfg.Containerd.Config, parsedTemplate)
 }
+
+// getPrivateRegistries loads registries.yaml, a missing file configures no
+// private registries.
+func getPrivateRegistries(path string) (*templates.Registry, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	registry := &templates.Registry{}
+	if err := yaml.Unmarshal(data, registry); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	for host, config := range registry.Configs {
+		if config.TLS != nil {
+			logrus.Warnf("Ignoring TLS settings of registry %s in %s, the embedded containerd only trusts the system CAs", host, path)
+		}
+	}
+	logrus.Infof("Using private registry config file at %s", path)
+	return registry, nil
+}
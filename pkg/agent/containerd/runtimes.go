@@ -0,0 +1,114 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/agent/templates"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/node"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// wasmShims are the shim v2 runtimes for WebAssembly workloads, keyed by
+// handler name. containerd runs them as containerd-shim-<name>-v1. The server
+// creates RuntimeClasses for the handlers listed in node.RuntimeHandlers.
+var wasmShims = map[string]string{
+	"wasmtime": "io.containerd.wasmtime.v1",
+	"spin":     "io.containerd.spin.v1",
+}
+
+// findContainerRuntimes returns the extra runtime handlers available on the
+// host, keyed by handler name.
+func findContainerRuntimes() map[string]templates.ContainerdRuntimeConfig {
+	runtimes := map[string]templates.ContainerdRuntimeConfig{}
+	if path, err := exec.LookPath("nvidia-container-runtime"); err == nil {
+		logrus.Infof("Found nvidia container runtime at %s", path)
+		runtimes["nvidia"] = templates.ContainerdRuntimeConfig{
+			RuntimeType:   "io.containerd.runtime.v1.linux",
+			RuntimeEngine: path,
+		}
+	}
+	for name, runtimeType := range wasmShims {
+		if path, err := exec.LookPath("containerd-shim-" + name + "-v1"); err == nil {
+			logrus.Infof("Found %s wasm shim at %s", name, path)
+			runtimes[name] = templates.ContainerdRuntimeConfig{
+				RuntimeType: runtimeType,
+			}
+		}
+	}
+	return runtimes
+}
+
+// labelRuntimes labels this node with the runtime handlers found on it, and
+// drops the labels of handlers that are gone, so that the server only creates
+// the RuntimeClass of a handler once some node can run it. The node may not be
+// registered yet, so this retries until it is.
+func labelRuntimes(ctx context.Context, cfg *config.Node, runtimes map[string]templates.ContainerdRuntimeConfig) {
+	// the node identity of the kubelet may only modify this node
+	restConfig, err := clientcmd.BuildConfigFromFlags("", cfg.AgentConfig.KubeConfigKubelet)
+	if err != nil {
+		logrus.Errorf("Failed to label container runtimes: %v", err)
+		return
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logrus.Errorf("Failed to label container runtimes: %v", err)
+		return
+	}
+
+	for {
+		err := patchRuntimeLabels(client, cfg.AgentConfig.NodeName, runtimes)
+		if err == nil {
+			return
+		}
+		logrus.Debugf("Failed to label container runtimes: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func patchRuntimeLabels(client kubernetes.Interface, nodeName string, runtimes map[string]templates.ContainerdRuntimeConfig) error {
+	n, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]interface{}{}
+	for label := range n.Labels {
+		if strings.HasPrefix(label, node.RuntimeLabelPrefix) {
+			if _, ok := runtimes[strings.TrimPrefix(label, node.RuntimeLabelPrefix)]; !ok {
+				labels[label] = nil
+			}
+		}
+	}
+	for name := range runtimes {
+		if n.Labels[node.RuntimeLabelPrefix+name] != "true" {
+			labels[node.RuntimeLabelPrefix+name] = "true"
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": labels,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+	return err
+}
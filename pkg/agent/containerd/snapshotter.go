@@ -0,0 +1,53 @@
+package containerd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/containerd/containerd/snapshots/overlay"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OverlaySnapshotter = "overlayfs"
+	NativeSnapshotter  = "native"
+)
+
+// proxySnapshotters are run outside of containerd and reached by their
+// socket. The stargz snapshotter is bundled and started by k3s.
+var proxySnapshotters = map[string]string{
+	"fuse-overlayfs":  "/run/containerd-fuse-overlayfs.sock",
+	stargzSnapshotter: stargzAddress,
+}
+
+// ValidSnapshotter checks that a --snapshotter value can be used.
+func ValidSnapshotter(name string) error {
+	switch name {
+	case OverlaySnapshotter, NativeSnapshotter:
+		return nil
+	case "btrfs", "zfs":
+		return fmt.Errorf("snapshotter %s is not built into the embedded containerd", name)
+	}
+	if _, ok := proxySnapshotters[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown snapshotter %s", name)
+}
+
+// setupSnapshotter falls back to the native snapshotter if overlayfs does not
+// work on the containerd root, and sets the socket of proxy snapshotters.
+func setupSnapshotter(cfg *config.Node) {
+	switch cfg.Containerd.Snapshotter {
+	case "", OverlaySnapshotter:
+		root := filepath.Join(cfg.Containerd.Root, "io.containerd.snapshotter.v1."+OverlaySnapshotter)
+		if err := overlay.Supported(root); err != nil {
+			logrus.Warnf("Falling back to the %s snapshotter, overlayfs is not supported: %v", NativeSnapshotter, err)
+			cfg.Containerd.Snapshotter = NativeSnapshotter
+			return
+		}
+		cfg.Containerd.Snapshotter = OverlaySnapshotter
+	default:
+		cfg.Containerd.SnapshotterAddress = proxySnapshotters[cfg.Containerd.Snapshotter]
+	}
+}
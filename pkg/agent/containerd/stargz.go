@@ -0,0 +1,79 @@
+package containerd
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/natefinch/lumberjack"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	stargzSnapshotter = "stargz"
+	stargzAddress     = "/run/k3s/containerd-stargz-grpc/containerd-stargz-grpc.sock"
+)
+
+// runStargz starts the bundled stargz snapshotter, which containerd uses as a
+// proxy plugin, and waits for its socket.
+func runStargz(ctx context.Context, cfg *config.Node) error {
+	path, err := exec.LookPath("containerd-stargz-grpc")
+	if err != nil {
+		return errors.Wrap(err, "--snapshotter=stargz requires containerd-stargz-grpc")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stargzAddress), 0700); err != nil {
+		return err
+	}
+	os.Remove(stargzAddress)
+
+	args := []string{
+		"--address", stargzAddress,
+		"--root", filepath.Join(cfg.Containerd.Root, "io.containerd.snapshotter.v1."+stargzSnapshotter),
+	}
+
+	out := io.Writer(os.Stderr)
+	if cfg.Containerd.Log != "" {
+		logFile := filepath.Join(filepath.Dir(cfg.Containerd.Log), "containerd-stargz-grpc.log")
+		logrus.Infof("Logging containerd-stargz-grpc to %s", logFile)
+		out = &lumberjack.Logger{
+			Filename:   logFile,
+			MaxSize:    50,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+		}
+	}
+
+	go func() {
+		logrus.Infof("Running containerd-stargz-grpc %s", config.ArgString(args))
+		cmd := exec.CommandContext(ctx, path, args...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Pdeathsig: syscall.SIGKILL,
+		}
+		err := cmd.Run()
+		if ctx.Err() == nil {
+			logrus.Fatalf("containerd-stargz-grpc exited: %v", err)
+		}
+	}()
+
+	for i := 0; i < 60; i++ {
+		if _, err := os.Stat(stargzAddress); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return errors.Errorf("timed out waiting for containerd-stargz-grpc at %s", stargzAddress)
+}
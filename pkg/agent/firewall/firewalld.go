@@ -0,0 +1,164 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/netutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Zone is the firewalld zone holding the cluster networks and interfaces.
+// Its target is ACCEPT, so that firewalld does not reject pod and service
+// traffic, whether it uses the iptables or the nftables backend.
+const Zone = "k3s"
+
+var (
+	// interfaces created by the flannel CNI plugin and the flannel backends
+	clusterInterfaces = []string{"cni0", "flannel.1", "flannel-wg"}
+
+	flannelPorts = map[string]netutil.Port{
+		flannel.VXLANBackend:     {Component: "flannel vxlan", Network: "udp", Port: 8472},
+		flannel.WireguardBackend: {Component: "flannel wireguard", Network: "udp", Port: 51820},
+	}
+)
+
+// Running returns true if firewalld is installed and running.
+func Running() bool {
+	if _, err := exec.LookPath("firewall-cmd"); err != nil {
+		return false
+	}
+	out, _ := exec.Command("firewall-cmd", "--state").Output()
+	return strings.TrimSpace(string(out)) == "running"
+}
+
+// Configure adds the cluster networks to the k3s zone and opens the ports
+// the agent is reached on in the default zone. Without --firewalld it only
+// warns if firewalld is running, as its default zone rejects traffic of the
+// cluster networks.
+func Configure(nodeConfig *config.Node) error {
+	if !Running() {
+		return nil
+	}
+	if !nodeConfig.Firewalld {
+		logrus.Warn("firewalld is running and may reject traffic of the cluster networks, use --firewalld to add the k3s rules to it or disable firewalld")
+		return nil
+	}
+
+	created, err := ensureZone()
+	if err != nil {
+		return err
+	}
+	if created {
+		// a zone created permanently only exists after a reload, this is
+		// done before kube-proxy and flannel add their rules
+		if _, err := firewallCmd("--reload"); err != nil {
+			return err
+		}
+	}
+
+	for _, cidr := range []net.IPNet{nodeConfig.AgentConfig.ClusterCIDR, nodeConfig.AgentConfig.ServiceCIDR} {
+		if cidr.IP == nil {
+			continue
+		}
+		if err := addToZone(Zone, "--add-source="+cidr.String()); err != nil {
+			return err
+		}
+	}
+	for _, iface := range clusterInterfaces {
+		if err := addToZone(Zone, "--add-interface="+iface); err != nil {
+			return err
+		}
+	}
+
+	ports := []netutil.Port{
+		{Component: "kubelet", Network: "tcp", Port: 10250},
+	}
+	if !nodeConfig.NoFlannel {
+		backend := nodeConfig.FlannelBackend
+		if backend == "" {
+			backend = flannel.VXLANBackend
+		}
+		if port, ok := flannelPorts[backend]; ok {
+			ports = append(ports, port)
+		}
+		if backend == flannel.IPSecBackend {
+			zone, err := defaultZone()
+			if err != nil {
+				return err
+			}
+			if err := addToZone(zone, "--add-protocol=esp"); err != nil {
+				return err
+			}
+		}
+	}
+	return OpenPorts(ports)
+}
+
+// OpenPorts opens ports in the default zone, at runtime and permanently.
+// Ports bound to a single address are skipped.
+func OpenPorts(ports []netutil.Port) error {
+	zone, err := defaultZone()
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		if port.Host != "" && port.Host != "0.0.0.0" {
+			continue
+		}
+		if err := addToZone(zone, fmt.Sprintf("--add-port=%d/%s", port.Port, port.Network)); err != nil {
+			return err
+		}
+		logrus.Infof("Opened %s (%s) in firewalld zone %s", port, port.Component, zone)
+	}
+	return nil
+}
+
+func defaultZone() (string, error) {
+	return firewallCmd("--get-default-zone")
+}
+
+// ensureZone creates the k3s zone, returning true if it did not exist.
+func ensureZone() (bool, error) {
+	zones, err := firewallCmd("--permanent", "--get-zones")
+	if err != nil {
+		return false, err
+	}
+	for _, zone := range strings.Fields(zones) {
+		if zone == Zone {
+			return false, nil
+		}
+	}
+
+	if _, err := firewallCmd("--permanent", "--new-zone="+Zone); err != nil {
+		return false, err
+	}
+	if _, err := firewallCmd("--permanent", "--zone="+Zone, "--set-target=ACCEPT"); err != nil {
+		return false, err
+	}
+	logrus.Infof("Created firewalld zone %s", Zone)
+	return true, nil
+}
+
+// addToZone applies a change to the runtime and the permanent configuration,
+// firewalld ignores changes that are already applied.
+func addToZone(zone, arg string) error {
+	if _, err := firewallCmd("--zone="+zone, arg); err != nil {
+		return err
+	}
+	_, err := firewallCmd("--permanent", "--zone="+zone, arg)
+	return err
+}
+
+func firewallCmd(args ...string) (string, error) {
+	out, err := exec.Command("firewall-cmd", args...).CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "firewall-cmd %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -19,6 +19,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/coreos/flannel/backend"
@@ -36,11 +37,17 @@ const (
 	subnetFile = "/run/flannel/subnet.env"
 )
 
-func flannel(ctx context.Context, flannelIface *net.Interface, flannelConf, kubeConfigFile string) error {
+func flannel(ctx context.Context, flannelIface *net.Interface, flannelConf, kubeConfigFile string, mtu int, externalIP net.IP) error {
 	extIface, err := LookupExtIface(flannelIface)
 	if err != nil {
 		return err
 	}
+	if externalIP != nil {
+		// the tunnels of other nodes end at the external IP, which is
+		// published as the public IP of the lease
+		log.Infof("Using external IP %s", externalIP)
+		extIface.ExtAddr = externalIP
+	}
 
 	sm, err := kube.NewSubnetManager("", flannelConf, kubeConfigFile, "flannel.alpha.coreos.com")
 	if err != nil {
@@ -52,6 +59,8 @@ func flannel(ctx context.Context, flannelIface *net.Interface, flannelConf, kube
 		return err
 	}
 
+	setMTU(extIface, config.BackendType, mtu)
+
 	// Create a backend manager then use it to create the backend and register the network with it.
 	bm := backend.NewManager(ctx, sm, extIface)
 
@@ -65,6 +74,12 @@ func flannel(ctx context.Context, flannelIface *net.Interface, flannelConf, kube
 		return err
 	}
 
+	if strings.ToLower(config.BackendType) == VXLANBackend {
+		if err := setVXLANMTU(bn.MTU()); err != nil {
+			log.Warningf("Failed to set the MTU of %s: %v", vxlanIface, err)
+		}
+	}
+
 	go network.SetupAndEnsureIPTables(network.MasqRules(config.Network, bn.Lease()), 60)
 	go network.SetupAndEnsureIPTables(network.ForwardRules(config.Network.String()), 50)
 
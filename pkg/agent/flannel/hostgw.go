@@ -0,0 +1,72 @@
+package flannel
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/coreos/flannel/backend"
+	"github.com/coreos/flannel/pkg/ip"
+	"github.com/coreos/flannel/subnet"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+// HostGWBackend is the flannel backend type that routes pod subnets through
+// the node addresses, without an overlay. All nodes must share a layer 2
+// network.
+const HostGWBackend = "host-gw"
+
+func init() {
+	backend.Register(HostGWBackend, newHostGWBackend)
+}
+
+type hostGWBackend struct {
+	sm       subnet.Manager
+	extIface *backend.ExternalInterface
+}
+
+func newHostGWBackend(sm subnet.Manager, extIface *backend.ExternalInterface) (backend.Backend, error) {
+	if !extIface.ExtAddr.Equal(extIface.IfaceAddr) {
+		return nil, fmt.Errorf("the public IP %s differs from the interface IP %s, nodes behind NAT are not supported by the %s backend", extIface.ExtAddr, extIface.IfaceAddr, HostGWBackend)
+	}
+	return &hostGWBackend{
+		sm:       sm,
+		extIface: extIface,
+	}, nil
+}
+
+// RegisterNetwork acquires the node's lease. The backend interface passes the
+// WaitGroup by value.
+func (be *hostGWBackend) RegisterNetwork(ctx context.Context, wg sync.WaitGroup, config *subnet.Config) (backend.Network, error) { //nolint:govet
+	n := &backend.RouteNetwork{
+		SimpleNetwork: backend.SimpleNetwork{
+			ExtIface: be.extIface,
+		},
+		SM:          be.sm,
+		BackendType: HostGWBackend,
+		Mtu:         be.extIface.Iface.MTU,
+		LinkIndex:   be.extIface.Iface.Index,
+	}
+	n.GetRoute = func(lease *subnet.Lease) *netlink.Route {
+		return &netlink.Route{
+			Dst:       lease.Subnet.ToIPNet(),
+			Gw:        lease.Attrs.PublicIP.ToIP(),
+			LinkIndex: n.LinkIndex,
+		}
+	}
+
+	lease, err := be.sm.AcquireLease(ctx, &subnet.LeaseAttrs{
+		PublicIP:    ip.FromIP(be.extIface.ExtAddr),
+		BackendType: HostGWBackend,
+	})
+	switch err {
+	case nil:
+		n.SubnetLease = lease
+	case context.Canceled, context.DeadlineExceeded:
+		return nil, err
+	default:
+		return nil, fmt.Errorf("failed to acquire lease: %v", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,502 @@
+package flannel
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/flannel/backend"
+	"github.com/coreos/flannel/pkg/ip"
+	"github.com/coreos/flannel/subnet"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+const (
+	// IPSecBackend is the flannel backend type that encrypts traffic between
+	// pod subnets with ESP tunnels.
+	IPSecBackend = "ipsec"
+	// IPSecSecretName is the kube-system secret holding the pre-shared key
+	// of the ipsec backend, under IPSecSecretKey.
+	IPSecSecretName = "k3s-flannel-ipsec"
+	IPSecSecretKey  = "psk"
+
+	// ipsecReqID marks the xfrm states and policies managed by k3s
+	ipsecReqID = 0x6b33
+	// ipsecOverhead is the overhead of ESP in tunnel mode with AES-GCM
+	ipsecOverhead = 60
+	ipsecAead     = "rfc4106(gcm(aes))"
+	// ipsecRekeyInterval is how often a node publishes a new nonce, which
+	// re-keys every tunnel it is part of
+	ipsecRekeyInterval = 4 * time.Hour
+	// ipsecRekeyGrace is how long a node keeps sending with its previous keys
+	// after publishing a new nonce, so that its peers can add the new inbound
+	// security associations first, and how long it keeps accepting the
+	// previous keys of a peer that published a new nonce
+	ipsecRekeyGrace = time.Minute
+)
+
+// A rekey of node A, as seen by its peer B:
+//
+//  1. A adds the inbound security association from B keyed with its new nonce,
+//     and publishes the nonce in its lease. It keeps sending to B with its
+//     previous keys.
+//  2. B sees the new nonce and adds both security associations keyed with it.
+//     It sends to A with the new keys right away, as A accepts them since 1,
+//     but keeps the inbound security association keyed with the previous nonce
+//     of A for ipsecRekeyGrace, as A may still be sending with it.
+//  3. ipsecRekeyGrace after 1, A starts sending to B with the new keys and
+//     removes its previous security associations. B removes the inbound one
+//     it kept once its own ipsecRekeyGrace passed.
+//
+// A peer that misses the new nonce for longer than ipsecRekeyGrace drops the
+// traffic of A until it sees the nonce.
+
+func init() {
+	backend.Register(IPSecBackend, newIPSecBackend)
+}
+
+// ipsecLeaseAttrs is published as the lease backend data. The nonce changes
+// on every start and every ipsecRekeyInterval, so that security associations
+// are never keyed twice with the same key after their sequence numbers are
+// reset, and no key is used for long.
+//
+// Keys are derived from the pre-shared key shared by the whole cluster, so any
+// node, and anyone able to read the secret, can derive the keys of every
+// tunnel, not only its own.
+type ipsecLeaseAttrs struct {
+	Nonce string
+}
+
+type ipsecBackend struct {
+	sm       subnet.Manager
+	extIface *backend.ExternalInterface
+}
+
+func newIPSecBackend(sm subnet.Manager, extIface *backend.ExternalInterface) (backend.Backend, error) {
+	if !extIface.ExtAddr.Equal(extIface.IfaceAddr) {
+		return nil, fmt.Errorf("the public IP %s differs from the interface IP %s, nodes behind NAT are not supported by the %s backend", extIface.ExtAddr, extIface.IfaceAddr, IPSecBackend)
+	}
+	return &ipsecBackend{
+		sm:       sm,
+		extIface: extIface,
+	}, nil
+}
+
+// RegisterNetwork removes the xfrm states and policies of a previous run and
+// acquires the node's lease. The backend interface passes the WaitGroup by
+// value.
+func (be *ipsecBackend) RegisterNetwork(ctx context.Context, wg sync.WaitGroup, config *subnet.Config) (backend.Network, error) { //nolint:govet
+	cfg := struct {
+		PSKFile string
+	}{}
+	if len(config.Backend) > 0 {
+		if err := json.Unmarshal(config.Backend, &cfg); err != nil {
+			return nil, errors.Wrap(err, "error decoding ipsec backend config")
+		}
+	}
+	if cfg.PSKFile == "" {
+		return nil, fmt.Errorf("ipsec backend config is missing PSKFile")
+	}
+	psk, err := ioutil.ReadFile(cfg.PSKFile)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := newIPSecNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ipsecFlush(); err != nil {
+		return nil, err
+	}
+
+	lease, err := ipsecAcquireLease(ctx, be.sm, be.extIface, nonce)
+	switch err {
+	case nil:
+	case context.Canceled, context.DeadlineExceeded:
+		return nil, err
+	default:
+		return nil, fmt.Errorf("failed to acquire lease: %v", err)
+	}
+
+	return &ipsecNetwork{
+		SimpleNetwork: backend.SimpleNetwork{
+			SubnetLease: lease,
+			ExtIface:    be.extIface,
+		},
+		sm:    be.sm,
+		psk:   []byte(strings.TrimSpace(string(psk))),
+		nonce: nonce,
+		peers: map[string]*ipsecPeer{},
+	}, nil
+}
+
+func newIPSecNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// ipsecAcquireLease publishes nonce in the node's lease.
+func ipsecAcquireLease(ctx context.Context, sm subnet.Manager, extIface *backend.ExternalInterface, nonce string) (*subnet.Lease, error) {
+	data, err := json.Marshal(&ipsecLeaseAttrs{Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+	return sm.AcquireLease(ctx, &subnet.LeaseAttrs{
+		PublicIP:    ip.FromIP(extIface.ExtAddr),
+		BackendType: IPSecBackend,
+		BackendData: json.RawMessage(data),
+	})
+}
+
+type ipsecNetwork struct {
+	backend.SimpleNetwork
+	sm    subnet.Manager
+	psk   []byte
+	nonce string
+	// peers maps subnet keys to the tunnel of their node
+	peers map[string]*ipsecPeer
+	// rekeyed maps subnet keys to tunnels keyed with the nonce published by
+	// the last rekey, of which only the inbound states were added yet
+	rekeyed map[string]*ipsecPeer
+	// retired are the inbound states of peers that published a new nonce,
+	// kept until their peer stopped sending with them
+	retired []ipsecRetiredState
+}
+
+type ipsecRetiredState struct {
+	state *netlink.XfrmState
+	until time.Time
+}
+
+type ipsecPeer struct {
+	lease    subnet.Lease
+	publicIP string
+	nonce    string
+	states   []*netlink.XfrmState
+	policies []*netlink.XfrmPolicy
+}
+
+func (n *ipsecNetwork) MTU() int {
+	return n.ExtIface.Iface.MTU - ipsecOverhead
+}
+
+func (n *ipsecNetwork) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+
+	logrus.Info("Watching for new subnet leases")
+	events := make(chan []subnet.Event)
+	wg.Add(1)
+	go func() {
+		subnet.WatchLeases(ctx, n.sm, n.SubnetLease, events)
+		wg.Done()
+	}()
+	defer wg.Wait()
+
+	rekey := time.NewTicker(ipsecRekeyInterval)
+	defer rekey.Stop()
+	var switchOver, retire <-chan time.Time
+
+	for {
+		select {
+		case batch := <-events:
+			for _, event := range batch {
+				n.handleEvent(event)
+			}
+			if retire == nil && len(n.retired) > 0 {
+				retire = time.After(time.Until(n.retired[0].until))
+			}
+		case <-rekey.C:
+			if err := n.rekey(ctx); err != nil {
+				logrus.Errorf("Failed to rekey ipsec tunnels: %v", err)
+				continue
+			}
+			switchOver = time.After(ipsecRekeyGrace)
+		case <-switchOver:
+			switchOver = nil
+			n.switchOver()
+		case <-retire:
+			retire = nil
+			if n.removeRetired(time.Now()) {
+				retire = time.After(time.Until(n.retired[0].until))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *ipsecNetwork) handleEvent(event subnet.Event) {
+	lease := event.Lease
+	if lease.Attrs.BackendType != IPSecBackend {
+		logrus.Warnf("Ignoring non-%s subnet %s: type=%s", IPSecBackend, lease.Subnet, lease.Attrs.BackendType)
+		return
+	}
+	old := n.peers[lease.Key()]
+	// tunnels are set up in full below, the pending rekey is superseded
+	rekeyed := n.rekeyed[lease.Key()]
+	delete(n.rekeyed, lease.Key())
+
+	switch event.Type {
+	case subnet.EventAdded:
+		attrs := ipsecLeaseAttrs{}
+		if err := json.Unmarshal(lease.Attrs.BackendData, &attrs); err != nil || attrs.Nonce == "" {
+			logrus.Errorf("Ignoring subnet %s without an ipsec nonce: %v", lease.Subnet, err)
+			return
+		}
+		if old != nil && old.nonce == attrs.Nonce && old.publicIP == lease.Attrs.PublicIP.String() {
+			if rekeyed != nil {
+				n.rekeyed[lease.Key()] = rekeyed
+			}
+			return
+		}
+		logrus.Infof("Subnet added: %s via %s", lease.Subnet, lease.Attrs.PublicIP)
+		peer := n.peer(&lease, attrs.Nonce)
+		if err := peer.add(); err != nil {
+			logrus.Errorf("Failed to add ipsec tunnel for %s: %v", lease.Subnet, err)
+			return
+		}
+		n.peers[lease.Key()] = peer
+		// the policies were replaced, only the old states remain. The peer may
+		// still send with the old keys until its switchOver.
+		if old != nil {
+			removeState(old.states[0])
+			n.retire(old.states[1])
+		}
+		if rekeyed != nil && rekeyed.states[1].Spi != peer.states[1].Spi {
+			n.retire(rekeyed.states[1])
+		}
+
+	case subnet.EventRemoved:
+		logrus.Infof("Subnet removed: %s", lease.Subnet)
+		if old != nil {
+			old.remove()
+			delete(n.peers, lease.Key())
+		}
+		if rekeyed != nil {
+			rekeyed.removeStates()
+		}
+	}
+}
+
+// rekey publishes a new nonce, which changes the keys of every tunnel. Peers
+// add the new security associations once they see the new nonce, so only the
+// inbound ones are added right away, the outbound ones by switchOver.
+func (n *ipsecNetwork) rekey(ctx context.Context) error {
+	nonce, err := newIPSecNonce()
+	if err != nil {
+		return err
+	}
+	previous := n.nonce
+	n.nonce = nonce
+
+	rekeyed := map[string]*ipsecPeer{}
+	for key, old := range n.peers {
+		peer := n.peer(&old.lease, old.nonce)
+		if err := netlink.XfrmStateUpdate(peer.states[1]); err != nil {
+			logrus.Errorf("Failed to add xfrm state %s to %s: %v", peer.states[1].Src, peer.states[1].Dst, err)
+			continue
+		}
+		rekeyed[key] = peer
+	}
+
+	if _, err := ipsecAcquireLease(ctx, n.sm, n.ExtIface, nonce); err != nil {
+		// peers keep using the previous nonce
+		n.nonce = previous
+		for _, peer := range rekeyed {
+			peer.removeStates()
+		}
+		return err
+	}
+	for _, peer := range n.rekeyed {
+		peer.removeStates()
+	}
+	n.rekeyed = rekeyed
+	return nil
+}
+
+// switchOver starts sending with the keys of the last rekey and removes the
+// previous security associations.
+func (n *ipsecNetwork) switchOver() {
+	for key, peer := range n.rekeyed {
+		if err := peer.add(); err != nil {
+			logrus.Errorf("Failed to rekey ipsec tunnel for %s: %v", peer.lease.Subnet, err)
+			continue
+		}
+		if old := n.peers[key]; old != nil {
+			old.removeStates()
+		}
+		n.peers[key] = peer
+	}
+	n.rekeyed = nil
+}
+
+// retire queues the removal of the inbound state ipsecRekeyGrace from now.
+func (n *ipsecNetwork) retire(state *netlink.XfrmState) {
+	n.retired = append(n.retired, ipsecRetiredState{
+		state: state,
+		until: time.Now().Add(ipsecRekeyGrace),
+	})
+}
+
+// removeRetired removes the retired states that were kept until before now,
+// and returns whether any remain.
+func (n *ipsecNetwork) removeRetired(now time.Time) bool {
+	for len(n.retired) > 0 && !n.retired[0].until.After(now) {
+		removeState(n.retired[0].state)
+		n.retired = n.retired[1:]
+	}
+	return len(n.retired) > 0
+}
+
+// peer returns the security associations and policies of the tunnel to the
+// node holding lease. Traffic from any address to the remote subnet is
+// encrypted, traffic from the remote subnet to the local subnet must be.
+func (n *ipsecNetwork) peer(lease *subnet.Lease, nonce string) *ipsecPeer {
+	local := n.ExtIface.ExtAddr
+	remote := lease.Attrs.PublicIP.ToIP()
+	localSubnet := n.SubnetLease.Subnet.ToIPNet()
+	remoteSubnet := lease.Subnet.ToIPNet()
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+
+	return &ipsecPeer{
+		lease:    *lease,
+		publicIP: lease.Attrs.PublicIP.String(),
+		nonce:    nonce,
+		states: []*netlink.XfrmState{
+			n.state(local, remote, n.nonce, nonce),
+			n.state(remote, local, nonce, n.nonce),
+		},
+		policies: []*netlink.XfrmPolicy{
+			ipsecPolicy(all, remoteSubnet, local, remote, netlink.XFRM_DIR_OUT),
+			ipsecPolicy(remoteSubnet, localSubnet, remote, local, netlink.XFRM_DIR_IN),
+			ipsecPolicy(remoteSubnet, localSubnet, remote, local, netlink.XFRM_DIR_FWD),
+		},
+	}
+}
+
+// state returns the security association from src to dst. Both nodes derive
+// its SPI and key from the pre-shared key, their addresses and nonces.
+func (n *ipsecNetwork) state(src, dst net.IP, srcNonce, dstNonce string) *netlink.XfrmState {
+	mac := hmac.New(sha256.New, n.psk)
+	fmt.Fprintf(mac, "k3s ipsec %s %s %s %s", src, dst, srcNonce, dstNonce)
+	sum := mac.Sum(nil)
+
+	// SPIs below 256 are reserved
+	spi := binary.BigEndian.Uint32(sum[:4]) | 0x100
+	return &netlink.XfrmState{
+		Src:          src,
+		Dst:          dst,
+		Proto:        netlink.XFRM_PROTO_ESP,
+		Mode:         netlink.XFRM_MODE_TUNNEL,
+		Spi:          int(spi),
+		Reqid:        ipsecReqID,
+		ReplayWindow: 32,
+		// 32 bit sequence numbers would wrap after a few hours at line rate
+		ESN: true,
+		Aead: &netlink.XfrmStateAlgo{
+			Name: ipsecAead,
+			// a 128 bit key followed by the 32 bit salt
+			Key:    sum[4:24],
+			ICVLen: 128,
+		},
+	}
+}
+
+func ipsecPolicy(src, dst *net.IPNet, tunnelSrc, tunnelDst net.IP, dir netlink.Dir) *netlink.XfrmPolicy {
+	return &netlink.XfrmPolicy{
+		Src: src,
+		Dst: dst,
+		Dir: dir,
+		Tmpls: []netlink.XfrmPolicyTmpl{{
+			Src:   tunnelSrc,
+			Dst:   tunnelDst,
+			Proto: netlink.XFRM_PROTO_ESP,
+			Mode:  netlink.XFRM_MODE_TUNNEL,
+			Reqid: ipsecReqID,
+		}},
+	}
+}
+
+func (p *ipsecPeer) add() error {
+	for _, state := range p.states {
+		if err := netlink.XfrmStateUpdate(state); err != nil {
+			return errors.Wrapf(err, "failed to add xfrm state %s to %s", state.Src, state.Dst)
+		}
+	}
+	for _, policy := range p.policies {
+		if err := netlink.XfrmPolicyUpdate(policy); err != nil {
+			return errors.Wrapf(err, "failed to add xfrm %s policy %s to %s", policy.Dir, policy.Src, policy.Dst)
+		}
+	}
+	return nil
+}
+
+func (p *ipsecPeer) remove() {
+	for _, policy := range p.policies {
+		if err := netlink.XfrmPolicyDel(policy); err != nil {
+			logrus.Errorf("Failed to delete xfrm %s policy %s to %s: %v", policy.Dir, policy.Src, policy.Dst, err)
+		}
+	}
+	p.removeStates()
+}
+
+// removeStates removes only the security associations, for tunnels whose
+// policies were replaced by another peer.
+func (p *ipsecPeer) removeStates() {
+	for _, state := range p.states {
+		removeState(state)
+	}
+}
+
+func removeState(state *netlink.XfrmState) {
+	if err := netlink.XfrmStateDel(state); err != nil {
+		logrus.Errorf("Failed to delete xfrm state %s to %s: %v", state.Src, state.Dst, err)
+	}
+}
+
+// ipsecFlush removes the xfrm states and policies left by a previous run.
+func ipsecFlush() error {
+	policies, err := netlink.XfrmPolicyList(netlink.FAMILY_V4)
+	if err != nil {
+		return errors.Wrap(err, "failed to list xfrm policies, is xfrm supported by the kernel")
+	}
+	for i := range policies {
+		if len(policies[i].Tmpls) == 0 || policies[i].Tmpls[0].Reqid != ipsecReqID {
+			continue
+		}
+		if err := netlink.XfrmPolicyDel(&policies[i]); err != nil {
+			return err
+		}
+	}
+
+	states, err := netlink.XfrmStateList(netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+	for i := range states {
+		if states[i].Reqid != ipsecReqID {
+			continue
+		}
+		if err := netlink.XfrmStateDel(&states[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
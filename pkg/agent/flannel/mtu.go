@@ -0,0 +1,98 @@
+package flannel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/flannel/backend"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	vxlanIface    = "flannel.1"
+	vxlanOverhead = 50
+
+	// minMTU is the smallest MTU every IPv4 host must accept
+	minMTU = 576
+	maxMTU = 65535
+)
+
+// backendOverhead is the encapsulation overhead of each backend over IPv4.
+var backendOverhead = map[string]int{
+	VXLANBackend:     vxlanOverhead,
+	HostGWBackend:    0,
+	IPSecBackend:     ipsecOverhead,
+	WireguardBackend: wireguardOverhead,
+}
+
+// ValidMTU checks a --flannel-mtu value, 0 derives the MTU.
+func ValidMTU(mtu int) error {
+	if mtu != 0 && (mtu < minMTU || mtu > maxMTU) {
+		return fmt.Errorf("%d is not between %d and %d", mtu, minMTU, maxMTU)
+	}
+	return nil
+}
+
+// setMTU sizes the external interface handed to the backend, which derives
+// the overlay MTU from it. Without an override the underlay MTU is used, so
+// that the overlay MTU is the underlay MTU minus the backend overhead.
+func setMTU(extIface *backend.ExternalInterface, backendType string, override int) {
+	overhead := backendOverhead[strings.ToLower(backendType)]
+	underlay := underlayMTU(extIface.Iface)
+
+	iface := *extIface.Iface
+	iface.MTU = underlay
+	if override > 0 {
+		if override+overhead > underlay {
+			logrus.Warnf("Flannel MTU %d with the %d byte %s overhead exceeds the %d byte MTU of %s, large packets will be fragmented or dropped", override, overhead, backendType, underlay, iface.Name)
+		}
+		iface.MTU = override + overhead
+	}
+	extIface.Iface = &iface
+	logrus.Infof("Using flannel MTU %d for the %s backend over %s", iface.MTU-overhead, backendType, iface.Name)
+}
+
+// underlayMTU returns the MTU of the interface, or the MTU of its default
+// route if that is lower, as is common on PPPoE and VPN underlays.
+func underlayMTU(iface *net.Interface) int {
+	mtu := iface.MTU
+
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return mtu
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	scan.Scan() // header
+	for scan.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU ...
+		fields := strings.Fields(scan.Text())
+		if len(fields) < 9 || fields[0] != iface.Name || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		if routeMTU, err := strconv.Atoi(fields[8]); err == nil && routeMTU > 0 && routeMTU < mtu {
+			logrus.Infof("Using MTU %d of the default route over %s", routeMTU, iface.Name)
+			mtu = routeMTU
+		}
+	}
+	return mtu
+}
+
+// setVXLANMTU aligns the MTU of the vxlan device with the overlay MTU, as
+// the device is reused across restarts and keeps the MTU it was created with.
+func setVXLANMTU(mtu int) error {
+	link, err := netlink.LinkByName(vxlanIface)
+	if err != nil {
+		return err
+	}
+	if link.Attrs().MTU == mtu {
+		return nil
+	}
+	return netlink.LinkSetMTU(link, mtu)
+}
@@ -0,0 +1,48 @@
+package flannel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/agent/util"
+	"github.com/rancher/k3s/pkg/daemons/config"
+)
+
+// MultusConf sorts before the flannel config, so that the container runtime
+// uses multus when it is enabled.
+const MultusConf = "00-multus.conf"
+
+// createMultusConf makes multus the network of all pods, delegating the
+// default interface to flannel. Multus reads the additional networks of pods
+// with the kubelet credentials, which the node authorizer restricts to the pods
+// of this node.
+func createMultusConf(config *config.Node) error {
+	dir := config.AgentConfig.CNIConfDir
+	p := filepath.Join(dir, MultusConf)
+	if !config.Multus {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	binDir := config.AgentConfig.CNIBinDir
+	if _, err := os.Stat(filepath.Join(binDir, "multus")); err != nil {
+		return errors.Wrapf(err, "multus is enabled but is not installed in %s", binDir)
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"cniVersion": "0.3.1",
+		"name":       "multus-cni-network",
+		"type":       "multus",
+		"kubeconfig": config.AgentConfig.KubeConfigKubelet,
+		"binDir":     binDir,
+		"delegates":  []json.RawMessage{json.RawMessage(cniConf)},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(p, string(data))
+}
@@ -2,6 +2,10 @@ package flannel
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -9,6 +13,7 @@ import (
 	"github.com/rancher/k3s/pkg/agent/util"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -37,18 +42,34 @@ const (
 `
 	netJSON = `{
     "Network": "%CIDR%",
-    "Backend": {
-    "Type": "vxlan"
-    }
+    "Backend": %BACKEND%
 }
 `
+
+	VXLANBackend = "vxlan"
+	// NoneBackend disables flannel on all nodes, leaving the pod network
+	// to an external CNI plugin
+	NoneBackend = "none"
 )
 
+// ValidBackend checks that a --flannel-backend value is supported.
+func ValidBackend(backend string) error {
+	switch backend {
+	case "", VXLANBackend, HostGWBackend, IPSecBackend, WireguardBackend, NoneBackend:
+		return nil
+	}
+	return fmt.Errorf("unsupported flannel backend %s", backend)
+}
+
 func Prepare(ctx context.Context, config *config.Node) error {
 	if err := createCNIConf(config.AgentConfig.CNIConfDir); err != nil {
 		return err
 	}
 
+	if err := createMultusConf(config); err != nil {
+		return err
+	}
+
 	return createFlannelConf(config)
 }
 
@@ -78,8 +99,15 @@ func Run(ctx context.Context, config *config.Node) error {
 		time.Sleep(2 * time.Second)
 	}
 
+	if config.FlannelBackend == IPSecBackend {
+		if err := writeIPSecPSK(ctx, client, ipsecPSKFile(config)); err != nil {
+			return err
+		}
+	}
+
+	// the node identity of the kubelet may only modify this node
 	go func() {
-		err := flannel(ctx, config.FlannelIface, config.FlannelConf, config.AgentConfig.KubeConfigNode)
+		err := flannel(ctx, config.FlannelIface, config.FlannelConf, config.AgentConfig.KubeConfigKubelet, config.FlannelMTU, config.FlannelExternalIP)
 		logrus.Fatalf("flannel exited: %v", err)
 	}()
 
@@ -98,6 +126,100 @@ func createFlannelConf(config *config.Node) error {
 	if config.FlannelConf == "" {
 		return nil
 	}
-	return util.WriteFile(config.FlannelConf,
-		strings.Replace(netJSON, "%CIDR%", config.AgentConfig.ClusterCIDR.String(), -1))
+	backend, err := backendConf(config)
+	if err != nil {
+		return err
+	}
+	confJSON := strings.Replace(netJSON, "%CIDR%", config.AgentConfig.ClusterCIDR.String(), -1)
+	confJSON = strings.Replace(confJSON, "%BACKEND%", backend, -1)
+	return util.WriteFile(config.FlannelConf, confJSON)
+}
+
+// backendConf returns the flannel backend config for the backend chosen by
+// the server.
+func backendConf(config *config.Node) (string, error) {
+	backend := map[string]interface{}{
+		"Type": VXLANBackend,
+	}
+	switch config.FlannelBackend {
+	case "", VXLANBackend:
+	case HostGWBackend:
+		backend["Type"] = HostGWBackend
+	case IPSecBackend:
+		backend["Type"] = IPSecBackend
+		backend["PSKFile"] = ipsecPSKFile(config)
+	case WireguardBackend:
+		backend["Type"] = WireguardBackend
+		backend["PrivateKeyFile"] = filepath.Join(filepath.Dir(config.FlannelConf), "wireguard.key")
+	default:
+		return "", fmt.Errorf("unsupported flannel backend %s", config.FlannelBackend)
+	}
+	data, err := json.Marshal(backend)
+	return string(data), err
+}
+
+func ipsecPSKFile(config *config.Node) string {
+	return filepath.Join(filepath.Dir(config.FlannelConf), "ipsec.psk")
+}
+
+// writeIPSecPSK waits for the server to create the pre-shared key of the
+// ipsec backend, and writes it where the backend reads it.
+func writeIPSecPSK(ctx context.Context, client kubernetes.Interface, file string) error {
+	for {
+		secret, err := client.CoreV1().Secrets(metav1.NamespaceSystem).Get(IPSecSecretName, metav1.GetOptions{})
+		if err == nil && len(secret.Data[IPSecSecretKey]) > 0 {
+			return ioutil.WriteFile(file, secret.Data[IPSecSecretKey], 0600)
+		}
+		if err == nil {
+			err = fmt.Errorf("%s has no %s", IPSecSecretName, IPSecSecretKey)
+		}
+		logrus.Infof("waiting for the ipsec pre-shared key: %v", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Cleanup removes what flannel left behind on a node that ran it before,
+// so that it does not compete with an external CNI plugin: the k3s CNI config,
+// the subnet file, the tunnel devices and the ipsec policies. The CNI bridge
+// and everything in the default CNI directories are left alone. The CNI config
+// written by k3s marks a node that ran flannel, it is removed last so that
+// later starts leave the node alone.
+func Cleanup(dataDir string) {
+	conflist := filepath.Join(dataDir, "etc/cni/net.d/10-flannel.conflist")
+	if _, err := os.Stat(conflist); err != nil {
+		return
+	}
+	logrus.Infof("Found %s, cleaning up after flannel, which is now disabled", conflist)
+
+	for _, file := range []string{filepath.Join(dataDir, "etc/cni/net.d", MultusConf), subnetFile} {
+		if err := os.Remove(file); err == nil {
+			logrus.Infof("Removed %s, flannel is disabled", file)
+		} else if !os.IsNotExist(err) {
+			logrus.Warnf("Failed to remove %s: %v", file, err)
+		}
+	}
+
+	for _, name := range []string{vxlanIface, wireguardIface} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			logrus.Warnf("Failed to delete the flannel interface %s: %v", name, err)
+		} else {
+			logrus.Infof("Deleted the flannel interface %s, flannel is disabled", name)
+		}
+	}
+
+	if err := ipsecFlush(); err != nil {
+		logrus.Debugf("Failed to remove flannel ipsec policies: %v", err)
+	}
+
+	if err := os.Remove(conflist); err != nil {
+		logrus.Warnf("Failed to remove %s: %v", conflist, err)
+	}
 }
@@ -0,0 +1,281 @@
+package flannel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/coreos/flannel/backend"
+	"github.com/coreos/flannel/pkg/ip"
+	"github.com/coreos/flannel/subnet"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+const (
+	// WireguardBackend is the flannel backend type of the WireGuard overlay.
+	WireguardBackend = "wireguard-native"
+
+	wireguardIface      = "flannel-wg"
+	wireguardListenPort = 51820
+	// wireguardOverhead is the encapsulation overhead of WireGuard over IPv4
+	wireguardOverhead = 60
+)
+
+func init() {
+	backend.Register(WireguardBackend, newWireguardBackend)
+}
+
+// wireguardLeaseAttrs is published as the lease backend data, which the kube
+// subnet manager stores in the node's flannel backend-data annotation.
+type wireguardLeaseAttrs struct {
+	PublicKey string
+}
+
+type wireguardBackend struct {
+	sm       subnet.Manager
+	extIface *backend.ExternalInterface
+}
+
+func newWireguardBackend(sm subnet.Manager, extIface *backend.ExternalInterface) (backend.Backend, error) {
+	return &wireguardBackend{
+		sm:       sm,
+		extIface: extIface,
+	}, nil
+}
+
+// RegisterNetwork sets up the wireguard interface and acquires the node's
+// lease. The backend interface passes the WaitGroup by value.
+func (be *wireguardBackend) RegisterNetwork(ctx context.Context, wg sync.WaitGroup, config *subnet.Config) (backend.Network, error) { //nolint:govet
+	cfg := struct {
+		PrivateKeyFile string
+		ListenPort     int
+	}{
+		ListenPort: wireguardListenPort,
+	}
+	if len(config.Backend) > 0 {
+		if err := json.Unmarshal(config.Backend, &cfg); err != nil {
+			return nil, errors.Wrap(err, "error decoding wireguard backend config")
+		}
+	}
+	if cfg.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("wireguard backend config is missing PrivateKeyFile")
+	}
+
+	publicKey, err := wireguardKey(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := wireguardLink(be.extIface.Iface.MTU - wireguardOverhead)
+	if err != nil {
+		return nil, err
+	}
+	if err := wgSet("listen-port", fmt.Sprint(cfg.ListenPort), "private-key", cfg.PrivateKeyFile); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(&wireguardLeaseAttrs{PublicKey: publicKey})
+	if err != nil {
+		return nil, err
+	}
+	lease, err := be.sm.AcquireLease(ctx, &subnet.LeaseAttrs{
+		PublicIP:    ip.FromIP(be.extIface.ExtAddr),
+		BackendType: WireguardBackend,
+		BackendData: json.RawMessage(data),
+	})
+	switch err {
+	case nil:
+	case context.Canceled, context.DeadlineExceeded:
+		return nil, err
+	default:
+		return nil, fmt.Errorf("failed to acquire lease: %v", err)
+	}
+
+	// as with vxlan, a /32 address gives host to workload traffic a source
+	// address on the flannel network without adding broadcast routes
+	if err := ip.EnsureV4AddressOnLink(ip.IP4Net{IP: lease.Subnet.IP, PrefixLen: 32}, link); err != nil {
+		return nil, fmt.Errorf("failed to ensure address of interface %s: %s", wireguardIface, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set interface %s to UP state: %s", wireguardIface, err)
+	}
+
+	return &wireguardNetwork{
+		SimpleNetwork: backend.SimpleNetwork{
+			SubnetLease: lease,
+			ExtIface:    be.extIface,
+		},
+		sm:         be.sm,
+		link:       link,
+		listenPort: cfg.ListenPort,
+		peers:      map[string]string{},
+	}, nil
+}
+
+type wireguardNetwork struct {
+	backend.SimpleNetwork
+	sm         subnet.Manager
+	link       netlink.Link
+	listenPort int
+	// peers maps subnet keys to the public key of their node
+	peers map[string]string
+}
+
+func (n *wireguardNetwork) MTU() int {
+	return n.link.Attrs().MTU
+}
+
+func (n *wireguardNetwork) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+
+	logrus.Info("Watching for new subnet leases")
+	events := make(chan []subnet.Event)
+	wg.Add(1)
+	go func() {
+		subnet.WatchLeases(ctx, n.sm, n.SubnetLease, events)
+		wg.Done()
+	}()
+	defer wg.Wait()
+
+	for {
+		select {
+		case batch := <-events:
+			for _, event := range batch {
+				n.handleEvent(event)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *wireguardNetwork) handleEvent(event subnet.Event) {
+	lease := event.Lease
+	if lease.Attrs.BackendType != WireguardBackend {
+		logrus.Warnf("Ignoring non-%s subnet %s: type=%s", WireguardBackend, lease.Subnet, lease.Attrs.BackendType)
+		return
+	}
+	route := &netlink.Route{
+		LinkIndex: n.link.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Dst:       lease.Subnet.ToIPNet(),
+	}
+
+	switch event.Type {
+	case subnet.EventAdded:
+		attrs := wireguardLeaseAttrs{}
+		if err := json.Unmarshal(lease.Attrs.BackendData, &attrs); err != nil || attrs.PublicKey == "" {
+			logrus.Errorf("Ignoring subnet %s without a wireguard public key: %v", lease.Subnet, err)
+			return
+		}
+		logrus.Infof("Subnet added: %s via %s", lease.Subnet, lease.Attrs.PublicIP)
+		if old, ok := n.peers[lease.Key()]; ok && old != attrs.PublicKey {
+			n.removePeer(old)
+		}
+		endpoint := fmt.Sprintf("%s:%d", lease.Attrs.PublicIP, n.listenPort)
+		if err := wgSet("peer", attrs.PublicKey, "endpoint", endpoint,
+			"allowed-ips", lease.Subnet.String(), "persistent-keepalive", "25"); err != nil {
+			logrus.Errorf("Failed to add wireguard peer for %s: %v", lease.Subnet, err)
+			return
+		}
+		n.peers[lease.Key()] = attrs.PublicKey
+		if err := netlink.RouteReplace(route); err != nil {
+			logrus.Errorf("Failed to add route to %s: %v", lease.Subnet, err)
+		}
+
+	case subnet.EventRemoved:
+		logrus.Infof("Subnet removed: %s", lease.Subnet)
+		if publicKey, ok := n.peers[lease.Key()]; ok {
+			n.removePeer(publicKey)
+			delete(n.peers, lease.Key())
+		}
+		if err := netlink.RouteDel(route); err != nil && err != syscall.ESRCH {
+			logrus.Errorf("Failed to delete route to %s: %v", lease.Subnet, err)
+		}
+	}
+}
+
+func (n *wireguardNetwork) removePeer(publicKey string) {
+	if err := wgSet("peer", publicKey, "remove"); err != nil {
+		logrus.Errorf("Failed to remove wireguard peer %s: %v", publicKey, err)
+	}
+}
+
+// wireguardKey creates the node's private key if it does not exist yet, and
+// returns its public key.
+func wireguardKey(privateKeyFile string) (string, error) {
+	if _, err := os.Stat(privateKeyFile); os.IsNotExist(err) {
+		privateKey, err := wgCommand("genkey", nil)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(privateKeyFile), 0700); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(privateKeyFile, []byte(privateKey+"\n"), 0600); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	privateKey, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return wgCommand("pubkey", privateKey)
+}
+
+// wireguardLink returns the wireguard interface, creating it if needed.
+func wireguardLink(mtu int) (netlink.Link, error) {
+	link, err := netlink.LinkByName(wireguardIface)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = wireguardIface
+		attrs.MTU = mtu
+		if err := netlink.LinkAdd(&netlink.GenericLink{LinkAttrs: attrs, LinkType: "wireguard"}); err != nil {
+			return nil, errors.Wrapf(err, "failed to create %s, is the wireguard kernel module available", wireguardIface)
+		}
+		return netlink.LinkByName(wireguardIface)
+	} else if err != nil {
+		return nil, err
+	}
+	if link.Type() != "wireguard" {
+		return nil, fmt.Errorf("%s exists but is a %s interface", wireguardIface, link.Type())
+	}
+	if link.Attrs().MTU != mtu {
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			return nil, err
+		}
+	}
+	return link, nil
+}
+
+// wgCommand runs the wireguard tool, returning its trimmed output.
+func wgCommand(command string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("wg", append([]string{command}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "wg %s: %s", command, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// wgSet changes the configuration of the wireguard interface.
+func wgSet(args ...string) error {
+	_, err := wgCommand("set", nil, append([]string{wireguardIface}, args...)...)
+	return err
+}
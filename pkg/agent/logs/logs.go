@@ -0,0 +1,210 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Socket is the unix socket the log server listens on, in a directory only
+	// root can access; the server reaches it through the agent tunnel.
+	Socket = "/run/k3s/logs/logs.sock"
+
+	defaultTail = 100
+)
+
+// klogLine matches the header of lines logged by the embedded Kubernetes components
+var klogLine = regexp.MustCompile(`^[IWEF]\d{4} `)
+
+// Run serves this node's k3s and containerd logs on a unix socket only root can use.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	dir := filepath.Dir(Socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Remove(Socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", Socket)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler: handler(nodeConfig),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Log server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func handler(nodeConfig *config.Node) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		component := strings.TrimPrefix(req.URL.Path, "/logs/")
+		follow, _ := strconv.ParseBool(req.URL.Query().Get("follow"))
+		tail := defaultTail
+		if v := req.URL.Query().Get("tail"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(resp, "invalid tail "+v, http.StatusBadRequest)
+				return
+			}
+			tail = n
+		}
+
+		var (
+			args   func(lines string, follow bool) []string
+			filter func(string) bool
+		)
+		switch component {
+		case "containerd":
+			if nodeConfig.Containerd.Log == "" {
+				http.Error(resp, "containerd is not logging to a file on this node", http.StatusNotFound)
+				return
+			}
+			args = tailArgs(nodeConfig.Containerd.Log)
+		case "buildkit":
+			if nodeConfig.Buildkit.Log == "" {
+				http.Error(resp, "buildkitd is not logging to a file on this node", http.StatusNotFound)
+				return
+			}
+			args = tailArgs(nodeConfig.Buildkit.Log)
+		case "kubelet", "agent":
+			if nodeConfig.LogFile != "" {
+				args = tailArgs(nodeConfig.LogFile)
+			} else {
+				args = journalArgs
+			}
+			kubelet := component == "kubelet"
+			filter = func(line string) bool {
+				return klogLine.MatchString(line) == kubelet
+			}
+		default:
+			http.Error(resp, fmt.Sprintf("unknown component %q", component), http.StatusNotFound)
+			return
+		}
+
+		resp.Header().Set("Content-Type", "text/plain")
+		if filter == nil {
+			if err := run(req.Context(), args(strconv.Itoa(tail), follow), lineWriter(resp, nil)); err != nil {
+				http.Error(resp, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// tail counts the lines left after filtering, so the whole log is read
+		// and only the last matching lines are kept
+		var last []string
+		err := run(req.Context(), args(allLines, false), func(line string) bool {
+			if filter(line) && tail > 0 {
+				if len(last) == tail {
+					last = last[1:]
+				}
+				last = append(last, line)
+			}
+			return true
+		})
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		write := lineWriter(resp, filter)
+		for _, line := range last {
+			if !write(line) {
+				return
+			}
+		}
+		if follow {
+			run(req.Context(), args("0", true), write)
+		}
+	})
+}
+
+// allLines asks tail and journalctl for the whole log.
+const allLines = "all"
+
+func tailArgs(file string) func(lines string, follow bool) []string {
+	return func(lines string, follow bool) []string {
+		if lines == allLines {
+			lines = "+1"
+		}
+		args := []string{"tail", "-n", lines}
+		if follow {
+			args = append(args, "-F")
+		}
+		return append(args, file)
+	}
+}
+
+// journalArgs reads the journal entries of this process, which is where the
+// agent and the embedded kubelet log to when k3s runs as a systemd unit.
+func journalArgs(lines string, follow bool) []string {
+	args := []string{"journalctl", "--no-pager", "-o", "cat", "-n", lines, "_PID=" + strconv.Itoa(os.Getpid())}
+	if follow {
+		args = append(args, "-f")
+	}
+	return args
+}
+
+// run passes each line the command prints to fn, until fn returns false.
+func run(ctx context.Context, args []string, fn func(string) bool) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if !fn(scanner.Text()) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// lineWriter copies lines accepted by filter to resp, flushing after each line
+// so that followed logs show up immediately.
+func lineWriter(resp http.ResponseWriter, filter func(string) bool) func(string) bool {
+	flusher, _ := resp.(http.Flusher)
+	return func(line string) bool {
+		if filter != nil && !filter(line) {
+			return true
+		}
+		if _, err := io.WriteString(resp, line+"\n"); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+}
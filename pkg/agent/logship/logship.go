@@ -0,0 +1,213 @@
+package logship
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	podLogsDir   = "/var/log/pods"
+	pollInterval = 2 * time.Second
+	maxRead      = 1024 * 1024
+)
+
+// entry is a single container log line.
+type entry struct {
+	time      time.Time
+	namespace string
+	pod       string
+	container string
+	stream    string
+	line      string
+}
+
+// sink forwards log entries to a collector.
+type sink interface {
+	send(entries []entry) error
+}
+
+// file tracks how far a container log file has been forwarded.
+type file struct {
+	inode   uint64
+	offset  int64
+	partial string
+
+	namespace, pod, container string
+}
+
+// Run forwards the logs of the containers on this node to the collector at
+// nodeConfig.LogForwardURL. Only logs written after startup are forwarded
+// for containers that already exist.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	if nodeConfig.Docker {
+		logrus.Warn("Log forwarding reads CRI formatted logs and is not supported with docker")
+		return nil
+	}
+
+	s, err := newSink(nodeConfig.LogForwardURL, nodeConfig.AgentConfig.NodeName)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*file{}
+	scan(files, true)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+
+			scan(files, false)
+			for path, f := range files {
+				if err := f.forward(path, s); err != nil {
+					logrus.Errorf("Failed to forward logs from %s: %v", path, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// scan adds new log files to files and removes those that are gone. Files
+// found at startup are forwarded from their current end.
+func scan(files map[string]*file, startup bool) {
+	paths, _ := filepath.Glob(filepath.Join(podLogsDir, "*", "*", "*.log"))
+	seen := map[string]bool{}
+	for _, path := range paths {
+		seen[path] = true
+		if _, ok := files[path]; ok {
+			continue
+		}
+
+		// pod log dirs are named <namespace>_<pod>_<uid>
+		parts := strings.SplitN(filepath.Base(filepath.Dir(filepath.Dir(path))), "_", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		f := &file{
+			namespace: parts[0],
+			pod:       parts[1],
+			container: filepath.Base(filepath.Dir(path)),
+		}
+		if startup {
+			if info, err := os.Stat(path); err == nil {
+				f.inode = inode(info)
+				f.offset = info.Size()
+			}
+		}
+		files[path] = f
+	}
+	for path := range files {
+		if !seen[path] {
+			delete(files, path)
+		}
+	}
+}
+
+// forward sends the complete lines appended to the file since the last call.
+// The offset only advances once the lines have been sent, so nothing is lost
+// while the collector is unreachable.
+func (f *file) forward(path string, s sink) error {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	info, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	// the kubelet rotates logs by renaming them, so a new inode means a new file
+	if ino := inode(info); ino != f.inode || info.Size() < f.offset {
+		f.inode = ino
+		f.offset = 0
+		f.partial = ""
+	}
+	if info.Size() == f.offset {
+		return nil
+	}
+
+	buf := make([]byte, min(info.Size()-f.offset, maxRead))
+	n, err := fh.ReadAt(buf, f.offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	buf = buf[:n]
+	end := bytes.LastIndexByte(buf, '\n')
+	if end < 0 {
+		return nil
+	}
+
+	partial := f.partial
+	var entries []entry
+	for _, line := range strings.Split(string(buf[:end]), "\n") {
+		e, ok := f.parse(line, &partial)
+		if ok {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > 0 {
+		if err := s.send(entries); err != nil {
+			return err
+		}
+	}
+	f.offset += int64(end + 1)
+	f.partial = partial
+	return nil
+}
+
+// parse reads a line in the CRI log format, "<time> <stream> <P|F> <message>",
+// joining partial lines.
+func (f *file) parse(line string, partial *string) (entry, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return entry{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return entry{}, false
+	}
+	if fields[2] == "P" {
+		*partial += fields[3]
+		return entry{}, false
+	}
+	message := *partial + fields[3]
+	*partial = ""
+	return entry{
+		time:      t,
+		namespace: f.namespace,
+		pod:       f.pod,
+		container: f.container,
+		stream:    fields[1],
+		line:      message,
+	}, true
+}
+
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
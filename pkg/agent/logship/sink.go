@@ -0,0 +1,144 @@
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func newSink(forwardURL, nodeName string) (sink, error) {
+	u, err := url.Parse(forwardURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid log forward URL")
+	}
+	logrus.Infof("Forwarding container logs to %s", u.Host)
+
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		return &syslogSink{network: "udp", address: u.Host, hostname: nodeName}, nil
+	case "syslog+tcp":
+		return &syslogSink{network: "tcp", address: u.Host, hostname: nodeName}, nil
+	case "http", "https":
+		return &lokiSink{
+			url:      u.String(),
+			nodeName: nodeName,
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported log forward URL scheme %q, expected syslog, syslog+tcp, http or https", u.Scheme)
+}
+
+// syslogSink sends entries as RFC 5424 messages, one per line.
+type syslogSink struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+func (s *syslogSink) send(entries []entry) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		// facility user, severity info for stdout and error for stderr
+		priority := 14
+		if e.stream == "stderr" {
+			priority = 11
+		}
+		fmt.Fprintf(&buf, "<%d>1 %s %s %s - - [k3s namespace=%q pod=%q container=%q] %s\n",
+			priority, e.time.Format(time.RFC3339Nano), s.hostname, e.container, e.namespace, e.pod, e.container, e.line)
+		if s.network == "udp" {
+			if err := s.write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+	}
+	return s.write(buf.Bytes())
+}
+
+func (s *syslogSink) write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(b); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// lokiSink pushes entries to the Loki push API, with a stream per container.
+type lokiSink struct {
+	url      string
+	nodeName string
+	client   *http.Client
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][]string        `json:"values"`
+}
+
+func (s *lokiSink) send(entries []entry) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, e := range entries {
+		key := e.namespace + "/" + e.pod + "/" + e.container + "/" + e.stream
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{
+				Stream: map[string]string{
+					"node":      s.nodeName,
+					"namespace": e.namespace,
+					"pod":       e.pod,
+					"container": e.container,
+					"stream":    e.stream,
+				},
+			}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, []string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+	}
+
+	body := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range order {
+		body.Streams = append(body.Streams, streams[key])
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,622 @@
+package netpol
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	chainPrefix  = "K3S-NP-"
+	forwardChain = chainPrefix + "FORWARD"
+	// acceptMark is set on a new connection by the policies that allow it
+	acceptMark = "0x10000"
+	setMark    = "-j MARK --set-xmark " + acceptMark + "/" + acceptMark
+	clearMark  = "-j MARK --set-xmark 0x0/" + acceptMark
+	resync     = 5 * time.Minute
+)
+
+var forwardJump = []string{"-m", "comment", "--comment", "k3s network policies", "-j", forwardChain}
+
+type controller struct {
+	nodeName   string
+	ipt        *iptables.IPTables
+	pods       corelisters.PodLister
+	namespaces corelisters.NamespaceLister
+	policies   networkinglisters.NetworkPolicyLister
+	changed    chan struct{}
+	recorder   record.EventRecorder
+	// reported holds the problems already recorded as events, per policy
+	// revision
+	reported map[string]bool
+	// tested holds the result of testing the rules of a policy with
+	// iptables-restore, by the rules
+	tested map[string]error
+}
+
+// Run enforces NetworkPolicy objects for the pods on this node, with iptables
+// chains in the filter table that new connections forwarded to or from the
+// pods go through. Connections that the policies selecting a pod do not allow
+// are dropped, traffic of other pods is not affected.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigNode)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	ipt, err := iptables.New()
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, resync)
+	pods := factory.Core().V1().Pods()
+	namespaces := factory.Core().V1().Namespaces()
+	policies := factory.Networking().V1().NetworkPolicies()
+
+	c := &controller{
+		nodeName:   nodeConfig.AgentConfig.NodeName,
+		ipt:        ipt,
+		pods:       pods.Lister(),
+		namespaces: namespaces.Lister(),
+		policies:   policies.Lister(),
+		changed:    make(chan struct{}, 1),
+		recorder:   newEventRecorder(client, nodeConfig.AgentConfig.NodeName),
+		reported:   map[string]bool{},
+		tested:     map[string]error{},
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.enqueue() },
+		UpdateFunc: func(interface{}, interface{}) { c.enqueue() },
+		DeleteFunc: func(interface{}) { c.enqueue() },
+	}
+	for _, informer := range []cache.SharedIndexInformer{pods.Informer(), namespaces.Informer(), policies.Informer()} {
+		informer.AddEventHandler(handler)
+	}
+	factory.Start(ctx.Done())
+
+	go func() {
+		if !cache.WaitForCacheSync(ctx.Done(), pods.Informer().HasSynced, namespaces.Informer().HasSynced, policies.Informer().HasSynced) {
+			return
+		}
+		logrus.Info("Network policy controller started")
+		for {
+			if err := c.sync(); err != nil {
+				logrus.Errorf("Failed to sync network policies: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.changed:
+				// batch the changes of pods starting or stopping together
+				time.Sleep(time.Second)
+			case <-time.After(resync):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func newEventRecorder(client kubernetes.Interface, nodeName string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "k3s-network-policy", Host: nodeName})
+}
+
+// Cleanup removes the network policy chains, when the controller is disabled.
+func Cleanup() {
+	ipt, err := iptables.New()
+	if err != nil {
+		return
+	}
+	if err := ipt.Delete("filter", "FORWARD", forwardJump...); err == nil {
+		logrus.Info("Removed the network policy rules, the network policy controller is disabled")
+	}
+	chains, err := ipt.ListChains("filter")
+	if err != nil {
+		return
+	}
+	deleteChains(ipt, ownChains(chains, nil))
+}
+
+func (c *controller) enqueue() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// sync replaces the network policy chains in one iptables-restore, so that
+// connections are never checked against half written rules.
+func (c *controller) sync() error {
+	pods, err := c.pods.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	namespaces, err := c.namespaces.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	policies, err := c.policies.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	var running []*corev1.Pod
+	for _, pod := range pods {
+		if pod.Status.PodIP != "" && !pod.Spec.HostNetwork && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			running = append(running, pod)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return podKey(running[i]) < podKey(running[j]) })
+	sort.Slice(policies, func(i, j int) bool { return policyKey(policies[i]) < policyKey(policies[j]) })
+
+	r := &ruleset{chains: map[string][]string{}}
+	r.add(forwardChain, "-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN")
+
+	policyChains := map[string]string{}
+	tested := c.tested
+	c.tested = map[string]error{}
+	for _, pod := range running {
+		if pod.Spec.NodeName != c.nodeName {
+			continue
+		}
+		var ingress, egress []string
+		for _, policy := range policies {
+			if !selects(policy, pod) {
+				continue
+			}
+			hasIngress, hasEgress := policyTypes(policy)
+			if hasIngress {
+				ingress = append(ingress, c.policyChain(r, policyChains, tested, policy, true, running, namespaces))
+			}
+			if hasEgress {
+				egress = append(egress, c.policyChain(r, policyChains, tested, policy, false, running, namespaces))
+			}
+		}
+		if len(egress) > 0 {
+			chain := chainName("PO", podKey(pod))
+			addPodChain(r, chain, egress)
+			r.add(forwardChain, fmt.Sprintf("-s %s/32 -j %s", pod.Status.PodIP, chain))
+		}
+		if len(ingress) > 0 {
+			chain := chainName("PI", podKey(pod))
+			addPodChain(r, chain, ingress)
+			r.add(forwardChain, fmt.Sprintf("-d %s/32 -j %s", pod.Status.PodIP, chain))
+		}
+	}
+	r.add(forwardChain, clearMark)
+
+	if err := restore(r); err != nil {
+		return err
+	}
+	if exists, err := c.ipt.Exists("filter", "FORWARD", forwardJump...); err != nil {
+		return err
+	} else if !exists {
+		if err := c.ipt.Insert("filter", "FORWARD", 1, forwardJump...); err != nil {
+			return err
+		}
+	}
+
+	chains, err := c.ipt.ListChains("filter")
+	if err != nil {
+		return err
+	}
+	deleteChains(c.ipt, ownChains(chains, r.chains))
+	return nil
+}
+
+// policyChain adds the chain of a policy for one direction, shared by the pods
+// the policy selects, which marks the connections the policy allows. Rules
+// iptables rejects would fail the restore of all policies, so a policy whose
+// rules fail a test restore allows nothing instead.
+func (c *controller) policyChain(r *ruleset, chains map[string]string, tested map[string]error, policy *networkingv1.NetworkPolicy, ingress bool, pods []*corev1.Pod, namespaces []*corev1.Namespace) string {
+	direction, kind := "egress", "NO"
+	if ingress {
+		direction, kind = "ingress", "NI"
+	}
+	key := policyKey(policy) + "/" + direction
+	if chain, ok := chains[key]; ok {
+		return chain
+	}
+	chain := chainName(kind, key)
+	chains[key] = chain
+	p := &ruleset{chains: map[string][]string{}}
+	p.add(chain)
+	c.policyRules(p, chain, key, direction, policy, ingress, pods, namespaces)
+
+	rules := p.String()
+	err, ok := tested[rules]
+	if !ok {
+		err = test(p)
+	}
+	c.tested[rules] = err
+	if err != nil {
+		c.report(policy, "network policy %s allows no %s traffic, iptables rejects its rules: %v", policyKey(policy), direction, err)
+		p = &ruleset{chains: map[string][]string{}}
+		p.add(chain)
+	}
+	r.merge(p)
+	return chain
+}
+
+// policyRules adds the rules of a policy for one direction to the chain.
+func (c *controller) policyRules(r *ruleset, chain, key, direction string, policy *networkingv1.NetworkPolicy, ingress bool, pods []*corev1.Pod, namespaces []*corev1.Namespace) {
+	peerFlag := "-d"
+	if ingress {
+		peerFlag = "-s"
+	}
+
+	var selected []*corev1.Pod
+	for _, pod := range pods {
+		if pod.Spec.NodeName == c.nodeName && selects(policy, pod) {
+			selected = append(selected, pod)
+		}
+	}
+
+	type rule struct {
+		peers []networkingv1.NetworkPolicyPeer
+		ports []networkingv1.NetworkPolicyPort
+	}
+	var rules []rule
+	if ingress {
+		for _, in := range policy.Spec.Ingress {
+			rules = append(rules, rule{in.From, in.Ports})
+		}
+	} else {
+		for _, out := range policy.Spec.Egress {
+			rules = append(rules, rule{out.To, out.Ports})
+		}
+	}
+
+	// named ports of ingress rules are those of the selected pods, of
+	// egress rules those of the destination, any pod unless the rule has
+	// pod peers
+	resolve := selected
+	if !ingress {
+		resolve = pods
+	}
+
+	for i, rule := range rules {
+		if len(rule.peers) == 0 {
+			for _, port := range portMatches(rule.ports, resolve, true) {
+				r.add(chain, joinRule(port, setMark))
+			}
+			continue
+		}
+
+		for j, peer := range rule.peers {
+			if peer.IPBlock != nil {
+				cidr, except, err := ipv4Block(peer.IPBlock)
+				if err != nil {
+					c.report(policy, "network policy %s: ignoring %s peer: %v", policyKey(policy), direction, err)
+					continue
+				}
+				block := chainName("B", fmt.Sprintf("%s/%d/%d", key, i, j))
+				for _, e := range except {
+					r.add(block, fmt.Sprintf("%s %s -j RETURN", peerFlag, e))
+				}
+				r.add(block, fmt.Sprintf("%s %s %s", peerFlag, cidr, setMark))
+				for _, port := range portMatches(rule.ports, resolve, true) {
+					r.add(chain, joinRule(port, "-j "+block))
+				}
+				continue
+			}
+
+			for _, peerPod := range peerPods(policy, peer, pods, namespaces) {
+				peerMatch := fmt.Sprintf("%s %s/32", peerFlag, peerPod.Status.PodIP)
+				if ingress {
+					for _, port := range portMatches(rule.ports, resolve, true) {
+						r.add(chain, joinRule(peerMatch, port, setMark))
+					}
+					continue
+				}
+				// the peer match already restricts the destination
+				for _, port := range portMatches(rule.ports, []*corev1.Pod{peerPod}, false) {
+					r.add(chain, joinRule(peerMatch, port, setMark))
+				}
+			}
+		}
+	}
+}
+
+// ipv4Block returns the CIDR and exceptions of an ipBlock. Only IPv4 traffic
+// is policed, so IPv6 exceptions are dropped, and a block of another family or
+// with invalid CIDRs is an error.
+func ipv4Block(block *networkingv1.IPBlock) (string, []string, error) {
+	_, cidr, err := net.ParseCIDR(block.CIDR)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid ipBlock CIDR %q", block.CIDR)
+	}
+	if cidr.IP.To4() == nil {
+		return "", nil, fmt.Errorf("ipBlock CIDR %s is not IPv4, only IPv4 traffic is policed", block.CIDR)
+	}
+	var except []string
+	for _, e := range block.Except {
+		_, exceptCIDR, err := net.ParseCIDR(e)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid ipBlock except CIDR %q", e)
+		}
+		if exceptCIDR.IP.To4() != nil {
+			except = append(except, exceptCIDR.String())
+		}
+	}
+	return cidr.String(), except, nil
+}
+
+// report records a problem with a policy as a warning event, once per
+// revision of the policy.
+func (c *controller) report(policy *networkingv1.NetworkPolicy, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	key := policyKey(policy) + "/" + policy.ResourceVersion + "/" + message
+	if c.reported[key] {
+		return
+	}
+	c.reported[key] = true
+	logrus.Warn(message)
+	c.recorder.Event(policy, corev1.EventTypeWarning, "InvalidNetworkPolicy", message)
+}
+
+// addPodChain checks new connections of a pod against the policies selecting
+// it, and drops those none of them allow.
+func addPodChain(r *ruleset, chain string, policyChains []string) {
+	r.add(chain, clearMark)
+	for _, policyChain := range policyChains {
+		r.add(chain, "-j "+policyChain)
+	}
+	r.add(chain, "-m mark --mark "+acceptMark+"/"+acceptMark+" -j RETURN")
+	r.add(chain, "-j DROP")
+}
+
+// portMatches returns the matches for the ports of a rule, a single empty
+// match if it allows all ports. Named ports are resolved against the container
+// ports of pods, with bind they only match the pod they resolved on.
+func portMatches(ports []networkingv1.NetworkPolicyPort, pods []*corev1.Pod, bind bool) []string {
+	if len(ports) == 0 {
+		return []string{""}
+	}
+
+	var matches []string
+	for _, port := range ports {
+		protocol := corev1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+		proto := strings.ToLower(string(protocol))
+
+		switch {
+		case port.Port == nil:
+			matches = append(matches, "-p "+proto)
+		case port.Port.Type == intstr.Int:
+			matches = append(matches, fmt.Sprintf("-p %s --dport %d", proto, port.Port.IntVal))
+		default:
+			for _, pod := range pods {
+				number, ok := containerPort(pod, port.Port.StrVal, protocol)
+				if !ok {
+					continue
+				}
+				match := fmt.Sprintf("-p %s --dport %d", proto, number)
+				if bind {
+					match = fmt.Sprintf("-d %s/32 %s", pod.Status.PodIP, match)
+				}
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches
+}
+
+func containerPort(pod *corev1.Pod, name string, protocol corev1.Protocol) (int32, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			portProtocol := port.Protocol
+			if portProtocol == "" {
+				portProtocol = corev1.ProtocolTCP
+			}
+			if port.Name == name && portProtocol == protocol {
+				return port.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// peerPods returns the pods a peer of a policy rule selects, in the namespace
+// of the policy unless it has a namespace selector.
+func peerPods(policy *networkingv1.NetworkPolicy, peer networkingv1.NetworkPolicyPeer, pods []*corev1.Pod, namespaces []*corev1.Namespace) []*corev1.Pod {
+	podSelector := labels.Everything()
+	if peer.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+		if err != nil {
+			logrus.Warnf("Invalid pod selector in network policy %s: %v", policyKey(policy), err)
+			return nil
+		}
+		podSelector = selector
+	}
+
+	inNamespace := map[string]bool{policy.Namespace: true}
+	if peer.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		if err != nil {
+			logrus.Warnf("Invalid namespace selector in network policy %s: %v", policyKey(policy), err)
+			return nil
+		}
+		inNamespace = map[string]bool{}
+		for _, namespace := range namespaces {
+			if selector.Matches(labels.Set(namespace.Labels)) {
+				inNamespace[namespace.Name] = true
+			}
+		}
+	}
+
+	var selected []*corev1.Pod
+	for _, pod := range pods {
+		if inNamespace[pod.Namespace] && podSelector.Matches(labels.Set(pod.Labels)) {
+			selected = append(selected, pod)
+		}
+	}
+	return selected
+}
+
+func selects(policy *networkingv1.NetworkPolicy, pod *corev1.Pod) bool {
+	if policy.Namespace != pod.Namespace {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+// policyTypes returns whether a policy isolates the pods it selects for
+// ingress and egress, by default ingress and, if it has egress rules, egress.
+func policyTypes(policy *networkingv1.NetworkPolicy) (ingress, egress bool) {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return true, len(policy.Spec.Egress) > 0
+	}
+	for _, policyType := range policy.Spec.PolicyTypes {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			ingress = true
+		case networkingv1.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return
+}
+
+type ruleset struct {
+	order  []string
+	chains map[string][]string
+}
+
+func (r *ruleset) add(chain string, rules ...string) {
+	if _, ok := r.chains[chain]; !ok {
+		r.order = append(r.order, chain)
+	}
+	r.chains[chain] = append(r.chains[chain], rules...)
+}
+
+// merge adds the chains of o.
+func (r *ruleset) merge(o *ruleset) {
+	for _, chain := range o.order {
+		r.add(chain, o.chains[chain]...)
+	}
+}
+
+// String returns the ruleset in the format of iptables-restore.
+func (r *ruleset) String() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("*filter\n")
+	for _, chain := range r.order {
+		fmt.Fprintf(buf, ":%s - [0:0]\n", chain)
+	}
+	for _, chain := range r.order {
+		for _, rule := range r.chains[chain] {
+			fmt.Fprintf(buf, "-A %s %s\n", chain, rule)
+		}
+	}
+	buf.WriteString("COMMIT\n")
+	return buf.String()
+}
+
+func restore(r *ruleset) error {
+	return iptablesRestore(r, "--noflush")
+}
+
+// test checks that iptables accepts the rules, without applying them.
+func test(r *ruleset) error {
+	return iptablesRestore(r, "--noflush", "--test")
+}
+
+func iptablesRestore(r *ruleset, args ...string) error {
+	cmd := exec.Command("iptables-restore", args...)
+	cmd.Stdin = strings.NewReader(r.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables-restore: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ownChains returns the network policy chains that are not in keep.
+func ownChains(chains []string, keep map[string][]string) []string {
+	var own []string
+	for _, chain := range chains {
+		if _, ok := keep[chain]; !ok && strings.HasPrefix(chain, chainPrefix) {
+			own = append(own, chain)
+		}
+	}
+	return own
+}
+
+// deleteChains flushes all the chains before deleting them, as they may jump
+// to each other.
+func deleteChains(ipt *iptables.IPTables, chains []string) {
+	for _, chain := range chains {
+		if err := ipt.ClearChain("filter", chain); err != nil {
+			logrus.Debugf("Failed to flush network policy chain %s: %v", chain, err)
+		}
+	}
+	for _, chain := range chains {
+		if err := ipt.DeleteChain("filter", chain); err != nil {
+			logrus.Debugf("Failed to delete network policy chain %s: %v", chain, err)
+		}
+	}
+}
+
+func joinRule(parts ...string) string {
+	var rule []string
+	for _, part := range parts {
+		if part != "" {
+			rule = append(rule, part)
+		}
+	}
+	return strings.Join(rule, " ")
+}
+
+// chainName derives a chain name from a key, within the 28 characters
+// iptables allows.
+func chainName(kind, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return chainPrefix + kind + "-" + base32.StdEncoding.EncodeToString(sum[:])[:16]
+}
+
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func policyKey(policy *networkingv1.NetworkPolicy) string {
+	return policy.Namespace + "/" + policy.Name
+}
@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/netutil"
+)
+
+// Ports returns the ports the agent listens on. Agents learn whether kube-proxy
+// is disabled from the server, so only servers leave out its ports.
+func Ports(cfg cmds.Agent, noKubeProxy bool) []netutil.Port {
+	ports := []netutil.Port{
+		{Component: "kubelet", Network: "tcp", Port: 10250},
+		{Component: "kubelet healthz", Network: "tcp", Host: "127.0.0.1", Port: 10248},
+	}
+	if !noKubeProxy {
+		ports = append(ports,
+			netutil.Port{Component: "kube-proxy metrics", Network: "tcp", Host: "127.0.0.1", Port: 10249},
+			netutil.Port{Component: "kube-proxy healthz", Network: "tcp", Port: 10256})
+	}
+	if !cfg.Docker && cfg.ContainerRuntimeEndpoint == "" {
+		ports = append(ports, netutil.Port{Component: "containerd stream server", Network: "tcp", Port: 10010})
+	}
+	// flannel's vxlan port is held by the kernel for as long as the flannel
+	// interface exists, so it is taken on every restart and not checked
+	return ports
+}
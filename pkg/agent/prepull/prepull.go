@@ -0,0 +1,194 @@
+package prepull
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	runtimeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/util"
+)
+
+const (
+	namespace = "kube-system"
+	// ConfigMapName lists the images to pre-pull in its images key, one per line,
+	// and optionally restricts the nodes pulling them with a label selector in its
+	// nodeSelector key.
+	ConfigMapName = "k3s-prepull"
+	// StatusConfigMapName holds the pull progress of each node, keyed by node name.
+	StatusConfigMapName = "k3s-prepull-status"
+	// StatusAnnotation is set by agents to the pull progress of their node, which
+	// the server copies into the status ConfigMap.
+	StatusAnnotation = "k3s.io/prepull-status"
+
+	interval = time.Minute
+)
+
+// Run periodically pulls the images listed in the pre-pull ConfigMap through
+// the CRI image service, reporting progress on the node.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	if nodeConfig.AgentConfig.RuntimeSocket == "" {
+		logrus.Info("Image pre-pulling disabled, no CRI socket configured")
+		return nil
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigNode)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	// the node identity of the kubelet may only modify this node
+	nodeRestConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigKubelet)
+	if err != nil {
+		return err
+	}
+	nodeClient, err := kubernetes.NewForConfig(nodeRestConfig)
+	if err != nil {
+		return err
+	}
+
+	p := &puller{
+		client:     client,
+		nodeClient: nodeClient,
+		socket:     nodeConfig.AgentConfig.RuntimeSocket,
+		nodeName:   nodeConfig.AgentConfig.NodeName,
+	}
+
+	go func() {
+		for {
+			if err := p.sync(ctx); err != nil {
+				logrus.Errorf("Failed to pre-pull images: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return nil
+}
+
+type puller struct {
+	client     kubernetes.Interface
+	nodeClient kubernetes.Interface
+	socket     string
+	nodeName   string
+	reported   string
+}
+
+func (p *puller) sync(ctx context.Context) error {
+	cm, err := p.client.CoreV1().ConfigMaps(namespace).Get(ConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	selected, err := p.selected(cm.Data["nodeSelector"])
+	if err != nil || !selected {
+		return err
+	}
+
+	images := strings.Fields(cm.Data["images"])
+	if len(images) == 0 {
+		return nil
+	}
+
+	imageService, conn, err := p.imageService()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var failed []string
+	pulled := 0
+	for _, image := range images {
+		if err := pull(ctx, imageService, image); err != nil {
+			logrus.Errorf("Failed to pre-pull image %s: %v", image, err)
+			failed = append(failed, image)
+			continue
+		}
+		pulled++
+	}
+
+	status := fmt.Sprintf("%d/%d pulled", pulled, len(images))
+	if len(failed) > 0 {
+		status += ", failed: " + strings.Join(failed, " ")
+	}
+	return p.report(status)
+}
+
+// selected returns true if this node matches the label selector.
+func (p *puller) selected(nodeSelector string) (bool, error) {
+	if strings.TrimSpace(nodeSelector) == "" {
+		return true, nil
+	}
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid nodeSelector in %s/%s: %v", namespace, ConfigMapName, err)
+	}
+	node, err := p.client.CoreV1().Nodes().Get(p.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+func (p *puller) imageService() (runtimeapi.ImageServiceClient, *grpc.ClientConn, error) {
+	addr, dialer, err := util.GetAddressAndDialer(p.socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithTimeout(3*time.Second), grpc.WithDialer(dialer))
+	if err != nil {
+		return nil, nil, err
+	}
+	return runtimeapi.NewImageServiceClient(conn), conn, nil
+}
+
+func pull(ctx context.Context, imageService runtimeapi.ImageServiceClient, image string) error {
+	spec := &runtimeapi.ImageSpec{Image: image}
+	status, err := imageService.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{Image: spec})
+	if err != nil {
+		return err
+	}
+	if status.Image != nil {
+		return nil
+	}
+
+	logrus.Infof("Pre-pulling image %s", image)
+	_, err = imageService.PullImage(ctx, &runtimeapi.PullImageRequest{Image: spec})
+	return err
+}
+
+// report records this node's progress in an annotation on the node, as agents
+// may not write to the status ConfigMap, where they could change the progress
+// of other nodes.
+func (p *puller) report(status string) error {
+	if status == p.reported {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, StatusAnnotation, status)
+	if _, err := p.nodeClient.CoreV1().Nodes().Patch(p.nodeName, types.MergePatchType, []byte(patch)); err != nil {
+		return err
+	}
+
+	p.reported = status
+	return nil
+}
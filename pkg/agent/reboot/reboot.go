@@ -0,0 +1,87 @@
+package reboot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/node"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// sentinel is created by the OS package manager when a reboot is needed to
+	// finish applying updates
+	sentinel = "/var/run/reboot-required"
+	interval = time.Minute
+)
+
+// Run watches for the reboot sentinel file and requests a reboot from the server
+// by annotating this node, rebooting once the server has drained the node and
+// approved it.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	// the node identity of the kubelet may only modify this node
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigKubelet)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	nodeName := nodeConfig.AgentConfig.NodeName
+	go func() {
+		for {
+			if rebooting, err := check(client, nodeName); err != nil {
+				logrus.Errorf("Failed to check reboot status: %v", err)
+			} else if rebooting {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func check(client kubernetes.Interface, nodeName string) (bool, error) {
+	_, err := os.Stat(sentinel)
+	required := err == nil
+
+	n, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	requested := n.Annotations[node.RebootRequiredAnnotation] == "true"
+	approved := n.Annotations[node.RebootApprovedAnnotation] == "true"
+
+	switch {
+	case required && approved:
+		logrus.Infof("Reboot approved by server, rebooting")
+		return true, exec.Command("reboot").Run()
+	case required && !requested:
+		logrus.Infof("%s exists, requesting reboot", sentinel)
+		return false, annotate(client, nodeName, `"true"`)
+	case !required && requested:
+		return false, annotate(client, nodeName, "null")
+	}
+	return false, nil
+}
+
+func annotate(client kubernetes.Interface, nodeName, value string) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%s}}}`, node.RebootRequiredAnnotation, value)
+	_, err := client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, []byte(patch))
+	return err
+}
@@ -0,0 +1,182 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Run starts a stub resolver on the node IP that sends queries for the cluster domain
+// to the cluster DNS service and everything else to the host's upstream servers, and
+// writes the resolv.conf the kubelet passes to pods. Listening on the node IP rather
+// than loopback keeps it reachable from pods, avoiding forwarding loops through
+// loopback resolvers such as systemd-resolved. Only queries from pods and the node
+// itself are answered, so it is not an open resolver. If the port is taken, pods use
+// the resolv.conf they would without the local resolver.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	listen := net.JoinHostPort(nodeConfig.AgentConfig.NodeIP, "53")
+
+	upstream, err := dns.ClientConfigFromFile(nodeConfig.UpstreamResolvConf)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read upstream resolvers from %s", nodeConfig.UpstreamResolvConf)
+	}
+
+	h := &handler{
+		allowed:       allowedSources(nodeConfig),
+		clusterDomain: dns.Fqdn(nodeConfig.AgentConfig.ClusterDomain),
+		clusterDNS:    net.JoinHostPort(nodeConfig.AgentConfig.ClusterDNS.String(), "53"),
+		clients: map[string]*dns.Client{
+			"udp": {Net: "udp"},
+			"tcp": {Net: "tcp"},
+		},
+	}
+	for _, server := range upstream.Servers {
+		addr := net.JoinHostPort(server, upstream.Port)
+		if addr != listen {
+			h.upstreams = append(h.upstreams, addr)
+		}
+	}
+	if len(h.upstreams) == 0 {
+		return fmt.Errorf("no upstream resolvers found in %s", nodeConfig.UpstreamResolvConf)
+	}
+
+	packetConn, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return fallback(nodeConfig, listen, err)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		packetConn.Close()
+		return fallback(nodeConfig, listen, err)
+	}
+
+	if err := writeResolvConf(nodeConfig.AgentConfig.ResolvConf, nodeConfig.AgentConfig.NodeIP, upstream.Search); err != nil {
+		packetConn.Close()
+		listener.Close()
+		return err
+	}
+
+	servers := []*dns.Server{
+		{PacketConn: packetConn, Handler: h},
+		{Listener: listener, Handler: h},
+	}
+	for _, server := range servers {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil && ctx.Err() == nil {
+				logrus.Fatalf("local resolver exited: %v", err)
+			}
+		}(server)
+	}
+	go func() {
+		<-ctx.Done()
+		for _, server := range servers {
+			server.Shutdown()
+		}
+	}()
+
+	logrus.Infof("Running local resolver on %s, upstream %s", listen, strings.Join(h.upstreams, ","))
+	return nil
+}
+
+// fallback has pods use the resolv.conf they would without the local resolver,
+// such as when another DNS server, like dnsmasq, already listens on the node IP.
+func fallback(nodeConfig *config.Node, listen string, err error) error {
+	if nodeConfig.FallbackResolvConf == "" {
+		return errors.Wrapf(err, "failed to start local resolver on %s", listen)
+	}
+	logrus.Warnf("Failed to start local resolver on %s, is another DNS server listening there? Using %s for pods instead: %v",
+		listen, nodeConfig.FallbackResolvConf, err)
+	nodeConfig.AgentConfig.ResolvConf = nodeConfig.FallbackResolvConf
+	return nil
+}
+
+// allowedSources are the networks queries are answered from: loopback, the node
+// IP for host network pods, and the cluster CIDR.
+func allowedSources(nodeConfig *config.Node) []*net.IPNet {
+	_, v4, _ := net.ParseCIDR("127.0.0.0/8")
+	_, v6, _ := net.ParseCIDR("::1/128")
+	allowed := []*net.IPNet{v4, v6}
+	if ip := net.ParseIP(nodeConfig.AgentConfig.NodeIP); ip != nil {
+		allowed = append(allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+	}
+	if cidr := nodeConfig.AgentConfig.ClusterCIDR; cidr.IP != nil {
+		allowed = append(allowed, &cidr)
+	}
+	return allowed
+}
+
+type handler struct {
+	allowed       []*net.IPNet
+	clusterDomain string
+	clusterDNS    string
+	upstreams     []string
+	clients       map[string]*dns.Client
+}
+
+func (h *handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if !h.allow(w.RemoteAddr()) {
+		// dropped rather than refused, so spoofed queries are not reflected
+		w.Close()
+		return
+	}
+
+	servers := h.upstreams
+	if len(req.Question) > 0 && dns.IsSubDomain(h.clusterDomain, dns.Fqdn(req.Question[0].Name)) {
+		servers = []string{h.clusterDNS}
+	}
+
+	client := h.clients[w.RemoteAddr().Network()]
+	if client == nil {
+		client = h.clients["udp"]
+	}
+
+	for _, server := range servers {
+		resp, _, err := client.Exchange(req, server)
+		if err != nil {
+			logrus.Debugf("local resolver query to %s failed: %v", server, err)
+			continue
+		}
+		w.WriteMsg(resp)
+		return
+	}
+
+	resp := &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeServerFailure)
+	w.WriteMsg(resp)
+}
+
+func (h *handler) allow(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	for _, n := range h.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeResolvConf(path, nameserver string, search []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("nameserver %s\n", nameserver)
+	if len(search) > 0 {
+		content += fmt.Sprintf("search %s\n", strings.Join(search, " "))
+	}
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
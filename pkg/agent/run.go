@@ -2,26 +2,45 @@ package agent
 
 import (
 	"context"
-	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/agent/buildkit"
+	"github.com/rancher/k3s/pkg/agent/clock"
+	"github.com/rancher/k3s/pkg/agent/cni"
 	"github.com/rancher/k3s/pkg/agent/config"
 	"github.com/rancher/k3s/pkg/agent/containerd"
+	"github.com/rancher/k3s/pkg/agent/firewall"
 	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/agent/logs"
+	"github.com/rancher/k3s/pkg/agent/logship"
+	"github.com/rancher/k3s/pkg/agent/netpol"
+	"github.com/rancher/k3s/pkg/agent/prepull"
+	"github.com/rancher/k3s/pkg/agent/reboot"
+	"github.com/rancher/k3s/pkg/agent/resolver"
 	"github.com/rancher/k3s/pkg/agent/syssetup"
 	"github.com/rancher/k3s/pkg/agent/tunnel"
 	"github.com/rancher/k3s/pkg/cli/cmds"
 	"github.com/rancher/k3s/pkg/clientaccess"
 	"github.com/rancher/k3s/pkg/daemons/agent"
+	daemonconfig "github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/diskusage"
+	"github.com/rancher/k3s/pkg/drift"
+	"github.com/rancher/k3s/pkg/hooks"
 	"github.com/rancher/k3s/pkg/rootless"
+	"github.com/rancher/k3s/pkg/token"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func run(ctx context.Context, cfg cmds.Agent) error {
+func run(ctx context.Context, cfg cmds.Agent, agentHooks *hooks.Hooks) error {
 	nodeConfig := config.Get(ctx, cfg)
 
 	if err := config.HostnameCheck(cfg); err != nil {
@@ -32,6 +51,22 @@ func run(ctx context.Context, cfg cmds.Agent) error {
 		if err := flannel.Prepare(ctx, nodeConfig); err != nil {
 			return err
 		}
+	} else if !cfg.Rootless {
+		flannel.Cleanup(cfg.DataDir)
+	}
+
+	if err := syssetup.Configure(nodeConfig); err != nil {
+		return err
+	}
+
+	if err := syssetup.CheckDirs(nodeConfig); err != nil {
+		return err
+	}
+
+	if !cfg.Rootless {
+		if err := firewall.Configure(nodeConfig); err != nil {
+			return err
+		}
 	}
 
 	if nodeConfig.Docker || nodeConfig.ContainerRuntimeEndpoint != "" {
@@ -43,29 +78,122 @@ func run(ctx context.Context, cfg cmds.Agent) error {
 		}
 	}
 
-	if err := syssetup.Configure(); err != nil {
-		return err
+	if nodeConfig.Buildkit.Enabled {
+		if err := buildkit.Run(ctx, nodeConfig); err != nil {
+			return err
+		}
 	}
 
 	if err := tunnel.Setup(ctx, nodeConfig); err != nil {
 		return err
 	}
 
+	if nodeConfig.LocalResolver {
+		if err := resolver.Run(ctx, nodeConfig); err != nil {
+			return err
+		}
+	}
+
 	if err := agent.Agent(&nodeConfig.AgentConfig); err != nil {
 		return err
 	}
 
+	if nodeConfig.LogForwardURL != "" {
+		if err := logship.Run(ctx, nodeConfig); err != nil {
+			return err
+		}
+	}
+
+	if err := clock.Run(ctx, nodeConfig); err != nil {
+		return err
+	}
+
+	if err := logs.Run(ctx, nodeConfig); err != nil {
+		return err
+	}
+
+	if err := drift.Run(ctx, nodeConfig); err != nil {
+		return err
+	}
+
+	if err := diskusage.Run(ctx, nodeConfig); err != nil {
+		return err
+	}
+
+	if !cfg.Rootless {
+		if nodeConfig.DisableNetworkPolicy {
+			netpol.Cleanup()
+		} else if err := netpol.Run(ctx, nodeConfig); err != nil {
+			return err
+		}
+	}
+
+	if err := prepull.Run(ctx, nodeConfig); err != nil {
+		return err
+	}
+
+	if nodeConfig.AutoReboot {
+		if err := reboot.Run(ctx, nodeConfig); err != nil {
+			return err
+		}
+	}
+
 	if !nodeConfig.NoFlannel {
 		if err := flannel.Run(ctx, nodeConfig); err != nil {
 			return err
 		}
+	} else if err := cni.Run(ctx, nodeConfig); err != nil {
+		return err
 	}
 
+	agentHooks.Env["NODE_NAME"] = nodeConfig.AgentConfig.NodeName
+	agentHooks.Env["NODE_IP"] = nodeConfig.AgentConfig.NodeIP
+	agentHooks.RunPostBootstrap(ctx, func(ctx context.Context) error {
+		return waitForNodeReady(ctx, nodeConfig)
+	})
+
 	<-ctx.Done()
 	return ctx.Err()
 }
 
+// waitForNodeReady waits until the node of the agent reports Ready.
+func waitForNodeReady(ctx context.Context, nodeConfig *daemonconfig.Node) error {
+	// the node identity of the kubelet may read this node
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigKubelet)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	for {
+		node, err := client.CoreV1().Nodes().Get(nodeConfig.AgentConfig.NodeName, metav1.GetOptions{})
+		if err == nil {
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// Run starts the agent, running its site hooks.
 func Run(ctx context.Context, cfg cmds.Agent) error {
+	return RunWithHooks(ctx, cfg, nil)
+}
+
+// RunWithHooks starts the agent of a server, which has run its pre-start hook
+// already. The post-bootstrap hook of serverHooks runs once the node is ready,
+// instead of the hooks of the agent.
+func RunWithHooks(ctx context.Context, cfg cmds.Agent, serverHooks *hooks.Hooks) error {
 	if err := validate(); err != nil {
 		return err
 	}
@@ -74,17 +202,48 @@ func Run(ctx context.Context, cfg cmds.Agent) error {
 		if err := rootless.Rootless(cfg.DataDir); err != nil {
 			return err
 		}
+		os.Unsetenv("K3S_TOKEN")
+		os.Unsetenv("K3S_CLUSTER_SECRET")
 	}
 
 	cfg.DataDir = filepath.Join(cfg.DataDir, "agent")
 
+	agentHooks := serverHooks
+	if agentHooks == nil {
+		agentHooks = &hooks.Hooks{
+			PreStart:      cfg.PreStartHook,
+			PostBootstrap: cfg.PostBootstrapHook,
+			Role:          "agent",
+			DataDir:       cfg.DataDir,
+			Env: map[string]string{
+				"NODE_NAME": cfg.NodeName,
+				"NODE_IP":   cfg.NodeIP,
+				"URL":       cfg.ServerURL,
+			},
+		}
+		if err := agentHooks.RunPreStart(ctx); err != nil {
+			return err
+		}
+	}
+
 	if cfg.ClusterSecret != "" {
 		cfg.Token = "K10node:" + cfg.ClusterSecret
 	}
 
+	// Join tokens expire, so once exchanged the agent token is used on every later start
+	nodeTokenFile := filepath.Join(cfg.DataDir, "node-token")
+	if isJoinToken(cfg.Token) {
+		if _, err := os.Stat(nodeTokenFile); err == nil {
+			nodeToken, err := token.ReadFile(nodeTokenFile)
+			if err != nil {
+				return err
+			}
+			cfg.Token = nodeToken
+		}
+	}
+
 	for {
-		tmpFile, err := clientaccess.AgentAccessInfoToTempKubeConfig("", cfg.ServerURL, cfg.Token)
-		if err != nil {
+		if _, err := clientaccess.ParseAndValidateToken(cfg.ServerURL, cfg.Token); err != nil {
 			logrus.Error(err)
 			select {
 			case <-ctx.Done():
@@ -93,15 +252,55 @@ func Run(ctx context.Context, cfg cmds.Agent) error {
 			}
 			continue
 		}
-		os.Remove(tmpFile)
 		break
 	}
 
 	os.MkdirAll(cfg.DataDir, 0700)
-	return run(ctx, cfg)
+
+	if isJoinToken(cfg.Token) {
+		agentToken, err := exchangeJoinToken(cfg.ServerURL, cfg.Token)
+		if err != nil {
+			return err
+		}
+		content := []byte(agentToken + "\n")
+		err = ioutil.WriteFile(nodeTokenFile, content, 0600)
+		token.Zero(content)
+		if err != nil {
+			return err
+		}
+		cfg.Token = agentToken
+	}
+
+	return run(ctx, cfg, agentHooks)
+}
+
+func isJoinToken(t string) bool {
+	username, _, ok := clientaccess.ParseUsernamePassword(t)
+	return ok && token.IsJoinTokenID(username)
+}
+
+// exchangeJoinToken trades a join token for the agent token of the cluster.
+func exchangeJoinToken(serverURL, joinToken string) (string, error) {
+	info, err := clientaccess.ParseAndValidateToken(serverURL, joinToken)
+	if err != nil {
+		return "", err
+	}
+	agentToken, err := clientaccess.Get("/v1-k3s/node-token", info)
+	if err != nil {
+		return "", errors.Wrap(err, "exchanging join token")
+	}
+	return strings.TrimSpace(string(agentToken)), nil
 }
 
 func validate() error {
+	// A cgroup2 mount at /sys/fs/cgroup means the host only has the unified
+	// hierarchy, which the embedded kubelet, containerd and runc predate.
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		msg := "host uses cgroup v2 only, which this release does not support; boot with \"systemd.unified_cgroup_hierarchy=0\" on your linux cmdline to use cgroup v1"
+		logrus.Error(msg)
+		return errors.New(msg)
+	}
+
 	cgroups, err := ioutil.ReadFile("/proc/self/cgroup")
 	if err != nil {
 		return err
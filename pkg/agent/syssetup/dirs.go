@@ -0,0 +1,60 @@
+package syssetup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// CheckDirs checks that the filesystems of the kubelet and containerd
+// directories, which may be on separate disks, can hold what is written to
+// them, so that the agent fails at startup instead of when pods start.
+// Overlay support of the containerd root is checked when the snapshotter is
+// set up.
+func CheckDirs(cfg *config.Node) error {
+	if err := checkDir("kubelet root", cfg.AgentConfig.RootDir, false); err != nil {
+		return err
+	}
+
+	if cfg.Docker || cfg.ContainerRuntimeEndpoint != "" {
+		return nil
+	}
+	// container filesystems are unpacked to the root, and image layers carry
+	// file capabilities as extended attributes
+	if err := checkDir("containerd root", cfg.Containerd.Root, true); err != nil {
+		return err
+	}
+	return checkDir("containerd state", cfg.Containerd.State, false)
+}
+
+func checkDir(name, dir string, containers bool) error {
+	f, err := ioutil.TempFile(dir, ".k3s-check")
+	if err != nil {
+		return fmt.Errorf("%s %s is not writable: %v", name, dir, err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	st := unix.Statfs_t{}
+	if err := unix.Statfs(dir, &st); err != nil {
+		return err
+	}
+	if int64(st.Flags)&unix.MS_NOEXEC != 0 {
+		if containers {
+			return fmt.Errorf("%s %s is on a filesystem mounted noexec, containers can not run from it", name, dir)
+		}
+		logrus.Warnf("The %s %s is on a filesystem mounted noexec", name, dir)
+	}
+
+	if containers && !system.RunningInUserNS() {
+		if err := unix.Setxattr(f.Name(), "trusted.k3s", []byte("1"), 0); err != nil {
+			return fmt.Errorf("%s %s is on a filesystem without extended attributes, which images and overlayfs need: %v", name, dir, err)
+		}
+	}
+	return nil
+}
@@ -1,31 +1,145 @@
 package syssetup
 
 import (
+	"bufio"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	callIPTablesFile = "/proc/sys/net/bridge/bridge-nf-call-iptables"
 	forward          = "/proc/sys/net/ipv4/ip_forward"
+	modulesLoadFile  = "/etc/modules-load.d/k3s.conf"
 )
 
-func loadKernelModule(moduleName string) {
+// kernelModule is a module the agent needs. The agent fails to start if a
+// required module is missing from the kernel, optional modules and modules
+// that could not be loaded for other reasons only log a warning.
+type kernelModule struct {
+	name     string
+	optional bool
+}
+
+// kernelModules returns the modules needed by the agent configuration.
+func kernelModules(cfg *config.Node) []kernelModule {
+	modules := []kernelModule{
+		{name: "br_netfilter"},
+		{name: "nf_conntrack"},
+	}
+	if cfg.Containerd.Snapshotter == "" || cfg.Containerd.Snapshotter == "overlayfs" {
+		// containerd falls back to the native snapshotter without overlay
+		modules = append(modules, kernelModule{name: "overlay", optional: true})
+	}
+	if !cfg.NoFlannel {
+		switch cfg.FlannelBackend {
+		case "wireguard-native":
+			modules = append(modules, kernelModule{name: "wireguard"})
+		case "ipsec":
+			modules = append(modules, kernelModule{name: "esp4"})
+		case "host-gw":
+		default:
+			modules = append(modules, kernelModule{name: "vxlan"})
+		}
+	}
+	return modules
+}
+
+// loadKernelModule loads a module unless it is already loaded or built into
+// the kernel. missing is true if modprobe could not find the module at all.
+func loadKernelModule(moduleName string, builtin map[string]bool) (missing bool, err error) {
 	if _, err := os.Stat("/sys/module/" + moduleName); err == nil {
 		logrus.Infof("module %s was already loaded", moduleName)
-		return
+		return false, nil
+	}
+	if builtin[moduleName] {
+		return false, nil
+	}
+
+	out, err := exec.Command("modprobe", moduleName).CombinedOutput()
+	if err == nil {
+		return false, nil
+	}
+	if strings.Contains(string(out), "not found") {
+		return true, fmt.Errorf("kernel module %s is not available for this kernel, install the modules package of your distribution or build it into the kernel", moduleName)
+	}
+	return false, errors.Wrapf(err, "failed to load kernel module %s: %s", moduleName, strings.TrimSpace(string(out)))
+}
+
+// builtinModules lists the modules compiled into the running kernel.
+func builtinModules() map[string]bool {
+	builtin := map[string]bool{}
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return builtin
+	}
+	f, err := os.Open(filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "modules.builtin"))
+	if err != nil {
+		return builtin
 	}
+	defer f.Close()
 
-	if err := exec.Command("modprobe", moduleName).Run(); err != nil {
-		logrus.Warnf("failed to start %s module", moduleName)
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		name := strings.TrimSuffix(filepath.Base(scan.Text()), ".ko")
+		builtin[strings.Replace(name, "-", "_", -1)] = true
+	}
+	return builtin
+}
+
+// persistKernelModules lists the modules in modules-load.d, so that they are
+// loaded at boot before k3s starts.
+func persistKernelModules(modules []string) error {
+	if len(modules) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Dir(modulesLoadFile)); err != nil {
+		return nil
+	}
+	content := "# Kernel modules required by k3s, managed by k3s\n" + strings.Join(modules, "\n") + "\n"
+	if current, err := ioutil.ReadFile(modulesLoadFile); err == nil && string(current) == content {
+		return nil
 	}
+	return ioutil.WriteFile(modulesLoadFile, []byte(content), 0644)
 }
 
-func Configure() error {
-	loadKernelModule("br_netfilter")
+func Configure(cfg *config.Node) error {
+	modules := kernelModules(cfg)
+	builtin := builtinModules()
+	rootless := system.RunningInUserNS()
+
+	var loaded []string
+	for _, module := range modules {
+		if rootless {
+			// modules cannot be loaded from a user namespace
+			if _, err := os.Stat("/sys/module/" + module.name); err != nil && !builtin[module.name] {
+				logrus.Warnf("kernel module %s is not loaded and cannot be loaded rootless", module.name)
+			}
+			continue
+		}
+		if missing, err := loadKernelModule(module.name, builtin); err != nil {
+			if missing && !module.optional {
+				return err
+			}
+			logrus.Warn(err)
+			continue
+		}
+		loaded = append(loaded, module.name)
+	}
+	if !rootless {
+		if err := persistKernelModules(loaded); err != nil {
+			logrus.Warnf("failed to persist kernel modules to %s: %v", modulesLoadFile, err)
+		}
+	}
 
 	if err := ioutil.WriteFile(callIPTablesFile, []byte("1"), 0640); err != nil {
 		logrus.Warnf("failed to write value 1 at %s: %v", callIPTablesFile, err)
@@ -36,8 +150,5 @@ func Configure() error {
 		return nil
 	}
 
-	loadKernelModule("overlay")
-	loadKernelModule("nf_conntrack")
-
 	return nil
 }
@@ -0,0 +1,50 @@
+package templates
+
+import "strings"
+
+// Registry is the content of registries.yaml.
+type Registry struct {
+	// Mirrors are the endpoints tried, in order, for each registry host.
+	Mirrors map[string]Mirror `json:"mirrors"`
+	// Configs are the credentials and TLS settings of each registry host.
+	Configs map[string]RegistryConfig `json:"configs"`
+}
+
+type Mirror struct {
+	Endpoints []string `json:"endpoint"`
+}
+
+type RegistryConfig struct {
+	Auth *AuthConfig `json:"auth"`
+	TLS  *TLSConfig  `json:"tls"`
+}
+
+type AuthConfig struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identity_token"`
+}
+
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// Auths returns the credentials of the registries keyed by endpoint URL, the
+// form containerd looks them up in.
+func (r *Registry) Auths() map[string]*AuthConfig {
+	auths := map[string]*AuthConfig{}
+	for host, config := range r.Configs {
+		if config.Auth == nil {
+			continue
+		}
+		if !strings.Contains(host, "://") {
+			host = "https://" + host
+		}
+		auths[host] = config.Auth
+	}
+	return auths
+}
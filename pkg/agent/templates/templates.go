@@ -8,11 +8,24 @@ import (
 )
 
 type ContainerdConfig struct {
-	NodeConfig        *config.Node
-	IsRunningInUserNS bool
+	NodeConfig            *config.Node
+	IsRunningInUserNS     bool
+	PrivateRegistryConfig *Registry
+	ExtraRuntimes         map[string]ContainerdRuntimeConfig
+}
+
+// ContainerdRuntimeConfig is a CRI runtime handler, RuntimeEngine is only
+// used by shim v1 runtimes.
+type ContainerdRuntimeConfig struct {
+	RuntimeType   string
+	RuntimeEngine string
 }
 
 const ContainerdConfigTemplate = `
+{{- if .NodeConfig.Containerd.SocketGID }}
+[grpc]
+gid = {{ .NodeConfig.Containerd.SocketGID }}
+{{ end }}
 [plugins.opt]
 path = "{{ .NodeConfig.Containerd.Opt }}"
 
@@ -30,16 +43,68 @@ restrict_oom_score_adj = true
 sandbox_image = "{{ .NodeConfig.AgentConfig.PauseImage }}"
 {{ end -}}
 
-{{- if not .NodeConfig.NoFlannel }}
+{{- if .NodeConfig.Containerd.Snapshotter }}
+  [plugins.cri.containerd]
+    snapshotter = "{{ .NodeConfig.Containerd.Snapshotter }}"
+{{ end -}}
+
+{{- range $name, $runtime := .ExtraRuntimes }}
+  [plugins.cri.containerd.runtimes.{{ printf "%q" $name }}]
+    runtime_type = {{ printf "%q" $runtime.RuntimeType }}
+{{- if $runtime.RuntimeEngine }}
+    runtime_engine = {{ printf "%q" $runtime.RuntimeEngine }}
+{{- end }}
+{{ end -}}
+
+{{- if .NodeConfig.AgentConfig.CNIBinDir }}
   [plugins.cri.cni]
     bin_dir = "{{ .NodeConfig.AgentConfig.CNIBinDir }}"
     conf_dir = "{{ .NodeConfig.AgentConfig.CNIConfDir }}"
 {{ end -}}
+
+{{- if .NodeConfig.Containerd.SnapshotterAddress }}
+[proxy_plugins."{{ .NodeConfig.Containerd.Snapshotter }}"]
+  type = "snapshot"
+  address = "{{ .NodeConfig.Containerd.SnapshotterAddress }}"
+{{ end -}}
+
+{{- if .PrivateRegistryConfig }}
+{{- range $host, $mirror := .PrivateRegistryConfig.Mirrors }}
+[plugins.cri.registry.mirrors.{{ printf "%q" $host }}]
+  endpoint = [{{ range $i, $endpoint := $mirror.Endpoints }}{{ if $i }}, {{ end }}{{ printf "%q" $endpoint }}{{ end }}]
+{{ end -}}
+{{- range $url, $auth := .PrivateRegistryConfig.Auths }}
+[plugins.cri.registry.auths.{{ printf "%q" $url }}]
+{{- if $auth.Username }}
+  username = {{ printf "%q" $auth.Username }}
+{{- end }}
+{{- if $auth.Password }}
+  password = {{ printf "%q" $auth.Password }}
+{{- end }}
+{{- if $auth.Auth }}
+  auth = {{ printf "%q" $auth.Auth }}
+{{- end }}
+{{- if $auth.IdentityToken }}
+  identitytoken = {{ printf "%q" $auth.IdentityToken }}
+{{- end }}
+{{ end -}}
+{{ end -}}
 `
 
+// ParseTemplateFromConfig renders templateBuffer with config. The default
+// containerd config is available to it as the "base" template, so that
+// custom templates can extend it with {{ template "base" . }}.
 func ParseTemplateFromConfig(templateBuffer string, config interface{}) (string, error) {
 	out := new(bytes.Buffer)
-	t := template.Must(template.New("compiled_template").Parse(templateBuffer))
+	t, err := template.New("compiled_template").Parse(templateBuffer)
+	if err != nil {
+		return "", err
+	}
+	if t.Lookup("base") == nil {
+		if _, err := t.New("base").Parse(ContainerdConfigTemplate); err != nil {
+			return "", err
+		}
+	}
 	if err := t.Execute(out, config); err != nil {
 		return "", err
 	}
@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/rancher/k3s/pkg/agent/logs"
+	"github.com/rancher/k3s/pkg/chaos"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/remotedialer"
 	"github.com/sirupsen/logrus"
@@ -45,7 +47,7 @@ func getAddresses(endpoint *v1.Endpoints) []string {
 		for _, address := range subset.Addresses {
 			serverAddress := address.IP
 			if port != "" {
-				serverAddress += ":" + port
+				serverAddress = net.JoinHostPort(serverAddress, port)
 			}
 			serverAddresses = append(serverAddresses, serverAddress)
 		}
@@ -179,6 +181,11 @@ func connect(rootCtx context.Context, waitGroup *sync.WaitGroup, address string,
 		headers["Authorization"] = []string{"Basic " + auth}
 	}
 
+	allowed := map[string]bool{}
+	for _, address := range config.TunnelAddresses {
+		allowed[address] = true
+	}
+
 	once := sync.Once{}
 	if waitGroup != nil {
 		waitGroup.Add(1)
@@ -188,15 +195,21 @@ func connect(rootCtx context.Context, waitGroup *sync.WaitGroup, address string,
 
 	go func() {
 		for {
-			remotedialer.ClientConnect(ctx, wsURL, http.Header(headers), ws, func(proto, address string) bool {
+			// failure injection may drop the connection or hold it down
+			connCtx, connCancel := chaos.TunnelContext(ctx)
+			remotedialer.ClientConnect(connCtx, wsURL, http.Header(headers), ws, func(proto, address string) bool {
+				if proto == "unix" {
+					return address == logs.Socket
+				}
 				host, port, err := net.SplitHostPort(address)
-				return err == nil && proto == "tcp" && ports[port] && host == "127.0.0.1"
+				return err == nil && proto == "tcp" && (ports[port] && host == "127.0.0.1" || allowed[address])
 			}, func(_ context.Context) error {
 				if waitGroup != nil {
 					once.Do(waitGroup.Done)
 				}
 				return nil
 			})
+			connCancel()
 
 			if ctx.Err() != nil {
 				if waitGroup != nil {
@@ -8,11 +8,29 @@ import (
 	"github.com/pkg/errors"
 )
 
+// WriteFile writes a file only its owner can read, as rendered configs may hold
+// credentials. Files written by earlier releases with wider permissions are
+// replaced rather than rewritten in place.
 func WriteFile(name string, content string) error {
-	os.MkdirAll(filepath.Dir(name), 0755)
-	err := ioutil.WriteFile(name, []byte(content), 0644)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	tmp := name + ".tmp"
+	if err := writeFile(tmp, name, content); err != nil {
+		// the temporary file holds the same credentials
+		os.Remove(tmp)
 		return errors.Wrapf(err, "writing %s", name)
 	}
 	return nil
 }
+
+func writeFile(tmp, name, content string) error {
+	if err := ioutil.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	// ioutil.WriteFile keeps the mode of an existing file
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,297 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// defaultPolicy logs the metadata of every request.
+const defaultPolicy = `apiVersion: audit.k8s.io/v1
+kind: Policy
+rules:
+- level: Metadata
+`
+
+// Relay receives audit events from the apiserver over a local webhook, spills
+// them to disk and forwards them to the configured webhook, so events survive
+// the webhook being unreachable for longer than the apiserver buffers them.
+type Relay struct {
+	// WebhookConfig is the kubeconfig format webhook config events are sent to
+	WebhookConfig  string
+	Dir            string
+	MaxSize        int64
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	client *http.Client
+	url    string
+	token  string
+	notify chan struct{}
+	lock   sync.Mutex
+}
+
+// Start runs the relay and returns the webhook config for the apiserver.
+func (r *Relay) Start(ctx context.Context) (string, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", r.WebhookConfig)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid audit webhook config %s", r.WebhookConfig)
+	}
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return "", err
+	}
+	r.client = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	r.url = restConfig.Host
+	r.notify = make(chan struct{}, 1)
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = 10 * time.Second
+	}
+	if r.MaxBackoff < r.InitialBackoff {
+		r.MaxBackoff = r.InitialBackoff
+	}
+
+	if err := os.MkdirAll(r.Dir, 0700); err != nil {
+		return "", err
+	}
+	r.token, err = randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	kubeConfig := filepath.Join(r.Dir, "webhook-kubeconfig.yaml")
+	if err := r.writeKubeConfig(kubeConfig, l.Addr().String()); err != nil {
+		l.Close()
+		return "", err
+	}
+
+	server := &http.Server{Handler: r}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(l)
+	go r.forward(ctx)
+
+	return kubeConfig, nil
+}
+
+// ServeHTTP spills a batch of events from the apiserver to disk.
+func (r *Relay) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.Header.Get("Authorization") != "Bearer "+r.token {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := r.spill(body); err != nil {
+		logrus.Errorf("Failed to spill audit events: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (r *Relay) spill(body []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	name := filepath.Join(r.Dir, fmt.Sprintf("%020d.json", time.Now().UnixNano()))
+	if err := ioutil.WriteFile(name+".tmp", body, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	return r.trim()
+}
+
+// trim drops the oldest batches once the spill dir grows beyond MaxSize.
+func (r *Relay) trim() error {
+	if r.MaxSize <= 0 {
+		return nil
+	}
+	files, err := r.batches()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	dropped := 0
+	for i := 0; size > r.MaxSize && i < len(files)-1; i++ {
+		if err := os.Remove(filepath.Join(r.Dir, files[i].Name())); err != nil {
+			return err
+		}
+		size -= files[i].Size()
+		dropped++
+	}
+	if dropped > 0 {
+		logrus.Warnf("Audit spill dir %s is full, dropped %d batches of audit events", r.Dir, dropped)
+	}
+	return nil
+}
+
+// forward sends spilled batches oldest first, backing off while the webhook
+// is failing.
+func (r *Relay) forward(ctx context.Context) {
+	backoff := r.InitialBackoff
+	for {
+		var wakeup <-chan struct{}
+		wait := time.Duration(0)
+
+		switch err := r.sendOldest(ctx); err {
+		case nil:
+			backoff = r.InitialBackoff
+		case errEmpty:
+			wakeup = r.notify
+			wait = time.Minute
+		default:
+			logrus.Warnf("Failed to forward audit events, retrying in %v: %v", backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		}
+		if wait == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wakeup:
+		case <-time.After(wait):
+		}
+	}
+}
+
+var errEmpty = errors.New("no audit events to forward")
+
+func (r *Relay) sendOldest(ctx context.Context) error {
+	r.lock.Lock()
+	files, err := r.batches()
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errEmpty
+	}
+
+	name := filepath.Join(r.Dir, files[0].Name())
+	body, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		// trimmed while we were looking
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// batches returns the spilled batches, oldest first.
+func (r *Relay) batches() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []os.FileInfo
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			result = append(result, f)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+func (r *Relay) writeKubeConfig(file, address string) error {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["relay"] = &clientcmdapi.Cluster{
+		Server: "http://" + address,
+	}
+	config.AuthInfos["relay"] = &clientcmdapi.AuthInfo{
+		Token: r.token,
+	}
+	config.Contexts["relay"] = &clientcmdapi.Context{
+		Cluster:  "relay",
+		AuthInfo: "relay",
+	}
+	config.CurrentContext = "relay"
+	return clientcmd.WriteToFile(*config, file)
+}
+
+// DefaultPolicy writes a policy logging request metadata to dir, for use
+// when no audit policy file is given.
+func DefaultPolicy(dir string) (string, error) {
+	file := filepath.Join(dir, "audit-policy.yaml")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, []byte(defaultPolicy), 0600)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
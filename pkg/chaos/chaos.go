@@ -0,0 +1,290 @@
+// Package chaos injects failures into a running k3s for testing HA behavior
+// and fleet tooling. It is controlled through a local socket that is only
+// served when --chaos-socket is set:
+//
+//	curl --unix-socket SOCKET -X POST 'http://k3s/tunnel/drop?duration=30s'
+//	curl --unix-socket SOCKET -X POST 'http://k3s/proxy/delay?delay=2s&duration=5m'
+//	curl --unix-socket SOCKET -X POST 'http://k3s/kill?component=containerd'
+//	curl --unix-socket SOCKET http://k3s/
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var state = struct {
+	sync.Mutex
+	// tunnelDown is closed when the tunnel is dropped, tunnelUntil is when
+	// agents may reconnect
+	tunnelDown  chan struct{}
+	tunnelUntil time.Time
+	proxyDelay  time.Duration
+	delayUntil  time.Time
+	components  map[string]func() error
+}{
+	tunnelDown: make(chan struct{}),
+	components: map[string]func() error{},
+}
+
+type status struct {
+	TunnelDroppedUntil time.Time `json:"tunnelDroppedUntil,omitempty"`
+	ProxyDelay         string    `json:"proxyDelay,omitempty"`
+	ProxyDelayUntil    time.Time `json:"proxyDelayUntil,omitempty"`
+	Components         []string  `json:"components"`
+}
+
+// Serve listens on the socket until ctx is done.
+func Serve(ctx context.Context, socket string) error {
+	l, err := listen(socket)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleStatus)
+	mux.HandleFunc("/tunnel/drop", handleTunnelDrop)
+	mux.HandleFunc("/proxy/delay", handleProxyDelay)
+	mux.HandleFunc("/kill", handleKill)
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+		os.Remove(socket)
+	}()
+	go server.Serve(l)
+
+	logrus.Warnf("Failure injection is enabled on %s, do not use this in production", socket)
+	return nil
+}
+
+// listen creates the socket in a directory only this user can enter, so that
+// nobody connects before it is restricted to its owner, and then moves it into
+// place.
+func listen(socket string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(socket), ".chaos")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "socket")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed by path once it is moved
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, socket); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// RegisterComponent makes a component killable through the socket.
+func RegisterComponent(name string, kill func() error) {
+	state.Lock()
+	defer state.Unlock()
+	state.components[name] = kill
+}
+
+// TunnelContext returns a context that is canceled with ctx, or when the
+// tunnel is dropped. It waits while the tunnel is dropped, so that the
+// connection made with it is only established once the tunnel may be up.
+func TunnelContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	for {
+		state.Lock()
+		down := state.tunnelDown
+		wait := time.Until(state.tunnelUntil)
+		state.Unlock()
+		if wait <= 0 {
+			tunnelCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-down:
+					cancel()
+				case <-tunnelCtx.Done():
+				}
+			}()
+			return tunnelCtx, cancel
+		}
+		select {
+		case <-ctx.Done():
+			return ctx, func() {}
+		case <-time.After(wait):
+		}
+	}
+}
+
+// DelayProxiedWrites delays requests that change objects while a proxy delay
+// is set. It only wraps the apiserver proxy of the supervisor port, requests
+// made to the apiserver directly and writes of the apiserver itself are not
+// delayed.
+func DelayProxiedWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			state.Lock()
+			delay := state.proxyDelay
+			if time.Now().After(state.delayUntil) {
+				delay = 0
+			}
+			state.Unlock()
+			if delay > 0 {
+				select {
+				case <-req.Context().Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+func handleStatus(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(resp, req)
+		return
+	}
+
+	state.Lock()
+	s := status{}
+	if time.Now().Before(state.tunnelUntil) {
+		s.TunnelDroppedUntil = state.tunnelUntil
+	}
+	if state.proxyDelay > 0 && time.Now().Before(state.delayUntil) {
+		s.ProxyDelay = state.proxyDelay.String()
+		s.ProxyDelayUntil = state.delayUntil
+	}
+	for name := range state.components {
+		s.Components = append(s.Components, name)
+	}
+	state.Unlock()
+
+	sort.Strings(s.Components)
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(s)
+}
+
+// handleTunnelDrop disconnects the agent tunnel and keeps it down for the
+// duration.
+func handleTunnelDrop(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	duration, err := durationParam(req, "duration")
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state.Lock()
+	state.tunnelUntil = time.Now().Add(duration)
+	close(state.tunnelDown)
+	state.tunnelDown = make(chan struct{})
+	state.Unlock()
+
+	logrus.Warnf("Failure injection: dropping the tunnel for %s", duration)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// handleProxyDelay delays writes proxied to the apiserver by delay for the
+// duration, a delay of 0 removes it.
+func handleProxyDelay(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	delay, err := durationParam(req, "delay")
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	duration, err := durationParam(req, "duration")
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state.Lock()
+	state.proxyDelay = delay
+	state.delayUntil = time.Now().Add(duration)
+	state.Unlock()
+
+	logrus.Warnf("Failure injection: delaying proxied writes by %s for %s", delay, duration)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// handleKill kills a component with SIGKILL, or k3s itself.
+func handleKill(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := req.URL.Query().Get("component")
+
+	if name == "k3s" {
+		logrus.Warn("Failure injection: killing k3s")
+		resp.WriteHeader(http.StatusNoContent)
+		if f, ok := resp.(http.Flusher); ok {
+			f.Flush()
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		return
+	}
+
+	state.Lock()
+	kill, ok := state.components[name]
+	state.Unlock()
+	if !ok {
+		http.Error(resp, fmt.Sprintf("unknown component %q, k3s or one of %s", name, componentNames()), http.StatusBadRequest)
+		return
+	}
+
+	logrus.Warnf("Failure injection: killing %s", name)
+	if err := kill(); err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func componentNames() []string {
+	state.Lock()
+	defer state.Unlock()
+	var names []string
+	for name := range state.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func durationParam(req *http.Request, name string) (time.Duration, error) {
+	value := req.URL.Query().Get(name)
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
@@ -3,50 +3,60 @@ package agent
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
-	"time"
 
 	systemd "github.com/coreos/go-systemd/daemon"
 	"github.com/rancher/k3s/pkg/agent"
+	"github.com/rancher/k3s/pkg/agent/containerd"
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/chaos"
 	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/k3s/pkg/datadir"
 	"github.com/rancher/k3s/pkg/netutil"
+	"github.com/rancher/k3s/pkg/token"
 	"github.com/rancher/wrangler/pkg/signals"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
-func readToken(path string) (string, error) {
-	if path == "" {
-		return "", nil
-	}
+func Run(ctx *cli.Context) error {
+	return run(signals.SetupSignalHandler(context.Background()), ctx)
+}
 
-	for {
-		tokenBytes, err := ioutil.ReadFile(path)
-		if err == nil {
-			return strings.TrimSpace(string(tokenBytes)), nil
-		} else if os.IsNotExist(err) {
-			logrus.Infof("Waiting for %s to be available\n", path)
-			time.Sleep(2 * time.Second)
-		} else {
-			return "", err
-		}
+// RunContext returns an agent action that runs until ctx is done instead of
+// handling signals, for running k3s inside another process.
+func RunContext(ctx context.Context) func(*cli.Context) error {
+	return func(app *cli.Context) error {
+		return run(ctx, app)
 	}
 }
 
-func Run(ctx *cli.Context) error {
+func run(contextCtx context.Context, ctx *cli.Context) error {
 	if os.Getuid() != 0 {
 		return fmt.Errorf("agent must be ran as root")
 	}
 
 	if cmds.AgentConfig.TokenFile != "" {
-		token, err := readToken(cmds.AgentConfig.TokenFile)
+		t, err := token.ReadFile(cmds.AgentConfig.TokenFile)
 		if err != nil {
 			return err
 		}
-		cmds.AgentConfig.Token = token
+		cmds.AgentConfig.Token = t
+	}
+
+	if err := token.LoadCredentials(map[string]*string{
+		"token":          &cmds.AgentConfig.Token,
+		"cluster-secret": &cmds.AgentConfig.ClusterSecret,
+	}); err != nil {
+		return err
+	}
+
+	// keep secrets out of the environment inherited by containerd and other
+	// children, rootless agents unset them once k3s has been re-executed
+	if !cmds.AgentConfig.Rootless {
+		os.Unsetenv("K3S_TOKEN")
+		os.Unsetenv("K3S_CLUSTER_SECRET")
 	}
 
 	if cmds.AgentConfig.Token == "" && cmds.AgentConfig.ClusterSecret == "" {
@@ -57,6 +67,30 @@ func Run(ctx *cli.Context) error {
 		return fmt.Errorf("--server is required")
 	}
 
+	if err := config.ValidateFeatureGates(cmds.AgentConfig.FeatureGates); err != nil {
+		return fmt.Errorf("invalid --feature-gates: %v", err)
+	}
+
+	if err := config.ValidateNodeLabels(cmds.AgentConfig.Labels); err != nil {
+		return fmt.Errorf("invalid --node-label: %v", err)
+	}
+
+	if err := config.ValidateNodeTaints(cmds.AgentConfig.Taints); err != nil {
+		return fmt.Errorf("invalid --node-taint: %v", err)
+	}
+
+	if err := containerd.ValidSnapshotter(cmds.AgentConfig.Snapshotter); err != nil {
+		return fmt.Errorf("invalid --snapshotter: %v", err)
+	}
+
+	if err := flannel.ValidMTU(cmds.AgentConfig.FlannelMTU); err != nil {
+		return fmt.Errorf("invalid --flannel-mtu: %v", err)
+	}
+
+	if err := netutil.SingleStack("node-ip", cmds.AgentConfig.NodeIP); err != nil {
+		return err
+	}
+
 	if cmds.AgentConfig.FlannelIface != "" && cmds.AgentConfig.NodeIP == "" {
 		cmds.AgentConfig.NodeIP = netutil.GetIPFromInterface(cmds.AgentConfig.FlannelIface)
 	}
@@ -73,7 +107,18 @@ func Run(ctx *cli.Context) error {
 	cfg.DataDir = dataDir
 	cfg.Labels = append(cfg.Labels, "node-role.kubernetes.io/worker=true")
 
-	contextCtx := signals.SetupSignalHandler(context.Background())
+	if !cfg.Rootless {
+		if err := netutil.CheckPorts(agent.Ports(cfg, false)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ChaosSocket != "" {
+		if err := chaos.Serve(contextCtx, cfg.ChaosSocket); err != nil {
+			return fmt.Errorf("serving --chaos-socket: %v", err)
+		}
+	}
+
 	systemd.SdNotify(true, "READY=1\n")
 
 	return agent.Run(contextCtx, cfg)
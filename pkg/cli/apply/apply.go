@@ -0,0 +1,65 @@
+package apply
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/clusterspec"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/urfave/cli"
+)
+
+// Run applies a cluster spec and installs it where the server watches for
+// changes to it.
+func Run(app *cli.Context) error {
+	cfg := cmds.ApplyConfig
+	if cfg.File == "" {
+		return fmt.Errorf("--file is required")
+	}
+
+	content, err := ioutil.ReadFile(cfg.File)
+	if err != nil {
+		return err
+	}
+	spec, err := clusterspec.Parse(content)
+	if err != nil {
+		return fmt.Errorf("invalid cluster spec %s: %v", cfg.File, err)
+	}
+
+	dataDir, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	result, err := spec.Apply(clusterspec.Paths{
+		ConfigFile:     cfg.ConfigFile,
+		RegistriesFile: clusterspec.RegistriesFile,
+		DataDir:        filepath.Join(dataDir, "server"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.SpecFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(cfg.SpecFile, content, 0600); err != nil {
+		return err
+	}
+
+	for _, f := range result.Changed {
+		fmt.Printf("updated %s\n", f)
+	}
+	for _, f := range result.Removed {
+		fmt.Printf("removed %s\n", f)
+	}
+	if len(result.Changed)+len(result.Removed) == 0 {
+		fmt.Println("no changes")
+	}
+	if result.ConfigChanged {
+		fmt.Println("the server config changed, changes to flags that are not reload-safe need a restart of k3s")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/urfave/cli"
+)
+
+// Rotate removes the selected server certificates so that they are issued
+// again when the server starts.
+func Rotate(app *cli.Context) error {
+	serverDir, err := serverDataDir()
+	if err != nil {
+		return err
+	}
+
+	backupDir, err := control.RotateCerts(serverDir, cmds.CertificateConfig.Services)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("certificates backed up to %s\n", backupDir)
+	fmt.Println("start k3s to issue new certificates, then restart the agents so they fetch new kubelet and kube-proxy certificates")
+	return nil
+}
+
+// RotateCA replaces the cluster CAs and removes all certificates they issued.
+func RotateCA(app *cli.Context) error {
+	serverDir, err := serverDataDir()
+	if err != nil {
+		return err
+	}
+
+	backupDir, err := control.RotateCA(serverDir, cmds.CertificateConfig.CAPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("certificates backed up to %s\n", backupDir)
+	fmt.Println("start k3s to issue new certificates, then restart the agents so they fetch the new CA bundle")
+	fmt.Printf("agents joined with a token that includes the CA hash need the new %s\n", filepath.Join(serverDir, "node-token"))
+	return nil
+}
+
+func serverDataDir() (string, error) {
+	dataDir, err := datadir.Resolve(cmds.CertificateConfig.DataDir)
+	if err != nil {
+		return "", err
+	}
+	serverDir := filepath.Join(dataDir, "server")
+	if _, err := os.Stat(filepath.Join(serverDir, "tls")); err != nil {
+		return "", err
+	}
+	return serverDir, nil
+}
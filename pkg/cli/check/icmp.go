@@ -0,0 +1,105 @@
+package check
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	icmpEchoReply   = 0
+	icmpUnreachable = 3
+	icmpEcho        = 8
+)
+
+// ping sends an ICMP echo request with size bytes of payload to dst and waits
+// for the reply. Fragmentation is disabled, so packets larger than the path
+// MTU are lost or rejected instead of being fragmented.
+func ping(dst net.IP, size int, timeout time.Duration) (time.Duration, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return 0, fmt.Errorf("opening ICMP socket, run as root: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO); err != nil {
+		return 0, err
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return 0, err
+	}
+
+	id := uint16(os.Getpid())
+	seq := uint16(rand.Intn(1 << 16))
+	msg := make([]byte, 8+size)
+	msg[0] = icmpEcho
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+
+	addr := &syscall.SockaddrInet4{}
+	copy(addr.Addr[:], dst.To4())
+	start := time.Now()
+	if err := syscall.Sendto(fd, msg, 0, addr); err != nil {
+		if err == syscall.EMSGSIZE {
+			return 0, fmt.Errorf("larger than the MTU of the local route")
+		}
+		return 0, err
+	}
+
+	buf := make([]byte, 65536)
+	for time.Since(start) < timeout {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		// raw sockets receive the IP header
+		reply := buf[int(buf[0]&0x0f)*4 : n]
+		if len(reply) < 8 {
+			continue
+		}
+		switch reply[0] {
+		case icmpEchoReply:
+			if matches(reply, id, seq) {
+				return time.Since(start), nil
+			}
+		case icmpUnreachable:
+			// the request that could not be delivered follows the header
+			inner := reply[8:]
+			if len(inner) < 20 || len(inner) < int(inner[0]&0x0f)*4+8 || !matches(inner[int(inner[0]&0x0f)*4:], id, seq) {
+				continue
+			}
+			if reply[1] == 4 {
+				return 0, fmt.Errorf("fragmentation needed, next hop MTU is %d", binary.BigEndian.Uint16(reply[6:]))
+			}
+			return 0, fmt.Errorf("destination unreachable, code %d", reply[1])
+		}
+	}
+	return 0, fmt.Errorf("no reply within %s", timeout)
+}
+
+func matches(icmp []byte, id, seq uint16) bool {
+	return binary.BigEndian.Uint16(icmp[4:]) == id && binary.BigEndian.Uint16(icmp[6:]) == seq
+}
+
+// checksum is the internet checksum of RFC 1071.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,292 @@
+package check
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/netutil"
+	"github.com/urfave/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	flannelAnnotationPrefix = "flannel.alpha.coreos.com/"
+	flannelSubnetFile       = "/run/flannel/subnet.env"
+	// ipICMPHeaders is the size of the IPv4 and ICMP echo headers
+	ipICMPHeaders = 28
+)
+
+// result is a row of the report.
+type result struct {
+	peer   string
+	check  string
+	err    error
+	detail string
+}
+
+// Network tests the connectivity from this node to its peers: the ports the
+// peers listen on, ICMP through the flannel overlay, the overlay MTU with
+// fragmentation disabled, and DNS through the cluster DNS service.
+func Network(app *cli.Context) error {
+	client, err := kubeclient.New(checkKubeConfig())
+	if err != nil {
+		return err
+	}
+
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	peers, err := selectPeers(nodes.Items, cmds.CheckConfig.Peers)
+	if err != nil {
+		return err
+	}
+
+	timeout := cmds.CheckConfig.Timeout
+	mtu := overlayMTU()
+
+	var results []result
+	for _, peer := range peers {
+		results = append(results, checkPeer(peer, mtu, timeout)...)
+	}
+	results = append(results, checkDNS(client, cmds.CheckConfig.ClusterDomain, timeout))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tCHECK\tRESULT\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		status, detail := "pass", r.detail
+		if r.err != nil {
+			status, detail = "FAIL", r.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.peer, r.check, status, detail)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// checkKubeConfig returns the kubeconfig flag, or the agent kubeconfig on
+// agents that have no admin kubeconfig.
+func checkKubeConfig() string {
+	if cmds.CheckConfig.KubeConfig != "" {
+		return cmds.CheckConfig.KubeConfig
+	}
+	if _, err := os.Stat(datadir.GlobalConfig); err == nil {
+		return ""
+	}
+	agentKubeConfig := filepath.Join(datadir.DefaultDataDir, "agent", "kubeconfig.yaml")
+	if _, err := os.Stat(agentKubeConfig); err == nil {
+		return agentKubeConfig
+	}
+	return ""
+}
+
+// selectPeers returns the named nodes, or all nodes but this one. This node is
+// recognized by its internal IP being assigned to this host, as its name may
+// differ from the hostname with --node-name or --with-node-id.
+func selectPeers(nodes []corev1.Node, names string) ([]corev1.Node, error) {
+	byName := map[string]corev1.Node{}
+	for _, node := range nodes {
+		byName[node.Name] = node
+	}
+
+	if names == "" {
+		var peers []corev1.Node
+		for _, node := range nodes {
+			if _, local := netutil.FirstAssigned(internalIPs(node)); !local {
+				peers = append(peers, node)
+			}
+		}
+		return peers, nil
+	}
+
+	var peers []corev1.Node
+	for _, name := range strings.Split(names, ",") {
+		node, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("node %s not found", name)
+		}
+		peers = append(peers, node)
+	}
+	return peers, nil
+}
+
+func checkPeer(node corev1.Node, mtu int, timeout time.Duration) []result {
+	address := nodeAddress(node)
+	if address == "" {
+		return []result{{peer: node.Name, check: "address", err: fmt.Errorf("node has no internal IP")}}
+	}
+
+	results := []result{checkPort(node.Name, "kubelet", address, 10250, timeout)}
+	if node.Labels["node-role.kubernetes.io/master"] == "true" {
+		results = append(results, checkPort(node.Name, "supervisor", address, 6443, timeout))
+	}
+
+	overlay, backend, err := overlayAddress(node)
+	if err != nil {
+		return append(results, result{peer: node.Name, check: "overlay", err: err})
+	}
+	r := result{peer: node.Name, check: "overlay"}
+	rtt, err := ping(overlay, 56, timeout)
+	if err != nil {
+		r.err = fmt.Errorf("%s over %s: %v", overlay, backend, err)
+	} else {
+		r.detail = fmt.Sprintf("%s over %s in %s", overlay, backend, rtt.Round(time.Microsecond))
+	}
+	results = append(results, r)
+
+	r = result{peer: node.Name, check: "mtu"}
+	if mtu == 0 {
+		r.detail = "skipped, flannel is not running on this node"
+	} else if _, err := ping(overlay, mtu-ipICMPHeaders, timeout); err != nil {
+		r.err = fmt.Errorf("%d byte packets with DF set are lost: %v", mtu, err)
+	} else {
+		r.detail = fmt.Sprintf("%d byte packets with DF set pass", mtu)
+	}
+	return append(results, r)
+}
+
+func checkPort(peer, component, address string, port int, timeout time.Duration) result {
+	r := result{peer: peer, check: component + " port"}
+	addr := net.JoinHostPort(address, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	conn.Close()
+	r.detail = "tcp " + addr
+	return r
+}
+
+// checkDNS resolves the kubernetes service through the cluster DNS service,
+// or through a DNS pod if the service can not be read.
+func checkDNS(client kubernetes.Interface, clusterDomain string, timeout time.Duration) result {
+	r := result{peer: "-", check: "dns"}
+	server, err := dnsServer(client)
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	name := "kubernetes.default.svc." + clusterDomain
+	addrs, err := resolver.LookupHost(ctx, name)
+	if err != nil {
+		r.err = fmt.Errorf("%s via %s: %v", name, server, err)
+		return r
+	}
+	r.detail = fmt.Sprintf("%s is %s via %s", name, strings.Join(addrs, ","), server)
+	return r
+}
+
+func dnsServer(client kubernetes.Interface) (string, error) {
+	service, err := client.CoreV1().Services(metav1.NamespaceSystem).Get("kube-dns", metav1.GetOptions{})
+	if err == nil {
+		return service.Spec.ClusterIP, nil
+	}
+	endpoints, epErr := client.CoreV1().Endpoints(metav1.NamespaceSystem).Get("kube-dns", metav1.GetOptions{})
+	if epErr != nil {
+		return "", err
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			return address.IP, nil
+		}
+	}
+	return "", fmt.Errorf("kube-dns has no ready endpoints")
+}
+
+// nodeAddress returns the address flannel tunnels to, falling back to the
+// node's internal IP.
+func nodeAddress(node corev1.Node) string {
+	if address := node.Annotations[flannelAnnotationPrefix+"public-ip"]; address != "" {
+		return address
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+func internalIPs(node corev1.Node) []string {
+	var ips []string
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			ips = append(ips, address.Address)
+		}
+	}
+	return ips
+}
+
+// overlayAddress returns an address of the node reached through the flannel
+// backend: the subnet address on the vxlan and wireguard interfaces, and the
+// CNI bridge otherwise.
+func overlayAddress(node corev1.Node) (net.IP, string, error) {
+	backend := node.Annotations[flannelAnnotationPrefix+"backend-type"]
+	if backend == "" {
+		return nil, "", fmt.Errorf("flannel is not running on the node")
+	}
+	_, podCIDR, err := net.ParseCIDR(node.Spec.PodCIDR)
+	if err != nil {
+		return nil, backend, fmt.Errorf("invalid pod CIDR %q", node.Spec.PodCIDR)
+	}
+
+	address := podCIDR.IP.To4()
+	if address == nil {
+		return nil, backend, fmt.Errorf("pod CIDR %s is not IPv4", podCIDR)
+	}
+	address = append(net.IP{}, address...)
+	switch backend {
+	case "vxlan", "wireguard-native":
+	default:
+		address[3]++
+	}
+	return address, backend, nil
+}
+
+// overlayMTU reads the MTU flannel chose for this node, 0 if unknown.
+func overlayMTU() int {
+	f, err := os.Open(flannelSubnetFile)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		if value := strings.TrimPrefix(scan.Text(), "FLANNEL_MTU="); value != scan.Text() {
+			mtu, _ := strconv.Atoi(value)
+			return mtu
+		}
+	}
+	return 0
+}
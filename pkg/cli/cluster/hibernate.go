@@ -0,0 +1,234 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/wrangler/pkg/merr"
+	"github.com/urfave/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// replicasAnnotation records the replica count of a workload before it was
+	// scaled down by hibernate
+	replicasAnnotation = "k3s.io/hibernate-replicas"
+	// suspendAnnotation records that a cron job was suspended by hibernate
+	suspendAnnotation = "k3s.io/hibernate-suspended"
+)
+
+var (
+	systemNamespaces = map[string]bool{
+		"kube-system":     true,
+		"kube-public":     true,
+		"kube-node-lease": true,
+	}
+	// nonEssential are packaged components that are stopped along with workloads
+	nonEssential = map[string]bool{
+		"kube-system/traefik": true,
+	}
+)
+
+// Hibernate scales deployments and stateful sets to zero and suspends cron jobs,
+// annotating each with its previous state.
+func Hibernate(app *cli.Context) error {
+	return run(func(client kubernetes.Interface, namespace string) error {
+		return hibernate(client, namespace)
+	})
+}
+
+// Resume restores everything changed by Hibernate.
+func Resume(app *cli.Context) error {
+	return run(func(client kubernetes.Interface, namespace string) error {
+		return resume(client, namespace)
+	})
+}
+
+func run(f func(kubernetes.Interface, string) error) error {
+	client, err := kubeclient.New(cmds.ClusterConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	namespaces := []string(cmds.ClusterConfig.Namespaces)
+	if len(namespaces) == 0 {
+		list, err := client.CoreV1().Namespaces().List(metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, ns := range list.Items {
+			if !systemNamespaces[ns.Name] {
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+		// system namespaces are only visited for their non-essential components
+		namespaces = append(namespaces, "kube-system")
+	}
+
+	var errs []error
+	for _, namespace := range namespaces {
+		if err := f(client, namespace); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return merr.NewErrors(errs...)
+}
+
+func included(namespace, name string) bool {
+	if systemNamespaces[namespace] {
+		return nonEssential[namespace+"/"+name]
+	}
+	return true
+}
+
+func hibernate(client kubernetes.Interface, namespace string) error {
+	var errs []error
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, d := range deployments.Items {
+		if !included(namespace, d.Name) || d.Spec.Replicas == nil || *d.Spec.Replicas == 0 {
+			continue
+		}
+		if _, ok := d.Annotations[replicasAnnotation]; ok {
+			continue
+		}
+		setAnnotation(&d.ObjectMeta, replicasAnnotation, strconv.Itoa(int(*d.Spec.Replicas)))
+		d.Spec.Replicas = new(int32)
+		if _, err := client.AppsV1().Deployments(namespace).Update(&d); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("deployment %s/%s scaled to 0\n", namespace, d.Name)
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, s := range statefulSets.Items {
+		if !included(namespace, s.Name) || s.Spec.Replicas == nil || *s.Spec.Replicas == 0 {
+			continue
+		}
+		if _, ok := s.Annotations[replicasAnnotation]; ok {
+			continue
+		}
+		setAnnotation(&s.ObjectMeta, replicasAnnotation, strconv.Itoa(int(*s.Spec.Replicas)))
+		s.Spec.Replicas = new(int32)
+		if _, err := client.AppsV1().StatefulSets(namespace).Update(&s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("statefulset %s/%s scaled to 0\n", namespace, s.Name)
+	}
+
+	cronJobs, err := client.BatchV1beta1().CronJobs(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, c := range cronJobs.Items {
+		if !included(namespace, c.Name) || (c.Spec.Suspend != nil && *c.Spec.Suspend) {
+			continue
+		}
+		setAnnotation(&c.ObjectMeta, suspendAnnotation, "true")
+		suspend := true
+		c.Spec.Suspend = &suspend
+		if _, err := client.BatchV1beta1().CronJobs(namespace).Update(&c); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("cronjob %s/%s suspended\n", namespace, c.Name)
+	}
+
+	return merr.NewErrors(errs...)
+}
+
+func resume(client kubernetes.Interface, namespace string) error {
+	var errs []error
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, d := range deployments.Items {
+		replicas, ok, err := previousReplicas(d.ObjectMeta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		delete(d.Annotations, replicasAnnotation)
+		d.Spec.Replicas = &replicas
+		if _, err := client.AppsV1().Deployments(namespace).Update(&d); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("deployment %s/%s scaled to %d\n", namespace, d.Name, replicas)
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, s := range statefulSets.Items {
+		replicas, ok, err := previousReplicas(s.ObjectMeta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		delete(s.Annotations, replicasAnnotation)
+		s.Spec.Replicas = &replicas
+		if _, err := client.AppsV1().StatefulSets(namespace).Update(&s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("statefulset %s/%s scaled to %d\n", namespace, s.Name, replicas)
+	}
+
+	cronJobs, err := client.BatchV1beta1().CronJobs(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, c := range cronJobs.Items {
+		if _, ok := c.Annotations[suspendAnnotation]; !ok {
+			continue
+		}
+		delete(c.Annotations, suspendAnnotation)
+		suspend := false
+		c.Spec.Suspend = &suspend
+		if _, err := client.BatchV1beta1().CronJobs(namespace).Update(&c); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("cronjob %s/%s resumed\n", namespace, c.Name)
+	}
+
+	return merr.NewErrors(errs...)
+}
+
+func previousReplicas(meta metav1.ObjectMeta) (int32, bool, error) {
+	value, ok := meta.Annotations[replicasAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	replicas, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s annotation on %s/%s: %q", replicasAnnotation, meta.Namespace, meta.Name, value)
+	}
+	return int32(replicas), true, nil
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/rest"
+)
+
+type restartLockInfo struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// AcquireRestartLock waits for the restart lock, to be run before a server is
+// restarted.
+func AcquireRestartLock(app *cli.Context) error {
+	deadline := time.Now().Add(cmds.RestartLockConfig.Wait)
+	query := url.Values{
+		"holder":   []string{restartLockHolder()},
+		"duration": []string{cmds.RestartLockConfig.Duration.String()},
+	}
+	for {
+		body, status, err := restartLockRequest(http.MethodPost, query)
+		if err == nil && status == http.StatusOK {
+			info := restartLockInfo{}
+			if err := json.Unmarshal(body, &info); err != nil {
+				return err
+			}
+			fmt.Printf("Restart lock acquired by %s until %s\n", info.Holder, info.Expires.Local().Format(time.RFC3339))
+			return nil
+		}
+		if err == nil {
+			err = errors.New(strings.TrimSpace(string(body)))
+			if status != http.StatusConflict && status != http.StatusServiceUnavailable {
+				return err
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.Wrap(err, "timed out waiting for the restart lock")
+		}
+		logrus.Infof("Waiting for the restart lock: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// ReleaseRestartLock releases the restart lock.
+func ReleaseRestartLock(app *cli.Context) error {
+	body, status, err := restartLockRequest(http.MethodDelete, url.Values{"holder": []string{restartLockHolder()}})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// RestartLockStatus prints the holder of the restart lock.
+func RestartLockStatus(app *cli.Context) error {
+	body, status, err := restartLockRequest(http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	info := restartLockInfo{}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return err
+	}
+	if info.Holder == "" {
+		fmt.Println("The restart lock is free")
+		return nil
+	}
+	fmt.Printf("The restart lock is held by %s until %s\n", info.Holder, info.Expires.Local().Format(time.RFC3339))
+	return nil
+}
+
+func restartLockHolder() string {
+	if cmds.RestartLockConfig.Holder != "" {
+		return cmds.RestartLockConfig.Holder
+	}
+	return server.RestartLockHolder()
+}
+
+func restartLockRequest(method string, query url.Values) ([]byte, int, error) {
+	restConfig, err := kubeclient.Config(cmds.RestartLockConfig.KubeConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	u, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return nil, 0, err
+	}
+	u.Path = server.RestartLockPath
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/rancher/k3s/pkg/configfilearg"
 	"github.com/urfave/cli"
 )
 
@@ -12,22 +13,52 @@ type Agent struct {
 	TokenFile                string
 	ServerURL                string
 	ResolvConf               string
+	LocalResolver            bool
+	AutoReboot               bool
+	VerifyImages             bool
+	EnableBuildkit           bool
+	ContainerLogMaxSize      string
+	ContainerLogMaxFiles     int
+	LogForwardURL            string
 	DataDir                  string
 	NodeIP                   string
+	NodeExternalIP           string
 	NodeName                 string
+	WithNodeID               bool
 	ClusterSecret            string
 	PauseImage               string
 	Docker                   bool
 	ContainerRuntimeEndpoint string
+	CRISocketGroup           string
 	NoFlannel                bool
 	FlannelIface             string
+	FlannelMTU               int
+	Firewalld                bool
+	HealConfigDrift          bool
+	ChaosSocket              string
+	DiskUsageThreshold       int
 	Debug                    bool
 	Rootless                 bool
+	LogFile                  string
+	SnapshotDir              string
+	PreStartHook             string
+	PostBootstrapHook        string
 	AgentShared
-	ExtraKubeletArgs   cli.StringSlice
-	ExtraKubeProxyArgs cli.StringSlice
-	Labels             cli.StringSlice
-	Taints             cli.StringSlice
+	ExtraKubeletArgs      cli.StringSlice
+	ExtraKubeProxyArgs    cli.StringSlice
+	KubeletConfigFile     string
+	KubeletConfigDir      string
+	KubeProxyConfigFile   string
+	Labels                cli.StringSlice
+	Taints                cli.StringSlice
+	TunnelPorts           cli.StringSlice
+	FeatureGates          string
+	SystemDefaultRegistry string
+	PrivateRegistry       string
+	Snapshotter           string
+	KubeletRootDir        string
+	ContainerdRoot        string
+	ContainerdState       string
 }
 
 type AgentShared struct {
@@ -37,17 +68,33 @@ type AgentShared struct {
 var (
 	appName     = filepath.Base(os.Args[0])
 	AgentConfig Agent
-	NodeIPFlag  = cli.StringFlag{
+	ConfigFlag  = cli.StringFlag{
+		Name:   "config",
+		Usage:  "Load configuration from FILE",
+		EnvVar: "K3S_CONFIG_FILE",
+		Value:  configfilearg.DefaultConfigFile,
+	}
+	NodeIPFlag = cli.StringFlag{
 		Name:        "node-ip,i",
 		Usage:       "(agent) IP address to advertise for node",
 		Destination: &AgentConfig.NodeIP,
 	}
+	NodeExternalIPFlag = cli.StringFlag{
+		Name:        "node-external-ip",
+		Usage:       "(agent) External IP address of the node, used by flannel with --flannel-external-ip",
+		Destination: &AgentConfig.NodeExternalIP,
+	}
 	NodeNameFlag = cli.StringFlag{
 		Name:        "node-name",
 		Usage:       "(agent) Node name",
 		EnvVar:      "K3S_NODE_NAME",
 		Destination: &AgentConfig.NodeName,
 	}
+	WithNodeIDFlag = cli.BoolFlag{
+		Name:        "with-node-id",
+		Usage:       "(agent) Append a stable id derived from the SMBIOS UUID or machine-id to the node name, and let a re-imaged machine reclaim its node with the secret in /etc/rancher/node/node-id, which must be provisioned outside of the machine image as it is generated on first use",
+		Destination: &AgentConfig.WithNodeID,
+	}
 	DockerFlag = cli.BoolFlag{
 		Name:        "docker",
 		Usage:       "(agent) Use docker instead of containerd",
@@ -63,11 +110,21 @@ var (
 		Usage:       "(agent) Override default flannel interface",
 		Destination: &AgentConfig.FlannelIface,
 	}
+	FlannelMTUFlag = cli.IntFlag{
+		Name:        "flannel-mtu",
+		Usage:       "(agent) Override the flannel MTU derived from the interface and the backend overhead",
+		Destination: &AgentConfig.FlannelMTU,
+	}
 	CRIEndpointFlag = cli.StringFlag{
 		Name:        "container-runtime-endpoint",
 		Usage:       "(agent) Disable embedded containerd and use alternative CRI implementation",
 		Destination: &AgentConfig.ContainerRuntimeEndpoint,
 	}
+	CRISocketGroupFlag = cli.StringFlag{
+		Name:        "cri-socket-group",
+		Usage:       "(agent) Group name or id given read/write access to the embedded containerd socket",
+		Destination: &AgentConfig.CRISocketGroup,
+	}
 	PauseImageFlag = cli.StringFlag{
 		Name:        "pause-image",
 		Usage:       "(agent) Customized pause image for containerd sandbox",
@@ -79,24 +136,153 @@ var (
 		EnvVar:      "K3S_RESOLV_CONF",
 		Destination: &AgentConfig.ResolvConf,
 	}
+	LocalResolverFlag = cli.BoolFlag{
+		Name:        "local-resolver",
+		Usage:       "(agent) Run a DNS stub resolver on the node IP and use it as the kubelet resolv.conf",
+		Destination: &AgentConfig.LocalResolver,
+	}
+	AutoRebootFlag = cli.BoolFlag{
+		Name:        "auto-reboot",
+		Usage:       "(agent) Request a reboot from the server when /var/run/reboot-required exists, and reboot once the node is drained",
+		Destination: &AgentConfig.AutoReboot,
+	}
+	FirewalldFlag = cli.BoolFlag{
+		Name:        "firewalld",
+		Usage:       "(agent) Trust the cluster networks and open the k3s ports in firewalld, instead of requiring it to be disabled",
+		Destination: &AgentConfig.Firewalld,
+	}
+	HealConfigDriftFlag = cli.BoolFlag{
+		Name:        "heal-config-drift",
+		Usage:       "(agent) Restore files k3s manages, such as the containerd config, when they are modified outside of k3s",
+		Destination: &AgentConfig.HealConfigDrift,
+	}
+	ChaosSocketFlag = cli.StringFlag{
+		Name:        "chaos-socket",
+		Usage:       "(agent) (testing) Serve failure injection on this unix socket, to drop the tunnel, delay writes proxied to the apiserver or kill components",
+		Destination: &AgentConfig.ChaosSocket,
+		Hidden:      true,
+	}
+	DiskUsageThresholdFlag = cli.IntFlag{
+		Name:        "disk-usage-threshold",
+		Usage:       "(agent) Record an event on the node when a filesystem holding k3s data is fuller than this percentage, 0 to disable",
+		Value:       85,
+		Destination: &AgentConfig.DiskUsageThreshold,
+	}
+	VerifyImagesFlag = cli.BoolFlag{
+		Name:        "verify-images",
+		Usage:       "(agent) Warn if packaged component images in containerd do not match the digests pinned at build time",
+		Destination: &AgentConfig.VerifyImages,
+	}
+	EnableBuildkitFlag = cli.BoolFlag{
+		Name:        "enable-buildkit",
+		Usage:       "(agent) Run buildkitd from PATH with a worker using the embedded containerd",
+		Destination: &AgentConfig.EnableBuildkit,
+	}
+	ContainerLogMaxSizeFlag = cli.StringFlag{
+		Name:        "container-log-max-size",
+		Usage:       "(agent) Maximum size of a container log file before it is rotated (e.g. 10Mi)",
+		Destination: &AgentConfig.ContainerLogMaxSize,
+	}
+	ContainerLogMaxFilesFlag = cli.IntFlag{
+		Name:        "container-log-max-files",
+		Usage:       "(agent) Maximum number of container log files kept per container",
+		Destination: &AgentConfig.ContainerLogMaxFiles,
+	}
+	LogForwardFlag = cli.StringFlag{
+		Name:        "log-forward-url",
+		Usage:       "(agent) Forward container logs to syslog://host:port, syslog+tcp://host:port, or a Loki push URL",
+		EnvVar:      "K3S_LOG_FORWARD_URL",
+		Destination: &AgentConfig.LogForwardURL,
+	}
+	TunnelPortsFlag = cli.StringSliceFlag{
+		Name:  "tunnel-port",
+		Usage: "(agent) Additional node port or host:port the server may reach through the agent tunnel",
+		Value: &AgentConfig.TunnelPorts,
+	}
+	PreStartHookFlag = cli.StringFlag{
+		Name:        "pre-start-hook",
+		Usage:       "Executable to run before k3s starts, k3s fails to start if it fails",
+		EnvVar:      "K3S_PRE_START_HOOK",
+		Destination: &AgentConfig.PreStartHook,
+	}
+	PostBootstrapHookFlag = cli.StringFlag{
+		Name:        "post-bootstrap-hook",
+		Usage:       "Executable to run in the background once the node is ready, or the server is up with --disable-agent",
+		EnvVar:      "K3S_POST_BOOTSTRAP_HOOK",
+		Destination: &AgentConfig.PostBootstrapHook,
+	}
 	ExtraKubeletArgs = cli.StringSliceFlag{
 		Name:  "kubelet-arg",
 		Usage: "(agent) Customized flag for kubelet process",
 		Value: &AgentConfig.ExtraKubeletArgs,
 	}
+	KubeletConfigFileFlag = cli.StringFlag{
+		Name:        "kubelet-config-file",
+		Usage:       "(agent) Kubelet config file (KubeletConfiguration)",
+		Destination: &AgentConfig.KubeletConfigFile,
+	}
+	KubeletConfigDirFlag = cli.StringFlag{
+		Name:        "kubelet-config-dir",
+		Usage:       "(agent) Directory of kubelet config drop-ins (*.conf), merged in lexical order over --kubelet-config-file",
+		Destination: &AgentConfig.KubeletConfigDir,
+		Value:       "/etc/rancher/k3s/kubelet.conf.d",
+	}
 	ExtraKubeProxyArgs = cli.StringSliceFlag{
 		Name:  "kube-proxy-arg",
 		Usage: "(agent) Customized flag for kube-proxy process",
 		Value: &AgentConfig.ExtraKubeProxyArgs,
 	}
+	KubeProxyConfigFileFlag = cli.StringFlag{
+		Name:        "kube-proxy-config-file",
+		Usage:       "(agent) Kube-proxy config file (KubeProxyConfiguration), cannot be combined with --kube-proxy-arg",
+		Destination: &AgentConfig.KubeProxyConfigFile,
+	}
+	FeatureGatesFlag = cli.StringFlag{
+		Name:        "feature-gates",
+		Usage:       "Feature gates to set on all embedded Kubernetes components (e.g. Foo=true,Bar=false)",
+		Destination: &AgentConfig.FeatureGates,
+	}
+	SystemDefaultRegistryFlag = cli.StringFlag{
+		Name:        "system-default-registry",
+		Usage:       "Private registry to pull packaged component and pause images from",
+		EnvVar:      "K3S_SYSTEM_DEFAULT_REGISTRY",
+		Destination: &AgentConfig.SystemDefaultRegistry,
+	}
+	PrivateRegistryFlag = cli.StringFlag{
+		Name:        "private-registry",
+		Usage:       "(agent) Private registry configuration file",
+		Destination: &AgentConfig.PrivateRegistry,
+		Value:       "/etc/rancher/k3s/registries.yaml",
+	}
+	SnapshotterFlag = cli.StringFlag{
+		Name:        "snapshotter",
+		Usage:       "(agent) Containerd snapshotter (overlayfs, native, fuse-overlayfs or stargz), falls back to native if overlayfs is not supported",
+		Destination: &AgentConfig.Snapshotter,
+		Value:       "overlayfs",
+	}
+	KubeletRootDirFlag = cli.StringFlag{
+		Name:        "kubelet-root-dir",
+		Usage:       "(agent) Kubelet root directory for pod volumes, which may be on a separate filesystem (default: ${data-dir}/agent/kubelet)",
+		Destination: &AgentConfig.KubeletRootDir,
+	}
+	ContainerdRootFlag = cli.StringFlag{
+		Name:        "containerd-root",
+		Usage:       "(agent) Containerd root directory for images and container filesystems, which may be on a separate filesystem (default: ${data-dir}/agent/containerd)",
+		Destination: &AgentConfig.ContainerdRoot,
+	}
+	ContainerdStateFlag = cli.StringFlag{
+		Name:        "containerd-state",
+		Usage:       "(agent) Containerd state directory (default: /run/k3s/containerd)",
+		Destination: &AgentConfig.ContainerdState,
+	}
 	NodeTaints = cli.StringSliceFlag{
 		Name:  "node-taint",
-		Usage: "(agent) Registering kubelet with set of taints",
+		Usage: "(agent) Registering kubelet with set of taints (key=value:Effect), may be repeated",
 		Value: &AgentConfig.Taints,
 	}
 	NodeLabels = cli.StringSliceFlag{
 		Name:  "node-label",
-		Usage: "(agent) Registering kubelet with set of labels",
+		Usage: "(agent) Registering kubelet with set of labels (key=value), may be repeated",
 		Value: &AgentConfig.Labels,
 	}
 )
@@ -108,6 +294,7 @@ func NewAgentCommand(action func(ctx *cli.Context) error) cli.Command {
 		UsageText: appName + " agent [OPTIONS]",
 		Action:    action,
 		Flags: []cli.Flag{
+			ConfigFlag,
 			cli.StringFlag{
 				Name:        "token,t",
 				Usage:       "Token to use for authentication",
@@ -116,7 +303,7 @@ func NewAgentCommand(action func(ctx *cli.Context) error) cli.Command {
 			},
 			cli.StringFlag{
 				Name:        "token-file",
-				Usage:       "Token file to use for authentication",
+				Usage:       "Token file to use for authentication, or fd:N to read the token from an inherited file descriptor",
 				EnvVar:      "K3S_TOKEN_FILE",
 				Destination: &AgentConfig.TokenFile,
 			},
@@ -146,13 +333,41 @@ func NewAgentCommand(action func(ctx *cli.Context) error) cli.Command {
 			DockerFlag,
 			FlannelFlag,
 			FlannelIfaceFlag,
+			FlannelMTUFlag,
 			NodeNameFlag,
+			WithNodeIDFlag,
 			NodeIPFlag,
+			NodeExternalIPFlag,
 			CRIEndpointFlag,
+			CRISocketGroupFlag,
 			PauseImageFlag,
 			ResolvConfFlag,
+			LocalResolverFlag,
+			AutoRebootFlag,
+			FirewalldFlag,
+			HealConfigDriftFlag,
+			ChaosSocketFlag,
+			DiskUsageThresholdFlag,
+			VerifyImagesFlag,
+			EnableBuildkitFlag,
+			ContainerLogMaxSizeFlag,
+			ContainerLogMaxFilesFlag,
+			LogForwardFlag,
+			TunnelPortsFlag,
+			PreStartHookFlag,
+			PostBootstrapHookFlag,
 			ExtraKubeletArgs,
+			KubeletConfigFileFlag,
+			KubeletConfigDirFlag,
 			ExtraKubeProxyArgs,
+			KubeProxyConfigFileFlag,
+			FeatureGatesFlag,
+			SystemDefaultRegistryFlag,
+			PrivateRegistryFlag,
+			SnapshotterFlag,
+			KubeletRootDirFlag,
+			ContainerdRootFlag,
+			ContainerdStateFlag,
 			NodeLabels,
 			NodeTaints,
 		},
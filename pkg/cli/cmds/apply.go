@@ -0,0 +1,51 @@
+package cmds
+
+import (
+	"github.com/rancher/k3s/pkg/clusterspec"
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/urfave/cli"
+)
+
+type Apply struct {
+	File       string
+	SpecFile   string
+	ConfigFile string
+	DataDir    string
+}
+
+var ApplyConfig Apply
+
+func NewApplyCommand(action func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:      "apply",
+		Usage:     "Apply a cluster spec to the server config, registries and addon manifests",
+		UsageText: appName + " apply [OPTIONS] -f FILE",
+		Action:    action,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "file,f",
+				Usage:       "Cluster spec to apply",
+				Destination: &ApplyConfig.File,
+			},
+			cli.StringFlag{
+				Name:        "cluster-spec",
+				Usage:       "Cluster spec file the server watches, the applied spec is installed there",
+				EnvVar:      "K3S_CLUSTER_SPEC",
+				Value:       clusterspec.DefaultSpecFile,
+				Destination: &ApplyConfig.SpecFile,
+			},
+			cli.StringFlag{
+				Name:        "config",
+				Usage:       "Server config file to write",
+				EnvVar:      "K3S_CONFIG_FILE",
+				Value:       configfilearg.DefaultConfigFile,
+				Destination: &ApplyConfig.ConfigFile,
+			},
+			cli.StringFlag{
+				Name:        "data-dir,d",
+				Usage:       "Folder holding server state, addons are written to its manifests folder",
+				Destination: &ApplyConfig.DataDir,
+			},
+		},
+	}
+}
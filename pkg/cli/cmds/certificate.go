@@ -0,0 +1,56 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Certificate struct {
+	DataDir  string
+	Services cli.StringSlice
+	CAPath   string
+}
+
+var CertificateConfig Certificate
+
+func NewCertCommand(rotate, rotateCA func(*cli.Context) error) cli.Command {
+	dataDirFlag := cli.StringFlag{
+		Name:        "data-dir,d",
+		Usage:       "Folder holding server state",
+		Destination: &CertificateConfig.DataDir,
+	}
+
+	return cli.Command{
+		Name:  "certificate",
+		Usage: "Manage server certificates",
+		Subcommands: []cli.Command{
+			{
+				Name:      "rotate",
+				Usage:     "Regenerate server certificates from the existing CAs on the next start, the server must be stopped",
+				UsageText: appName + " certificate rotate [OPTIONS]",
+				Action:    rotate,
+				Flags: []cli.Flag{
+					dataDirFlag,
+					cli.StringSliceFlag{
+						Name:  "service,s",
+						Usage: "Service whose certificates to rotate (admin, api-server, auth-proxy, controller-manager, k3s-server, kube-proxy, scheduler), defaults to all",
+						Value: &CertificateConfig.Services,
+					},
+				},
+			},
+			{
+				Name:      "rotate-ca",
+				Usage:     "Replace the cluster CAs, cross-signed by the current ones, and issue new certificates on the next start, the server must be stopped",
+				UsageText: appName + " certificate rotate-ca [OPTIONS]",
+				Action:    rotateCA,
+				Flags: []cli.Flag{
+					dataDirFlag,
+					cli.StringFlag{
+						Name:        "path",
+						Usage:       "Directory holding the new server-ca, client-ca and request-header-ca .crt and .key files, new CAs are generated if not set",
+						Destination: &CertificateConfig.CAPath,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+type Check struct {
+	KubeConfig    string
+	Peers         string
+	ClusterDomain string
+	Timeout       time.Duration
+}
+
+var CheckConfig Check
+
+func NewCheckCommand(network func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "check",
+		Usage: "Diagnose this node",
+		Subcommands: []cli.Command{
+			{
+				Name:      "network",
+				Usage:     "Test the ports, overlay, MTU and DNS from this node to other nodes",
+				UsageText: appName + " check network [OPTIONS]",
+				Action:    network,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "peers",
+						Usage:       "Comma separated names of the nodes to test, defaults to all other nodes",
+						Destination: &CheckConfig.Peers,
+					},
+					cli.StringFlag{
+						Name:        "kubeconfig",
+						Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig, or the agent kubeconfig on agents",
+						EnvVar:      "KUBECONFIG",
+						Destination: &CheckConfig.KubeConfig,
+					},
+					cli.StringFlag{
+						Name:        "cluster-domain",
+						Usage:       "Cluster domain to resolve the kubernetes service in",
+						Value:       "cluster.local",
+						Destination: &CheckConfig.ClusterDomain,
+					},
+					cli.DurationFlag{
+						Name:        "timeout",
+						Usage:       "Timeout of each test",
+						Value:       3 * time.Second,
+						Destination: &CheckConfig.Timeout,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Cluster struct {
+	KubeConfig string
+	Namespaces cli.StringSlice
+}
+
+var ClusterConfig Cluster
+
+func NewClusterCommand(hibernate, resume func(*cli.Context) error) cli.Command {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "kubeconfig",
+			Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+			EnvVar:      "KUBECONFIG",
+			Destination: &ClusterConfig.KubeConfig,
+		},
+		cli.StringSliceFlag{
+			Name:  "namespace,n",
+			Usage: "Only include workloads in this namespace, may be repeated (default: all namespaces except system ones)",
+			Value: &ClusterConfig.Namespaces,
+		},
+	}
+
+	return cli.Command{
+		Name:  "cluster",
+		Usage: "Manage the cluster as a whole",
+		Subcommands: []cli.Command{
+			{
+				Name:      "hibernate",
+				Usage:     "Scale workloads to zero and suspend cron jobs, recording their state for resume",
+				UsageText: appName + " cluster hibernate [OPTIONS]",
+				Action:    hibernate,
+				Flags:     flags,
+			},
+			{
+				Name:      "resume",
+				Usage:     "Restore workloads scaled down by hibernate",
+				UsageText: appName + " cluster resume [OPTIONS]",
+				Action:    resume,
+				Flags:     flags,
+			},
+		},
+	}
+}
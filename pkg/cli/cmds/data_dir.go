@@ -0,0 +1,54 @@
+package cmds
+
+import (
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/urfave/cli"
+)
+
+type DataDir struct {
+	DataDir    string
+	To         string
+	ConfigFile string
+	NoRestart  bool
+}
+
+var DataDirConfig DataDir
+
+func NewDataDirCommand(migrate func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "data-dir",
+		Usage: "Manage the data directory",
+		Subcommands: []cli.Command{
+			{
+				Name:      "migrate",
+				Usage:     "Move the data directory to another path, such as a larger disk, stopping k3s and its containers and starting it again from the new path",
+				UsageText: appName + " data-dir migrate [OPTIONS] --to DIR",
+				Action:    migrate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "data-dir,d",
+						Usage:       "Folder holding the current state",
+						Destination: &DataDirConfig.DataDir,
+					},
+					cli.StringFlag{
+						Name:        "to",
+						Usage:       "Missing or empty directory to move the state to",
+						Destination: &DataDirConfig.To,
+					},
+					cli.StringFlag{
+						Name:        "config",
+						Usage:       "Config file to set the new data-dir in",
+						EnvVar:      "K3S_CONFIG_FILE",
+						Value:       configfilearg.DefaultConfigFile,
+						Destination: &DataDirConfig.ConfigFile,
+					},
+					cli.BoolFlag{
+						Name:        "no-restart",
+						Usage:       "Leave k3s stopped after the move",
+						Destination: &DataDirConfig.NoRestart,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Datastore struct {
+	DataDir     string
+	Endpoint    string
+	CAFile      string
+	CertFile    string
+	KeyFile     string
+	EtcdDataDir string
+}
+
+var DatastoreConfig Datastore
+
+func NewDatastoreCommand(migrate, relocate func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "datastore",
+		Usage: "Manage the server datastore",
+		Subcommands: []cli.Command{
+			{
+				Name:      "migrate",
+				Usage:     "Copy the embedded sqlite datastore to an empty MySQL or PostgreSQL datastore, the server must be stopped",
+				UsageText: appName + " datastore migrate [OPTIONS] --datastore-endpoint ENDPOINT",
+				Action:    migrate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "data-dir,d",
+						Usage:       "Folder holding server state",
+						Destination: &DatastoreConfig.DataDir,
+					},
+					cli.StringFlag{
+						Name:        "datastore-endpoint",
+						Usage:       "Datastore to migrate to (mysql://... or postgres://...)",
+						Destination: &DatastoreConfig.Endpoint,
+					},
+					cli.StringFlag{
+						Name:        "datastore-cafile",
+						Usage:       "SSL Certificate Authority file used to secure datastore communication",
+						Destination: &DatastoreConfig.CAFile,
+					},
+					cli.StringFlag{
+						Name:        "datastore-certfile",
+						Usage:       "SSL certification file used to secure datastore communication",
+						Destination: &DatastoreConfig.CertFile,
+					},
+					cli.StringFlag{
+						Name:        "datastore-keyfile",
+						Usage:       "SSL key file used to secure datastore communication",
+						Destination: &DatastoreConfig.KeyFile,
+					},
+				},
+			},
+			{
+				Name:      "relocate",
+				Usage:     "Move the embedded sqlite datastore to another directory, such as a dedicated disk, the server must be stopped",
+				UsageText: appName + " datastore relocate [OPTIONS] --etcd-data-dir DIR",
+				Action:    relocate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "data-dir,d",
+						Usage:       "Folder holding server state",
+						Destination: &DatastoreConfig.DataDir,
+					},
+					cli.StringFlag{
+						Name:        "etcd-data-dir",
+						Usage:       "Empty directory to move the datastore to, start the server with the same --etcd-data-dir",
+						Destination: &DatastoreConfig.EtcdDataDir,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package cmds
+
+import (
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/urfave/cli"
+)
+
+type DiskUsage struct {
+	DataDir    string
+	ConfigFile string
+}
+
+var DiskUsageConfig DiskUsage
+
+func NewDiskUsageCommand(action func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:      "du",
+		Usage:     "Show the disk space used by k3s, broken down by data dir, datastore, snapshots, images, kubelet and logs",
+		UsageText: appName + " du [OPTIONS]",
+		Action:    action,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "data-dir,d",
+				Usage:       "Folder holding the state, if not set in the config file",
+				Destination: &DiskUsageConfig.DataDir,
+			},
+			cli.StringFlag{
+				Name:        "config",
+				Usage:       "Config file to read the kubelet, containerd, snapshot and log paths from",
+				EnvVar:      "K3S_CONFIG_FILE",
+				Value:       configfilearg.DefaultConfigFile,
+				Destination: &DiskUsageConfig.ConfigFile,
+			},
+		},
+	}
+}
@@ -0,0 +1,146 @@
+package cmds
+
+import (
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/urfave/cli"
+)
+
+type EtcdSnapshot struct {
+	DataDir     string
+	Dir         string
+	Name        string
+	Retention   int
+	S3          bool
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Folder    string
+	S3AccessKey string
+	S3SecretKey string
+	S3Insecure  bool
+	Key         string
+	KeyFile     string
+}
+
+var EtcdSnapshotConfig EtcdSnapshot
+
+func NewEtcdSnapshotCommand(save, list, prune, delete func(*cli.Context) error) cli.Command {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "data-dir,d",
+			Usage:       "Folder holding server state",
+			Destination: &EtcdSnapshotConfig.DataDir,
+		},
+		cli.StringFlag{
+			Name:        "dir",
+			Usage:       "Folder to keep snapshots in (default: ${data-dir}/server/db/snapshots)",
+			Destination: &EtcdSnapshotConfig.Dir,
+		},
+		cli.BoolFlag{
+			Name:        "s3",
+			Usage:       "Also store snapshots in S3 compatible object storage",
+			Destination: &EtcdSnapshotConfig.S3,
+		},
+		cli.StringFlag{
+			Name:        "s3-endpoint",
+			Usage:       "S3 endpoint host",
+			Value:       "s3.amazonaws.com",
+			Destination: &EtcdSnapshotConfig.S3Endpoint,
+		},
+		cli.StringFlag{
+			Name:        "s3-region",
+			Usage:       "S3 region",
+			Value:       "us-east-1",
+			Destination: &EtcdSnapshotConfig.S3Region,
+		},
+		cli.StringFlag{
+			Name:        "s3-bucket",
+			Usage:       "S3 bucket name",
+			Destination: &EtcdSnapshotConfig.S3Bucket,
+		},
+		cli.StringFlag{
+			Name:        "s3-folder",
+			Usage:       "S3 folder within the bucket",
+			Destination: &EtcdSnapshotConfig.S3Folder,
+		},
+		cli.StringFlag{
+			Name:        "s3-access-key",
+			Usage:       "S3 access key",
+			EnvVar:      "AWS_ACCESS_KEY_ID",
+			Destination: &EtcdSnapshotConfig.S3AccessKey,
+		},
+		cli.StringFlag{
+			Name:        "s3-secret-key",
+			Usage:       "S3 secret key",
+			EnvVar:      "AWS_SECRET_ACCESS_KEY",
+			Destination: &EtcdSnapshotConfig.S3SecretKey,
+		},
+		cli.BoolFlag{
+			Name:        "s3-insecure",
+			Usage:       "Use http rather than https to reach S3",
+			Destination: &EtcdSnapshotConfig.S3Insecure,
+		},
+	}
+	nameFlag := cli.StringFlag{
+		Name:        "name",
+		Usage:       "Name prefix of the snapshots",
+		Value:       snapshot.DefaultName,
+		Destination: &EtcdSnapshotConfig.Name,
+	}
+
+	keyFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:        "etcd-snapshot-encryption-key",
+			Usage:       "Base64 encoded 32 byte key to encrypt the snapshot with",
+			EnvVar:      "K3S_ETCD_SNAPSHOT_ENCRYPTION_KEY",
+			Destination: &EtcdSnapshotConfig.Key,
+		},
+		cli.StringFlag{
+			Name:        "etcd-snapshot-encryption-key-file",
+			Usage:       "File holding the snapshot encryption key",
+			Destination: &EtcdSnapshotConfig.KeyFile,
+		},
+	}
+
+	return cli.Command{
+		Name:  "etcd-snapshot",
+		Usage: "Take and manage snapshots of the embedded datastore",
+		Subcommands: []cli.Command{
+			{
+				Name:      "save",
+				Usage:     "Take a snapshot of the datastore",
+				UsageText: appName + " etcd-snapshot save [OPTIONS]",
+				Action:    save,
+				Flags:     append(append(flags, nameFlag), keyFlags...),
+			},
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "List snapshots",
+				UsageText: appName + " etcd-snapshot list [OPTIONS]",
+				Action:    list,
+				Flags:     flags,
+			},
+			{
+				Name:      "prune",
+				Usage:     "Remove snapshots beyond the retention limit",
+				UsageText: appName + " etcd-snapshot prune [OPTIONS]",
+				Action:    prune,
+				Flags: append(flags, nameFlag, cli.IntFlag{
+					Name:        "snapshot-retention",
+					Usage:       "Number of snapshots to keep",
+					Value:       5,
+					Destination: &EtcdSnapshotConfig.Retention,
+				}),
+			},
+			{
+				Name:      "delete",
+				Aliases:   []string{"rm"},
+				Usage:     "Delete snapshots",
+				UsageText: appName + " etcd-snapshot delete [OPTIONS] NAME...",
+				Action:    delete,
+				Flags:     flags,
+			},
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Generate struct {
+	Role           string
+	ServerURL      string
+	Token          string
+	Format         string
+	ConfigFile     string
+	RegistriesFile string
+	InstallURL     string
+}
+
+var GenerateConfig Generate
+
+func NewGenerateCommand(action func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "generate",
+		Usage: "Generate node provisioning documents",
+		Subcommands: []cli.Command{
+			{
+				Name:      "cloud-init",
+				Usage:     "Generate a cloud-init or ignition document that installs and configures k3s",
+				UsageText: appName + " generate cloud-init [OPTIONS] [-- K3S FLAGS]",
+				Action:    action,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "role",
+						Usage:       "Role of the provisioned node (one of: server, agent)",
+						Value:       "agent",
+						Destination: &GenerateConfig.Role,
+					},
+					cli.StringFlag{
+						Name:        "server,s",
+						Usage:       "Server the provisioned node connects to",
+						EnvVar:      "K3S_URL",
+						Destination: &GenerateConfig.ServerURL,
+					},
+					cli.StringFlag{
+						Name:        "token,t",
+						Usage:       "Token the provisioned node uses for authentication",
+						EnvVar:      "K3S_TOKEN",
+						Destination: &GenerateConfig.Token,
+					},
+					cli.StringFlag{
+						Name:        "format",
+						Usage:       "Output format (one of: cloud-init, ignition)",
+						Value:       "cloud-init",
+						Destination: &GenerateConfig.Format,
+					},
+					cli.StringFlag{
+						Name:        "config-file",
+						Usage:       "Config file to merge into the generated config.yaml",
+						Destination: &GenerateConfig.ConfigFile,
+					},
+					cli.StringFlag{
+						Name:        "registries-file",
+						Usage:       "Registries file to install as registries.yaml",
+						Destination: &GenerateConfig.RegistriesFile,
+					},
+					cli.StringFlag{
+						Name:        "install-url",
+						Usage:       "URL of the k3s install script",
+						Value:       "https://get.k3s.io",
+						Destination: &GenerateConfig.InstallURL,
+					},
+				},
+			},
+		},
+	}
+}
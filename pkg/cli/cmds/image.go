@@ -0,0 +1,108 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Image struct {
+	ContainerdAddress string
+	Platform          string
+	AllPlatforms      bool
+	Output            string
+	Input             string
+	Quiet             bool
+}
+
+var ImageConfig Image
+
+func NewImageCommand(list, pull, remove, tag, save, load func(*cli.Context) error) cli.Command {
+	addressFlag := cli.StringFlag{
+		Name:        "containerd-address",
+		Usage:       "Address of the containerd socket",
+		Value:       "/run/k3s/containerd/containerd.sock",
+		Destination: &ImageConfig.ContainerdAddress,
+	}
+	platformFlag := cli.StringFlag{
+		Name:        "platform",
+		Usage:       "Platform of the image to use (default: the platform of this node), images for other platforms are not unpacked",
+		Destination: &ImageConfig.Platform,
+	}
+	allPlatformsFlag := cli.BoolFlag{
+		Name:        "all-platforms",
+		Usage:       "Use the image for all platforms instead of a single one",
+		Destination: &ImageConfig.AllPlatforms,
+	}
+
+	return cli.Command{
+		Name:  "image",
+		Usage: "Manage images in the containerd used by Kubernetes",
+		Subcommands: []cli.Command{
+			{
+				Name:      "ls",
+				Usage:     "List images",
+				UsageText: appName + " image ls [OPTIONS]",
+				Action:    list,
+				Flags: []cli.Flag{
+					addressFlag,
+					cli.BoolFlag{
+						Name:        "quiet,q",
+						Usage:       "Only print image references",
+						Destination: &ImageConfig.Quiet,
+					},
+				},
+			},
+			{
+				Name:      "pull",
+				Usage:     "Pull and unpack an image",
+				UsageText: appName + " image pull [OPTIONS] IMAGE",
+				Action:    pull,
+				Flags:     []cli.Flag{addressFlag, platformFlag, allPlatformsFlag},
+			},
+			{
+				Name:      "rm",
+				Usage:     "Remove images",
+				UsageText: appName + " image rm [OPTIONS] IMAGE [IMAGE...]",
+				Action:    remove,
+				Flags:     []cli.Flag{addressFlag},
+			},
+			{
+				Name:      "tag",
+				Usage:     "Add a reference to an image",
+				UsageText: appName + " image tag [OPTIONS] SOURCE TARGET",
+				Action:    tag,
+				Flags:     []cli.Flag{addressFlag},
+			},
+			{
+				Name:      "save",
+				Usage:     "Export images to an OCI archive",
+				UsageText: appName + " image save [OPTIONS] IMAGE",
+				Action:    save,
+				Flags: []cli.Flag{
+					addressFlag,
+					platformFlag,
+					allPlatformsFlag,
+					cli.StringFlag{
+						Name:        "output,o",
+						Usage:       "File to write to (default: stdout)",
+						Destination: &ImageConfig.Output,
+					},
+				},
+			},
+			{
+				Name:      "load",
+				Usage:     "Import images from an OCI or docker archive",
+				UsageText: appName + " image load [OPTIONS]",
+				Action:    load,
+				Flags: []cli.Flag{
+					addressFlag,
+					platformFlag,
+					cli.StringFlag{
+						Name:        "input,i",
+						Usage:       "File to read from (default: stdin)",
+						Destination: &ImageConfig.Input,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+type Node struct {
+	Namespace         string
+	OutputDir         string
+	ContainerdAddress string
+	To                string
+	KubeConfig        string
+	Timeout           time.Duration
+	Component         string
+	Follow            bool
+	Tail              int
+	Drain             bool
+	Force             bool
+	DeleteLocalData   bool
+	Reason            string
+}
+
+var NodeConfig Node
+
+var (
+	forceFlag = cli.BoolFlag{
+		Name:        "force",
+		Usage:       "Also evict pods not managed by a controller, they are not recreated elsewhere",
+		Destination: &NodeConfig.Force,
+	}
+	deleteLocalDataFlag = cli.BoolFlag{
+		Name:        "delete-local-data",
+		Usage:       "Also evict pods using emptyDir volumes, their data is lost",
+		Destination: &NodeConfig.DeleteLocalData,
+	}
+)
+
+func NewNodeCommand(checkpoint, evacuate, logs, maintenance func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "node",
+		Usage: "Manage workloads running on this node",
+		Subcommands: []cli.Command{
+			{
+				Name:      "checkpoint",
+				Usage:     "Checkpoint the containers of a pod running on this node with CRIU",
+				UsageText: appName + " node checkpoint [OPTIONS] POD",
+				Action:    checkpoint,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "namespace,n",
+						Usage:       "Namespace of the pod",
+						Value:       "default",
+						Destination: &NodeConfig.Namespace,
+					},
+					cli.StringFlag{
+						Name:        "output-dir,o",
+						Usage:       "Folder to write an OCI checkpoint archive for each container to",
+						Value:       ".",
+						Destination: &NodeConfig.OutputDir,
+					},
+					cli.StringFlag{
+						Name:        "containerd-address",
+						Usage:       "Address of the containerd socket",
+						Value:       "/run/k3s/containerd/containerd.sock",
+						Destination: &NodeConfig.ContainerdAddress,
+					},
+				},
+			},
+			{
+				Name:      "evacuate",
+				Usage:     "Cordon a node and move its pods elsewhere",
+				UsageText: appName + " node evacuate [OPTIONS] NODE",
+				Action:    evacuate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "to",
+						Usage:       "Node that should receive the evacuated pods, it must be ready and schedulable, the other nodes are tainted PreferNoSchedule until the pods are rescheduled",
+						Destination: &NodeConfig.To,
+					},
+					forceFlag,
+					deleteLocalDataFlag,
+					cli.StringFlag{
+						Name:        "kubeconfig",
+						Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+						EnvVar:      "KUBECONFIG",
+						Destination: &NodeConfig.KubeConfig,
+					},
+					cli.DurationFlag{
+						Name:        "timeout",
+						Usage:       "How long to wait for evacuated pods to be rescheduled, including evictions blocked by pod disruption budgets",
+						Value:       5 * time.Minute,
+						Destination: &NodeConfig.Timeout,
+					},
+				},
+			},
+			{
+				Name:      "logs",
+				Usage:     "Print the logs of a node, streamed through the agent tunnel",
+				UsageText: appName + " node logs [OPTIONS] NODE",
+				Action:    logs,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "component,c",
+						Usage:       "Component to print logs of: agent, kubelet, containerd or buildkit",
+						Value:       "agent",
+						Destination: &NodeConfig.Component,
+					},
+					cli.BoolFlag{
+						Name:        "follow,f",
+						Usage:       "Keep streaming new log lines",
+						Destination: &NodeConfig.Follow,
+					},
+					cli.IntFlag{
+						Name:        "tail",
+						Usage:       "Number of recent log lines to print",
+						Value:       100,
+						Destination: &NodeConfig.Tail,
+					},
+					cli.StringFlag{
+						Name:        "kubeconfig",
+						Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+						EnvVar:      "KUBECONFIG",
+						Destination: &NodeConfig.KubeConfig,
+					},
+				},
+			},
+			{
+				Name:      "maintenance",
+				Usage:     "Turn maintenance mode of a node on or off, a node under maintenance stays cordoned across reboots",
+				UsageText: appName + " node maintenance [OPTIONS] on|off [NODE]",
+				Action:    maintenance,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:        "drain",
+						Usage:       "Evict the pods of the node when turning maintenance on",
+						Destination: &NodeConfig.Drain,
+					},
+					forceFlag,
+					deleteLocalDataFlag,
+					cli.StringFlag{
+						Name:        "reason",
+						Usage:       "Reason for the maintenance, recorded on the node",
+						Destination: &NodeConfig.Reason,
+					},
+					cli.DurationFlag{
+						Name:        "timeout",
+						Usage:       "How long to wait for drained pods to be gone, including evictions blocked by pod disruption budgets",
+						Value:       5 * time.Minute,
+						Destination: &NodeConfig.Timeout,
+					},
+					cli.StringFlag{
+						Name:        "kubeconfig",
+						Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+						EnvVar:      "KUBECONFIG",
+						Destination: &NodeConfig.KubeConfig,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+type RestartLock struct {
+	KubeConfig string
+	Holder     string
+	Duration   time.Duration
+	Wait       time.Duration
+}
+
+var RestartLockConfig RestartLock
+
+func NewRestartLockCommand(acquire, release, status func(*cli.Context) error) cli.Command {
+	kubeConfigFlag := cli.StringFlag{
+		Name:        "kubeconfig",
+		Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+		EnvVar:      "KUBECONFIG",
+		Destination: &RestartLockConfig.KubeConfig,
+	}
+	holderFlag := cli.StringFlag{
+		Name:        "holder",
+		Usage:       "Name the lock is held under, defaults to the hostname that the server releases the lock for once it is back",
+		Destination: &RestartLockConfig.Holder,
+	}
+
+	return cli.Command{
+		Name:  "restart-lock",
+		Usage: "Coordinate restarts of servers so that only one restarts at a time",
+		Subcommands: []cli.Command{
+			{
+				Name:      "acquire",
+				Usage:     "Wait until no other server is restarting and the datastore is healthy, then take the lock",
+				UsageText: appName + " restart-lock acquire [OPTIONS]",
+				Action:    acquire,
+				Flags: []cli.Flag{
+					kubeConfigFlag,
+					holderFlag,
+					cli.DurationFlag{
+						Name:        "duration",
+						Usage:       "Time after which the lock expires if the server does not come back",
+						Value:       10 * time.Minute,
+						Destination: &RestartLockConfig.Duration,
+					},
+					cli.DurationFlag{
+						Name:        "wait",
+						Usage:       "Time to wait for the lock before failing",
+						Value:       5 * time.Minute,
+						Destination: &RestartLockConfig.Wait,
+					},
+				},
+			},
+			{
+				Name:      "release",
+				Usage:     "Release the lock, servers release it themselves once they are up",
+				UsageText: appName + " restart-lock release [OPTIONS]",
+				Action:    release,
+				Flags:     []cli.Flag{kubeConfigFlag, holderFlag},
+			},
+			{
+				Name:      "status",
+				Usage:     "Show which server holds the lock",
+				UsageText: appName + " restart-lock status [OPTIONS]",
+				Action:    status,
+				Flags:     []cli.Flag{kubeConfigFlag},
+			},
+		},
+	}
+}
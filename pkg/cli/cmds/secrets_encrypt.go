@@ -0,0 +1,68 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type SecretsEncrypt struct {
+	DataDir    string
+	KubeConfig string
+}
+
+var SecretsEncryptConfig SecretsEncrypt
+
+func NewSecretsEncryptCommand(status, prepare, rotate, reencrypt, dropOldKeys func(*cli.Context) error) cli.Command {
+	dataDirFlag := cli.StringFlag{
+		Name:        "data-dir,d",
+		Usage:       "Folder holding server state",
+		Destination: &SecretsEncryptConfig.DataDir,
+	}
+	kubeConfigFlag := cli.StringFlag{
+		Name:        "kubeconfig",
+		Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+		EnvVar:      "KUBECONFIG",
+		Destination: &SecretsEncryptConfig.KubeConfig,
+	}
+
+	return cli.Command{
+		Name:  "secrets-encrypt",
+		Usage: "Manage the keys secrets are encrypted with, run on every server",
+		Subcommands: []cli.Command{
+			{
+				Name:      "status",
+				Usage:     "Show the secrets encryption keys and rotation stage",
+				UsageText: appName + " secrets-encrypt status [OPTIONS]",
+				Action:    status,
+				Flags:     []cli.Flag{dataDirFlag},
+			},
+			{
+				Name:      "prepare",
+				Usage:     "Add a new key that can decrypt secrets, restart k3s afterwards",
+				UsageText: appName + " secrets-encrypt prepare [OPTIONS]",
+				Action:    prepare,
+				Flags:     []cli.Flag{dataDirFlag},
+			},
+			{
+				Name:      "rotate",
+				Usage:     "Encrypt secrets with the prepared key, restart k3s afterwards",
+				UsageText: appName + " secrets-encrypt rotate [OPTIONS]",
+				Action:    rotate,
+				Flags:     []cli.Flag{dataDirFlag},
+			},
+			{
+				Name:      "reencrypt",
+				Usage:     "Rewrite all secrets with the current key, once every server encrypts with it",
+				UsageText: appName + " secrets-encrypt reencrypt [OPTIONS]",
+				Action:    reencrypt,
+				Flags:     []cli.Flag{dataDirFlag, kubeConfigFlag},
+			},
+			{
+				Name:      "drop-old-keys",
+				Usage:     "Remove the old keys once every server encrypts with the current key and all secrets were rewritten, restart k3s afterwards",
+				UsageText: appName + " secrets-encrypt drop-old-keys [OPTIONS]",
+				Action:    dropOldKeys,
+				Flags:     []cli.Flag{dataDirFlag, kubeConfigFlag},
+			},
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+type Token struct {
+	DataDir     string
+	KubeConfig  string
+	TTL         time.Duration
+	Description string
+}
+
+var TokenConfig Token
+
+func NewTokenCommand(create, list, delete, rotate func(*cli.Context) error) cli.Command {
+	kubeConfigFlag := cli.StringFlag{
+		Name:        "kubeconfig",
+		Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+		EnvVar:      "KUBECONFIG",
+		Destination: &TokenConfig.KubeConfig,
+	}
+
+	return cli.Command{
+		Name:  "token",
+		Usage: "Manage tokens agents join the cluster with",
+		Subcommands: []cli.Command{
+			{
+				Name:      "create",
+				Usage:     "Create a join token agents can use until it expires",
+				UsageText: appName + " token create [OPTIONS]",
+				Action:    create,
+				Flags: []cli.Flag{
+					kubeConfigFlag,
+					cli.DurationFlag{
+						Name:        "ttl",
+						Usage:       "Duration before the token expires, must be positive",
+						Value:       24 * time.Hour,
+						Destination: &TokenConfig.TTL,
+					},
+					cli.StringFlag{
+						Name:        "description",
+						Usage:       "Description of what the token is for",
+						Destination: &TokenConfig.Description,
+					},
+				},
+			},
+			{
+				Name:      "list",
+				Usage:     "List join tokens",
+				UsageText: appName + " token list [OPTIONS]",
+				Action:    list,
+				Flags:     []cli.Flag{kubeConfigFlag},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete join tokens",
+				UsageText: appName + " token delete [OPTIONS] ID...",
+				Action:    delete,
+				Flags:     []cli.Flag{kubeConfigFlag},
+			},
+			{
+				Name:      "rotate",
+				Usage:     "Replace the node token, takes effect when the servers restart, the agent token and join tokens are not replaced",
+				UsageText: appName + " token rotate [OPTIONS]",
+				Action:    rotate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "data-dir,d",
+						Usage:       "Folder holding server state",
+						Destination: &TokenConfig.DataDir,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,41 @@
+package cmds
+
+import (
+	"github.com/urfave/cli"
+)
+
+type Tunnel struct {
+	KubeConfig string
+	Address    string
+}
+
+var TunnelConfig Tunnel
+
+func NewTunnelCommand(portForward func(*cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "tunnel",
+		Usage: "Reach node-local services through the agent tunnel",
+		Subcommands: []cli.Command{
+			{
+				Name:      "port-forward",
+				Usage:     "Forward a local port to a port on a node; the agent must allow it with --tunnel-port unless it is the kubelet",
+				UsageText: appName + " tunnel port-forward [OPTIONS] NODE LOCAL_PORT:[HOST:]NODE_PORT",
+				Action:    portForward,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:        "kubeconfig",
+						Usage:       "Kubeconfig to use, defaults to the k3s admin kubeconfig",
+						EnvVar:      "KUBECONFIG",
+						Destination: &TunnelConfig.KubeConfig,
+					},
+					cli.StringFlag{
+						Name:        "address",
+						Usage:       "Local address to listen on",
+						Value:       "127.0.0.1",
+						Destination: &TunnelConfig.Address,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,359 @@
+package datadir
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const killallScript = "k3s-killall.sh"
+
+// bulkDirs hold images, containers, volumes, binaries and the datastore. They
+// do not refer to the data dir by path, and are too large to scan.
+var bulkDirs = map[string]bool{
+	"agent/buildkit":   true,
+	"agent/containerd": true,
+	"agent/kubelet":    true,
+	"data":             true,
+	"server/db":        true,
+}
+
+type service struct {
+	name    string
+	systemd bool
+}
+
+// Migrate moves the data dir to --to. k3s and its containers are stopped
+// first, as they run from the data dir, and k3s is started again from the new
+// path once the paths in its config are updated.
+func Migrate(app *cli.Context) error {
+	cfg := cmds.DataDirConfig
+	if cfg.To == "" {
+		return fmt.Errorf("--to is required")
+	}
+
+	from, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	if from, err = filepath.Abs(from); err != nil {
+		return err
+	}
+	if from, err = filepath.EvalSymlinks(from); err != nil {
+		return err
+	}
+	to, err := filepath.Abs(cfg.To)
+	if err != nil {
+		return err
+	}
+	if within(to, from) || within(from, to) {
+		return fmt.Errorf("%s and %s must not contain each other", from, to)
+	}
+	if files, err := ioutil.ReadDir(to); err == nil && len(files) > 0 {
+		return fmt.Errorf("%s is not empty", to)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	services := activeServices()
+	if err := stop(services); err != nil {
+		return err
+	}
+
+	if err := checkUnused(from); err != nil {
+		start(services, false)
+		return err
+	}
+
+	logrus.Infof("Moving %s to %s", from, to)
+	old, err := move(from, to)
+	if err != nil {
+		start(services, false)
+		return err
+	}
+
+	if err := rewriteTree(to, from, to); err != nil {
+		return errors.Wrapf(err, "the data dir was moved to %s, but updating the paths in it failed", to)
+	}
+	if err := updateConfig(cfg.ConfigFile, from, to); err != nil {
+		return errors.Wrapf(err, "the data dir was moved to %s, but updating %s failed", to, cfg.ConfigFile)
+	}
+	reload, err := updateServices(from, to)
+	if err != nil {
+		return errors.Wrapf(err, "the data dir was moved to %s, but updating the services failed", to)
+	}
+
+	if !cfg.NoRestart {
+		if err := start(services, reload); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Data dir moved to %s\n", to)
+	if old != "" {
+		fmt.Printf("The previous data dir was kept at %s, remove it once k3s is up\n", old)
+	}
+	return nil
+}
+
+// activeServices returns the k3s services installed by the install script
+// that are running.
+func activeServices() []service {
+	var services []service
+	units, _ := filepath.Glob("/etc/systemd/system/k3s*.service")
+	for _, unit := range units {
+		name := filepath.Base(unit)
+		if exec.Command("systemctl", "is-active", "--quiet", name).Run() == nil {
+			services = append(services, service{name: name, systemd: true})
+		}
+	}
+	scripts, _ := filepath.Glob("/etc/init.d/k3s*")
+	for _, script := range scripts {
+		if exec.Command(script, "status").Run() == nil {
+			services = append(services, service{name: script})
+		}
+	}
+	return services
+}
+
+// stop stops k3s and the containers it started, with the killall script if it
+// is installed, otherwise only the services.
+func stop(services []service) error {
+	if script, err := exec.LookPath(killallScript); err == nil {
+		logrus.Infof("Stopping k3s and all containers with %s", script)
+		if out, err := exec.Command(script).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v: %s", script, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	for _, s := range services {
+		logrus.Infof("Stopping %s", s.name)
+		if err := s.run("stop"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func start(services []service, reload bool) error {
+	if reload {
+		if out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl daemon-reload: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, s := range services {
+		logrus.Infof("Starting %s", s.name)
+		if err := s.run("start"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s service) run(action string) error {
+	cmd := exec.Command(s.name, action)
+	if s.systemd {
+		cmd = exec.Command("systemctl", action, s.name)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", action, s.name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checkUnused fails if processes other than this one run from dir, such as
+// container shims, or if filesystems are mounted below it, such as container
+// root filesystems and pod volumes.
+func checkUnused(dir string) error {
+	self := strconv.Itoa(os.Getpid())
+	var pids []string
+	exes, _ := filepath.Glob("/proc/[0-9]*/exe")
+	for _, exe := range exes {
+		pid := filepath.Base(filepath.Dir(exe))
+		if path, err := os.Readlink(exe); err == nil && pid != self && within(path, dir) {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) > 0 {
+		return fmt.Errorf("processes %s still run from %s, stop k3s and its containers with %s first", strings.Join(pids, ", "), dir, killallScript)
+	}
+
+	mounts, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(mounts), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && within(fields[1], dir) {
+			return fmt.Errorf("%s is still mounted, stop k3s and its containers with %s first", fields[1], killallScript)
+		}
+	}
+	return nil
+}
+
+// move renames from to to, or copies it if to is on another filesystem. The
+// copied data dir is kept aside, and the path it was moved to returned.
+func move(from, to string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return "", err
+	}
+	err := os.Rename(from, to)
+	if err == nil {
+		return "", nil
+	}
+	if linkErr, ok := err.(*os.LinkError); !ok || (linkErr.Err != syscall.EXDEV && linkErr.Err != syscall.EBUSY) {
+		return "", err
+	}
+
+	logrus.Infof("%s is on another filesystem, copying", to)
+	if err := os.MkdirAll(to, 0700); err != nil {
+		return "", err
+	}
+	if out, err := exec.Command("cp", "-a", from+"/.", to).CombinedOutput(); err != nil {
+		cleanup(to)
+		return "", fmt.Errorf("copying %s to %s: %v: %s", from, to, err, strings.TrimSpace(string(out)))
+	}
+
+	old := fmt.Sprintf("%s.migrated-%d", from, time.Now().Unix())
+	return old, os.Rename(from, old)
+}
+
+// cleanup empties to after a failed copy, leaving it in place as it may be a
+// mount point.
+func cleanup(to string) {
+	files, _ := ioutil.ReadDir(to)
+	for _, file := range files {
+		os.RemoveAll(filepath.Join(to, file.Name()))
+	}
+}
+
+// rewriteTree replaces the old path in the generated config files of the data
+// dir, such as kubeconfigs referring to certificates, and in its symlinks.
+func rewriteTree(dir, from, to string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if bulkDirs[rel] {
+				return filepath.SkipDir
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil || !within(target, from) {
+				return err
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			return os.Symlink(to+strings.TrimPrefix(target, from), path)
+		case info.Mode().IsRegular() && info.Size() < 1<<20:
+			_, err := rewriteFile(path, from, to)
+			return err
+		}
+		return nil
+	})
+}
+
+// rewriteFile replaces the old path in a text file, returning whether it
+// changed.
+func rewriteFile(path, from, to string) (bool, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !bytes.Contains(content, []byte(from)) || !utf8.Valid(content) {
+		return false, nil
+	}
+
+	ref := regexp.MustCompile(regexp.QuoteMeta(from) + `([/"'\s]|$)`)
+	updated := ref.ReplaceAllFunc(content, func(match []byte) []byte {
+		return append([]byte(to), match[len(from):]...)
+	})
+	if bytes.Equal(updated, content) {
+		return false, nil
+	}
+	logrus.Infof("Updating %s", path)
+	return true, ioutil.WriteFile(path, updated, 0600)
+}
+
+// updateConfig points the config file at the new data dir, replacing the old
+// path in it or adding data-dir.
+func updateConfig(file, from, to string) error {
+	if _, err := rewriteFile(file, from, to); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	values, err := configfilearg.Values(content)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"data-dir", "d"} {
+		if dirs, ok := values[key]; ok {
+			if len(dirs) == 1 && dirs[0] == to {
+				return nil
+			}
+			return fmt.Errorf("%s sets %s to %v, set it to %s", file, key, dirs, to)
+		}
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, fmt.Sprintf("data-dir: %q\n", to)...)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	logrus.Infof("Setting data-dir in %s", file)
+	return ioutil.WriteFile(file, content, 0600)
+}
+
+// updateServices replaces the old path in the services and their environment
+// files, returning whether systemd units changed.
+func updateServices(from, to string) (bool, error) {
+	var reload bool
+	for _, pattern := range []string{"/etc/systemd/system/k3s*.service", "/etc/systemd/system/k3s*.service.env", "/etc/init.d/k3s*", "/etc/rancher/k3s/k3s*.env"} {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			changed, err := rewriteFile(file, from, to)
+			if err != nil {
+				return false, err
+			}
+			if changed && strings.HasPrefix(file, "/etc/systemd/") {
+				reload = true
+			}
+		}
+	}
+	return reload, nil
+}
+
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/datastore"
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/urfave/cli"
+)
+
+// Migrate copies the embedded datastore to an external SQL datastore.
+func Migrate(app *cli.Context) error {
+	cfg := cmds.DatastoreConfig
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("--datastore-endpoint is required")
+	}
+
+	dataDir, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	dbFile := snapshot.DBFile(filepath.Join(dataDir, "server"))
+	if _, err := os.Stat(dbFile); err != nil {
+		return err
+	}
+
+	copied, err := datastore.Migrate(context.Background(), dbFile, cfg.Endpoint, &transport.TLSInfo{
+		CAFile:   cfg.CAFile,
+		CertFile: cfg.CertFile,
+		KeyFile:  cfg.KeyFile,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("migrated %d rows, start the server with --datastore-endpoint to use the new datastore\n", copied)
+	return nil
+}
@@ -0,0 +1,37 @@
+package datastore
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/datastore"
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/urfave/cli"
+)
+
+// Relocate moves the embedded datastore to --etcd-data-dir.
+func Relocate(app *cli.Context) error {
+	cfg := cmds.DatastoreConfig
+	if cfg.EtcdDataDir == "" {
+		return fmt.Errorf("--etcd-data-dir is required")
+	}
+
+	dataDir, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	dbDir := filepath.Dir(snapshot.DBFile(filepath.Join(dataDir, "server")))
+
+	old, err := datastore.Relocate(dbDir, cfg.EtcdDataDir)
+	if err != nil {
+		return err
+	}
+	if err := datastore.CheckDisk(cfg.EtcdDataDir); err != nil {
+		return err
+	}
+
+	fmt.Printf("datastore moved to %s, start the server with --etcd-data-dir %s and remove %s once it is up\n", cfg.EtcdDataDir, cfg.EtcdDataDir, old)
+	return nil
+}
@@ -0,0 +1,66 @@
+package diskusage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/diskusage"
+	"github.com/urfave/cli"
+)
+
+// Run prints the space used below each path k3s writes to, taking the paths
+// k3s was configured with from the config file.
+func Run(app *cli.Context) error {
+	cfg := cmds.DiskUsageConfig
+	content, err := ioutil.ReadFile(cfg.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	values, err := configfilearg.Values(content)
+	if err != nil {
+		return errors.Wrapf(err, "parsing config file %s", cfg.ConfigFile)
+	}
+	value := func(keys ...string) string {
+		for _, key := range keys {
+			if v := values[key]; len(v) > 0 {
+				return v[len(v)-1]
+			}
+		}
+		return ""
+	}
+
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = value("data-dir", "d")
+	}
+	dataDir, err = datadir.Resolve(dataDir)
+	if err != nil {
+		return err
+	}
+
+	dirs := diskusage.Dirs{
+		DataDir:        dataDir,
+		KubeletRoot:    value("kubelet-root-dir"),
+		ContainerdRoot: value("containerd-root"),
+		SnapshotDir:    value("etcd-snapshot-dir"),
+		LogFile:        value("log", "l"),
+	}
+	usages, err := diskusage.MeasureAll(dirs.Paths())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPATH\tSIZE\tFILESYSTEM USED")
+	for _, u := range usages {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d%% of %s\n", u.Name, u.Path.Path, units.BytesSize(float64(u.Bytes)), u.UsedPercent(), units.BytesSize(float64(u.FSSize)))
+	}
+	return w.Flush()
+}
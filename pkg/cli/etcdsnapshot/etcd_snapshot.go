@@ -0,0 +1,174 @@
+package etcdsnapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/urfave/cli"
+)
+
+// Save takes a snapshot of the datastore and uploads it to S3 if enabled.
+func Save(app *cli.Context) error {
+	serverDataDir, dir, err := dirs()
+	if err != nil {
+		return err
+	}
+	s3, err := s3Store()
+	if err != nil {
+		return err
+	}
+	key, err := snapshot.LoadKey(cmds.EtcdSnapshotConfig.Key, cmds.EtcdSnapshotConfig.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	s, err := snapshot.Save(snapshot.DBFile(serverDataDir), dir, cmds.EtcdSnapshotConfig.Name, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("saved %s\n", s.Location)
+
+	if s3 != nil {
+		if err := s3.Upload(context.Background(), s); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", s.Name, err)
+		}
+		fmt.Printf("uploaded %s\n", s3.Location(s.Name))
+	}
+	return nil
+}
+
+// List prints the local snapshots, and those in S3 if enabled.
+func List(app *cli.Context) error {
+	_, dir, err := dirs()
+	if err != nil {
+		return err
+	}
+	s3, err := s3Store()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := snapshot.List(dir)
+	if err != nil {
+		return err
+	}
+	if s3 != nil {
+		remote, err := s3.List(context.Background())
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, remote...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLOCATION\tSIZE\tCREATED")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", s.Name, s.Location, s.Size, s.Created.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// Prune removes snapshots beyond the retention limit, locally and in S3 if
+// enabled.
+func Prune(app *cli.Context) error {
+	_, dir, err := dirs()
+	if err != nil {
+		return err
+	}
+	s3, err := s3Store()
+	if err != nil {
+		return err
+	}
+
+	cfg := cmds.EtcdSnapshotConfig
+	removed, err := snapshot.Prune(dir, cfg.Name, cfg.Retention)
+	for _, name := range removed {
+		fmt.Printf("removed %s\n", filepath.Join(dir, name))
+	}
+	if err != nil {
+		return err
+	}
+
+	if s3 != nil {
+		removed, err := s3.Prune(context.Background(), cfg.Name, cfg.Retention)
+		for _, name := range removed {
+			fmt.Printf("removed %s\n", s3.Location(name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the named snapshots, locally and in S3 if enabled.
+func Delete(app *cli.Context) error {
+	if app.NArg() == 0 {
+		return fmt.Errorf("at least one snapshot name is required")
+	}
+	_, dir, err := dirs()
+	if err != nil {
+		return err
+	}
+	s3, err := s3Store()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range app.Args() {
+		if err := snapshot.Delete(dir, name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if s3 != nil {
+			if err := s3.Delete(context.Background(), name); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("deleted %s\n", name)
+	}
+	return nil
+}
+
+// dirs returns the server data dir and the snapshot dir.
+func dirs() (string, string, error) {
+	dataDir, err := datadir.Resolve(cmds.EtcdSnapshotConfig.DataDir)
+	if err != nil {
+		return "", "", err
+	}
+	serverDataDir := filepath.Join(dataDir, "server")
+
+	dir := cmds.EtcdSnapshotConfig.Dir
+	if dir == "" {
+		dir = snapshot.DefaultDir(serverDataDir)
+	}
+	return serverDataDir, dir, nil
+}
+
+func s3Store() (*snapshot.S3, error) {
+	cfg := cmds.EtcdSnapshotConfig
+	if !cfg.S3 {
+		return nil, nil
+	}
+	if cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("--s3-bucket is required with --s3")
+	}
+	if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+		return nil, fmt.Errorf("--s3-access-key and --s3-secret-key are required with --s3")
+	}
+	return &snapshot.S3{
+		Endpoint:  cfg.S3Endpoint,
+		Region:    cfg.S3Region,
+		Bucket:    cfg.S3Bucket,
+		Folder:    cfg.S3Folder,
+		AccessKey: cfg.S3AccessKey,
+		SecretKey: cfg.S3SecretKey,
+		Insecure:  cfg.S3Insecure,
+	}, nil
+}
@@ -0,0 +1,222 @@
+package generate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configPath     = "/etc/rancher/k3s/config.yaml"
+	registriesPath = "/etc/rancher/k3s/registries.yaml"
+)
+
+type file struct {
+	path    string
+	content []byte
+}
+
+func Run(ctx *cli.Context) error {
+	cfg := cmds.GenerateConfig
+
+	var command cli.Command
+	switch cfg.Role {
+	case "server":
+		command = cmds.NewServerCommand(nil)
+		if cfg.ServerURL != "" || cfg.Token != "" {
+			return fmt.Errorf("--server and --token are only valid for role agent")
+		}
+	case "agent":
+		command = cmds.NewAgentCommand(nil)
+		if cfg.ServerURL == "" || cfg.Token == "" {
+			return fmt.Errorf("--server and --token are required for role agent")
+		}
+	default:
+		return fmt.Errorf("invalid role %q, must be one of: server, agent", cfg.Role)
+	}
+
+	config := map[string]interface{}{}
+	if cfg.ConfigFile != "" {
+		content, err := ioutil.ReadFile(cfg.ConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(content, &config); err != nil {
+			return errors.Wrapf(err, "parsing %s", cfg.ConfigFile)
+		}
+	}
+	if err := parseArgs(command, ctx.Args(), config); err != nil {
+		return err
+	}
+	if cfg.ServerURL != "" {
+		config["server"] = cfg.ServerURL
+	}
+	if cfg.Token != "" {
+		config["token"] = cfg.Token
+	}
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	files := []file{{path: configPath, content: content}}
+
+	if cfg.RegistriesFile != "" {
+		content, err := ioutil.ReadFile(cfg.RegistriesFile)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path: registriesPath, content: content})
+	}
+
+	switch cfg.Format {
+	case "cloud-init":
+		return writeCloudInit(cfg, files)
+	case "ignition":
+		return writeIgnition(cfg, files)
+	}
+	return fmt.Errorf("invalid format %q, must be one of: cloud-init, ignition", cfg.Format)
+}
+
+// parseArgs adds the k3s flags passed after -- to config, rejecting any the role does not accept.
+func parseArgs(command cli.Command, args []string, config map[string]interface{}) error {
+	flags := map[string]cli.Flag{}
+	for _, flag := range command.Flags {
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			flags[strings.TrimSpace(name)] = flag
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return fmt.Errorf("unexpected argument %q", args[i])
+		}
+		name := strings.TrimLeft(args[i], "-")
+		var value interface{}
+		if parts := strings.SplitN(name, "=", 2); len(parts) == 2 {
+			name, value = parts[0], parts[1]
+		}
+
+		flag, ok := flags[name]
+		if !ok || name == "config" {
+			return fmt.Errorf("flag --%s is not valid for %s", name, command.Name)
+		}
+		name = strings.TrimSpace(strings.Split(flag.GetName(), ",")[0])
+
+		if value == nil {
+			if _, isBool := flag.(cli.BoolFlag); isBool {
+				value = true
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return fmt.Errorf("flag --%s requires a value", name)
+			}
+		}
+
+		switch flag.(type) {
+		case cli.StringSliceFlag, cli.IntSliceFlag, cli.Int64SliceFlag:
+			list, _ := config[name].([]interface{})
+			config[name] = append(list, value)
+		default:
+			config[name] = value
+		}
+	}
+	return nil
+}
+
+func writeCloudInit(cfg cmds.Generate, files []file) error {
+	type writeFile struct {
+		Path        string `json:"path"`
+		Permissions string `json:"permissions"`
+		Content     string `json:"content"`
+	}
+	doc := struct {
+		WriteFiles []writeFile `json:"write_files"`
+		RunCmd     []string    `json:"runcmd"`
+	}{
+		RunCmd: []string{fmt.Sprintf("curl -sfL %s | INSTALL_K3S_EXEC=%q sh -", cfg.InstallURL, cfg.Role)},
+	}
+	for _, f := range files {
+		doc.WriteFiles = append(doc.WriteFiles, writeFile{
+			Path:        f.path,
+			Permissions: "0600",
+			Content:     string(f.content),
+		})
+	}
+
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "#cloud-config\n%s", content)
+	return err
+}
+
+func writeIgnition(cfg cmds.Generate, files []file) error {
+	type ignitionFile struct {
+		Path      string `json:"path"`
+		Mode      int    `json:"mode"`
+		Overwrite bool   `json:"overwrite"`
+		Contents  struct {
+			Source string `json:"source"`
+		} `json:"contents"`
+	}
+	type unit struct {
+		Name     string `json:"name"`
+		Enabled  bool   `json:"enabled"`
+		Contents string `json:"contents"`
+	}
+	doc := struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+		Storage struct {
+			Files []ignitionFile `json:"files"`
+		} `json:"storage"`
+		Systemd struct {
+			Units []unit `json:"units"`
+		} `json:"systemd"`
+	}{}
+
+	doc.Ignition.Version = "3.0.0"
+	for _, f := range files {
+		i := ignitionFile{
+			Path:      f.path,
+			Mode:      0600,
+			Overwrite: true,
+		}
+		i.Contents.Source = "data:;base64," + base64.StdEncoding.EncodeToString(f.content)
+		doc.Storage.Files = append(doc.Storage.Files, i)
+	}
+	doc.Systemd.Units = append(doc.Systemd.Units, unit{
+		Name:    "k3s-install.service",
+		Enabled: true,
+		Contents: fmt.Sprintf(`[Unit]
+Description=Install k3s
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=!/usr/local/bin/k3s
+
+[Service]
+Type=oneshot
+Environment=INSTALL_K3S_EXEC=%s
+ExecStart=/bin/sh -c "curl -sfL %s | sh -"
+
+[Install]
+WantedBy=multi-user.target
+`, cfg.Role, cfg.InstallURL),
+	})
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
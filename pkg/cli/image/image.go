@@ -0,0 +1,397 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/oci"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	units "github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/util"
+)
+
+// namespace is the containerd namespace used by the CRI plugin
+const namespace = "k8s.io"
+
+func connect() (*containerd.Client, context.Context, error) {
+	client, err := containerd.New(cmds.ImageConfig.ContainerdAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, namespaces.WithNamespace(context.Background(), namespace), nil
+}
+
+// normalize expands an image name the way docker and the kubelet do, so that
+// nginx refers to docker.io/library/nginx:latest.
+func normalize(name string) (reference.Named, error) {
+	ref, err := reference.ParseDockerRef(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid image reference %s", name)
+	}
+	return ref, nil
+}
+
+// platformMatcher returns the matcher for --platform, and whether it is the
+// platform of this node, as images for other platforms cannot be unpacked.
+func platformMatcher() (platforms.MatchComparer, bool, error) {
+	if cmds.ImageConfig.Platform == "" {
+		return platforms.Default(), true, nil
+	}
+	p, err := platforms.Parse(cmds.ImageConfig.Platform)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid platform %s", cmds.ImageConfig.Platform)
+	}
+	return platforms.Only(p), platforms.Default().Match(p), nil
+}
+
+// snapshotter returns the snapshotter the CRI plugin unpacks images with, which
+// is the --snapshotter of the node, or the one k3s fell back to.
+func snapshotter(ctx context.Context) (string, error) {
+	addr, dialer, err := util.GetAddressAndDialer("unix://" + cmds.ImageConfig.ContainerdAddress)
+	if err != nil {
+		return "", err
+	}
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithDialer(dialer))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	resp, err := runtimeapi.NewRuntimeServiceClient(conn).Status(ctx, &runtimeapi.StatusRequest{Verbose: true})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the CRI config")
+	}
+	var config struct {
+		Containerd struct {
+			Snapshotter string `json:"snapshotter"`
+		} `json:"containerd"`
+	}
+	if err := json.Unmarshal([]byte(resp.Info["config"]), &config); err != nil {
+		return "", errors.Wrap(err, "failed to parse the CRI config")
+	}
+	if config.Containerd.Snapshotter == "" {
+		return containerd.DefaultSnapshotter, nil
+	}
+	return config.Containerd.Snapshotter, nil
+}
+
+// List prints the images, hiding the image id references created by CRI.
+func List(app *cli.Context) error {
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	imageList, err := client.ImageService().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if !cmds.ImageConfig.Quiet {
+		fmt.Fprintln(w, "REF\tDIGEST\tSIZE\tPLATFORMS")
+	}
+	for _, image := range imageList {
+		if strings.HasPrefix(image.Name, "sha256:") {
+			continue
+		}
+		if cmds.ImageConfig.Quiet {
+			fmt.Fprintln(w, image.Name)
+			continue
+		}
+
+		size := "-"
+		if s, err := image.Size(ctx, client.ContentStore(), platforms.Default()); err == nil {
+			size = units.HumanSize(float64(s))
+		}
+		var names []string
+		if ps, err := images.Platforms(ctx, client.ContentStore(), image.Target); err == nil {
+			for _, p := range ps {
+				names = append(names, platforms.Format(p))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Name, image.Target.Digest, size, strings.Join(names, ","))
+	}
+	return w.Flush()
+}
+
+// Pull pulls an image and unpacks it for this node with the snapshotter the
+// kubelet uses, also adding a digest reference to it as the kubelet does.
+// Images pulled for another platform are not unpacked.
+func Pull(app *cli.Context) error {
+	if app.NArg() != 1 {
+		return fmt.Errorf("exactly one image is required")
+	}
+	ref, err := normalize(app.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	matcher, host, err := platformMatcher()
+	if err != nil {
+		return err
+	}
+	snapshotterName, err := snapshotter(ctx)
+	if err != nil {
+		return err
+	}
+
+	var image images.Image
+	if cmds.ImageConfig.AllPlatforms {
+		image, err = client.Fetch(ctx, ref.String(), containerd.WithPlatformMatcher(platforms.All))
+		if err == nil {
+			err = containerd.NewImageWithPlatform(client, image, platforms.Default()).Unpack(ctx, snapshotterName)
+		}
+	} else {
+		opts := []containerd.RemoteOpt{containerd.WithPlatformMatcher(matcher)}
+		if host {
+			opts = append(opts, containerd.WithPullUnpack, containerd.WithPullSnapshotter(snapshotterName))
+		}
+		var pulled containerd.Image
+		pulled, err = client.Pull(ctx, ref.String(), opts...)
+		if pulled != nil {
+			image = images.Image{Name: pulled.Name(), Target: pulled.Target(), Labels: pulled.Labels()}
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to pull %s", ref)
+	}
+
+	if _, digested := ref.(reference.Digested); !digested {
+		digestRef, err := reference.WithDigest(reference.TrimNamed(ref), image.Target.Digest)
+		if err != nil {
+			return err
+		}
+		if err := setImage(ctx, client, digestRef.String(), image.Target); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s %s\n", ref, image.Target.Digest)
+	return nil
+}
+
+// Remove removes references to images, their content is garbage collected
+// once no reference is left.
+func Remove(app *cli.Context) error {
+	if app.NArg() == 0 {
+		return fmt.Errorf("at least one image is required")
+	}
+
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, name := range app.Args() {
+		ref, err := normalize(name)
+		if err != nil {
+			return err
+		}
+		err = client.ImageService().Delete(ctx, ref.String(), images.SynchronousDelete())
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("image %s not found", ref)
+		} else if err != nil {
+			return err
+		}
+		fmt.Println(ref)
+	}
+	return nil
+}
+
+// Tag adds a reference to an existing image.
+func Tag(app *cli.Context) error {
+	if app.NArg() != 2 {
+		return fmt.Errorf("a source and a target image are required")
+	}
+	source, err := normalize(app.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	target, err := normalize(app.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	image, err := client.ImageService().Get(ctx, source.String())
+	if err != nil {
+		return errors.Wrapf(err, "image %s", source)
+	}
+	return setImage(ctx, client, target.String(), image.Target)
+}
+
+// Save exports an image for one platform, or for all of them, to an OCI archive.
+func Save(app *cli.Context) error {
+	if app.NArg() != 1 {
+		return fmt.Errorf("exactly one image is required")
+	}
+	ref, err := normalize(app.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	image, err := client.ImageService().Get(ctx, ref.String())
+	if err != nil {
+		return errors.Wrapf(err, "image %s", ref)
+	}
+
+	desc := image.Target
+	if !cmds.ImageConfig.AllPlatforms {
+		matcher, _, err := platformMatcher()
+		if err != nil {
+			return err
+		}
+		if desc, err = platformManifest(ctx, client.ContentStore(), desc, matcher); err != nil {
+			return err
+		}
+	}
+	if desc.Annotations == nil {
+		desc.Annotations = map[string]string{}
+	}
+	desc.Annotations[ocispec.AnnotationRefName] = ref.String()
+
+	out := io.Writer(os.Stdout)
+	if cmds.ImageConfig.Output != "" {
+		f, err := os.Create(cmds.ImageConfig.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	r, err := client.Export(ctx, &oci.V1Exporter{}, desc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(out, r)
+	return err
+}
+
+// Load imports the images in an archive and, unless they are for another
+// platform, unpacks them for this node with the snapshotter the kubelet uses.
+func Load(app *cli.Context) error {
+	in := io.Reader(os.Stdin)
+	if cmds.ImageConfig.Input != "" {
+		f, err := os.Open(cmds.ImageConfig.Input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	client, ctx, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, host, err := platformMatcher()
+	if err != nil {
+		return err
+	}
+	snapshotterName, err := snapshotter(ctx)
+	if err != nil {
+		return err
+	}
+
+	imported, err := client.Import(ctx, in)
+	if err != nil {
+		return err
+	}
+	for _, image := range imported {
+		if host {
+			if err := containerd.NewImageWithPlatform(client, image, platforms.Default()).Unpack(ctx, snapshotterName); err != nil {
+				return errors.Wrapf(err, "failed to unpack %s", image.Name)
+			}
+		}
+		fmt.Printf("%s %s\n", image.Name, image.Target.Digest)
+	}
+	return nil
+}
+
+// setImage points name at target, creating or updating the reference.
+func setImage(ctx context.Context, client *containerd.Client, name string, target ocispec.Descriptor) error {
+	image := images.Image{
+		Name:   name,
+		Target: target,
+	}
+	if _, err := client.ImageService().Create(ctx, image); errdefs.IsAlreadyExists(err) {
+		_, err = client.ImageService().Update(ctx, image, "target")
+		return err
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// platformManifest returns the manifest for the platform if desc is an index,
+// and desc itself otherwise.
+func platformManifest(ctx context.Context, store content.Provider, desc ocispec.Descriptor, matcher platforms.MatchComparer) (ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+	default:
+		return desc, nil
+	}
+
+	p, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return desc, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(p, &index); err != nil {
+		return desc, err
+	}
+	var best *ocispec.Descriptor
+	for i, m := range index.Manifests {
+		if m.Platform == nil || !matcher.Match(*m.Platform) {
+			continue
+		}
+		if best == nil || matcher.Less(*m.Platform, *best.Platform) {
+			best = &index.Manifests[i]
+		}
+	}
+	if best == nil {
+		return desc, fmt.Errorf("no manifest for the requested platform")
+	}
+	return *best, nil
+}
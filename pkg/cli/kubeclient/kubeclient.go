@@ -0,0 +1,31 @@
+package kubeclient
+
+import (
+	"github.com/rancher/k3s/pkg/server"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Config loads the cluster in kubeConfig, or in the k3s admin kubeconfig if
+// kubeConfig is empty.
+func Config(kubeConfig string) (*rest.Config, error) {
+	if kubeConfig == "" {
+		var err error
+		kubeConfig, err = server.HomeKubeConfig(false, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeConfig)
+}
+
+// New returns a client for the cluster in kubeConfig, or in the k3s admin
+// kubeconfig if kubeConfig is empty.
+func New(kubeConfig string) (kubernetes.Interface, error) {
+	restConfig, err := Config(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
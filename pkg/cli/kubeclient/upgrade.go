@@ -0,0 +1,83 @@
+package kubeclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+)
+
+// Upgrade sends an upgrade request for protocol to the k3s supervisor at path
+// and returns the resulting raw connection.
+func Upgrade(restConfig *rest.Config, path string, query url.Values, protocol string) (net.Conn, error) {
+	u, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	tlsConfig, err := rest.TLSConfigFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.NextProtos = []string{"http/1.1"}
+
+	conn, err := tls.Dial("tcp", u.Host, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", protocol)
+	if restConfig.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+restConfig.BearerToken)
+	} else if restConfig.Username != "" {
+		req.SetBasicAuth(restConfig.Username, restConfig.Password)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(resp.Body)
+		conn.Close()
+		return nil, errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn drains data read ahead while parsing the upgrade response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	containerdclient "github.com/containerd/containerd"
+	"github.com/containerd/containerd/images/oci"
+	"github.com/containerd/containerd/namespaces"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/agent/containerd"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	podNameLabel       = "io.kubernetes.pod.name"
+	podNamespaceLabel  = "io.kubernetes.pod.namespace"
+	containerNameLabel = "io.kubernetes.container.name"
+	containerKindLabel = "io.cri-containerd.kind"
+)
+
+// checkpointRuntimes are the runtimes that checkpoint containers with runc and
+// criu, the wasm shims cannot.
+var checkpointRuntimes = map[string]bool{
+	"io.containerd.runtime.v1.linux": true,
+	"io.containerd.runc.v1":          true,
+	"io.containerd.runc.v2":          true,
+}
+
+// Checkpoint checkpoints each application container of a pod with CRIU, leaving
+// it running, and exports the checkpoints as OCI image archives.
+func Checkpoint(app *cli.Context) error {
+	if app.NArg() != 1 {
+		return fmt.Errorf("exactly one pod name is required")
+	}
+	pod := app.Args().First()
+	namespace := cmds.NodeConfig.Namespace
+
+	if _, err := containerd.CRIUPath(cmds.NodeConfig.ContainerdAddress); err != nil {
+		return err
+	}
+
+	client, err := containerdclient.New(cmds.NodeConfig.ContainerdAddress)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	containers, err := client.Containers(ctx, fmt.Sprintf(`labels.%q==%s,labels.%q==%s,labels.%q==container`,
+		podNameLabel, pod, podNamespaceLabel, namespace, containerKindLabel))
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found for pod %s/%s on this node", namespace, pod)
+	}
+
+	// every container is checked first, so that a pod is checkpointed whole or not at all
+	for _, container := range containers {
+		info, err := container.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if !checkpointRuntimes[info.Runtime.Name] {
+			return fmt.Errorf("container %s of pod %s/%s runs with %s, which cannot checkpoint containers", info.Labels[containerNameLabel], namespace, pod, info.Runtime.Name)
+		}
+	}
+
+	for _, container := range containers {
+		labels, err := container.Labels(ctx)
+		if err != nil {
+			return err
+		}
+		name := labels[containerNameLabel]
+
+		path, err := checkpoint(ctx, client, container, fmt.Sprintf("%s_%s_%s", namespace, pod, name))
+		if err != nil {
+			return errors.Wrapf(err, "failed to checkpoint container %s", name)
+		}
+		logrus.Infof("Wrote checkpoint of container %s to %s", name, path)
+	}
+
+	return nil
+}
+
+func checkpoint(ctx context.Context, client *containerdclient.Client, container containerdclient.Container, name string) (string, error) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	image, err := task.Checkpoint(ctx, containerdclient.WithCheckpointName(fmt.Sprintf("checkpoint/%s:%d", name, time.Now().Unix())))
+	if err != nil {
+		return "", err
+	}
+	defer client.ImageService().Delete(ctx, image.Name())
+
+	desc := image.Target()
+	desc.Annotations = map[string]string{
+		ocispec.AnnotationRefName: image.Name(),
+	}
+	r, err := client.Export(ctx, &oci.V1Exporter{}, desc)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	path := filepath.Join(cmds.NodeConfig.OutputDir, name+".tar")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return "", err
+	}
+	return path, nil
+}
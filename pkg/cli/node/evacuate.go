@@ -0,0 +1,291 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/urfave/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/kubernetes/pkg/kubectl/drain"
+)
+
+// evacuationTaint is added with the PreferNoSchedule effect to the nodes other
+// than the target of an evacuation, until the evacuated pods are rescheduled.
+const evacuationTaint = "k3s.io/evacuation"
+
+// evacuation tracks what happened to a single pod on the evacuated node.
+type evacuation struct {
+	pod     corev1.Pod
+	evicted time.Time
+	result  string
+	done    bool
+}
+
+// Evacuate cordons a node, evicts its pods and reports where each of them was
+// rescheduled to. With --to the other nodes are tainted PreferNoSchedule until
+// the pods are rescheduled, so that the scheduler prefers the target without
+// keeping other pods from being scheduled. The taints are removed when the
+// command is interrupted as well.
+func Evacuate(app *cli.Context) error {
+	if app.NArg() != 1 {
+		return fmt.Errorf("exactly one node name is required")
+	}
+	from := app.Args().First()
+	to := cmds.NodeConfig.To
+
+	client, err := kubeclient.New(cmds.NodeConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	if to != "" {
+		if err := checkTarget(client, to); err != nil {
+			return err
+		}
+	}
+
+	node, err := client.CoreV1().Nodes().Get(from, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err := setUnschedulable(client, node, true); err != nil {
+		return err
+	}
+
+	helper := &drain.Helper{
+		Client:              client,
+		Force:               cmds.NodeConfig.Force,
+		IgnoreAllDaemonSets: true,
+		DeleteLocalData:     cmds.NodeConfig.DeleteLocalData,
+		GracePeriodSeconds:  -1,
+	}
+	list, errs := helper.GetPodsForDeletion(from)
+	if len(errs) > 0 {
+		return errors.Wrapf(errs[0], "cannot evacuate %s, see --force and --delete-local-data", from)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(stop)
+	go func() {
+		select {
+		case sig := <-stop:
+			fmt.Fprintf(os.Stderr, "Received %s, stopping the evacuation\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if to != "" {
+		tainted, err := taintOthers(client, from, to)
+		defer untaint(client, tainted)
+		if len(tainted) > 0 {
+			fmt.Printf("Tainted %s=%s:%s on the nodes other than %s until the pods are rescheduled: %s\n",
+				evacuationTaint, from, corev1.TaintEffectPreferNoSchedule, to, strings.Join(tainted, ", "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(cmds.NodeConfig.Timeout)
+	var evacuations []*evacuation
+	for _, pod := range list.Pods() {
+		if ctx.Err() != nil {
+			break
+		}
+		e := &evacuation{pod: pod}
+		if err := evictPod(ctx, helper, pod, deadline); err != nil {
+			e.result = fmt.Sprintf("eviction failed: %v", err)
+			e.done = true
+		} else {
+			e.result = "evicted, not yet rescheduled"
+			e.evicted = time.Now().Truncate(time.Second)
+		}
+		evacuations = append(evacuations, e)
+	}
+
+	claimed := map[types.UID]bool{}
+	for !allDone(evacuations) && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		for _, e := range evacuations {
+			if !e.done {
+				checkRescheduled(client, e, claimed, from, to)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tRESULT")
+	for _, e := range evacuations {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.pod.Namespace, e.pod.Name, e.result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("evacuation of %s interrupted", from)
+	}
+	return nil
+}
+
+// taintOthers taints the nodes other than from and to with evacuationTaint,
+// and returns the names of the nodes it tainted.
+func taintOthers(client kubernetes.Interface, from, to string) ([]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var tainted []string
+	for _, node := range nodes.Items {
+		if node.Name == from || node.Name == to {
+			continue
+		}
+		if err := setEvacuationTaint(client, node.Name, from); err != nil {
+			return tainted, err
+		}
+		tainted = append(tainted, node.Name)
+	}
+	return tainted, nil
+}
+
+// untaint reverts taintOthers.
+func untaint(client kubernetes.Interface, names []string) {
+	for _, name := range names {
+		if err := setEvacuationTaint(client, name, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "%v, remove it with: kubectl taint nodes %s %s-\n", err, name, evacuationTaint)
+		}
+	}
+}
+
+// setEvacuationTaint sets evacuationTaint on a node to the name of the
+// evacuated node, or removes it if from is empty.
+func setEvacuationTaint(client kubernetes.Interface, name, from string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var taints []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != evacuationTaint {
+				taints = append(taints, taint)
+			}
+		}
+		if from != "" {
+			taints = append(taints, corev1.Taint{
+				Key:    evacuationTaint,
+				Value:  from,
+				Effect: corev1.TaintEffectPreferNoSchedule,
+			})
+		}
+		node.Spec.Taints = taints
+		_, err = client.CoreV1().Nodes().Update(node)
+		return err
+	})
+	if err != nil {
+		if from != "" {
+			return errors.Wrapf(err, "failed to taint %s", name)
+		}
+		return errors.Wrapf(err, "failed to remove the %s taint from %s", evacuationTaint, name)
+	}
+	return nil
+}
+
+func setUnschedulable(client kubernetes.Interface, node *corev1.Node, unschedulable bool) error {
+	cordon := drain.NewCordonHelper(node)
+	if !cordon.UpdateIfRequired(unschedulable) {
+		return nil
+	}
+	err, patchErr := cordon.PatchOrReplace(client)
+	if patchErr != nil {
+		err = patchErr
+	}
+	if err != nil {
+		if unschedulable {
+			return errors.Wrapf(err, "failed to cordon %s", node.Name)
+		}
+		return errors.Wrapf(err, "failed to uncordon %s", node.Name)
+	}
+	return nil
+}
+
+func checkTarget(client kubernetes.Interface, name string) error {
+	node, err := client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable {
+		return fmt.Errorf("target node %s is cordoned", name)
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			return nil
+		}
+	}
+	return fmt.Errorf("target node %s is not ready", name)
+}
+
+// checkRescheduled looks for the pod that replaced an evicted pod, which is a pod
+// with the same controller created after the eviction and not already matched to
+// another evicted pod.
+func checkRescheduled(client kubernetes.Interface, e *evacuation, claimed map[types.UID]bool, from, to string) {
+	owner := metav1.GetControllerOf(&e.pod)
+	if owner == nil {
+		if _, err := client.CoreV1().Pods(e.pod.Namespace).Get(e.pod.Name, metav1.GetOptions{}); err != nil {
+			e.result = "deleted, not managed by a controller"
+			e.done = true
+		}
+		return
+	}
+
+	pods, err := client.CoreV1().Pods(e.pod.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	for _, pod := range pods.Items {
+		if claimed[pod.UID] || pod.Spec.NodeName == "" || pod.Spec.NodeName == from || pod.CreationTimestamp.Time.Before(e.evicted) {
+			continue
+		}
+		if podOwner := metav1.GetControllerOf(&pod); podOwner == nil || podOwner.UID != owner.UID {
+			continue
+		}
+		claimed[pod.UID] = true
+		e.result = "rescheduled to " + pod.Spec.NodeName
+		if to != "" && pod.Spec.NodeName != to {
+			e.result += ", not " + to
+		}
+		e.done = true
+		return
+	}
+}
+
+func allDone(evacuations []*evacuation) bool {
+	for _, e := range evacuations {
+		if !e.done {
+			return false
+		}
+	}
+	return true
+}
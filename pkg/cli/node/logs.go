@@ -0,0 +1,63 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/server"
+	"github.com/urfave/cli"
+	"k8s.io/client-go/rest"
+)
+
+// Logs prints the logs of a component on a node, as served by the node's
+// agent through the supervisor.
+func Logs(app *cli.Context) error {
+	if app.NArg() != 1 {
+		return fmt.Errorf("exactly one node name is required")
+	}
+
+	restConfig, err := kubeclient.Config(cmds.NodeConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return err
+	}
+	u.Path = server.NodeLogsPath
+	u.RawQuery = url.Values{
+		"node":      []string{app.Args().First()},
+		"component": []string{cmds.NodeConfig.Component},
+		"follow":    []string{strconv.FormatBool(cmds.NodeConfig.Follow)},
+		"tail":      []string{strconv.Itoa(cmds.NodeConfig.Tail)},
+	}.Encode()
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
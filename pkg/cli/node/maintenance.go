@@ -0,0 +1,177 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/node"
+	"github.com/urfave/cli"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/pkg/kubectl/drain"
+)
+
+// Maintenance turns maintenance mode of a node on or off. The state is kept in
+// an annotation on the node, and the server keeps the node cordoned while it
+// is set, including after the node reboots.
+func Maintenance(app *cli.Context) error {
+	if app.NArg() < 1 || app.NArg() > 2 {
+		return fmt.Errorf("usage: %s", app.Command.UsageText)
+	}
+	mode := app.Args().Get(0)
+	if mode != "on" && mode != "off" {
+		return fmt.Errorf("maintenance mode must be on or off, not %q", mode)
+	}
+
+	name := app.Args().Get(1)
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		name = strings.ToLower(hostname)
+	}
+
+	client, err := kubeclient.New(cmds.NodeConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	if mode == "off" {
+		return maintenanceOff(client, name)
+	}
+	if err := maintenanceOn(client, name, cmds.NodeConfig.Reason); err != nil {
+		return err
+	}
+	if cmds.NodeConfig.Drain {
+		return drainNode(client, name, cmds.NodeConfig.Timeout, cmds.NodeConfig.Force, cmds.NodeConfig.DeleteLocalData)
+	}
+	return nil
+}
+
+func maintenanceOn(client kubernetes.Interface, name, reason string) error {
+	n, err := client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = "true"
+	}
+
+	if n.Annotations == nil {
+		n.Annotations = map[string]string{}
+	}
+	if !node.InMaintenance(n) {
+		// remember whether the node was schedulable before the maintenance
+		n.Annotations[node.MaintenanceCordonedAnnotation] = fmt.Sprint(!n.Spec.Unschedulable)
+	}
+	n.Annotations[node.MaintenanceAnnotation] = reason
+	n.Spec.Unschedulable = true
+	if _, err := client.CoreV1().Nodes().Update(n); err != nil {
+		return errors.Wrapf(err, "failed to cordon %s", name)
+	}
+
+	fmt.Printf("Node %s is under maintenance and cordoned\n", name)
+	return nil
+}
+
+func maintenanceOff(client kubernetes.Interface, name string) error {
+	n, err := client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !node.InMaintenance(n) {
+		fmt.Printf("Node %s is not under maintenance\n", name)
+		return nil
+	}
+
+	if n.Annotations[node.MaintenanceCordonedAnnotation] == "true" {
+		n.Spec.Unschedulable = false
+	}
+	delete(n.Annotations, node.MaintenanceAnnotation)
+	delete(n.Annotations, node.MaintenanceCordonedAnnotation)
+	if _, err := client.CoreV1().Nodes().Update(n); err != nil {
+		return err
+	}
+
+	if n.Spec.Unschedulable {
+		fmt.Printf("Node %s is no longer under maintenance, it was cordoned before and stays cordoned\n", name)
+	} else {
+		fmt.Printf("Node %s is no longer under maintenance and uncordoned\n", name)
+	}
+	return nil
+}
+
+// drainNode evicts the pods of a node and waits for them to be gone. Unless
+// forced, pods not managed by a controller or using emptyDir volumes keep the
+// node from being drained.
+func drainNode(client kubernetes.Interface, name string, timeout time.Duration, force, deleteLocalData bool) error {
+	helper := &drain.Helper{
+		Client:              client,
+		Force:               force,
+		IgnoreAllDaemonSets: true,
+		DeleteLocalData:     deleteLocalData,
+		GracePeriodSeconds:  -1,
+	}
+	list, errs := helper.GetPodsForDeletion(name)
+	if len(errs) > 0 {
+		return errors.Wrapf(errs[0], "cannot drain %s, see --force and --delete-local-data", name)
+	}
+
+	deadline := time.Now().Add(timeout)
+	pods := list.Pods()
+	for _, pod := range pods {
+		if err := evictPod(context.Background(), helper, pod, deadline); err != nil {
+			return errors.Wrapf(err, "failed to evict %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+
+	for len(pods) > 0 && time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		pods = remainingPods(client, pods)
+	}
+	if len(pods) > 0 {
+		return fmt.Errorf("timed out draining %s, %d pods left", name, len(pods))
+	}
+
+	fmt.Printf("Node %s is drained\n", name)
+	return nil
+}
+
+// evictPod evicts pod, retrying until deadline or until ctx is done while a pod
+// disruption budget does not allow it.
+func evictPod(ctx context.Context, helper *drain.Helper, pod corev1.Pod, deadline time.Time) error {
+	for {
+		err := helper.EvictPod(pod, "policy/v1beta1")
+		if err == nil || apierrors.IsNotFound(err) {
+			return nil
+		}
+		if !apierrors.IsTooManyRequests(err) || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func remainingPods(client kubernetes.Interface, pods []corev1.Pod) []corev1.Pod {
+	var remaining []corev1.Pod
+	for _, pod := range pods {
+		current, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+		if (err != nil && !apierrors.IsNotFound(err)) || (err == nil && current.UID == pod.UID) {
+			remaining = append(remaining, pod)
+		}
+	}
+	return remaining
+}
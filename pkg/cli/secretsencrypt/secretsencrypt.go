@@ -0,0 +1,164 @@
+package secretsencrypt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/secretsencrypt"
+	"github.com/urfave/cli"
+)
+
+const restartNotice = "restart k3s on every server, with the same encryption config, for the change to take effect"
+
+// Status prints the keys and the stage of a key rotation.
+func Status(app *cli.Context) error {
+	file, err := configFile()
+	if os.IsNotExist(err) {
+		fmt.Println("secrets encryption: disabled, start the server with --secrets-encryption to enable it")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	names, err := secretsencrypt.Keys(file)
+	if err != nil {
+		return err
+	}
+	stage, err := secretsencrypt.CurrentStage(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("secrets encryption: enabled (%s)\n", file)
+	fmt.Printf("rotation stage: %s\n", stage)
+	for i, name := range names {
+		role := "decrypt"
+		if i == 0 {
+			role = "encrypt, decrypt"
+		}
+		fmt.Printf("key %s: %s\n", name, role)
+	}
+	return nil
+}
+
+// Prepare adds a new decryption key.
+func Prepare(app *cli.Context) error {
+	file, err := configFile()
+	if err != nil {
+		return err
+	}
+	if err := secretsencrypt.Prepare(file); err != nil {
+		return err
+	}
+	fmt.Printf("new key prepared, %s, then run rotate\n", restartNotice)
+	return nil
+}
+
+// Rotate switches encryption to the prepared key.
+func Rotate(app *cli.Context) error {
+	file, err := configFile()
+	if err != nil {
+		return err
+	}
+	if err := secretsencrypt.Rotate(file); err != nil {
+		return err
+	}
+	fmt.Printf("secrets are now encrypted with the new key, %s, then run reencrypt\n", restartNotice)
+	return nil
+}
+
+// Reencrypt rewrites all secrets with the current key, once every server
+// encrypts with it.
+func Reencrypt(app *cli.Context) error {
+	file, err := configFile()
+	if err != nil {
+		return err
+	}
+	stage, err := secretsencrypt.CurrentStage(file)
+	if err != nil {
+		return err
+	}
+	if stage == secretsencrypt.StagePrepared {
+		return fmt.Errorf("the prepared key is not used to encrypt yet, run rotate first")
+	}
+	names, err := secretsencrypt.Keys(file)
+	if err != nil {
+		return err
+	}
+
+	client, err := kubeclient.New(cmds.SecretsEncryptConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+	// secrets written through a server still using an old key would stay encrypted with it
+	if err := secretsencrypt.VerifyLoaded(client, names[0]); err != nil {
+		return err
+	}
+	count, err := secretsencrypt.Reencrypt(client)
+	if err != nil {
+		return fmt.Errorf("failed after rewriting %d secrets: %v", count, err)
+	}
+	if err := secretsencrypt.RecordReencrypted(client, names[0]); err != nil {
+		return err
+	}
+	fmt.Printf("rewrote %d secrets\n", count)
+
+	if stage == secretsencrypt.StageRotated {
+		fmt.Println("run drop-old-keys to remove the old keys")
+	}
+	return nil
+}
+
+// DropOldKeys removes the keys secrets are no longer encrypted with, once
+// every server encrypts with the current key and all secrets were rewritten
+// with it.
+func DropOldKeys(app *cli.Context) error {
+	file, err := configFile()
+	if err != nil {
+		return err
+	}
+	stage, err := secretsencrypt.CurrentStage(file)
+	if err != nil {
+		return err
+	}
+	if stage != secretsencrypt.StageRotated {
+		return fmt.Errorf("there are no old keys to drop at stage %s", stage)
+	}
+	names, err := secretsencrypt.Keys(file)
+	if err != nil {
+		return err
+	}
+
+	client, err := kubeclient.New(cmds.SecretsEncryptConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+	if err := secretsencrypt.VerifyLoaded(client, names[0]); err != nil {
+		return err
+	}
+	if err := secretsencrypt.VerifyReencrypted(client, names[0]); err != nil {
+		return err
+	}
+
+	if err := secretsencrypt.DropOldKeys(file); err != nil {
+		return err
+	}
+	fmt.Printf("old keys removed, %s\n", restartNotice)
+	return nil
+}
+
+func configFile() (string, error) {
+	dataDir, err := datadir.Resolve(cmds.SecretsEncryptConfig.DataDir)
+	if err != nil {
+		return "", err
+	}
+	file := secretsencrypt.File(filepath.Join(dataDir, "server"))
+	if _, err := os.Stat(file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
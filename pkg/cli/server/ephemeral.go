@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const ephemeralDatastore = "sqlite://file:k3s-ephemeral?mode=memory&cache=shared"
+
+// setupEphemeral points the server at an in-memory datastore, a temporary
+// data dir and free ports. It returns the port for the embedded apiserver and
+// a function that removes the data dir.
+func setupEphemeral(cfg *cmds.Server) (int, func(), error) {
+	if cfg.StorageEndpoint != "" || cfg.StorageBackend != "" {
+		return 0, nil, fmt.Errorf("--ephemeral can not be used with --datastore-endpoint or --storage-backend")
+	}
+	if cfg.ClusterReset {
+		return 0, nil, fmt.Errorf("--ephemeral can not be used with --cluster-reset")
+	}
+
+	dataDir, err := ioutil.TempDir("", "k3s-ephemeral-")
+	if err != nil {
+		return 0, nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dataDir)
+	}
+
+	httpsPort, err := freePort()
+	if err != nil {
+		cleanup()
+		return 0, nil, err
+	}
+	listenPort, err := freePort()
+	if err != nil {
+		cleanup()
+		return 0, nil, err
+	}
+
+	cfg.DataDir = dataDir
+	if cfg.KubeConfigOutput == "" {
+		cfg.KubeConfigOutput = filepath.Join(dataDir, "kubeconfig.yaml")
+	}
+	cfg.StorageEndpoint = ephemeralDatastore
+	cfg.HTTPSPort = httpsPort
+	cfg.DisableAgent = true
+	cfg.DisableSnapshots = true
+	// the insecure ports of the controller-manager and scheduler are fixed,
+	// turn them off so that several servers can run on one host
+	cfg.ExtraControllerArgs = append(cli.StringSlice{"port=0"}, cfg.ExtraControllerArgs...)
+	cfg.ExtraSchedulerArgs = append(cli.StringSlice{"port=0"}, cfg.ExtraSchedulerArgs...)
+
+	logrus.Infof("Running ephemeral server in %s", dataDir)
+	return listenPort, cleanup, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// printReady writes the server URL, node token and admin kubeconfig as a
+// line of JSON to stdout, for tests waiting for an ephemeral server.
+func printReady(url, token, kubeConfig string) {
+	json.NewEncoder(os.Stdout).Encode(map[string]string{
+		"url":        url,
+		"token":      token,
+		"kubeconfig": kubeConfig,
+	})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/configfilearg"
+	"github.com/rancher/k3s/pkg/server"
+	"github.com/sirupsen/logrus"
+)
+
+const configPollInterval = 15 * time.Second
+
+// watchConfig applies changes to reload-safe flags in the config file to the
+// running server, and logs the changes that need a restart.
+func watchConfig(ctx context.Context, file string, serverConfig *server.Config) {
+	names := flagNames()
+	last, err := readConfig(file, names)
+	if err != nil {
+		logrus.Errorf("Failed to read config file %s: %v", file, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(configPollInterval):
+		}
+
+		current, err := readConfig(file, names)
+		if err != nil {
+			logrus.Errorf("Failed to read config file %s: %v", file, err)
+			continue
+		}
+
+		for _, flag := range changedFlags(last, current) {
+			switch {
+			case setOnCommandLine(flag, names):
+				logrus.Warnf("Ignoring change to %s in %s, it is set on the command line", flag, file)
+			case !server.ReloadSafeFlags[flag]:
+				logrus.Warnf("Change to %s in %s requires a restart", flag, file)
+			default:
+				if err := server.Reload(serverConfig, flag, current); err != nil {
+					logrus.Errorf("Failed to apply change to %s in %s: %v", flag, file, err)
+					continue
+				}
+				logrus.Infof("Applied change to %s in %s", flag, file)
+			}
+		}
+		last = current
+	}
+}
+
+// readConfig returns the flag values in the config file keyed by the primary
+// flag name. A missing file sets no flags.
+func readConfig(file string, names map[string]string) (map[string][]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return map[string][]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	values, err := configfilearg.Values(content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for name, v := range values {
+		if primary, ok := names[name]; ok {
+			name = primary
+		}
+		result[name] = v
+	}
+	return result, nil
+}
+
+func changedFlags(last, current map[string][]string) []string {
+	var changed []string
+	for name, v := range current {
+		if !reflect.DeepEqual(last[name], v) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range last {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flagNames maps every name of a server flag to its primary name.
+func flagNames() map[string]string {
+	names := map[string]string{}
+	for _, flag := range cmds.NewServerCommand(nil).Flags {
+		aliases := strings.Split(flag.GetName(), ",")
+		for _, alias := range aliases {
+			names[strings.TrimSpace(alias)] = strings.TrimSpace(aliases[0])
+		}
+	}
+	return names
+}
+
+// setOnCommandLine returns true if flag was passed to k3s directly, which
+// overrides the config file.
+func setOnCommandLine(flag string, names map[string]string) bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(strings.SplitN(arg, "=", 2)[0], "-")
+		if names[name] == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -6,21 +6,37 @@ import (
 	"fmt"
 	net2 "net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/rancher/k3s/pkg/netutil"
-
 	systemd "github.com/coreos/go-systemd/daemon"
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/natefinch/lumberjack"
 	"github.com/pkg/errors"
 	"github.com/rancher/k3s/pkg/agent"
+	"github.com/rancher/k3s/pkg/agent/containerd"
+	"github.com/rancher/k3s/pkg/agent/firewall"
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/chaos"
 	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/clusterspec"
+	"github.com/rancher/k3s/pkg/containerenv"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/daemons/control"
 	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/datastore"
+	"github.com/rancher/k3s/pkg/diskusage"
+	"github.com/rancher/k3s/pkg/hooks"
+	"github.com/rancher/k3s/pkg/netutil"
+	"github.com/rancher/k3s/pkg/node"
 	"github.com/rancher/k3s/pkg/rootless"
 	"github.com/rancher/k3s/pkg/server"
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/rancher/k3s/pkg/token"
 	"github.com/rancher/wrangler/pkg/signals"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -33,6 +49,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"    // ensure we have sqlite
 )
 
+// shutdownTimeout bounds how long a stopping server waits for the apiserver.
+const shutdownTimeout = 30 * time.Second
+
 func setupLogging(app *cli.Context) {
 	if !app.GlobalBool("debug") {
 		flag.Set("stderrthreshold", "WARNING")
@@ -57,28 +76,99 @@ func runWithLogging(app *cli.Context, cfg *cmds.Server) error {
 	cmd.Stderr = l
 	cmd.Stdout = l
 	cmd.Stdin = os.Stdin
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// pass stop signals on, so that the server shuts down cleanly when this
+	// process is stopped, such as when it is PID 1 of a container
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		for sig := range stop {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	return cmd.Wait()
 }
 
 func Run(app *cli.Context) error {
-	return run(app, &cmds.ServerConfig)
+	if cmds.ServerConfig.Log != "" && os.Getenv("_RIO_REEXEC_") == "" {
+		return runWithLogging(app, &cmds.ServerConfig)
+	}
+	return run(signals.SetupSignalHandler(context.Background()), app, &cmds.ServerConfig, nil)
+}
+
+// ReadyFunc is called with the server URL, the node token and the admin
+// kubeconfig once the server is up and, unless the agent is disabled, its
+// node is ready.
+type ReadyFunc func(url, token, kubeConfig string)
+
+// RunContext returns a server action that runs until ctx is done instead of
+// handling signals, for running k3s inside another process.
+func RunContext(ctx context.Context, ready ReadyFunc) func(*cli.Context) error {
+	return func(app *cli.Context) error {
+		return run(ctx, app, &cmds.ServerConfig, ready)
+	}
 }
 
-func run(app *cli.Context, cfg *cmds.Server) error {
+func run(ctx context.Context, app *cli.Context, cfg *cmds.Server, ready ReadyFunc) error {
 	var (
 		err error
 	)
 
-	if cfg.Log != "" && os.Getenv("_RIO_REEXEC_") == "" {
-		return runWithLogging(app, cfg)
-	}
-
 	if err := checkUnixTimestamp(); err != nil {
 		return err
 	}
 
 	setupLogging(app)
 
+	if cfg.LogLevel != "" {
+		if err := server.SetLogLevel(cfg.LogLevel); err != nil {
+			return errors.Wrap(err, "invalid --log-level")
+		}
+	}
+
+	if err := token.LoadCredentials(map[string]*string{
+		"cluster-secret":               &cfg.ClusterSecret,
+		"agent-token":                  &cfg.AgentToken,
+		"storage-endpoint":             &cfg.StorageEndpoint,
+		"etcd-snapshot-encryption-key": &cfg.SnapshotKey,
+	}); err != nil {
+		return err
+	}
+
+	if cfg.AgentTokenFile != "" {
+		t, err := token.ReadFile(cfg.AgentTokenFile)
+		if err != nil {
+			return err
+		}
+		cfg.AgentToken = t
+	}
+
+	if (cfg.StorageCertFile == "") != (cfg.StorageKeyFile == "") {
+		return fmt.Errorf("--datastore-certfile and --datastore-keyfile must be given together for client certificate auth")
+	}
+
+	snapshotKey, err := snapshot.LoadKey(cfg.SnapshotKey, cfg.SnapshotKeyFile)
+	if err != nil {
+		return err
+	}
+
+	var listenPort int
+	if cfg.Ephemeral {
+		var cleanup func()
+		listenPort, cleanup, err = setupEphemeral(cfg)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		if ready == nil {
+			ready = printReady
+		}
+	}
+
 	if !cfg.DisableAgent && os.Getuid() != 0 && !cfg.Rootless {
 		return fmt.Errorf("must run as root unless --disable-agent is specified")
 	}
@@ -94,19 +184,45 @@ func run(app *cli.Context, cfg *cmds.Server) error {
 		}
 	}
 
+	// keep secrets out of the environment inherited by containerd and other children,
+	// once the rootless parent has re-executed k3s with them
+	os.Unsetenv("K3S_CLUSTER_SECRET")
+	os.Unsetenv("K3S_AGENT_TOKEN")
+	os.Unsetenv("K3S_STORAGE_ENDPOINT")
+	os.Unsetenv("K3S_ETCD_SNAPSHOT_ENCRYPTION_KEY")
+
+	if cfg.ClusterReset {
+		return clusterReset(cfg, snapshotKey)
+	}
+
+	if err := setupContainer(cfg); err != nil {
+		return err
+	}
+
 	// If running agent in server, set this so that CSI initializes properly
 	csi.WaitForValidHostName = !cfg.DisableAgent
 
 	serverConfig := server.Config{}
 	serverConfig.ControlConfig.ClusterSecret = cfg.ClusterSecret
+	serverConfig.ControlConfig.AgentToken = cfg.AgentToken
 	serverConfig.ControlConfig.DataDir = cfg.DataDir
 	serverConfig.ControlConfig.KubeConfigOutput = cfg.KubeConfigOutput
 	serverConfig.ControlConfig.KubeConfigMode = cfg.KubeConfigMode
 	serverConfig.ControlConfig.NoScheduler = cfg.DisableScheduler
 	serverConfig.Rootless = cfg.Rootless
+	serverConfig.RebootWindow = cfg.RebootWindow
+	serverConfig.PowerOffWindow = cfg.PowerOffWindow
+	serverConfig.DisableSnapshots = cfg.DisableSnapshots
+	serverConfig.SnapshotCron = cfg.SnapshotCron
+	serverConfig.SnapshotRetention = cfg.SnapshotRetention
+	serverConfig.SnapshotDir = cfg.SnapshotDir
+	serverConfig.SnapshotKey = snapshotKey
+	serverConfig.NamespaceDefaults = cfg.NamespaceDefaults
 	serverConfig.TLSConfig.HTTPSPort = cfg.HTTPSPort
 	serverConfig.TLSConfig.HTTPPort = cfg.HTTPPort
 	for _, san := range knownIPs(cfg.TLSSan) {
+		// IPv6 addresses may be given in URL form
+		san = strings.TrimSuffix(strings.TrimPrefix(san, "["), "]")
 		addr := net2.ParseIP(san)
 		if addr != nil {
 			serverConfig.TLSConfig.KnownIPs = append(serverConfig.TLSConfig.KnownIPs, san)
@@ -116,28 +232,104 @@ func run(app *cli.Context, cfg *cmds.Server) error {
 	}
 	serverConfig.TLSConfig.BindAddress = cfg.BindAddress
 	serverConfig.ControlConfig.HTTPSPort = cfg.HTTPSPort
+	serverConfig.ControlConfig.ListenPort = listenPort
 	serverConfig.ControlConfig.ExtraAPIArgs = cfg.ExtraAPIArgs
 	serverConfig.ControlConfig.ExtraControllerArgs = cfg.ExtraControllerArgs
+	serverConfig.ControlConfig.DisableControllers = cfg.DisableControllers
+	serverConfig.ControlConfig.DisableAPIs, err = control.DisabledAPIs(cfg.DisableAPIs)
+	if err != nil {
+		return errors.Wrap(err, "invalid --disable-apis")
+	}
+	serverConfig.ControlConfig.FeatureGates = cmds.AgentConfig.FeatureGates
 	serverConfig.ControlConfig.ExtraSchedulerAPIArgs = cfg.ExtraSchedulerArgs
+	serverConfig.ControlConfig.SchedulerConfig = cfg.SchedulerConfig
+	serverConfig.ControlConfig.SecondarySchedulers = cfg.SecondarySchedulers
 	serverConfig.ControlConfig.ClusterDomain = cfg.ClusterDomain
-	serverConfig.ControlConfig.StorageEndpoint = cfg.StorageEndpoint
+	if err := flannel.ValidBackend(cfg.FlannelBackend); err != nil {
+		return err
+	}
+	serverConfig.ControlConfig.FlannelBackend = cfg.FlannelBackend
+	serverConfig.ControlConfig.FlannelExternalIP = cfg.FlannelExternalIP
+	if cfg.Multus && cfg.FlannelBackend == flannel.NoneBackend {
+		return fmt.Errorf("--multus delegates to flannel and can not be used with --flannel-backend=%s", flannel.NoneBackend)
+	}
+	serverConfig.ControlConfig.Multus = cfg.Multus
+	serverConfig.ControlConfig.DisableKubeProxy = cfg.DisableKubeProxy
+	serverConfig.ControlConfig.DisableNetworkPolicy = cfg.NoNetworkPolicy
+	serverConfig.ControlConfig.StorageEndpoint = datastoreEndpoint(cfg.StorageEndpoint)
 	serverConfig.ControlConfig.StorageBackend = cfg.StorageBackend
 	serverConfig.ControlConfig.StorageCAFile = cfg.StorageCAFile
 	serverConfig.ControlConfig.StorageCertFile = cfg.StorageCertFile
 	serverConfig.ControlConfig.StorageKeyFile = cfg.StorageKeyFile
-	serverConfig.ControlConfig.AdvertiseIP = cfg.AdvertiseIP
 	serverConfig.ControlConfig.AdvertisePort = cfg.AdvertisePort
 	serverConfig.ControlConfig.BootstrapType = cfg.BootstrapType
+	serverConfig.ControlConfig.EncryptSecrets = cfg.EncryptSecrets
+	serverConfig.ControlConfig.ClusterSizeHint = cfg.ClusterSizeHint
+	serverConfig.ControlConfig.HealConfigDrift = cmds.AgentConfig.HealConfigDrift
+	serverConfig.ControlConfig.Audit.WebhookConfig = cfg.AuditWebhookConfig
+	serverConfig.ControlConfig.Audit.PolicyFile = cfg.AuditPolicyFile
+	serverConfig.ControlConfig.Audit.SpillDir = cfg.AuditSpillDir
+	serverConfig.ControlConfig.Audit.SpillMaxSize = int64(cfg.AuditSpillMaxSize) * 1024 * 1024
+	serverConfig.ControlConfig.Audit.InitialBackoff = cfg.AuditInitialBackoff
+	serverConfig.ControlConfig.Audit.MaxBackoff = cfg.AuditMaxBackoff
+
+	serverConfig.ControlConfig.SystemDefaultRegistry = cmds.AgentConfig.SystemDefaultRegistry
+
+	if _, err := node.ParseMaintenanceWindow(cfg.RebootWindow); err != nil {
+		return err
+	}
+
+	if _, err := node.ParseMaintenanceWindow(cfg.PowerOffWindow); err != nil {
+		return err
+	}
+
+	if !control.ValidClusterSizeHint(cfg.ClusterSizeHint) {
+		return fmt.Errorf("invalid --cluster-size-hint %s, must be small, medium or large", cfg.ClusterSizeHint)
+	}
+
+	if err := config.ValidateFeatureGates(cmds.AgentConfig.FeatureGates); err != nil {
+		return errors.Wrap(err, "invalid --feature-gates")
+	}
+
+	if err := config.ValidateNodeLabels(cmds.AgentConfig.Labels); err != nil {
+		return errors.Wrap(err, "invalid --node-label")
+	}
+
+	if err := config.ValidateNodeTaints(cmds.AgentConfig.Taints); err != nil {
+		return errors.Wrap(err, "invalid --node-taint")
+	}
+
+	if err := containerd.ValidSnapshotter(cmds.AgentConfig.Snapshotter); err != nil {
+		return errors.Wrap(err, "invalid --snapshotter")
+	}
+
+	if err := flannel.ValidMTU(cmds.AgentConfig.FlannelMTU); err != nil {
+		return errors.Wrap(err, "invalid --flannel-mtu")
+	}
 
 	if cmds.AgentConfig.FlannelIface != "" && cmds.AgentConfig.NodeIP == "" {
 		cmds.AgentConfig.NodeIP = netutil.GetIPFromInterface(cmds.AgentConfig.FlannelIface)
 	}
 
-	if serverConfig.ControlConfig.AdvertiseIP == "" && cmds.AgentConfig.NodeIP != "" {
-		serverConfig.ControlConfig.AdvertiseIP = cmds.AgentConfig.NodeIP
+	// addresses are listed in priority order, the apiserver advertises the first
+	// one assigned to this host and all of them are valid for the serving
+	// certificate
+	advertiseIPs, err := advertiseAddresses(cfg.AdvertiseIP)
+	if err != nil {
+		return err
+	}
+	if len(advertiseIPs) == 0 && cmds.AgentConfig.NodeIP != "" {
+		advertiseIPs = []string{cmds.AgentConfig.NodeIP}
 	}
-	if serverConfig.ControlConfig.AdvertiseIP != "" {
-		serverConfig.TLSConfig.KnownIPs = append(serverConfig.TLSConfig.KnownIPs, serverConfig.ControlConfig.AdvertiseIP)
+	if len(advertiseIPs) > 0 {
+		serverConfig.ControlConfig.AdvertiseIP = advertiseAddress(advertiseIPs)
+		serverConfig.TLSConfig.KnownIPs = append(serverConfig.TLSConfig.KnownIPs, advertiseIPs...)
+	}
+
+	for flag, value := range map[string]string{"cluster-cidr": cfg.ClusterCIDR, "service-cidr": cfg.ServiceCIDR, "node-ip": cmds.AgentConfig.NodeIP} {
+		if err := netutil.SingleStack(flag, value); err != nil {
+			return err
+		}
 	}
 
 	_, serverConfig.ControlConfig.ClusterIPRange, err = net2.ParseCIDR(cfg.ClusterCIDR)
@@ -165,27 +357,80 @@ func run(app *cli.Context, cfg *cmds.Server) error {
 		serverConfig.ControlConfig.ClusterDNS = net2.ParseIP(cfg.ClusterDNS)
 	}
 
-	if serverConfig.ControlConfig.StorageBackend != "etcd3" {
+	// several servers may share an external SQL datastore, only the embedded
+	// sqlite datastore guarantees a single server
+	if serverConfig.ControlConfig.StorageBackend != "etcd3" && sqliteEndpoint(serverConfig.ControlConfig.StorageEndpoint) {
 		serverConfig.ControlConfig.NoLeaderElect = true
 	}
 
-	for _, noDeploy := range app.StringSlice("no-deploy") {
-		if noDeploy == "servicelb" {
-			serverConfig.DisableServiceLB = true
-			continue
-		}
-
-		if !strings.HasSuffix(noDeploy, ".yaml") {
-			noDeploy = noDeploy + ".yaml"
-		}
-		serverConfig.ControlConfig.Skips = append(serverConfig.ControlConfig.Skips, noDeploy)
+	serverConfig.ControlConfig.Skips, serverConfig.DisableServiceLB = server.Disable(server.DisableValues(app.StringSlice))
+	if !cfg.Multus {
+		serverConfig.ControlConfig.Skips = append(serverConfig.ControlConfig.Skips, server.MultusManifest)
+	}
+	serverConfig.ControlConfig.PinnedAddons, err = server.PinAddons(app.StringSlice("pin-addon"))
+	if err != nil {
+		return err
 	}
 
 	logrus.Info("Starting k3s ", app.App.Version)
 	notifySocket := os.Getenv("NOTIFY_SOCKET")
 	os.Unsetenv("NOTIFY_SOCKET")
 
-	ctx := signals.SetupSignalHandler(context.Background())
+	dataDir, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	serverHooks := &hooks.Hooks{
+		PreStart:      cmds.AgentConfig.PreStartHook,
+		PostBootstrap: cmds.AgentConfig.PostBootstrapHook,
+		Role:          "server",
+		DataDir:       filepath.Join(dataDir, "server"),
+		Env: map[string]string{
+			"NODE_NAME": cmds.AgentConfig.NodeName,
+			"NODE_IP":   cmds.AgentConfig.NodeIP,
+		},
+	}
+	if err := serverHooks.RunPreStart(ctx); err != nil {
+		return err
+	}
+
+	if err := clusterspec.Watch(ctx, cfg.ClusterSpec, clusterspec.Paths{
+		ConfigFile:     app.String("config"),
+		RegistriesFile: clusterspec.RegistriesFile,
+		DataDir:        filepath.Join(dataDir, "server"),
+	}); err != nil {
+		return errors.Wrapf(err, "applying cluster spec %s", cfg.ClusterSpec)
+	}
+
+	if err := setupDatastoreDir(cfg, filepath.Join(dataDir, "server")); err != nil {
+		return err
+	}
+
+	if err := checkDisabledAPIs(cfg, serverConfig.ControlConfig.DisableAPIs, filepath.Join(dataDir, "server")); err != nil {
+		return err
+	}
+
+	if !cfg.Rootless {
+		ports := serverPorts(cfg, listenPort)
+		if !cfg.DisableAgent {
+			ports = append(ports, agent.Ports(cmds.AgentConfig, cfg.DisableKubeProxy)...)
+		}
+		if err := netutil.CheckPorts(ports); err != nil {
+			return err
+		}
+		if cmds.AgentConfig.Firewalld && firewall.Running() {
+			if err := firewall.OpenPorts(serverPorts(cfg, listenPort)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cmds.AgentConfig.ChaosSocket != "" {
+		if err := chaos.Serve(ctx, cmds.AgentConfig.ChaosSocket); err != nil {
+			return errors.Wrap(err, "serving --chaos-socket")
+		}
+	}
+
 	certs, err := server.StartServer(ctx, &serverConfig)
 	if err != nil {
 		return err
@@ -197,25 +442,79 @@ func run(app *cli.Context, cfg *cmds.Server) error {
 		systemd.SdNotify(true, "READY=1\n")
 	}
 
-	if cfg.DisableAgent {
-		<-ctx.Done()
-		return nil
-	}
 	ip := serverConfig.TLSConfig.BindAddress
 	if ip == "" {
 		ip = "localhost"
 	}
-	url := fmt.Sprintf("https://%s:%d", ip, serverConfig.TLSConfig.HTTPSPort)
+	url := "https://" + net2.JoinHostPort(ip, strconv.Itoa(serverConfig.TLSConfig.HTTPSPort))
+
+	serverHooks.Env["URL"] = url
+	serverHooks.Env["KUBECONFIG"] = cfg.KubeConfigOutput
+	if cfg.KubeConfigOutput == "" {
+		serverHooks.Env["KUBECONFIG"], _ = server.HomeKubeConfig(false, cfg.Rootless)
+	}
 	token := server.FormatToken(serverConfig.ControlConfig.Runtime.NodeToken, certs)
+	if ready != nil {
+		kubeConfig := serverHooks.Env["KUBECONFIG"]
+		serverHooks.OnReady = func() {
+			ready(url, token, kubeConfig)
+		}
+	}
+
+	go watchConfig(ctx, app.String("config"), &serverConfig)
+
+	if cfg.DisableAgent {
+		// without an agent nothing else accounts for the datastore and snapshots
+		if err := runDiskUsage(ctx, cfg, dataDir, serverConfig.ControlConfig.Runtime.KubeConfigAdmin); err != nil {
+			return err
+		}
+		serverHooks.RunPostBootstrap(ctx, nil)
+		<-ctx.Done()
+		waitForShutdown(serverConfig.ControlConfig.Runtime)
+		return nil
+	}
 
 	agentConfig := cmds.AgentConfig
 	agentConfig.Debug = app.GlobalBool("bool")
+	agentConfig.LogFile = cfg.Log
+	agentConfig.SnapshotDir = cfg.SnapshotDir
 	agentConfig.DataDir = filepath.Dir(serverConfig.ControlConfig.DataDir)
 	agentConfig.ServerURL = url
 	agentConfig.Token = token
 	agentConfig.Labels = append(agentConfig.Labels, "node-role.kubernetes.io/master=true")
 
-	return agent.Run(ctx, agentConfig)
+	// the post-bootstrap hook of the server runs once the node of its agent is ready
+	return agent.RunWithHooks(ctx, agentConfig, serverHooks)
+}
+
+// runDiskUsage monitors the space used by a server that runs no agent, under
+// the node name the agent would have used.
+func runDiskUsage(ctx context.Context, cfg *cmds.Server, dataDir, kubeConfig string) error {
+	nodeName := cmds.AgentConfig.NodeName
+	if nodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		nodeName = strings.ToLower(hostname)
+	}
+	dirs := diskusage.Dirs{
+		DataDir:     dataDir,
+		SnapshotDir: cfg.SnapshotDir,
+		LogFile:     cfg.Log,
+	}
+	return diskusage.RunServer(ctx, kubeConfig, dirs, nodeName, cmds.AgentConfig.DiskUsageThreshold)
+}
+
+// waitForShutdown lets the apiserver finish the requests in flight before
+// the server exits, so that stopping a --disable-agent server, such as one in
+// a container, does not fail clients or exit with an error.
+func waitForShutdown(runtime *config.ControlRuntime) {
+	select {
+	case <-runtime.APIServerStopped:
+	case <-time.After(shutdownTimeout):
+		logrus.Warnf("The apiserver did not stop within %v", shutdownTimeout)
+	}
 }
 
 func knownIPs(ips []string) []string {
@@ -227,6 +526,39 @@ func knownIPs(ips []string) []string {
 	return ips
 }
 
+func advertiseAddresses(value string) ([]string, error) {
+	var ips []string
+	for _, ip := range strings.Split(value, ",") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if net2.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid --advertise-address %s", ip)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// advertiseAddress picks the address the apiserver advertises, the first one
+// assigned to this host, such as a VIP while this server holds it. If none is
+// assigned, as with an address the host is reached at through NAT, the first
+// one is advertised.
+func advertiseAddress(ips []string) string {
+	ip, ok := netutil.FirstAssigned(ips)
+	if !ok {
+		if len(ips) > 1 {
+			logrus.Infof("None of the --advertise-address addresses %s is assigned to this host, advertising %s", strings.Join(ips, ", "), ips[0])
+		}
+		return ips[0]
+	}
+	if ip != ips[0] {
+		logrus.Infof("Advertising %s, the addresses before it in --advertise-address are not assigned to this host", ip)
+	}
+	return ip
+}
+
 func checkUnixTimestamp() error {
 	timeNow := time.Now()
 	// check if time before 01/01/1980
@@ -235,3 +567,168 @@ func checkUnixTimestamp() error {
 	}
 	return nil
 }
+
+// clusterReset restores the datastore from a snapshot. The server exits
+// afterwards so that it is not restored again on every restart.
+func clusterReset(cfg *cmds.Server, key []byte) error {
+	if cfg.ClusterResetRestore == "" {
+		return fmt.Errorf("--cluster-reset requires --cluster-reset-restore-path, there is no cluster membership to reset with the embedded datastore")
+	}
+	if cfg.StorageEndpoint != "" || cfg.StorageBackend == "etcd3" {
+		return fmt.Errorf("--cluster-reset is only supported for the embedded sqlite datastore")
+	}
+	if cfg.NewClusterToken && cfg.ClusterSecret != "" {
+		return fmt.Errorf("--new-cluster-token can not rotate the token set by --cluster-secret, change the cluster secret instead")
+	}
+
+	dataDir, err := datadir.Resolve(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	serverDataDir := filepath.Join(dataDir, "server")
+	if err := snapshot.Restore(cfg.ClusterResetRestore, snapshot.DBFile(serverDataDir), key); err != nil {
+		return err
+	}
+	logrus.Infof("Datastore restored from %s, restart without --cluster-reset", cfg.ClusterResetRestore)
+
+	if !cfg.NewClusterToken {
+		return nil
+	}
+	if err := control.RotateNodeToken(serverDataDir); err != nil {
+		return errors.Wrap(err, "failed to rotate the node token")
+	}
+
+	fmt.Printf(`The node token has been rotated, agents need the new token to rejoin:
+  1. Start the server without --cluster-reset.
+  2. Read the new token from %[1]s.
+  3. On each agent, set K3S_TOKEN (or --token) to the new token, remove
+     <agent data dir>/node-token if the agent joined with a join token,
+     and restart the agent.
+  4. If the server runs on new hardware its CA and service account key are
+     new as well. Agents pick up the new CA with the new token. Delete the
+     old service account tokens so they are reissued, and restart the pods
+     that use them:
+       %[2]s kubectl delete secret --all-namespaces --field-selector type=kubernetes.io/service-account-token
+Only the node token was rotated, the following credentials stay valid:
+  - The agent token set by --agent-token. Start the servers with a new one to
+    replace it.
+  - Join tokens restored with the datastore. List and delete them with
+      %[2]s token list
+      %[2]s token delete ID...
+  - The CA and service account key, when the server keeps its data dir.
+`, filepath.Join(serverDataDir, "node-token"), filepath.Base(os.Args[0]))
+	return nil
+}
+
+// datastoreEndpoint accepts the postgresql:// scheme used by libpq for the
+// postgres:// scheme of the datastore.
+func datastoreEndpoint(endpoint string) string {
+	if strings.HasPrefix(endpoint, "postgresql://") {
+		return "postgres://" + strings.TrimPrefix(endpoint, "postgresql://")
+	}
+	return endpoint
+}
+
+// setupDatastoreDir links the embedded datastore to --etcd-data-dir and
+// checks that its disk is fast enough.
+func setupDatastoreDir(cfg *cmds.Server, serverDataDir string) error {
+	if cfg.StorageBackend == "etcd3" || !sqliteEndpoint(cfg.StorageEndpoint) || cfg.Ephemeral {
+		if cfg.EtcdDataDir != "" {
+			return fmt.Errorf("--etcd-data-dir only applies to the embedded sqlite datastore")
+		}
+		return nil
+	}
+
+	dbDir := filepath.Dir(snapshot.DBFile(serverDataDir))
+	if cfg.EtcdDataDir != "" {
+		if err := datastore.Link(dbDir, cfg.EtcdDataDir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dbDir, 0700); err != nil {
+		return err
+	}
+
+	if err := datastore.CheckDisk(dbDir); err != nil {
+		logrus.Warn(err)
+	}
+	return nil
+}
+
+// checkDisabledAPIs refuses to disable APIs that still serve objects in the
+// datastore, they would become unreachable but stay stored.
+func checkDisabledAPIs(cfg *cmds.Server, apis []string, serverDataDir string) error {
+	resources := control.DisabledAPIResources(apis)
+	if len(resources) == 0 || cfg.Ephemeral {
+		return nil
+	}
+	if cfg.StorageBackend == "etcd3" || cfg.StorageEndpoint != "" {
+		logrus.Warnf("Not checking for existing objects of disabled APIs, this is only supported for the embedded sqlite datastore")
+		return nil
+	}
+
+	for prefix, api := range resources {
+		n, err := datastore.CountObjects(snapshot.DBFile(serverDataDir), prefix)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check for existing objects of %s", api)
+		}
+		if n > 0 {
+			return fmt.Errorf("can not disable %s, the datastore holds %d objects under %s", api, n, prefix)
+		}
+	}
+	return nil
+}
+
+// serverPorts returns the ports the server listens on.
+func serverPorts(cfg *cmds.Server, listenPort int) []netutil.Port {
+	if listenPort == 0 {
+		listenPort = 6444
+	}
+	ports := []netutil.Port{
+		{Component: "supervisor", Network: "tcp", Host: cfg.BindAddress, Port: cfg.HTTPSPort},
+		{Component: "kube-apiserver", Network: "tcp", Host: "127.0.0.1", Port: listenPort},
+	}
+	if cfg.Ephemeral {
+		// the controller-manager and scheduler do not listen
+		return ports
+	}
+	ports = append(ports, netutil.Port{Component: "kube-controller-manager", Network: "tcp", Host: "127.0.0.1", Port: 10252})
+	if !cfg.DisableScheduler {
+		ports = append(ports, netutil.Port{Component: "kube-scheduler", Network: "tcp", Host: "127.0.0.1", Port: 10251})
+	}
+	for i := range cfg.SecondarySchedulers {
+		ports = append(ports, netutil.Port{Component: "secondary kube-scheduler", Network: "tcp", Host: "127.0.0.1", Port: 10261 + i})
+	}
+	return ports
+}
+
+// setupContainer prepares a server running inside a container to be used by
+// agents on the hosts, and warns about the settings it needs. A --disable-agent
+// server writes no CNI config or binaries, the agents on the hosts keep their
+// own, so there is nothing to export for them.
+func setupContainer(cfg *cmds.Server) error {
+	if !containerenv.Detect() {
+		return nil
+	}
+	if !cfg.DisableAgent {
+		logrus.Warn("Running in a container, the embedded agent needs a privileged container with /sys/fs/cgroup mounted, use --disable-agent to only run the control plane")
+		return nil
+	}
+	if cfg.AdvertiseIP == "" {
+		logrus.Warn("Running in a container without --advertise-address, agents on other hosts must be able to reach the container address")
+	}
+	if cfg.KubeConfigOutput == "" {
+		// /etc/rancher/k3s is gone with the container, the data dir is
+		// expected to be a volume the hosts can read
+		dataDir, err := datadir.Resolve(cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		cfg.KubeConfigOutput = filepath.Join(dataDir, "kubeconfig.yaml")
+		logrus.Infof("Running in a container, writing the kubeconfig to %s", cfg.KubeConfigOutput)
+	}
+	return nil
+}
+
+func sqliteEndpoint(endpoint string) bool {
+	return endpoint == "" || strings.HasPrefix(endpoint, "sqlite://")
+}
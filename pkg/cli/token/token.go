@@ -0,0 +1,153 @@
+package token
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/k3s/pkg/datadir"
+	"github.com/rancher/k3s/pkg/server"
+	k3stoken "github.com/rancher/k3s/pkg/token"
+	"github.com/urfave/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/rest"
+)
+
+// Create mints a join token through the supervisor, which refuses to do so
+// without an agent token, and prints it with the CA hash of the server.
+func Create(app *cli.Context) error {
+	restConfig, err := kubeclient.Config(cmds.TokenConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return err
+	}
+	u.Path = server.TokensPath
+	u.RawQuery = url.Values{
+		"ttl":         []string{cmds.TokenConfig.TTL.String()},
+		"description": []string{cmds.TokenConfig.Description},
+	}.Encode()
+
+	resp, err := (&http.Client{Transport: transport}).Post(u.String(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var t struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return err
+	}
+	fmt.Println(t.Token)
+	return nil
+}
+
+// List prints the join tokens.
+func List(app *cli.Context) error {
+	client, err := kubeclient.New(cmds.TokenConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := client.CoreV1().Secrets(k3stoken.Namespace).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(k3stoken.SecretType)).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEXPIRES\tDESCRIPTION")
+	for i := range secrets.Items {
+		joinToken, err := k3stoken.FromSecret(&secrets.Items[i])
+		if err != nil {
+			continue
+		}
+		expires := joinToken.Expires.Local().Format(time.RFC3339)
+		if joinToken.Expired() {
+			expires = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", joinToken.ID, expires, joinToken.Description)
+	}
+	return w.Flush()
+}
+
+// Delete removes the join tokens given as arguments. Agents that already
+// joined with them keep working.
+func Delete(app *cli.Context) error {
+	if app.NArg() == 0 {
+		return fmt.Errorf("at least one token ID is required")
+	}
+	client, err := kubeclient.New(cmds.TokenConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range app.Args() {
+		// accept full tokens as well as IDs
+		if i := strings.LastIndex(id, "::"); i >= 0 {
+			id = id[i+2:]
+		}
+		id = strings.SplitN(strings.TrimPrefix(id, "K10"), ":", 2)[0]
+		if !k3stoken.IsJoinTokenID(id) {
+			return fmt.Errorf("%s is not a join token", id)
+		}
+		if err := client.CoreV1().Secrets(k3stoken.Namespace).Delete(k3stoken.SecretName(id), &metav1.DeleteOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to delete join token %s", id)
+		}
+		fmt.Printf("deleted %s\n", id)
+	}
+	return nil
+}
+
+// Rotate replaces the node token in the server password file.
+func Rotate(app *cli.Context) error {
+	dataDir, err := datadir.Resolve(cmds.TokenConfig.DataDir)
+	if err != nil {
+		return err
+	}
+	serverDataDir := filepath.Join(dataDir, "server")
+	if _, err := os.Stat(filepath.Join(serverDataDir, "cred", "passwd")); err != nil {
+		return err
+	}
+
+	if err := control.RotateNodeToken(serverDataDir); err != nil {
+		return err
+	}
+
+	fmt.Printf(`node token rotated, restart k3s to use it, the new token is then written to %s
+agents that joined with the node token must rejoin with the new token
+a server started with --cluster-secret resets the node token to the cluster secret
+the agent token set by --agent-token and join tokens stay valid, replace --agent-token and delete join tokens to revoke them
+`, filepath.Join(serverDataDir, "node-token"))
+	return nil
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/kubeclient"
+	"github.com/rancher/k3s/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"k8s.io/client-go/rest"
+)
+
+// PortForward listens on a local port and forwards each connection to an
+// address on a node through the server's agent tunnel.
+func PortForward(app *cli.Context) error {
+	if app.NArg() != 2 {
+		return fmt.Errorf("a node name and LOCAL_PORT:[HOST:]NODE_PORT are required")
+	}
+	node := app.Args().Get(0)
+	localPort, address, err := parsePorts(app.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	restConfig, err := kubeclient.Config(cmds.TunnelConfig.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(cmds.TunnelConfig.Address, localPort))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	fmt.Printf("Forwarding from %s to %s on node %s\n", listener.Addr(), address, node)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go forward(conn, restConfig, node, address)
+	}
+}
+
+func forward(conn net.Conn, restConfig *rest.Config, node, address string) {
+	defer conn.Close()
+
+	remote, err := kubeclient.Upgrade(restConfig, server.PortForwardPath, url.Values{
+		"node":    []string{node},
+		"address": []string{address},
+	}, server.PortForwardProtocol)
+	if err != nil {
+		logrus.Errorf("Failed to forward connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// parsePorts splits LOCAL_PORT:[HOST:]NODE_PORT, defaulting HOST to the node's
+// loopback address.
+func parsePorts(spec string) (string, string, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid port mapping %s, expected LOCAL_PORT:[HOST:]NODE_PORT", spec)
+	}
+	address := parts[1]
+	if !strings.Contains(address, ":") {
+		address = "127.0.0.1:" + address
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid port mapping %s: %v", spec, err)
+	}
+	for _, p := range []string{parts[0], port} {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", "", fmt.Errorf("invalid port %s in %s", p, spec)
+		}
+	}
+	return parts[0], address, nil
+}
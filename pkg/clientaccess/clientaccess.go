@@ -9,10 +9,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/token"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
@@ -35,21 +35,6 @@ type clientToken struct {
 	password string
 }
 
-func AgentAccessInfoToTempKubeConfig(tempDir, server, token string) (string, error) {
-	f, err := ioutil.TempFile(tempDir, "tmp-")
-	if err != nil {
-		return "", err
-	}
-	if err := f.Close(); err != nil {
-		return "", err
-	}
-	err = accessInfoToKubeConfig(f.Name(), server, token)
-	if err != nil {
-		os.Remove(f.Name())
-	}
-	return f.Name(), err
-}
-
 func AgentAccessInfoToKubeConfig(destFile, server, token string) error {
 	return accessInfoToKubeConfig(destFile, server, token)
 }
@@ -104,6 +89,10 @@ func ParseAndValidateToken(server, token string) (*Info, error) {
 		return nil, errors.Wrapf(err, "Invalid url, failed to parse %s", server)
 	}
 
+	if strings.Count(url.Host, ":") > 1 && !strings.HasPrefix(url.Host, "[") {
+		return nil, fmt.Errorf("invalid url %s, IPv6 addresses must be enclosed in brackets, as in https://[::1]:6443", server)
+	}
+
 	if url.Scheme != "https" {
 		return nil, fmt.Errorf("only https:// URLs are supported, invalid scheme: %s", server)
 	}
@@ -117,17 +106,16 @@ func ParseAndValidateToken(server, token string) (*Info, error) {
 		return nil, err
 	}
 
-	cacerts, err := GetCACerts(*url)
+	var cacerts []byte
+	if parsedToken.caHash != "" {
+		cacerts, err = getPinnedCACerts(*url, parsedToken.caHash)
+	} else {
+		cacerts, err = GetCACerts(*url)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if len(cacerts) > 0 && len(parsedToken.caHash) > 0 {
-		if ok, hash, newHash := validateCACerts(cacerts, parsedToken.caHash); !ok {
-			return nil, fmt.Errorf("token does not match the server %s != %s", hash, newHash)
-		}
-	}
-
 	if err := validateToken(*url, cacerts, parsedToken.username, parsedToken.password); err != nil {
 		return nil, err
 	}
@@ -152,6 +140,10 @@ func accessInfoToKubeConfig(destFile, server, token string) error {
 
 func validateToken(u url.URL, cacerts []byte, username, password string) error {
 	u.Path = "/apis"
+	if token.IsJoinTokenID(username) {
+		// join tokens are only accepted for exchanging them
+		u.Path = "/v1-k3s/node-token"
+	}
 	_, err := get(u.String(), GetHTTPClient(cacerts), username, password)
 	if err != nil {
 		return errors.Wrap(err, "token is not valid")
@@ -253,6 +245,30 @@ func GetCACerts(u url.URL) ([]byte, error) {
 	return cacerts, nil
 }
 
+// getPinnedCACerts fetches the CA certs of the server, checks them against
+// the hash from the token, and verifies that the server presents a serving
+// certificate signed by them, before any credentials are sent. Unlike
+// GetCACerts it does not accept servers trusted by the system roots alone.
+func getPinnedCACerts(u url.URL, hash string) ([]byte, error) {
+	u.Path = "/cacerts"
+	url := u.String()
+
+	cacerts, err := get(url, insecureClient, "", "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get CA certs at %s", url)
+	}
+
+	if ok, hash, newHash := validateCACerts(cacerts, hash); !ok {
+		return nil, fmt.Errorf("token does not match the server %s != %s", hash, newHash)
+	}
+
+	if _, err := get(url, GetHTTPClient(cacerts), "", ""); err != nil {
+		return nil, errors.Wrapf(err, "server %s does not present a certificate signed by the CA in the token", url)
+	}
+
+	return cacerts, nil
+}
+
 func get(u string, client *http.Client, username, password string) ([]byte, error) {
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
@@ -0,0 +1,196 @@
+package clusterspec
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultSpecFile = "/etc/rancher/k3s/cluster.yaml"
+	RegistriesFile  = "/etc/rancher/k3s/registries.yaml"
+
+	APIVersion = "k3s.cattle.io/v1"
+	Kind       = "ClusterSpec"
+
+	// addonsFile lists the manifests written from the spec, so that addons
+	// removed from the spec are removed from the manifests dir as well
+	addonsFile  = "cluster-spec-addons"
+	addonPrefix = "spec-"
+)
+
+// Spec describes the configuration of a cluster in a single document.
+type Spec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Server holds settings in config.yaml format
+	Server map[string]interface{} `json:"server,omitempty"`
+	// Disable lists packaged components not to deploy, as --disable does
+	Disable []string `json:"disable,omitempty"`
+	// Registries holds settings in registries.yaml format
+	Registries map[string]interface{} `json:"registries,omitempty"`
+	// Addons maps a name to a manifest, given either as a string or as objects
+	Addons map[string]interface{} `json:"addons,omitempty"`
+}
+
+// Paths are the files a spec is applied to.
+type Paths struct {
+	ConfigFile     string
+	RegistriesFile string
+	// DataDir is the server data dir, addons go to its manifests dir
+	DataDir string
+}
+
+// Result reports the files changed by applying a spec.
+type Result struct {
+	Changed []string
+	Removed []string
+	// ConfigChanged is set if the server config changed
+	ConfigChanged bool
+}
+
+// Read parses and validates the spec in file.
+func Read(file string) (*Spec, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content)
+}
+
+// Parse parses and validates a spec.
+func Parse(content []byte) (*Spec, error) {
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(content, spec); err != nil {
+		return nil, err
+	}
+	if spec.APIVersion != APIVersion || spec.Kind != Kind {
+		return nil, fmt.Errorf("expected apiVersion %s and kind %s", APIVersion, Kind)
+	}
+	for _, flag := range []string{"disable", "no-deploy"} {
+		if _, ok := spec.Server[flag]; ok && len(spec.Disable) > 0 {
+			return nil, fmt.Errorf("server %s and disable can not both be set", flag)
+		}
+	}
+	for name := range spec.Addons {
+		if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("invalid addon name %q", name)
+		}
+	}
+	return spec, nil
+}
+
+// Apply writes the config, registries and addon manifests described by the spec.
+func (s *Spec) Apply(paths Paths) (*Result, error) {
+	result := &Result{}
+
+	if s.Server != nil || len(s.Disable) > 0 {
+		config := map[string]interface{}{}
+		for k, v := range s.Server {
+			config[k] = v
+		}
+		if len(s.Disable) > 0 {
+			config["disable"] = s.Disable
+		}
+		changed, err := writeYAML(paths.ConfigFile, config)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			result.Changed = append(result.Changed, paths.ConfigFile)
+			result.ConfigChanged = true
+		}
+	}
+
+	if s.Registries != nil {
+		changed, err := writeYAML(paths.RegistriesFile, s.Registries)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			result.Changed = append(result.Changed, paths.RegistriesFile)
+		}
+	}
+
+	if err := s.applyAddons(filepath.Join(paths.DataDir, "manifests"), filepath.Join(paths.DataDir, addonsFile), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Spec) applyAddons(manifestsDir, addonsFile string, result *Result) error {
+	var names []string
+	for name, manifest := range s.Addons {
+		file := filepath.Join(manifestsDir, addonPrefix+name+".yaml")
+		var (
+			changed bool
+			err     error
+		)
+		if content, ok := manifest.(string); ok {
+			changed, err = writeFile(file, []byte(content))
+		} else {
+			changed, err = writeYAML(file, manifest)
+		}
+		if err != nil {
+			return err
+		}
+		if changed {
+			result.Changed = append(result.Changed, file)
+		}
+		names = append(names, filepath.Base(file))
+	}
+	sort.Strings(names)
+
+	current := map[string]bool{}
+	for _, name := range names {
+		current[name] = true
+	}
+	previous, err := ioutil.ReadFile(addonsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range strings.Fields(string(previous)) {
+		if current[name] || filepath.Base(name) != name {
+			continue
+		}
+		file := filepath.Join(manifestsDir, name)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result.Removed = append(result.Removed, file)
+	}
+
+	_, err = writeFile(addonsFile, []byte(strings.Join(names, "\n")))
+	return err
+}
+
+func writeYAML(file string, data interface{}) (bool, error) {
+	content, err := yaml.Marshal(data)
+	if err != nil {
+		return false, errors.Wrapf(err, "encoding %s", file)
+	}
+	return writeFile(file, content)
+}
+
+// writeFile replaces file with content, returning whether it changed.
+func writeFile(file string, content []byte) (bool, error) {
+	existing, err := ioutil.ReadFile(file)
+	if err == nil && bytes.Equal(existing, content) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return false, err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, file)
+}
@@ -0,0 +1,71 @@
+package clusterspec
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const pollInterval = 15 * time.Second
+
+// Watch applies the spec in file now and whenever it changes. A missing file
+// is not an error, so that a spec can be added to a running server.
+func Watch(ctx context.Context, file string, paths Paths) error {
+	last, err := reconcile(file, nil, paths)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			content, err := reconcile(file, last, paths)
+			if err != nil {
+				logrus.Errorf("Failed to apply cluster spec %s: %v", file, err)
+				continue
+			}
+			last = content
+		}
+	}()
+	return nil
+}
+
+// reconcile applies file if its content differs from last, returning the content applied.
+func reconcile(file string, last []byte, paths Paths) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return last, nil
+	} else if err != nil {
+		return last, err
+	}
+	if last != nil && bytes.Equal(content, last) {
+		return last, nil
+	}
+
+	spec, err := Parse(content)
+	if err != nil {
+		return last, err
+	}
+	result, err := spec.Apply(paths)
+	if err != nil {
+		return last, err
+	}
+	for _, f := range result.Changed {
+		logrus.Infof("Cluster spec %s updated %s", file, f)
+	}
+	for _, f := range result.Removed {
+		logrus.Infof("Cluster spec %s removed %s", file, f)
+	}
+	if result.ConfigChanged {
+		logrus.Infof("Cluster spec %s changed the server config, changes to flags that are not reload-safe need a restart", file)
+	}
+	return content, nil
+}
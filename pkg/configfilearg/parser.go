@@ -0,0 +1,146 @@
+package configfilearg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultConfigFile = "/etc/rancher/k3s/config.yaml"
+	configFlag        = "--config"
+)
+
+var (
+	// commands that accept settings from the config file
+	commands = map[string]bool{
+		"server": true,
+		"agent":  true,
+	}
+)
+
+// MustParse is Parse, exiting on error.
+func MustParse(args []string) []string {
+	result, err := Parse(args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return result
+}
+
+// Parse inserts the settings from the config file as flags directly following the
+// server or agent subcommand, so that anything passed on the command line overrides
+// the config file.
+func Parse(args []string) ([]string, error) {
+	index, file, required := findConfigFile(args)
+	if index < 0 {
+		return args, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !required {
+		return args, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "reading config file %s", file)
+	}
+
+	flags, err := ToArgs(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing config file %s", file)
+	}
+
+	result := append([]string{}, args[:index+1]...)
+	result = append(result, flags...)
+	return append(result, args[index+1:]...), nil
+}
+
+// ToArgs converts the content of a config file into command line flags.
+func ToArgs(content []byte) ([]string, error) {
+	values, err := Values(content)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		for _, v := range values[k] {
+			args = append(args, fmt.Sprintf("--%s=%s", k, v))
+		}
+	}
+	return args, nil
+}
+
+// Values returns the flag values set by the content of a config file, keyed by
+// flag name.
+func Values(content []byte) (map[string][]string, error) {
+	data := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+
+	values := map[string][]string{}
+	for k, v := range data {
+		name := strings.TrimLeft(k, "-")
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case float64:
+			values[name] = []string{strconv.FormatFloat(v, 'f', -1, 64)}
+		case nil:
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values, nil
+}
+
+// findConfigFile returns the position of the subcommand the config applies to, the
+// config file to read, and whether it was explicitly requested.
+func findConfigFile(args []string) (int, string, bool) {
+	index := -1
+	for i, arg := range args {
+		if i > 0 && commands[arg] {
+			index = i
+			break
+		}
+		if i > 0 && !strings.HasPrefix(arg, "-") {
+			// some other subcommand
+			return -1, "", false
+		}
+	}
+	if index < 0 {
+		return -1, "", false
+	}
+
+	for i, arg := range args[index+1:] {
+		if arg == "--" {
+			break
+		}
+		if arg == configFlag && index+i+2 < len(args) {
+			return index, args[index+i+2], true
+		}
+		if strings.HasPrefix(arg, configFlag+"=") {
+			return index, strings.TrimPrefix(arg, configFlag+"="), true
+		}
+	}
+
+	if file := os.Getenv("K3S_CONFIG_FILE"); file != "" {
+		return index, file, true
+	}
+	return index, DefaultConfigFile, false
+}
@@ -0,0 +1,33 @@
+package containerenv
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var markers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// Detect reports whether k3s runs inside a container, based on the marker
+// files of docker and podman and the cgroups of PID 1.
+func Detect() bool {
+	if os.Getenv("container") != "" {
+		return true
+	}
+	for _, marker := range markers {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+
+	cgroups, err := ioutil.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, runtime := range []string{"/docker/", "/kubepods", "/lxc/", "/containerd/"} {
+		if strings.Contains(string(cgroups), runtime) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,21 +3,27 @@ package agent
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/util/net"
+	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/logs"
 	app2 "k8s.io/kubernetes/cmd/kube-proxy/app"
 	"k8s.io/kubernetes/cmd/kubelet/app"
+	"k8s.io/kubernetes/cmd/kubelet/app/options"
 	"k8s.io/kubernetes/pkg/kubeapiserver/authorizer/modes"
 
+	_ "github.com/rancher/k3s/pkg/images/prometheus"    // for image metric registration
 	_ "k8s.io/kubernetes/pkg/client/metrics/prometheus" // for client metric registration
 	_ "k8s.io/kubernetes/pkg/version/prometheus"        // for version metric registration
 )
@@ -25,30 +31,56 @@ import (
 func Agent(config *config.Agent) error {
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	kubelet(config)
-	kubeProxy(config)
-
-	return nil
+	if err := kubelet(config); err != nil {
+		return err
+	}
+	if config.DisableKubeProxy {
+		if len(config.ExtraKubeProxyArgs) > 0 || config.KubeProxyConfigFile != "" {
+			logrus.Warn("kube-proxy is disabled by the server, ignoring --kube-proxy-arg and --kube-proxy-config-file")
+		}
+		logrus.Info("kube-proxy is disabled, services must be proxied by a replacement")
+		return nil
+	}
+	return kubeProxy(config)
 }
 
-func kubeProxy(cfg *config.Agent) {
+func kubeProxy(cfg *config.Agent) error {
 	argsMap := map[string]string{
 		"proxy-mode":           "iptables",
 		"healthz-bind-address": "127.0.0.1",
 		"kubeconfig":           cfg.KubeConfigKubeProxy,
 		"cluster-cidr":         cfg.ClusterCIDR.String(),
 	}
-	args := config.GetArgsList(argsMap, cfg.ExtraKubeProxyArgs)
+	if cfg.FeatureGates != "" {
+		argsMap["feature-gates"] = cfg.FeatureGates
+	}
+	if cfg.KubeProxyConfigFile != "" {
+		if len(cfg.ExtraKubeProxyArgs) > 0 {
+			return fmt.Errorf("kube-proxy ignores its flags when given a config file, set --kube-proxy-arg values in %s instead", cfg.KubeProxyConfigFile)
+		}
+		if err := kubeProxyConfig(cfg); err != nil {
+			return err
+		}
+		argsMap = map[string]string{
+			"config": cfg.KubeProxyConfig,
+		}
+	}
 
 	command := app2.NewProxyCommand()
+	if err := config.ValidateArgs("kube-proxy", command.Flags(), argsMap, cfg.ExtraKubeProxyArgs); err != nil {
+		return err
+	}
+	args := config.GetArgsList(argsMap, cfg.ExtraKubeProxyArgs)
 	command.SetArgs(args)
 	go func() {
 		err := command.Execute()
 		logrus.Fatalf("kube-proxy exited: %v", err)
 	}()
+
+	return nil
 }
 
-func kubelet(cfg *config.Agent) {
+func kubelet(cfg *config.Agent) error {
 	command := app.NewKubeletCommand(context.Background().Done())
 	logs.InitLogs()
 	defer logs.FlushLogs()
@@ -67,6 +99,9 @@ func kubelet(cfg *config.Agent) {
 		"authentication-token-webhook": "true",
 		"authorization-mode":           modes.ModeWebhook,
 	}
+	if cfg.FeatureGates != "" {
+		argsMap["feature-gates"] = cfg.FeatureGates
+	}
 	if cfg.RootDir != "" {
 		argsMap["root-dir"] = cfg.RootDir
 		argsMap["cert-dir"] = filepath.Join(cfg.RootDir, "pki")
@@ -91,6 +126,14 @@ func kubelet(cfg *config.Agent) {
 		argsMap["container-runtime"] = "remote"
 		argsMap["container-runtime-endpoint"] = cfg.RuntimeSocket
 		argsMap["serialize-image-pulls"] = "false"
+		if cfg.ContainerLogMaxSize != "" {
+			argsMap["container-log-max-size"] = cfg.ContainerLogMaxSize
+		}
+		if cfg.ContainerLogMaxFiles > 0 {
+			argsMap["container-log-max-files"] = strconv.Itoa(cfg.ContainerLogMaxFiles)
+		}
+	} else if cfg.ContainerLogMaxSize != "" || cfg.ContainerLogMaxFiles > 0 {
+		logrus.Warn("Container log rotation settings only apply to CRI runtimes, configure the docker log driver instead")
 	}
 	if cfg.ListenAddress != "" {
 		argsMap["address"] = cfg.ListenAddress
@@ -119,20 +162,38 @@ func kubelet(cfg *config.Agent) {
 		logrus.Warn("Disabling pod PIDs limit feature due to missing cgroup pids support")
 		argsMap["cgroups-per-qos"] = "false"
 		argsMap["enforce-node-allocatable"] = ""
-		argsMap["feature-gates"] = addFeatureGate(argsMap["feature-gates"], "SupportPodPidsLimit=false")
+		argsMap["feature-gates"] = config.MergeFeatureGates(argsMap["feature-gates"], "SupportPodPidsLimit=false")
 	}
 	if root != "" {
 		argsMap["runtime-cgroups"] = root
 		argsMap["kubelet-cgroups"] = root
 	}
 	if system.RunningInUserNS() {
-		argsMap["feature-gates"] = addFeatureGate(argsMap["feature-gates"], "DevicePlugins=false")
+		argsMap["feature-gates"] = config.MergeFeatureGates(argsMap["feature-gates"], "DevicePlugins=false")
 	}
 
+	if cfg.PauseImage != "" {
+		argsMap["pod-infra-container-image"] = cfg.PauseImage
+	}
 	argsMap["node-labels"] = strings.Join(cfg.NodeLabels, ",")
 	if len(cfg.NodeTaints) > 0 {
 		argsMap["register-with-taints"] = strings.Join(cfg.NodeTaints, ",")
 	}
+	configFile, configFields, err := kubeletConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if configFile != "" {
+		argsMap["config"] = configFile
+		for flag, field := range kubeletConfigDefaults {
+			if _, ok := configFields[field]; ok {
+				delete(argsMap, flag)
+			}
+		}
+	}
+	if err := config.ValidateArgs("kubelet", kubeletFlags(), argsMap, cfg.ExtraKubeletArgs); err != nil {
+		return err
+	}
 	args := config.GetArgsList(argsMap, cfg.ExtraKubeletArgs)
 	command.SetArgs(args)
 
@@ -140,13 +201,23 @@ func kubelet(cfg *config.Agent) {
 		logrus.Infof("Running kubelet %s", config.ArgString(args))
 		logrus.Fatalf("kubelet exited: %v", command.Execute())
 	}()
+
+	return nil
 }
 
-func addFeatureGate(current, new string) string {
-	if current == "" {
-		return new
+// kubeletFlags returns the flags accepted by the kubelet. The kubelet command parses
+// its own flag set rather than the cobra one, so it is rebuilt here the same way.
+func kubeletFlags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("kubelet", pflag.ContinueOnError)
+	fs.SetNormalizeFunc(cliflag.WordSepNormalizeFunc)
+	kubeletConfig, err := options.NewKubeletConfiguration()
+	if err != nil {
+		logrus.Fatal(err)
 	}
-	return current + "," + new
+	options.NewKubeletFlags().AddFlags(fs)
+	options.AddKubeletConfigFlags(fs, kubeletConfig)
+	options.AddGlobalFlags(fs)
+	return fs
 }
 
 func checkCgroups() (root string, hasCFS bool, hasPIDs bool) {
@@ -167,9 +238,14 @@ func checkCgroups() (root string, hasCFS bool, hasPIDs bool) {
 			if system == "pids" {
 				hasPIDs = true
 			} else if system == "cpu" {
-				p := filepath.Join("/sys/fs/cgroup", parts[1], parts[2], "cpu.cfs_period_us")
-				if _, err := os.Stat(p); err == nil {
-					hasCFS = true
+				// inside a container the own cgroup is usually mounted as the root
+				for _, p := range []string{
+					filepath.Join("/sys/fs/cgroup", parts[1], parts[2], "cpu.cfs_period_us"),
+					filepath.Join("/sys/fs/cgroup", parts[1], "cpu.cfs_period_us"),
+				} {
+					if _, err := os.Stat(p); err == nil {
+						hasCFS = true
+					}
 				}
 			} else if system == "name=systemd" {
 				last := parts[len(parts)-1]
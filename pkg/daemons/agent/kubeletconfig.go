@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	kubeletscheme "k8s.io/kubernetes/pkg/kubelet/apis/config/scheme"
+	"k8s.io/kubernetes/pkg/kubelet/apis/config/validation"
+	"k8s.io/kubernetes/pkg/kubelet/kubeletconfig/util/codec"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeletConfigDefaults maps the kubelet flags k3s only sets as defaults to
+// their KubeletConfiguration field. The flag is dropped when the config file
+// sets the field, as kubelet flags take precedence over the file.
+var kubeletConfigDefaults = map[string]string{
+	"healthz-bind-address":     "healthzBindAddress",
+	"read-only-port":           "readOnlyPort",
+	"eviction-hard":            "evictionHard",
+	"eviction-minimum-reclaim": "evictionMinimumReclaim",
+	"fail-swap-on":             "failSwapOn",
+	"cgroup-driver":            "cgroupDriver",
+	"serialize-image-pulls":    "serializeImagePulls",
+}
+
+// kubeletConfig merges the kubelet config file and the *.conf files of the
+// drop-in directory, in lexical order, as JSON merge patches. The result is
+// validated and written to cfg.KubeletConfig, whose path is returned along
+// with the top level fields it sets. No path is returned if there is nothing
+// to merge.
+func kubeletConfig(cfg *config.Agent) (string, map[string]interface{}, error) {
+	var files []string
+	if cfg.KubeletConfigFile != "" {
+		files = append(files, cfg.KubeletConfigFile)
+	}
+	if cfg.KubeletConfigDir != "" {
+		dropIns, err := filepath.Glob(filepath.Join(cfg.KubeletConfigDir, "*.conf"))
+		if err != nil {
+			return "", nil, err
+		}
+		sort.Strings(dropIns)
+		files = append(files, dropIns...)
+	}
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+
+	merged := []byte("{}")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", nil, err
+		}
+		patch, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "parsing kubelet config %s", file)
+		}
+		if merged, err = jsonpatch.MergePatch(merged, patch); err != nil {
+			return "", nil, errors.Wrapf(err, "merging kubelet config %s", file)
+		}
+		logrus.Infof("Using kubelet config %s", file)
+	}
+
+	_, codecs, err := kubeletscheme.NewSchemeAndCodecs()
+	if err != nil {
+		return "", nil, err
+	}
+	kubeletConfig, err := codec.DecodeKubeletConfiguration(codecs, merged)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "decoding kubelet config")
+	}
+	if err := validation.ValidateKubeletConfiguration(kubeletConfig); err != nil {
+		return "", nil, errors.Wrap(err, "invalid kubelet config")
+	}
+
+	fields := map[string]interface{}{}
+	if err := yaml.Unmarshal(merged, &fields); err != nil {
+		return "", nil, err
+	}
+	data, err := yaml.JSONToYAML(merged)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.KubeletConfig), 0700); err != nil {
+		return "", nil, err
+	}
+	return cfg.KubeletConfig, fields, ioutil.WriteFile(cfg.KubeletConfig, data, 0600)
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	kubeproxyconfig "k8s.io/kubernetes/pkg/proxy/apis/config"
+	kubeproxyscheme "k8s.io/kubernetes/pkg/proxy/apis/config/scheme"
+	"k8s.io/kubernetes/pkg/proxy/apis/config/v1alpha1"
+	"k8s.io/kubernetes/pkg/proxy/apis/config/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeProxyConfig loads a KubeProxyConfiguration and fills in the values k3s
+// computes for the node. kube-proxy ignores its other flags when given a
+// config file, so the settings k3s otherwise passes as flags are applied
+// here unless the file sets them. The result is validated and written to
+// cfg.KubeProxyConfig.
+func kubeProxyConfig(cfg *config.Agent) error {
+	data, err := ioutil.ReadFile(cfg.KubeProxyConfigFile)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return errors.Wrapf(err, "parsing kube-proxy config %s", cfg.KubeProxyConfigFile)
+	}
+
+	proxyConfig := &kubeproxyconfig.KubeProxyConfiguration{}
+	if err := k8sruntime.DecodeInto(kubeproxyscheme.Codecs.UniversalDecoder(), data, proxyConfig); err != nil {
+		return errors.Wrapf(err, "decoding kube-proxy config %s", cfg.KubeProxyConfigFile)
+	}
+
+	proxyConfig.ClientConnection.Kubeconfig = cfg.KubeConfigKubeProxy
+	proxyConfig.ClusterCIDR = cfg.ClusterCIDR.String()
+	proxyConfig.HostnameOverride = cfg.NodeName
+	if _, ok := raw["mode"]; !ok {
+		proxyConfig.Mode = kubeproxyconfig.ProxyModeIPTables
+	}
+	if _, ok := raw["healthzBindAddress"]; !ok {
+		proxyConfig.HealthzBindAddress = "127.0.0.1:10256"
+	}
+	if proxyConfig.FeatureGates == nil {
+		proxyConfig.FeatureGates = map[string]bool{}
+	}
+	if err := mergeFeatureGates(proxyConfig.FeatureGates, cfg.FeatureGates); err != nil {
+		return err
+	}
+
+	if errs := validation.Validate(proxyConfig); len(errs) > 0 {
+		return errors.Wrapf(errs.ToAggregate(), "invalid kube-proxy config %s", cfg.KubeProxyConfigFile)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.KubeProxyConfig), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(cfg.KubeProxyConfig, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewYAMLSerializer(json.DefaultMetaFactory, kubeproxyscheme.Scheme, kubeproxyscheme.Scheme)
+	logrus.Infof("Using kube-proxy config %s", cfg.KubeProxyConfigFile)
+	return kubeproxyscheme.Codecs.EncoderForVersion(encoder, v1alpha1.SchemeGroupVersion).Encode(proxyConfig, f)
+}
+
+// mergeFeatureGates adds a --feature-gates value to gates, keeping the gates
+// already set.
+func mergeFeatureGates(gates map[string]bool, value string) error {
+	for _, gate := range strings.Split(value, ",") {
+		gate = strings.TrimSpace(gate)
+		if gate == "" {
+			continue
+		}
+		parts := strings.SplitN(gate, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("invalid feature gate %s", gate)
+		}
+		enabled, err := strconv.ParseBool(parts[1])
+		if err != nil {
+			return errors.Wrapf(err, "invalid feature gate %s", gate)
+		}
+		if _, ok := gates[parts[0]]; !ok {
+			gates[parts[0]] = enabled
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// ValidateArgs checks that every extra arg is a flag accepted by the component, and
+// logs how the extra args change the arguments k3s would otherwise pass. It must be
+// called before GetArgsList, which merges the extra args into argsMap.
+func ValidateArgs(component string, flags *pflag.FlagSet, argsMap map[string]string, extraArgs []string) error {
+	var unknown, changes []string
+	for _, arg := range extraArgs {
+		splitArg := strings.SplitN(arg, "=", 2)
+		name := strings.TrimLeft(splitArg[0], "-")
+		if flags.Lookup(name) == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		value := "true"
+		if len(splitArg) == 2 {
+			value = splitArg[1]
+		}
+		if def, ok := argsMap[name]; ok {
+			changes = append(changes, fmt.Sprintf("--%s: %q -> %q", name, def, value))
+		} else {
+			changes = append(changes, fmt.Sprintf("--%s: (unset) -> %q", name, value))
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown %s flags: --%s", component, strings.Join(unknown, ", --"))
+	}
+	for _, change := range changes {
+		logrus.Infof("%s argument override %s", component, change)
+	}
+	return nil
+}
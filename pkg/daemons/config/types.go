@@ -6,8 +6,13 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	_ "k8s.io/kubernetes/pkg/features" // for feature gate registration
+	"k8s.io/kubernetes/pkg/util/taints"
 )
 
 type Node struct {
@@ -16,13 +21,46 @@ type Node struct {
 	NoFlannel                bool
 	FlannelConf              string
 	FlannelIface             *net.Interface
+	FlannelBackend           string
+	FlannelMTU               int
 	LocalAddress             string
 	Containerd               Containerd
 	Images                   string
+	VerifyImages             bool
 	AgentConfig              Agent
 	CACerts                  []byte
 	ServerAddress            string
 	Certificate              *tls.Certificate
+	LocalResolver            bool
+	UpstreamResolvConf       string
+	FallbackResolvConf       string
+	AutoReboot               bool
+	Firewalld                bool
+	HealConfigDrift          bool
+	// TunnelAddresses are extra host:port addresses reachable through the tunnel
+	TunnelAddresses []string
+	// LogFile is where k3s logs to, if not the journal
+	LogFile  string
+	Buildkit Buildkit
+	// LogForwardURL is where container logs are shipped to, if set
+	LogForwardURL string
+	// FlannelExternalIP is the address the tunnels of other nodes end at,
+	// if not the address of the flannel interface
+	FlannelExternalIP net.IP
+	// CNIExample is where an example network config is written for
+	// external CNI plugins, when flannel is disabled
+	CNIExample string
+	// Multus chains flannel behind the multus meta-plugin
+	Multus bool
+	// DisableNetworkPolicy leaves NetworkPolicy enforcement to the CNI plugin
+	DisableNetworkPolicy bool
+	// DataDir is the data dir holding the agent and server state, SnapshotDir
+	// is where datastore snapshots are kept on servers
+	DataDir     string
+	SnapshotDir string
+	// DiskUsageThreshold is how full in percent the filesystems holding k3s
+	// paths may be before an event is recorded
+	DiskUsageThreshold int
 }
 
 type Containerd struct {
@@ -33,36 +71,62 @@ type Containerd struct {
 	Config   string
 	Opt      string
 	Template string
+	Registry string
+	// Snapshotter is the containerd snapshotter, SnapshotterAddress is set
+	// for snapshotters running outside of containerd
+	Snapshotter        string
+	SnapshotterAddress string
+	// SocketGID is the group owning the containerd socket, if set
+	SocketGID string
+}
+
+type Buildkit struct {
+	Enabled bool
+	Address string
+	Root    string
+	Log     string
 }
 
 type Agent struct {
-	NodeName            string
-	ClientKubeletCert   string
-	ClientKubeletKey    string
-	ClientKubeProxyCert string
-	ClientKubeProxyKey  string
-	ServingKubeletCert  string
-	ServingKubeletKey   string
-	ClusterCIDR         net.IPNet
-	ClusterDNS          net.IP
-	ClusterDomain       string
-	ResolvConf          string
-	RootDir             string
-	KubeConfigNode      string
-	KubeConfigKubelet   string
-	KubeConfigKubeProxy string
-	NodeIP              string
-	RuntimeSocket       string
-	ListenAddress       string
-	ClientCA            string
-	CNIBinDir           string
-	CNIConfDir          string
-	ExtraKubeletArgs    []string
-	ExtraKubeProxyArgs  []string
-	PauseImage          string
-	CNIPlugin           bool
-	NodeTaints          []string
-	NodeLabels          []string
+	NodeName             string
+	ClientKubeletCert    string
+	ClientKubeletKey     string
+	ClientKubeProxyCert  string
+	ClientKubeProxyKey   string
+	ServingKubeletCert   string
+	ServingKubeletKey    string
+	ClusterCIDR          net.IPNet
+	ServiceCIDR          net.IPNet
+	ClusterDNS           net.IP
+	ClusterDomain        string
+	ResolvConf           string
+	RootDir              string
+	KubeConfigNode       string
+	KubeConfigKubelet    string
+	KubeConfigKubeProxy  string
+	NodeIP               string
+	RuntimeSocket        string
+	ListenAddress        string
+	ClientCA             string
+	CNIBinDir            string
+	CNIConfDir           string
+	ExtraKubeletArgs     []string
+	ExtraKubeProxyArgs   []string
+	FeatureGates         string
+	PauseImage           string
+	ContainerLogMaxSize  string
+	ContainerLogMaxFiles int
+	CNIPlugin            bool
+	NodeTaints           []string
+	NodeLabels           []string
+	KubeletConfigFile    string
+	KubeletConfigDir     string
+	KubeProxyConfigFile  string
+	DisableKubeProxy     bool
+	// KubeletConfig and KubeProxyConfig are the completed config files
+	// passed to the kubelet and kube-proxy
+	KubeletConfig   string
+	KubeProxyConfig string
 }
 
 type Control struct {
@@ -72,15 +136,22 @@ type Control struct {
 	HTTPSPort             int
 	ProxyPort             int
 	ClusterSecret         string
+	AgentToken            string `json:"-"`
 	ClusterIPRange        *net.IPNet
 	ServiceIPRange        *net.IPNet
 	ClusterDNS            net.IP
 	ClusterDomain         string
+	FlannelBackend        string
+	FlannelExternalIP     bool
+	Multus                bool
+	DisableKubeProxy      bool
+	DisableNetworkPolicy  bool
 	NoCoreDNS             bool
 	KubeConfigOutput      string
 	KubeConfigMode        string
 	DataDir               string
 	Skips                 []string
+	PinnedAddons          map[string]string
 	BootstrapType         string
 	StorageBackend        string
 	StorageEndpoint       string
@@ -88,14 +159,33 @@ type Control struct {
 	StorageCertFile       string
 	StorageKeyFile        string
 	NoScheduler           bool
+	SchedulerConfig       string
+	SecondarySchedulers   []string
 	ExtraAPIArgs          []string
 	ExtraControllerArgs   []string
+	DisableControllers    []string
+	DisableAPIs           []string
 	ExtraSchedulerAPIArgs []string
 	NoLeaderElect         bool
+	FeatureGates          string
+	SystemDefaultRegistry string
+	EncryptSecrets        bool
+	ClusterSizeHint       string
+	HealConfigDrift       bool
+	Audit                 Audit
 
 	Runtime *ControlRuntime `json:"-"`
 }
 
+type Audit struct {
+	WebhookConfig  string
+	PolicyFile     string
+	SpillDir       string
+	SpillMaxSize   int64
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
 type ControlRuntime struct {
 	ClientKubeAPICert string
 	ClientKubeAPIKey  string
@@ -116,9 +206,14 @@ type ControlRuntime struct {
 	ServingKubeAPIKey  string
 	ClientToken        string
 	NodeToken          string
+	AgentToken         string
 	Handler            http.Handler
 	Tunnel             http.Handler
 	Authenticator      authenticator.Request
+	EncryptionKey      string
+	// APIServerStopped is closed once the apiserver finished the requests in
+	// flight after the server context was cancelled.
+	APIServerStopped <-chan struct{}
 
 	RequestHeaderCA     string
 	RequestHeaderCAKey  string
@@ -159,6 +254,11 @@ func GetArgsList(argsMap map[string]string, extraArgs []string) []string {
 			argsMap[splitArg[0]] = "true"
 			continue
 		}
+		if splitArg[0] == "feature-gates" {
+			// component specific gates override individual cluster wide gates
+			argsMap[splitArg[0]] = MergeFeatureGates(argsMap[splitArg[0]], splitArg[1])
+			continue
+		}
 		argsMap[splitArg[0]] = splitArg[1]
 	}
 	var args []string
@@ -168,3 +268,80 @@ func GetArgsList(argsMap map[string]string, extraArgs []string) []string {
 	}
 	return args
 }
+
+// MergeFeatureGates combines two --feature-gates values, with gates set in override
+// taking precedence over the same gates in base.
+func MergeFeatureGates(base, override string) string {
+	var keys []string
+	gates := map[string]string{}
+	for _, value := range []string{base, override} {
+		for _, gate := range strings.Split(value, ",") {
+			gate = strings.TrimSpace(gate)
+			if gate == "" {
+				continue
+			}
+			key := strings.SplitN(gate, "=", 2)[0]
+			if _, ok := gates[key]; !ok {
+				keys = append(keys, key)
+			}
+			gates[key] = gate
+		}
+	}
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, gates[key])
+	}
+	return strings.Join(result, ",")
+}
+
+// ValidateFeatureGates checks that a --feature-gates value only refers to known gates.
+func ValidateFeatureGates(gates string) error {
+	if gates == "" {
+		return nil
+	}
+	return utilfeature.DefaultMutableFeatureGate.DeepCopy().Set(gates)
+}
+
+// ValidateNodeLabels checks that --node-label values are key=value pairs the
+// kubelet can register the node with.
+func ValidateNodeLabels(labels []string) error {
+	for _, label := range splitList(labels) {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid node label %q, must be key=value", label)
+		}
+		if errs := validation.IsQualifiedName(parts[0]); len(errs) > 0 {
+			return fmt.Errorf("invalid node label key %q: %s", parts[0], strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(parts[1]); len(errs) > 0 {
+			return fmt.Errorf("invalid node label value %q: %s", parts[1], strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// ValidateNodeTaints checks that --node-taint values are key=value:Effect
+// taints the kubelet can register the node with.
+func ValidateNodeTaints(nodeTaints []string) error {
+	_, remove, err := taints.ParseTaints(splitList(nodeTaints))
+	if err != nil {
+		return err
+	}
+	if len(remove) > 0 {
+		return fmt.Errorf("invalid node taint %s-, taints can not be removed at registration", remove[0].ToString())
+	}
+	return nil
+}
+
+func splitList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package control
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// optionalAPI is an API group version that may be turned off with
+// --disable-apis, the controllers that only work with it and the resources,
+// by registry prefix, that are not served by any other version.
+type optionalAPI struct {
+	controllers []string
+	resources   []string
+}
+
+var optionalAPIs = map[string]optionalAPI{
+	"apps/v1beta1":          {},
+	"apps/v1beta2":          {},
+	"autoscaling/v2beta1":   {},
+	"autoscaling/v2beta2":   {},
+	"batch/v1beta1":         {controllers: []string{"cronjob"}, resources: []string{"cronjobs"}},
+	"events.k8s.io/v1beta1": {},
+	"certificates.k8s.io/v1beta1": {
+		controllers: []string{"csrapproving", "csrsigning", "csrcleaner"},
+		resources:   []string{"certificatesigningrequests"},
+	},
+	"networking.k8s.io/v1beta1": {},
+	"node.k8s.io/v1beta1":       {resources: []string{"runtimeclasses"}},
+	"policy/v1beta1": {
+		controllers: []string{"disruption"},
+		resources:   []string{"poddisruptionbudgets", "podsecuritypolicy"},
+	},
+	"scheduling.k8s.io/v1beta1": {},
+}
+
+// DisabledAPIs splits the values of --disable-apis and checks that each one
+// is an API group version that can be turned off.
+func DisabledAPIs(values []string) ([]string, error) {
+	var apis []string
+	for _, value := range values {
+		for _, api := range strings.Split(value, ",") {
+			api = strings.TrimSpace(api)
+			if api == "" {
+				continue
+			}
+			if _, ok := optionalAPIs[api]; !ok {
+				return nil, fmt.Errorf("API %q can not be disabled, must be one of: %s", api, strings.Join(optionalAPINames(), ", "))
+			}
+			apis = append(apis, api)
+		}
+	}
+	return apis, nil
+}
+
+// DisabledAPIResources returns the registry keys of the objects that become
+// unreachable when the given APIs are disabled.
+func DisabledAPIResources(apis []string) map[string]string {
+	resources := map[string]string{}
+	for _, api := range apis {
+		for _, resource := range optionalAPIs[api].resources {
+			resources["/registry/"+resource+"/"] = api
+		}
+	}
+	return resources
+}
+
+func optionalAPINames() []string {
+	var names []string
+	for name := range optionalAPIs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runtimeConfigArg builds the kube-apiserver --runtime-config value that
+// turns off the given APIs.
+func runtimeConfigArg(apis []string) string {
+	var values []string
+	for _, api := range apis {
+		values = append(values, api+"=false")
+	}
+	return strings.Join(values, ",")
+}
+
+func disabledAPIControllers(apis []string) []string {
+	var controllers []string
+	for _, api := range apis {
+		controllers = append(controllers, optionalAPIs[api].controllers...)
+	}
+	return controllers
+}
@@ -0,0 +1,183 @@
+package control
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"path"
+	"time"
+
+	certutil "github.com/rancher/dynamiclistener/cert"
+)
+
+// caNames are the cluster CAs, each kept as <name>.crt and <name>.key in the
+// tls directory. Operators may place their own root or intermediate CA
+// there before the first start; the first certificate in the .crt file must
+// match the .key file and is used for signing.
+var caNames = []string{"server-ca", "client-ca", "request-header-ca"}
+
+// RotateCA replaces the cluster CAs with the ones in newDir, or with newly
+// generated ones if newDir is empty. Each new CA is cross-signed by the one
+// it replaces, and the old CA is kept in the bundle, so that clients that
+// only trust the old CA keep working until they fetch the new bundle. All
+// leaf certificates are issued again on the next start. The tls directory is
+// backed up first and the backup location returned.
+func RotateCA(dataDir, newDir string) (string, error) {
+	tlsDir := path.Join(dataDir, "tls")
+	backupDir := path.Join(dataDir, fmt.Sprintf("tls-%d", time.Now().Unix()))
+
+	rotated := map[string][]byte{}
+	for _, name := range caNames {
+		newCert, newKey, err := loadNewCA(name, newDir)
+		if err != nil {
+			return "", err
+		}
+		if newCert == nil {
+			continue
+		}
+		bundle, err := crossSign(path.Join(tlsDir, name+".crt"), path.Join(tlsDir, name+".key"), newCert)
+		if err != nil {
+			return "", fmt.Errorf("failed to cross-sign %s: %v", name, err)
+		}
+		rotated[name+".crt"] = bundle
+		rotated[name+".key"] = newKey
+	}
+	if len(rotated) == 0 {
+		return "", fmt.Errorf("no CA certificates and keys found in %s", newDir)
+	}
+
+	if err := copyDir(tlsDir, backupDir); err != nil {
+		return "", err
+	}
+	for name, data := range rotated {
+		if err := ioutil.WriteFile(path.Join(tlsDir, name), data, 0600); err != nil {
+			return "", err
+		}
+	}
+	return backupDir, removeCerts(tlsDir, CertServices())
+}
+
+// loadNewCA returns the PEM encoded certificates and key of a replacement
+// CA, or nil if newDir does not hold one.
+func loadNewCA(name, newDir string) ([]byte, []byte, error) {
+	if newDir == "" {
+		key, err := certutil.NewPrivateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := certutil.NewSelfSignedCACert(certutil.Config{
+			CommonName: fmt.Sprintf("k3s-%s@%d", name, time.Now().Unix()),
+		}, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return certutil.EncodeCertPEM(cert), certutil.EncodePrivateKeyPEM(key), nil
+	}
+
+	certFile, keyFile := path.Join(newDir, name+".crt"), path.Join(newDir, name+".key")
+	if !exists(certFile) && !exists(keyFile) {
+		return nil, nil, nil
+	}
+	if err := validateCA(certFile, keyFile); err != nil {
+		return nil, nil, err
+	}
+	certBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certBytes, keyBytes, nil
+}
+
+// crossSign returns the new CA bundle followed by the new CA signed by the
+// old one, and by the old CA.
+func crossSign(oldCertFile, oldKeyFile string, newCertBytes []byte) ([]byte, error) {
+	oldCerts, err := certutil.CertsFromFile(oldCertFile)
+	if err != nil {
+		return nil, err
+	}
+	oldKey, err := certutil.PrivateKeyFromFile(oldKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := oldKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a signing key", oldKeyFile)
+	}
+	newCerts, err := certutil.ParseCertsPEM(newCertBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := newCerts[0].NotAfter
+	if oldCerts[0].NotAfter.Before(notAfter) {
+		notAfter = oldCerts[0].NotAfter
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               newCerts[0].Subject,
+		SubjectKeyId:          newCerts[0].SubjectKeyId,
+		NotBefore:             time.Now().UTC(),
+		NotAfter:              notAfter,
+		KeyUsage:              newCerts[0].KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	crossDER, err := x509.CreateCertificate(rand.Reader, &tmpl, oldCerts[0], newCerts[0].PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	cross, err := x509.ParseCertificate(crossDER)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := append([]byte{}, newCertBytes...)
+	bundle = append(bundle, certutil.EncodeCertPEM(cross)...)
+	return append(bundle, certutil.EncodeCertPEM(oldCerts[0])...), nil
+}
+
+// validateCA checks that the first certificate in certFile is a CA matching
+// the key in keyFile.
+func validateCA(certFile, keyFile string) error {
+	certs, err := certutil.CertsFromFile(certFile)
+	if err != nil {
+		return err
+	}
+	if !certs[0].IsCA {
+		return fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, err := certutil.PrivateKeyFromFile(keyFile)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("%s is not a signing key", keyFile)
+	}
+
+	certPub, err := x509.MarshalPKIXPublicKey(certs[0].PublicKey)
+	if err != nil {
+		return err
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		return fmt.Errorf("%s does not match the key in %s", certFile, keyFile)
+	}
+	return nil
+}
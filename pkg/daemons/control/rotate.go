@@ -0,0 +1,125 @@
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	certutil "github.com/rancher/dynamiclistener/cert"
+	"github.com/sirupsen/logrus"
+)
+
+// CertRenewBefore is how long before expiry certificates are regenerated on
+// startup.
+const CertRenewBefore = 90 * 24 * time.Hour
+
+// RegenerateListenerFile, when present in the tls directory, makes the server
+// discard its listener certificate on the next start.
+const RegenerateListenerFile = "dynamic-cert-regenerate"
+
+// certServices maps the services accepted by RotateCerts to the certificates
+// issued for them, relative to the tls directory.
+var certServices = map[string][]string{
+	"admin":              {"client-admin.crt"},
+	"api-server":         {"client-kube-apiserver.crt", "serving-kube-apiserver.crt"},
+	"controller-manager": {"client-controller.crt"},
+	"scheduler":          {"client-scheduler.crt"},
+	"kube-proxy":         {"client-kube-proxy.crt"},
+	"auth-proxy":         {"client-auth-proxy.crt"},
+	"k3s-server":         {},
+}
+
+// CertServices returns the services accepted by RotateCerts.
+func CertServices() []string {
+	var services []string
+	for service := range certServices {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// RotateCerts backs up the tls directory and removes the certificates of the
+// given services, or of all services if none are given, so that they are
+// issued again by the existing CAs on the next start. Keys are kept.
+func RotateCerts(dataDir string, services []string) (string, error) {
+	if len(services) == 0 {
+		services = CertServices()
+	}
+	for _, service := range services {
+		if _, ok := certServices[service]; !ok {
+			return "", fmt.Errorf("unknown service %s, must be one of %s", service, strings.Join(CertServices(), ", "))
+		}
+	}
+
+	tlsDir := path.Join(dataDir, "tls")
+	backupDir := path.Join(dataDir, fmt.Sprintf("tls-%d", time.Now().Unix()))
+	if err := copyDir(tlsDir, backupDir); err != nil {
+		return "", err
+	}
+
+	return backupDir, removeCerts(tlsDir, services)
+}
+
+func removeCerts(tlsDir string, services []string) error {
+	for _, service := range services {
+		if service == "k3s-server" {
+			if err := ioutil.WriteFile(path.Join(tlsDir, RegenerateListenerFile), nil, 0600); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, file := range certServices[service] {
+			if err := os.Remove(path.Join(tlsDir, file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expiresSoon reports whether the first certificate in certFile expires
+// within CertRenewBefore.
+func expiresSoon(certFile string) bool {
+	certBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	certs, err := certutil.ParseCertsPEM(certBytes)
+	if err != nil || len(certs) == 0 {
+		logrus.Warnf("Regenerating %s, it could not be parsed: %v", certFile, err)
+		return true
+	}
+	if time.Until(certs[0].NotAfter) < CertRenewBefore {
+		logrus.Infof("Regenerating %s, it expires %s", certFile, certs[0].NotAfter.Format(time.RFC3339))
+		return true
+	}
+	return false
+}
+
+func copyDir(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(src, file.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(dst, file.Name()), data, file.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
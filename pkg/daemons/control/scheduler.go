@@ -0,0 +1,86 @@
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app/options"
+	kubeschedulerconfig "k8s.io/kubernetes/pkg/scheduler/apis/config"
+	kubeschedulerscheme "k8s.io/kubernetes/pkg/scheduler/apis/config/scheme"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultSchedulerName     = "default-scheduler"
+	defaultSchedulerLockName = "kube-scheduler"
+	schedulerPort            = 10251
+	secondarySchedulerPort   = 10261
+)
+
+// schedulerCommand is a secondary scheduler that passed validation and is yet to
+// be started.
+type schedulerCommand struct {
+	name string
+	args []string
+}
+
+// schedulerConfig loads and validates a KubeSchedulerConfiguration, filling in the
+// settings k3s normally passes as flags, and writes the result under the data dir.
+// It returns the scheduler name and the path of the written config.
+func schedulerConfig(cfg *config.Control, runtime *config.ControlRuntime, file string, secondary bool, port int) (string, string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", "", errors.Wrapf(err, "parsing scheduler config %s", file)
+	}
+
+	schedulerConfig := &kubeschedulerconfig.KubeSchedulerConfiguration{}
+	if err := k8sruntime.DecodeInto(kubeschedulerscheme.Codecs.UniversalDecoder(), data, schedulerConfig); err != nil {
+		return "", "", errors.Wrapf(err, "decoding scheduler config %s", file)
+	}
+
+	if schedulerConfig.ClientConnection.Kubeconfig == "" {
+		schedulerConfig.ClientConnection.Kubeconfig = runtime.KubeConfigScheduler
+	}
+	addr := fmt.Sprintf("%s:%d", localhostIP, port)
+	if _, ok := raw["healthzBindAddress"]; !ok {
+		schedulerConfig.HealthzBindAddress = addr
+	}
+	if _, ok := raw["metricsBindAddress"]; !ok {
+		schedulerConfig.MetricsBindAddress = addr
+	}
+	if cfg.NoLeaderElect {
+		schedulerConfig.LeaderElection.LeaderElect = false
+	}
+
+	name := schedulerConfig.SchedulerName
+	if secondary {
+		if name == defaultSchedulerName {
+			return "", "", fmt.Errorf("secondary scheduler config %s must set a schedulerName other than %s", file, defaultSchedulerName)
+		}
+		if schedulerConfig.LeaderElection.LockObjectName == defaultSchedulerLockName {
+			schedulerConfig.LeaderElection.LockObjectName = name
+		}
+	}
+
+	if errs := validation.ValidateKubeSchedulerConfiguration(schedulerConfig); len(errs) > 0 {
+		return "", "", errors.Wrapf(errs.ToAggregate(), "invalid scheduler config %s", file)
+	}
+
+	dir := filepath.Join(cfg.DataDir, "etc", "scheduler")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	configFile := filepath.Join(dir, name+".yaml")
+	return name, configFile, options.WriteConfigFile(configFile, schedulerConfig)
+}
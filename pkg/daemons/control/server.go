@@ -22,8 +22,11 @@ import (
 	"time"
 
 	certutil "github.com/rancher/dynamiclistener/cert"
+	"github.com/rancher/k3s/pkg/audit"
 	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/secretsencrypt"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/kubernetes/cmd/kube-apiserver/app"
 	cmapp "k8s.io/kubernetes/cmd/kube-controller-manager/app"
@@ -63,6 +66,37 @@ users:
 `))
 )
 
+const (
+	// AgentUser is the user agents joining with the agent token authenticate
+	// as. Unlike the node user it is not a member of system:masters.
+	AgentUser = "k3s-agent"
+	// AgentGroup is the group of the agent user, bound to the permissions
+	// agents need.
+	AgentGroup = "k3s:agent"
+)
+
+// clusterSizePresets are apiserver arguments for each --cluster-size-hint.
+// Medium clusters use the upstream defaults.
+var clusterSizePresets = map[string]map[string]string{
+	"small": {
+		"default-watch-cache-size":       "20",
+		"max-requests-inflight":          "100",
+		"max-mutating-requests-inflight": "50",
+	},
+	"medium": {},
+	"large": {
+		"default-watch-cache-size":       "500",
+		"max-requests-inflight":          "1600",
+		"max-mutating-requests-inflight": "800",
+	},
+}
+
+// ValidClusterSizeHint reports whether hint is a known cluster size.
+func ValidClusterSizeHint(hint string) bool {
+	_, ok := clusterSizePresets[hint]
+	return ok
+}
+
 func Server(ctx context.Context, cfg *config.Control) error {
 	rand.Seed(time.Now().UTC().UnixNano())
 
@@ -85,15 +119,15 @@ func Server(ctx context.Context, cfg *config.Control) error {
 	runtime.Authenticator = auth
 
 	if !cfg.NoScheduler {
-		scheduler(cfg, runtime)
+		if err := scheduler(cfg, runtime); err != nil {
+			return err
+		}
 	}
 
-	controllerManager(cfg, runtime)
-
-	return nil
+	return controllerManager(cfg, runtime)
 }
 
-func controllerManager(cfg *config.Control, runtime *config.ControlRuntime) {
+func controllerManager(cfg *config.Control, runtime *config.ControlRuntime) error {
 	argsMap := map[string]string{
 		"kubeconfig":                       runtime.KubeConfigController,
 		"service-account-private-key-file": runtime.ServiceKey,
@@ -110,36 +144,127 @@ func controllerManager(cfg *config.Control, runtime *config.ControlRuntime) {
 	if cfg.NoLeaderElect {
 		argsMap["leader-elect"] = "false"
 	}
-
-	args := config.GetArgsList(argsMap, cfg.ExtraControllerArgs)
+	if cfg.FeatureGates != "" {
+		argsMap["feature-gates"] = cfg.FeatureGates
+	}
+	if disabled := append(disabledAPIControllers(cfg.DisableAPIs), cfg.DisableControllers...); len(disabled) > 0 {
+		controllers, err := controllersArg(disabled)
+		if err != nil {
+			return err
+		}
+		argsMap["controllers"] = controllers
+	}
 
 	command := cmapp.NewControllerManagerCommand()
+	if err := config.ValidateArgs("kube-controller-manager", command.Flags(), argsMap, cfg.ExtraControllerArgs); err != nil {
+		return err
+	}
+	args := config.GetArgsList(argsMap, cfg.ExtraControllerArgs)
 	command.SetArgs(args)
 
 	go func() {
 		logrus.Infof("Running kube-controller-manager %s", config.ArgString(args))
 		logrus.Fatalf("controller-manager exited: %v", command.Execute())
 	}()
+
+	return nil
+}
+
+// controllersArg builds the kube-controller-manager --controllers value that enables
+// the default set minus the given controllers.
+func controllersArg(disabled []string) (string, error) {
+	known := sets.NewString(cmapp.KnownControllers()...)
+	controllers := []string{"*"}
+	for _, list := range disabled {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !known.Has(name) {
+				return "", fmt.Errorf("unknown kube-controller-manager controller %q, must be one of: %s", name, strings.Join(known.List(), ", "))
+			}
+			controllers = append(controllers, "-"+name)
+		}
+	}
+	return strings.Join(controllers, ","), nil
 }
 
-func scheduler(cfg *config.Control, runtime *config.ControlRuntime) {
+func scheduler(cfg *config.Control, runtime *config.ControlRuntime) error {
 	argsMap := map[string]string{
 		"kubeconfig":   runtime.KubeConfigScheduler,
-		"port":         "10251",
+		"port":         strconv.Itoa(schedulerPort),
 		"bind-address": "127.0.0.1",
 		"secure-port":  "0",
 	}
 	if cfg.NoLeaderElect {
 		argsMap["leader-elect"] = "false"
 	}
+	primaryName := defaultSchedulerName
+	if cfg.SchedulerConfig != "" {
+		name, configFile, err := schedulerConfig(cfg, runtime, cfg.SchedulerConfig, false, schedulerPort)
+		if err != nil {
+			return err
+		}
+		primaryName = name
+		argsMap = map[string]string{
+			"config":      configFile,
+			"secure-port": "0",
+		}
+	}
+	if cfg.FeatureGates != "" {
+		argsMap["feature-gates"] = cfg.FeatureGates
+	}
+	if err := config.ValidateArgs("kube-scheduler", sapp.NewSchedulerCommand().Flags(), argsMap, cfg.ExtraSchedulerAPIArgs); err != nil {
+		return err
+	}
 	args := config.GetArgsList(argsMap, cfg.ExtraSchedulerAPIArgs)
+	if _, ok := argsMap["config"]; !ok && argsMap["scheduler-name"] != "" {
+		// the flag is ignored when the scheduler is given a config
+		primaryName = argsMap["scheduler-name"]
+	}
+
+	// every name is checked before any scheduler starts, so that a bad
+	// secondary config does not leave the others running
+	names := map[string]string{primaryName: "the kube-scheduler"}
+	var secondaries []schedulerCommand
+	for i, file := range cfg.SecondarySchedulers {
+		name, configFile, err := schedulerConfig(cfg, runtime, file, true, secondarySchedulerPort+i)
+		if err != nil {
+			return err
+		}
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("secondary scheduler config %s uses the schedulerName %s of %s", file, name, other)
+		}
+		names[name] = file
+		secondaryArgsMap := map[string]string{
+			"config":      configFile,
+			"secure-port": "0",
+		}
+		if cfg.FeatureGates != "" {
+			secondaryArgsMap["feature-gates"] = cfg.FeatureGates
+		}
+		secondaries = append(secondaries, schedulerCommand{
+			name: name,
+			args: config.GetArgsList(secondaryArgsMap, nil),
+		})
+	}
 
+	runScheduler("kube-scheduler", args)
+	for _, secondary := range secondaries {
+		runScheduler(secondary.name, secondary.args)
+	}
+
+	return nil
+}
+
+func runScheduler(name string, args []string) {
 	command := sapp.NewSchedulerCommand()
 	command.SetArgs(args)
 
 	go func() {
-		logrus.Infof("Running kube-scheduler %s", config.ArgString(args))
-		logrus.Fatalf("scheduler exited: %v", command.Execute())
+		logrus.Infof("Running %s %s", name, config.ArgString(args))
+		logrus.Fatalf("%s exited: %v", name, command.Execute())
 	}()
 }
 
@@ -183,15 +308,49 @@ func apiServer(ctx context.Context, cfg *config.Control, runtime *config.Control
 	argsMap["requestheader-username-headers"] = "X-Remote-User"
 	argsMap["client-ca-file"] = runtime.ClientCA
 	argsMap["enable-admission-plugins"] = "NodeRestriction"
-
-	args := config.GetArgsList(argsMap, cfg.ExtraAPIArgs)
+	if cfg.FeatureGates != "" {
+		argsMap["feature-gates"] = cfg.FeatureGates
+	}
+	if len(cfg.DisableAPIs) > 0 {
+		argsMap["runtime-config"] = runtimeConfigArg(cfg.DisableAPIs)
+	}
+	for k, v := range clusterSizePresets[cfg.ClusterSizeHint] {
+		argsMap[k] = v
+	}
+	if cfg.EncryptSecrets {
+		encryptionConfig := secretsencrypt.File(cfg.DataDir)
+		if err := secretsencrypt.Ensure(encryptionConfig); err != nil {
+			return nil, nil, err
+		}
+		keys, err := secretsencrypt.Keys(encryptionConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		runtime.EncryptionKey = keys[0]
+		argsMap["encryption-provider-config"] = encryptionConfig
+	}
+	if cfg.Audit.WebhookConfig != "" {
+		if err := auditWebhook(ctx, argsMap, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	command := app.NewAPIServerCommand(ctx.Done())
+	if err := config.ValidateArgs("kube-apiserver", command.Flags(), argsMap, cfg.ExtraAPIArgs); err != nil {
+		return nil, nil, err
+	}
+	args := config.GetArgsList(argsMap, cfg.ExtraAPIArgs)
 	command.SetArgs(args)
 
+	stopped := make(chan struct{})
+	runtime.APIServerStopped = stopped
 	go func() {
 		logrus.Infof("Running kube-apiserver %s", config.ArgString(args))
-		logrus.Fatalf("apiserver exited: %v", command.Execute())
+		err := command.Execute()
+		if ctx.Err() == nil {
+			logrus.Fatalf("apiserver exited: %v", err)
+		}
+		close(stopped)
 	}()
 
 	startupConfig := <-app.StartupConfig
@@ -199,6 +358,37 @@ func apiServer(ctx context.Context, cfg *config.Control, runtime *config.Control
 	return startupConfig.Authenticator, startupConfig.Handler, nil
 }
 
+// auditWebhook points the apiserver at a local relay that buffers audit
+// events on disk before sending them to the configured webhook.
+func auditWebhook(ctx context.Context, argsMap map[string]string, cfg *config.Control) error {
+	spillDir := cfg.Audit.SpillDir
+	if spillDir == "" {
+		spillDir = filepath.Join(cfg.DataDir, "audit")
+	}
+	relay := &audit.Relay{
+		WebhookConfig:  cfg.Audit.WebhookConfig,
+		Dir:            spillDir,
+		MaxSize:        cfg.Audit.SpillMaxSize,
+		InitialBackoff: cfg.Audit.InitialBackoff,
+		MaxBackoff:     cfg.Audit.MaxBackoff,
+	}
+	webhookConfig, err := relay.Start(ctx)
+	if err != nil {
+		return err
+	}
+
+	policyFile := cfg.Audit.PolicyFile
+	if policyFile == "" {
+		policyFile, err = audit.DefaultPolicy(filepath.Join(cfg.DataDir, "etc"))
+		if err != nil {
+			return err
+		}
+	}
+	argsMap["audit-policy-file"] = policyFile
+	argsMap["audit-webhook-config-file"] = webhookConfig
+	return nil
+}
+
 func defaults(config *config.Control) {
 	if config.ClusterIPRange == nil {
 		_, clusterIPNet, _ := net.ParseCIDR("10.42.0.0/16")
@@ -304,6 +494,10 @@ func prepare(config *config.Control, runtime *config.ControlRuntime) error {
 		return err
 	}
 
+	if err := ensureAgentToken(config, runtime); err != nil {
+		return err
+	}
+
 	if err := storeBootstrapData(config); err != nil {
 		return err
 	}
@@ -351,6 +545,9 @@ func readTokens(runtime *config.ControlRuntime) error {
 	if clientToken, ok := tokens["admin"]; ok {
 		runtime.ClientToken = "admin:" + clientToken
 	}
+	if agentToken, ok := tokens[AgentUser]; ok {
+		runtime.AgentToken = AgentUser + ":" + agentToken
+	}
 
 	return nil
 }
@@ -392,6 +589,102 @@ func ensureNodeToken(config *config.Control, runtime *config.ControlRuntime) err
 	return WritePasswords(runtime.PasswdFile, records)
 }
 
+// ensureAgentToken adds the agent user to the password file with the agent
+// token as its password, or removes it if no agent token is set.
+func ensureAgentToken(config *config.Control, runtime *config.ControlRuntime) error {
+	f, err := os.Open(runtime.PasswdFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	changed := false
+	found := false
+	records := [][]string{}
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) > 1 && record[1] == AgentUser {
+			if config.AgentToken == "" || found {
+				changed = true
+				continue
+			}
+			found = true
+			if record[0] != config.AgentToken {
+				record[0] = config.AgentToken
+				changed = true
+			}
+		}
+		records = append(records, record)
+	}
+	if config.AgentToken != "" && !found {
+		records = append(records, []string{config.AgentToken, AgentUser, AgentUser, AgentGroup})
+		changed = true
+	}
+
+	f.Close()
+	if !changed {
+		return nil
+	}
+	return WritePasswords(runtime.PasswdFile, records)
+}
+
+// RotateNodeToken replaces the password of the node user with a new random
+// one, so that agents holding the old node token can no longer join. The new
+// token is written to the node-token file on the next start. Only the node
+// token is rotated: the agent token comes from --agent-token, join tokens are
+// stored in the datastore and stay valid until they expire or are deleted, and
+// the CA and the service account key are kept.
+func RotateNodeToken(dataDir string) error {
+	passwdFile := path.Join(dataDir, "cred", "passwd")
+	f, err := os.Open(passwdFile)
+	if os.IsNotExist(err) {
+		// a token is generated on first start
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nodeToken, err := getToken()
+	if err != nil {
+		return err
+	}
+
+	records := [][]string{}
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) > 1 && record[1] == "node" {
+			record[0] = nodeToken
+		}
+		records = append(records, record)
+	}
+
+	f.Close()
+	if err := WritePasswords(passwdFile, records); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(dataDir, "node-token")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func WritePasswords(passwdFile string, records [][]string) error {
 	out, err := os.Create(passwdFile + ".tmp")
 	if err != nil {
@@ -594,7 +887,7 @@ func genRequestHeaderCerts(config *config.Control, runtime *config.ControlRuntim
 
 func createClientCertKey(regen bool, commonName string, organization []string, altNames *certutil.AltNames, extKeyUsage []x509.ExtKeyUsage, caCertFile, caKeyFile, certFile, keyFile string) (bool, error) {
 	if !regen {
-		if exists(certFile, keyFile) {
+		if exists(certFile, keyFile) && !expiresSoon(certFile) {
 			return false, nil
 		}
 	}
@@ -642,7 +935,7 @@ func createClientCertKey(regen bool, commonName string, organization []string, a
 		return false, err
 	}
 
-	return true, certutil.WriteCert(certFile, append(certutil.EncodeCertPEM(cert), certutil.EncodeCertPEM(caCert[0])...))
+	return true, certutil.WriteCert(certFile, append(certutil.EncodeCertPEM(cert), caBytes...))
 }
 
 func exists(files ...string) bool {
@@ -670,7 +963,7 @@ func genServiceAccount(runtime *config.ControlRuntime) error {
 
 func createSigningCertKey(prefix, certFile, keyFile string) (bool, error) {
 	if exists(certFile, keyFile) {
-		return false, nil
+		return false, validateCA(certFile, keyFile)
 	}
 
 	caKeyBytes, _, err := certutil.LoadOrGenerateKeyFile(keyFile)
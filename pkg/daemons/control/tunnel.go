@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/rancher/remotedialer"
-	"github.com/rancher/wrangler/pkg/kv"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/kubernetes/cmd/kube-apiserver/app"
@@ -21,12 +20,14 @@ func setupTunnel() http.Handler {
 
 func setupProxyDialer(tunnelServer *remotedialer.Server) {
 	app.DefaultProxyDialerFn = utilnet.DialFunc(func(_ context.Context, network, address string) (net.Conn, error) {
-		_, port, _ := net.SplitHostPort(address)
+		nodeName, port, err := net.SplitHostPort(address)
+		if err != nil {
+			nodeName = address
+		}
 		addr := "127.0.0.1"
 		if port != "" {
-			addr += ":" + port
+			addr = net.JoinHostPort(addr, port)
 		}
-		nodeName, _ := kv.Split(address, ":")
 		return tunnelServer.Dial(nodeName, 15*time.Second, "tcp", addr)
 	})
 }
@@ -37,7 +38,7 @@ func authorizer(req *http.Request) (clientKey string, authed bool, err error) {
 		return "", false, nil
 	}
 
-	if user.GetName() != "node" {
+	if user.GetName() != "node" && user.GetName() != AgentUser {
 		return "", false, nil
 	}
 
@@ -0,0 +1,192 @@
+package datastore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsyncSamples writes of fsyncBlockSize are timed, which is about the
+	// size of a typical datastore transaction.
+	fsyncSamples   = 50
+	fsyncBlockSize = 2300
+	// maxFsyncLatency is the 99th percentile fsync latency recommended for
+	// etcd, the same holds for the sqlite write ahead log.
+	maxFsyncLatency = 10 * time.Millisecond
+)
+
+// unsuitableFilesystems are filesystems the datastore should not be kept on.
+var unsuitableFilesystems = map[int64]string{
+	unix.NFS_SUPER_MAGIC:       "nfs, file locking is not reliable",
+	0xff534d42:                 "cifs, file locking is not reliable",
+	0xfe534d42:                 "smb2, file locking is not reliable",
+	0x65735546:                 "fuse",
+	unix.TMPFS_MAGIC:           "tmpfs, data is lost on reboot",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlayfs",
+}
+
+// CheckDisk warns when the filesystem holding dir is not suitable for the
+// datastore, or when its fsync latency is too high.
+func CheckDisk(dir string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return err
+	}
+	if reason, ok := unsuitableFilesystems[int64(stat.Type)]; ok {
+		logrus.Warnf("Datastore directory %s is on %s", dir, reason)
+	}
+
+	latency, err := fsyncLatency(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to measure fsync latency of %s", dir)
+	}
+	if latency > maxFsyncLatency {
+		logrus.Warnf("Datastore directory %s has a 99th percentile fsync latency of %v, more than the recommended %v, consider a dedicated disk with --etcd-data-dir",
+			dir, latency, maxFsyncLatency)
+	} else {
+		logrus.Infof("Datastore directory %s has a 99th percentile fsync latency of %v", dir, latency)
+	}
+	return nil
+}
+
+func fsyncLatency(dir string) (time.Duration, error) {
+	f, err := ioutil.TempFile(dir, ".fsync-check")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	block := make([]byte, fsyncBlockSize)
+	samples := make([]time.Duration, 0, fsyncSamples)
+	for i := 0; i < fsyncSamples; i++ {
+		if _, err := f.Write(block); err != nil {
+			return 0, err
+		}
+		start := time.Now()
+		if err := unix.Fdatasync(int(f.Fd())); err != nil {
+			return 0, err
+		}
+		samples = append(samples, time.Since(start))
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)*99/100], nil
+}
+
+// Link makes dbDir a symlink to dir, so that the datastore is kept in dir.
+// A datastore already in dbDir must be moved with Relocate first.
+func Link(dbDir, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	target, err := os.Readlink(dbDir)
+	if err == nil {
+		if target == dir {
+			return nil
+		}
+		return fmt.Errorf("%s already links to %s, run datastore relocate to move it to %s", dbDir, target, dir)
+	}
+	if _, err := os.Lstat(dbDir); err == nil {
+		return fmt.Errorf("%s holds an existing datastore, run datastore relocate to move it to %s", dbDir, dir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dbDir), 0700); err != nil {
+		return err
+	}
+	return os.Symlink(dir, dbDir)
+}
+
+// Relocate copies the datastore files from dbDir to the empty directory dir,
+// which may be on another device, and links dbDir to it. The previous files
+// are kept and their location returned. The server must not be running.
+func Relocate(dbDir, dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dbDir)
+	if err != nil {
+		return "", err
+	}
+	isLink := info.Mode()&os.ModeSymlink != 0
+	src := dbDir
+	if isLink {
+		if src, err = filepath.EvalSymlinks(dbDir); err != nil {
+			return "", err
+		}
+	}
+	if src == dir {
+		return "", fmt.Errorf("datastore is already in %s", dir)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if existing, err := ioutil.ReadDir(dir); err != nil {
+		return "", err
+	} else if len(existing) > 0 {
+		return "", fmt.Errorf("%s is not empty", dir)
+	}
+
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, file.Name()), filepath.Join(dir, file.Name()), file.Mode().Perm()); err != nil {
+			return "", err
+		}
+	}
+
+	old := src
+	if isLink {
+		if err := os.Remove(dbDir); err != nil {
+			return "", err
+		}
+	} else {
+		old = fmt.Sprintf("%s.relocated-%d", dbDir, time.Now().Unix())
+		if err := os.Rename(dbDir, old); err != nil {
+			return "", err
+		}
+	}
+	return old, os.Symlink(dir, dbDir)
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
@@ -0,0 +1,143 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/ibuildthecloud/kvsql/clientv3/driver/mysql"
+	"github.com/ibuildthecloud/kvsql/clientv3/driver/pgsql"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	batchSize = 1000
+	selectSQL = "SELECT name, value, old_value, old_revision, create_revision, revision, ttl, version, del FROM key_value ORDER BY id"
+	countSQL  = "SELECT COUNT(*), COALESCE(MAX(revision), 0) FROM key_value"
+)
+
+// Migrate copies every row of the embedded sqlite datastore, including old
+// revisions, to an empty MySQL or PostgreSQL datastore and verifies that
+// both hold the same number of rows. The server must not be running.
+func Migrate(ctx context.Context, sqliteFile, endpoint string, tlsInfo *transport.TLSInfo) (int64, error) {
+	src, err := sql.Open("sqlite3", "file:"+sqliteFile+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	srcCount, srcRevision, err := count(ctx, src)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %s", sqliteFile)
+	}
+
+	dst, insertSQL, err := open(endpoint, tlsInfo)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	if n, _, err := count(ctx, dst); err != nil {
+		return 0, err
+	} else if n > 0 {
+		return 0, fmt.Errorf("target datastore already holds %d rows, refusing to migrate into it", n)
+	}
+
+	copied, err := copyRows(ctx, src, dst, insertSQL)
+	if err != nil {
+		return copied, err
+	}
+
+	dstCount, dstRevision, err := count(ctx, dst)
+	if err != nil {
+		return copied, err
+	}
+	if dstCount != srcCount || dstRevision != srcRevision {
+		return copied, fmt.Errorf("verification failed: source has %d rows up to revision %d, target has %d rows up to revision %d",
+			srcCount, srcRevision, dstCount, dstRevision)
+	}
+	return copied, nil
+}
+
+// open connects to the target datastore with the kvsql driver for its
+// scheme, which also creates the database and schema.
+func open(endpoint string, tlsInfo *transport.TLSInfo) (*sql.DB, string, error) {
+	parts := strings.SplitN(endpoint, "://", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("invalid datastore endpoint %s", endpoint)
+	}
+
+	switch parts[0] {
+	case "mysql":
+		db, err := mysql.Open(parts[1], tlsInfo)
+		return db, mysql.NewMySQL().InsertSQL, err
+	case "postgres", "postgresql":
+		db, err := pgsql.Open(parts[1], tlsInfo)
+		return db, pgsql.NewPGSQL().InsertSQL, err
+	}
+	return nil, "", fmt.Errorf("can only migrate to mysql:// or postgres:// datastores, not %s://", parts[0])
+}
+
+func copyRows(ctx context.Context, src, dst *sql.DB, insertSQL string) (int64, error) {
+	rows, err := src.QueryContext(ctx, selectSQL)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		copied int64
+		tx     *sql.Tx
+		stmt   *sql.Stmt
+	)
+	for rows.Next() {
+		var (
+			name                                                     string
+			value, oldValue                                          []byte
+			oldRevision, createRevision, revision, ttl, version, del int64
+		)
+		if err := rows.Scan(&name, &value, &oldValue, &oldRevision, &createRevision, &revision, &ttl, &version, &del); err != nil {
+			return copied, err
+		}
+
+		if tx == nil {
+			if tx, err = dst.BeginTx(ctx, nil); err != nil {
+				return copied, err
+			}
+			if stmt, err = tx.PrepareContext(ctx, insertSQL); err != nil {
+				tx.Rollback()
+				return copied, err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, name, value, oldValue, oldRevision, createRevision, revision, ttl, version, del); err != nil {
+			tx.Rollback()
+			return copied, errors.Wrapf(err, "failed to copy %s at revision %d", name, revision)
+		}
+
+		copied++
+		if copied%batchSize == 0 {
+			if err := tx.Commit(); err != nil {
+				return copied, err
+			}
+			tx = nil
+			logrus.Infof("Copied %d rows", copied)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return copied, err
+	}
+	if tx != nil {
+		return copied, tx.Commit()
+	}
+	return copied, nil
+}
+
+func count(ctx context.Context, db *sql.DB) (int64, int64, error) {
+	var n, revision int64
+	err := db.QueryRowContext(ctx, countSQL).Scan(&n, &revision)
+	return n, revision, err
+}
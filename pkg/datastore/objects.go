@@ -0,0 +1,37 @@
+package datastore
+
+import (
+	"database/sql"
+	"os"
+)
+
+const countObjectsSQL = `
+SELECT COUNT(*)
+FROM key_value kv
+  INNER JOIN
+    (
+      SELECT MAX(revision) revision, kvi.name
+      FROM key_value kvi
+      WHERE kvi.name LIKE ?
+      GROUP BY kvi.name
+    ) AS r
+    ON r.name = kv.name AND r.revision = kv.revision
+WHERE kv.del = 0`
+
+// CountObjects returns the number of live objects under a key prefix of the
+// embedded sqlite datastore. A datastore that does not exist yet holds none.
+func CountObjects(sqliteFile, prefix string) (int64, error) {
+	if _, err := os.Stat(sqliteFile); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+sqliteFile+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var n int64
+	err = db.QueryRow(countObjectsSQL, prefix+"%").Scan(&n)
+	return n, err
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	errors2 "github.com/pkg/errors"
@@ -20,24 +21,32 @@ import (
 	"github.com/rancher/wrangler/pkg/merr"
 	"github.com/rancher/wrangler/pkg/objectset"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	ns       = "kube-system"
 	startKey = "_start_"
+
+	appliedReason     = "AppliedManifest"
+	applyFailedReason = "ApplyManifestFailed"
 )
 
-func WatchFiles(ctx context.Context, apply apply.Apply, addons v1.AddonController, bases ...string) error {
-	w := &watcher{
+func WatchFiles(ctx context.Context, apply apply.Apply, addons v1.AddonController, recorder record.EventRecorder, disabled []string, pinned map[string]string, bases ...string) (*Watcher, error) {
+	w := &Watcher{
 		apply:      apply,
 		addonCache: addons.Cache(),
 		addons:     addons,
+		recorder:   recorder,
 		bases:      bases,
 	}
+	w.SetDisabled(disabled)
+	w.SetPinned(pinned)
 
 	addons.Enqueue("", startKey)
 	addons.OnChange(ctx, "addon-start", func(key string, _ *v12.Addon) (*v12.Addon, error) {
@@ -47,17 +56,43 @@ func WatchFiles(ctx context.Context, apply apply.Apply, addons v1.AddonControlle
 		return nil, nil
 	})
 
-	return nil
+	return w, nil
 }
 
-type watcher struct {
+// Watcher deploys the manifests in a set of directories.
+type Watcher struct {
+	sync.Mutex
+
 	apply      apply.Apply
 	addonCache v1.AddonCache
 	addons     v1.AddonClient
-	bases      []string
+	recorder   record.EventRecorder
+	disabled   map[string]bool
+	pinned     map[string]string
+	// held are the checksums of the manifests not deployed by holdUpgrade
+	held  map[string]string
+	bases []string
+}
+
+// SetDisabled replaces the manifests that are not deployed, disabling any
+// that are deployed already.
+func (w *Watcher) SetDisabled(disabled []string) {
+	set := map[string]bool{}
+	for _, fileName := range disabled {
+		set[fileName] = true
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.disabled = set
+}
+
+func (w *Watcher) getDisabled() map[string]bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.disabled
 }
 
-func (w *watcher) start(ctx context.Context) {
+func (w *Watcher) start(ctx context.Context) {
 	force := true
 	for {
 		if err := w.listFiles(force); err == nil {
@@ -73,7 +108,7 @@ func (w *watcher) start(ctx context.Context) {
 	}
 }
 
-func (w *watcher) listFiles(force bool) error {
+func (w *Watcher) listFiles(force bool) error {
 	var errs []error
 	for _, base := range w.bases {
 		if err := w.listFilesIn(base, force); err != nil {
@@ -81,10 +116,15 @@ func (w *watcher) listFiles(force bool) error {
 		}
 
 	}
+	for fileName := range w.getDisabled() {
+		if err := w.disable(fileName); err != nil {
+			errs = append(errs, errors2.Wrapf(err, "failed to disable %s", fileName))
+		}
+	}
 	return merr.NewErrors(errs...)
 }
 
-func (w *watcher) listFilesIn(base string, force bool) error {
+func (w *Watcher) listFilesIn(base string, force bool) error {
 	files, err := ioutil.ReadDir(base)
 	if os.IsNotExist(err) {
 		return nil
@@ -92,6 +132,7 @@ func (w *watcher) listFilesIn(base string, force bool) error {
 		return err
 	}
 
+	disabled := w.getDisabled()
 	skips := map[string]bool{}
 	for _, file := range files {
 		if strings.HasSuffix(file.Name(), ".skip") {
@@ -101,7 +142,7 @@ func (w *watcher) listFilesIn(base string, force bool) error {
 
 	var errs []error
 	for _, file := range files {
-		if skipFile(file.Name(), skips) {
+		if skipFile(file.Name(), skips) || disabled[file.Name()] {
 			continue
 		}
 		p := filepath.Join(base, file.Name())
@@ -113,7 +154,7 @@ func (w *watcher) listFilesIn(base string, force bool) error {
 	return merr.NewErrors(errs...)
 }
 
-func (w *watcher) deploy(path string, compareChecksum bool) error {
+func (w *Watcher) deploy(path string, compareChecksum bool) error {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
@@ -125,35 +166,59 @@ func (w *watcher) deploy(path string, compareChecksum bool) error {
 		return err
 	}
 
+	if enable(&addon) {
+		compareChecksum = false
+	}
+
+	objs, err := YAMLToObjects(bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+
 	checksum := checksum(content)
+	version := Version(objs)
+	if w.holdUpgrade(path, &addon, version, checksum) {
+		return nil
+	}
 	if compareChecksum && checksum == addon.Spec.Checksum {
 		logrus.Debugf("Skipping existing deployment of %s, check=%v, checksum %s=%s", path, compareChecksum, checksum, addon.Spec.Checksum)
 		return nil
 	}
 
-	objectSet, err := objectSet(content)
-	if err != nil {
-		return err
-	}
+	objectSet := objectset.NewObjectSet()
+	objectSet.Add(objs...)
 
-	if err := w.apply.WithOwner(&addon).Apply(objectSet); err != nil {
+	// objects of types no longer in the manifest are pruned too
+	if err := w.apply.WithOwner(&addon).WithCacheTypes(pruneTypes(addon.Status.GVKs)...).Apply(objectSet); err != nil {
+		w.recorder.Eventf(&addon, corev1.EventTypeWarning, applyFailedReason, "Applying manifest at %q failed: %v", path, err)
 		return err
 	}
 
+	if version != "" {
+		if addon.Annotations == nil {
+			addon.Annotations = map[string]string{}
+		}
+		addon.Annotations[VersionAnnotation] = version
+	}
 	addon.Spec.Source = path
 	addon.Spec.Checksum = checksum
-	addon.Status.GVKs = nil
+	addon.Status.GVKs = objectSet.GVKOrder()
 
+	var result *v12.Addon
 	if addon.UID == "" {
-		_, err := w.addons.Create(&addon)
+		result, err = w.addons.Create(&addon)
+	} else {
+		result, err = w.addons.Update(&addon)
+	}
+	if err != nil {
 		return err
 	}
 
-	_, err = w.addons.Update(&addon)
-	return err
+	w.recorder.Eventf(result, corev1.EventTypeNormal, appliedReason, "Applied manifest at %q", path)
+	return nil
 }
 
-func (w *watcher) addon(name string) (v12.Addon, error) {
+func (w *Watcher) addon(name string) (v12.Addon, error) {
 	addon, err := w.addonCache.Get(ns, name)
 	if errors.IsNotFound(err) {
 		addon = v12.NewAddon(ns, name, v12.Addon{})
@@ -164,7 +229,7 @@ func (w *watcher) addon(name string) (v12.Addon, error) {
 }
 
 func objectSet(content []byte) (*objectset.ObjectSet, error) {
-	objs, err := yamlToObjects(bytes.NewBuffer(content))
+	objs, err := YAMLToObjects(bytes.NewBuffer(content))
 	if err != nil {
 		return nil, err
 	}
@@ -196,7 +261,8 @@ func isEmptyYaml(yaml []byte) bool {
 	return isEmpty
 }
 
-func yamlToObjects(in io.Reader) ([]runtime.Object, error) {
+// YAMLToObjects decodes the objects in a stream of yaml documents.
+func YAMLToObjects(in io.Reader) ([]runtime.Object, error) {
 	var result []runtime.Object
 	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
 	for {
@@ -260,3 +326,13 @@ func skipFile(fileName string, skips map[string]bool) bool {
 		return true
 	}
 }
+
+// AddonRef refers to the Addon the manifest at path is applied as.
+func AddonRef(path string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: v12.SchemeGroupVersion.String(),
+		Kind:       "Addon",
+		Namespace:  ns,
+		Name:       name(path),
+	}
+}
@@ -0,0 +1,145 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	v12 "github.com/rancher/k3s/pkg/apis/k3s.cattle.io/v1"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/objectset"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DisabledAnnotation is set on the addon of a packaged component that has been disabled
+	DisabledAnnotation = "k3s.cattle.io/disabled"
+	// ConfirmRemovalAnnotation must be set to "true" by the operator before the
+	// resources of a disabled component are removed
+	ConfirmRemovalAnnotation = "k3s.cattle.io/confirm-removal"
+
+	removalPendingReason = "RemovalPending"
+	removedReason        = "Removed"
+)
+
+// disable handles a packaged component that is no longer enabled. Its addon is marked
+// as disabled and left alone until removal is confirmed, at which point everything
+// applied for it is pruned. Pruning a HelmChart lets the helm controller uninstall the
+// release and clear its finalizer.
+func (w *Watcher) disable(fileName string) error {
+	name := name(fileName)
+	addon, err := w.addonCache.Get(ns, name)
+	if errors.IsNotFound(err) {
+		// manifests of operators are only removed once removal of their
+		// addon is confirmed
+		if _, err := Asset(fileName); err != nil {
+			return nil
+		}
+		return w.removeManifest(fileName)
+	} else if err != nil {
+		return err
+	}
+
+	if addon.Annotations[ConfirmRemovalAnnotation] != "true" {
+		if addon.Annotations[DisabledAnnotation] == "true" {
+			return nil
+		}
+		addon = addon.DeepCopy()
+		if addon.Annotations == nil {
+			addon.Annotations = map[string]string{}
+		}
+		addon.Annotations[DisabledAnnotation] = "true"
+		if addon, err = w.addons.Update(addon); err != nil {
+			return err
+		}
+		logrus.Infof("Addon %s is disabled, annotate it with %s=true to remove its resources", name, ConfirmRemovalAnnotation)
+		w.recorder.Eventf(addon, corev1.EventTypeWarning, removalPendingReason,
+			"Component is disabled, annotate with %s=true to remove its resources", ConfirmRemovalAnnotation)
+		return nil
+	}
+
+	gvks, err := w.appliedGVKs(addon)
+	if err != nil {
+		return err
+	}
+	if err := w.apply.WithOwner(addon).WithCacheTypes(pruneTypes(gvks)...).Apply(objectset.NewObjectSet()); err != nil {
+		return err
+	}
+	w.recorder.Eventf(addon, corev1.EventTypeNormal, removedReason, "Removed resources of disabled component")
+	if err := w.addons.Delete(ns, name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	logrus.Infof("Removed disabled addon %s", name)
+
+	return w.removeManifest(fileName)
+}
+
+// appliedGVKs returns the types of the objects applied for an addon. Addons
+// applied before the types were recorded fall back to their manifest.
+func (w *Watcher) appliedGVKs(addon *v12.Addon) ([]schema.GroupVersionKind, error) {
+	if len(addon.Status.GVKs) > 0 {
+		return addon.Status.GVKs, nil
+	}
+	if addon.Spec.Source == "" {
+		return nil, fmt.Errorf("addon %s does not record what was applied", addon.Name)
+	}
+	content, err := ioutil.ReadFile(addon.Spec.Source)
+	if err != nil {
+		return nil, fmt.Errorf("addon %s does not record what was applied, and its manifest can not be read: %v", addon.Name, err)
+	}
+	objs, err := objectSet(content)
+	if err != nil {
+		return nil, err
+	}
+	return objs.GVKOrder(), nil
+}
+
+// pruneType makes apply prune a type it has no informer for, listing the
+// objects from the apiserver instead.
+type pruneType schema.GroupVersionKind
+
+func pruneTypes(gvks []schema.GroupVersionKind) []apply.InformerGetter {
+	var types []apply.InformerGetter
+	for _, gvk := range gvks {
+		types = append(types, pruneType(gvk))
+	}
+	return types
+}
+
+func (t pruneType) Informer() cache.SharedIndexInformer {
+	return nil
+}
+
+func (t pruneType) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind(t)
+}
+
+func (w *Watcher) removeManifest(fileName string) error {
+	for _, base := range w.bases {
+		if err := os.Remove(filepath.Join(base, fileName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// enable clears the disabled marker from an addon whose component has been re-enabled.
+func enable(addon *v12.Addon) bool {
+	if _, ok := addon.Annotations[DisabledAnnotation]; !ok {
+		return false
+	}
+	annotations := map[string]string{}
+	for k, v := range addon.Annotations {
+		if k != DisabledAnnotation && k != ConfirmRemovalAnnotation {
+			annotations[k] = v
+		}
+	}
+	addon.Annotations = annotations
+	return true
+}
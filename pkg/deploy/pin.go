@@ -0,0 +1,119 @@
+package deploy
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/distribution/reference"
+	v12 "github.com/rancher/k3s/pkg/apis/k3s.cattle.io/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// SkipUpgradeAnnotation set to "true" on an addon keeps it as deployed,
+	// changes to its manifest, such as on k3s upgrades, are not applied
+	SkipUpgradeAnnotation = "k3s.cattle.io/skip-upgrade"
+	// VersionAnnotation is set on an addon to the version of its manifest
+	VersionAnnotation = "k3s.cattle.io/version"
+
+	upgradeSkippedReason = "UpgradeSkipped"
+)
+
+var (
+	// chartFileVersion matches the version in the file name of a chart URL
+	chartFileVersion = regexp.MustCompile(`/[\w.-]+?-(\d[\w.+-]*)\.tgz$`)
+	// containerPaths lead to the containers of the workloads in manifests
+	containerPaths = [][]string{
+		{"spec", "template", "spec", "containers"},
+		{"spec", "jobTemplate", "spec", "template", "spec", "containers"},
+		{"spec", "containers"},
+	}
+)
+
+// SetPinned replaces the versions addons are pinned to. A pinned addon is only
+// deployed from a manifest of that version.
+func (w *Watcher) SetPinned(pinned map[string]string) {
+	w.Lock()
+	defer w.Unlock()
+	w.pinned = pinned
+}
+
+func (w *Watcher) getPinned() map[string]string {
+	w.Lock()
+	defer w.Unlock()
+	return w.pinned
+}
+
+// Version returns the version of the objects of a manifest, the chart version
+// of a HelmChart, otherwise the tag of the first container image.
+func Version(objs []runtime.Object) string {
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetKind() != "HelmChart" {
+			continue
+		}
+		if version, _, _ := unstructured.NestedString(u.Object, "spec", "version"); version != "" {
+			return version
+		}
+		chart, _, _ := unstructured.NestedString(u.Object, "spec", "chart")
+		if m := chartFileVersion.FindStringSubmatch(chart); m != nil {
+			return m[1]
+		}
+	}
+
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		for _, path := range containerPaths {
+			containers, _, _ := unstructured.NestedSlice(u.Object, path...)
+			for _, container := range containers {
+				image, _, _ := unstructured.NestedString(container.(map[string]interface{}), "image")
+				ref, err := reference.ParseNormalizedNamed(image)
+				if err != nil {
+					continue
+				}
+				if tagged, ok := ref.(reference.Tagged); ok {
+					return tagged.Tag()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// holdUpgrade returns whether a changed manifest is not applied, because the
+// deployed addon is pinned to another version or its upgrades are skipped.
+// Addons that are not deployed yet are deployed whatever their version. Each
+// change held back is reported once.
+func (w *Watcher) holdUpgrade(path string, addon *v12.Addon, version, checksum string) bool {
+	var reason string
+	if pin, ok := w.getPinned()[addon.Name]; ok && addon.UID != "" && version != pin {
+		reason = fmt.Sprintf("the manifest at %q is version %s, the addon is pinned to %s", path, version, pin)
+	} else if addon.UID != "" && addon.Spec.Checksum != checksum && addon.Annotations[SkipUpgradeAnnotation] == "true" {
+		reason = fmt.Sprintf("the manifest at %q changed, the addon is annotated with %s", path, SkipUpgradeAnnotation)
+	} else {
+		return false
+	}
+
+	w.Lock()
+	reported := w.held[path] == checksum
+	if w.held == nil {
+		w.held = map[string]string{}
+	}
+	w.held[path] = checksum
+	w.Unlock()
+	if reported {
+		return true
+	}
+
+	logrus.Warnf("Not deploying addon %s, %s", addon.Name, reason)
+	if addon.UID != "" {
+		w.recorder.Eventf(addon, corev1.EventTypeWarning, upgradeSkippedReason, "Not deploying, %s", reason)
+	}
+	return true
+}
@@ -5,11 +5,24 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/images"
 	"github.com/sirupsen/logrus"
 )
 
+// imageLine matches the image of a container in a manifest, unquoted as the
+// packaged manifests write them.
+var imageLine = regexp.MustCompile(`(?m)^(\s*-?\s*image:\s*)([^"'\s#]+)([ \t]*)$`)
+
+// obsoleteAssets are manifests earlier releases packaged, Stage removes them so
+// that they are no longer deployed.
+var obsoleteAssets = []string{
+	// RuntimeClasses are created once a node has the runtime, see node.RegisterRuntimeClasses
+	"runtimes.yaml",
+}
+
 func Stage(dataDir string, templateVars map[string]string, skipList []string) error {
 	os.MkdirAll(dataDir, 0700)
 
@@ -18,6 +31,15 @@ func Stage(dataDir string, templateVars map[string]string, skipList []string) er
 		skips[skip] = true
 	}
 
+	for _, name := range obsoleteAssets {
+		p := filepath.Join(dataDir, name)
+		if err := os.Remove(p); err == nil {
+			logrus.Info("Removed obsolete manifest: ", p)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
 	for _, name := range AssetNames() {
 		if skips[name] {
 			continue
@@ -29,6 +51,7 @@ func Stage(dataDir string, templateVars map[string]string, skipList []string) er
 		for k, v := range templateVars {
 			content = bytes.Replace(content, []byte(k), []byte(v), -1)
 		}
+		content = pinImages(content)
 		p := filepath.Join(dataDir, name)
 		logrus.Info("Writing manifest: ", p)
 		if err := ioutil.WriteFile(p, content, 0600); err != nil {
@@ -38,3 +61,34 @@ func Stage(dataDir string, templateVars map[string]string, skipList []string) er
 
 	return nil
 }
+
+// pinImages pins the container images of a packaged manifest to the digests
+// recorded at build time. Images a chart composes from its values are not
+// in the manifest and are left to the chart.
+func pinImages(content []byte) []byte {
+	return imageLine.ReplaceAllFunc(content, func(line []byte) []byte {
+		m := imageLine.FindSubmatch(line)
+		ref := string(m[2])
+		pinned := images.Pin(ref)
+		if pinned == ref {
+			return line
+		}
+		return append(append(append([]byte{}, m[1]...), pinned...), m[3]...)
+	})
+}
+
+// Staged returns the paths of the packaged manifests Stage writes to dataDir.
+func Staged(dataDir string, skipList []string) []string {
+	skips := map[string]bool{}
+	for _, skip := range skipList {
+		skips[skip] = true
+	}
+
+	var paths []string
+	for _, name := range AssetNames() {
+		if !skips[name] {
+			paths = append(paths, filepath.Join(dataDir, name))
+		}
+	}
+	return paths
+}
@@ -1,6 +1,7 @@
 // Code generated by go-bindata.
 // sources:
 // manifests/coredns.yaml
+// manifests/multus.yaml
 // manifests/rolebindings.yaml
 // manifests/traefik.yaml
 // DO NOT EDIT!
@@ -70,7 +71,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _corednsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\x56\xcd\x6e\x1b\x37\x10\xbe\xeb\x29\x88\x2d\x72\xeb\xca\x12\x8c\xa4\x2e\x6f\x89\xe4\x26\x06\x62\x45\xb0\xec\x5c\x8a\x22\xa0\xb8\x23\x89\x35\x97\xc3\x92\xb3\x8a\xd5\xd4\xef\x5e\x70\xff\x44\xca\xeb\x20\x09\xb2\x27\x2e\x87\xf3\xcd\x70\x7e\xbe\xa1\xb0\xea\x23\x38\xaf\xd0\x70\xb6\x9f\x8e\xee\x95\x29\x38\x5b\x81\xdb\x2b\x09\xaf\xa5\xc4\xca\xd0\xa8\x04\x12\x85\x20\xc1\x47\x8c\x19\x51\x02\x67\x12\x1d\x14\xc6\xb7\xff\xde\x0a\x09\x9c\xdd\x57\x6b\xc8\xfd\xc1\x13\x94\xa3\x3c\xcf\x47\x31\xb4\x5b\x0b\x39\x16\x15\xed\xd0\xa9\x7f\x05\x29\x34\xe3\xfb\x0b\x3f\x56\x78\xb6\x9f\xae\x81\x44\x67\x79\xa6\x2b\x4f\xe0\x6e\x50\x43\x62\x56\x8b\x35\x68\x1f\x56\xac\xb6\xe3\x0c\x10\xd4\xfa\x6b\x44\xf2\xe4\x84\xb5\xca\x6c\x1b\x43\x79\x01\x1b\x51\x69\xf2\xbd\xbf\x8d\x57\xbc\x73\xdb\x55\x1a\x3c\x1f\xe5\x4c\x58\xf5\xd6\x61\x65\x6b\xe4\x9c\x65\xd9\x88\x31\x07\x1e\x2b\x27\xa1\xdd\x03\x53\x58\x54\xa6\x06\xcb\x99\x6f\x22\xd3\xfc\x58\x2c\x9a\x45\x1f\x84\xf0\xbb\x07\xb7\x6e\x75\xb5\xf2\x54\x2f\x3e\x0b\x92\xbb\x6f\xb3\x67\xb0\x38\x85\xd9\x02\xfd\x8c\x80\xbe\x51\xa6\x50\x66\x9b\xc4\x55\x18\x83\x54\xab\xb7\xc1\x1d\xc2\x4d\xe2\x2d\x2a\xc2\xca\x16\x82\x80\xb3\x8c\x5c\x05\xd9\xcf\x4f\x0f\x6a\xb8\x81\x4d\xed\x5f\x1b\xb0\xaf\x5c\x78\xc4\xd8\xd3\xda\x79\x06\xd9\x57\xeb\xbf\x41\x52\x9d\xfb\xc1\x52\xff\xe1\x02\xef\x7b\x67\x86\x66\xa3\xb6\xd7\xc2\xfe\x48\xdb\x74\xc7\x67\xe8\x60\xa3\x34\x70\xf6\x5f\x1d\xd3\x31\x7f\x79\xce\xbe\xd4\xcb\xf0\x81\x73\xe8\x7c\xff\xbb\x03\xa1\x69\xd7\xff\x1e\x13\xc0\x5e\x7c\x99\xbd\xbf\x5b\xdd\x5e\xde\x7c\x9a\x7f\xb8\x7e\x7d\xb5\x78\x7c\xc1\x94\xc9\x45\x51\xb8\xb1\x70\x56\x30\x65\x5f\x35\x8b\x23\x36\xab\xcb\x9a\x29\xe3\x41\x56\x0e\xa2\xfd\xca\x7a\x72\x20\xca\x68\x6b\x23\xb4\xa6\x9d\xc3\x6a\xbb\x1b\x06\xee\xcf\x3e\x1e\xbd\x45\x4f\x9e\x9d\x01\xc9\xb3\x36\x1e\x67\x0b\x2c\xe0\x5d\xbd\x1d\xfb\xe1\x40\xa3\x28\xd8\xd4\x0f\x1b\x1c\x80\xb6\x0e\x4b\xa0\x1d\x54\x9e\xf1\xdf\xa7\x2f\xcf\x63\xc1\xc3\x81\x8d\x1b\xab\xa1\xe1\xf4\x7e\x2c\xd1\x6c\xfa\x03\x52\xc8\x1d\xb0\xf3\x49\xbf\xa1\x11\xed\x28\xf5\x24\x92\x89\x62\x2d\xb4\x30\xb2\x09\xcf\xe3\x93\x6a\x80\x07\x02\x13\x96\xfe\xa4\x1d\xe7\x60\x35\x1e\x4a\xf8\x31\x56\x3d\x69\xb4\x0b\x9f\x0b\x6b\xdb\x23\x8d\xe2\x69\xfb\x35\xc0\x59\xa8\xa7\xf9\x62\x95\x8d\xbc\x05\x19\xb4\x7f\x71\x60\xb5\x92\xc2\x73\x36\x1d\x31\x16\x3a\x94\x60\x7b\x68\x80\xe9\x60\x81\xb3\x1b\xd4\x5a\x99\xed\x5d\xdd\xeb\x0d\x37\xc4\x3b\xbc\x0d\x47\x29\x1e\xee\x8c\xd8\x0b\xa5\xc5\x3a\x14\x6c\x0d\x07\x1a\x24\xa1\x6b\xce\x94\x81\xfc\xde\x47\x8e\x0f\xbb\x4e\x50\x5a\xdd\x03\xc7\xd1\xa9\x63\x9e\xe8\x3f\x77\xf9\xee\x7a\xf5\x3a\xe9\xec\xc5\x49\x84\xeb\x7b\xa2\x06\x17\x93\x5f\xf8\x72\x76\x0f\x87\x10\x32\xa7\x48\x49\xa1\x5f\x17\x05\x1a\xff\xc1\xe8\x43\x16\xd5\x21\xda\xa0\x89\x8e\xb3\xec\xf2\x41\x79\xf2\x9d\x30\xd0\xf7\x2a\xb9\x7e\xf8\x42\x09\x9c\xf0\x28\x7a\xce\xb4\x32\xd5\x43\x7b\x48\xa2\x21\xa1\x0c\xb8\xde\x97\xfc\x49\x59\x34\x9f\x2a\xc5\xf6\xb8\xdd\x35\x11\x9f\x8e\xcf\xc7\x93\xf4\xd0\xb2\xd2\x7a\x89\x5a\xc9\x03\x67\x57\x9b\x05\xd2\xd2\x81\x87\x9a\xe6\xba\xc2\x8e\x66\x4f\x5f\xde\xaa\x54\x94\xec\x84\x74\x94\xe8\x0e\x9c\x4d\x7f\x9b\x5c\xab\xa4\x47\xff\xa9\xc0\x9f\x9e\x96\xb6\xe2\x6c\x3a\x99\x94\x83\x18\x09\x84\x70\x5b\xcf\xd9\x9f\x2c\xcb\x43\x3f\x66\xbf\xb2\x2c\x61\x86\x8e\x08\x33\xf6\x57\xaf\xb2\x47\x5d\x95\x70\x1d\xb2\x9a\xe4\xad\x8b\x56\xe0\xdf\xbc\x39\x14\xd9\x2f\xc3\xf9\xa5\xa0\x1d\x4f\xb8\x27\xb9\x8b\x28\x42\x9e\x39\x0b\x63\xed\xc8\x1d\xe8\x52\x3b\x7d\xa6\x96\xe8\x88\xb3\x88\x66\xba\x46\x4e\x71\xad\x43\x42\x89\x9a\xb3\xbb\xf9\xf2\x7b\x71\x72\x92\x76\x10\xeb\x76\xf6\x15\xac\x84\xfc\x3a\xb4\x12\xc8\x29\x39\xec\x59\x8c\x56\x13\xbf\xa2\xc3\x0c\x0d\xc1\x03\xc5\xa9\x15\x5a\xe3\xe7\xa5\x53\x7b\xa5\x61\x0b\x97\x5e\x0a\x5d\xf7\x0f\x0f\xc4\xec\xe3\x70\x4b\x61\xc5\x5a\x69\x45\x0a\x4e\x8a\x43\x14\x45\xba\x91\xb3\xc5\xe5\xed\xa7\x37\x57\x8b\xf9\xa7\xd5\xe5\xcd\xc7\xab\xd9\x65\x22\x2e\x1c\xda\x53\x05\xa1\xf5\x40\xe2\x6e\x10\xe9\x0f\xa5\xa1\x1d\xfa\x69\x1a\xb5\xda\x83\x01\xef\x97\x0e\xd7\x10\xe3\xed\x88\xec\x5b\xa0\xd4\x84\x6d\x0a\xe5\x64\xb2\xb2\xb6\x1c\x38\xbb\x98\x5c\x4c\x92\x6d\x2f\x77\x10\x82\xfc\xee\xf6\x76\x19\x09\x94\x51\xa4\x84\x9e\x83\x16\x87\x15\x48\x34\x85\xe7\xec\x55\xac\x4a\xaa\x04\xac\xa8\x17\xbe\x8c\x64\xbe\x92\x12\xbc\xbf\xdd\x39\xf0\x3b\xd4\x45\xc3\xae\xdd\xb7\x11\x4a\x57\x0e\x22\x69\xa7\x5b\x18\xdf\xb5\xfd\xbc\x79\x6b\xb5\x82\xa6\x2b\xbe\xa3\x6b\x64\xf7\x9a\x49\xc3\x33\x4c\x4c\xf5\x85\x09\x4a\x7f\x9a\xae\x9a\x51\xbb\x56\x4e\x64\x5d\xa4\x07\x85\xad\x62\xff\x3a\x18\xd4\x3c\x4a\x9f\x7d\x92\xb5\x6f\xbc\x81\x89\x1b\x0d\x8f\x67\x47\xee\x93\x27\xf2\xf1\x95\x11\x78\xbc\xa9\x87\x2c\x74\x5c\x36\x20\xf6\xd2\x09\xfb\xec\x53\xf9\x1b\x26\xb8\x6c\x5e\xb5\x79\x3b\xce\x22\xa4\x6f\x9d\xf5\xe9\x34\x1e\xb2\xd9\xda\xb8\x5a\xf2\xf8\xc5\xb8\x58\x3d\xbe\x18\x45\xfc\x97\x9f\xb0\x9b\x8d\x69\xeb\x94\xe4\xf2\x01\x0a\x7b\x46\xa1\xe1\x9e\x7c\x80\xa5\x6c\x4a\x66\xa9\xca\xff\x01\x00\x00\xff\xff\x51\xb6\xd2\x56\xba\x0e\x00\x00")
+var _corednsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\x56\xdf\x6f\xdb\xb8\x0f\x7f\xcf\x5f\x41\xf8\x8b\xbe\x7d\x9d\x36\x28\xba\xeb\xf4\xd6\x25\xd9\x56\x60\xcd\x82\x24\x1d\x30\x1c\x0e\x85\x22\x33\xb1\xae\xb2\xa8\x93\xe4\xac\xb9\x5d\xff\xf7\x83\xfc\x2b\x76\xea\xec\xb6\x61\x51\x1e\x2c\x91\xfc\x48\xa2\xc8\x0f\xc9\x8d\xfc\x84\xd6\x49\xd2\x0c\x76\xa3\xc1\xa3\xd4\x09\x83\x25\xda\x9d\x14\x78\x23\x04\xe5\xda\x0f\x32\xf4\x3c\xe1\x9e\xb3\x01\x80\xe6\x19\x32\x10\x64\x31\xd1\xae\x9a\x3b\xc3\x05\x32\x78\xcc\xd7\x18\xbb\xbd\xf3\x98\x0d\xe2\x38\x1e\xb4\xa1\xed\x9a\x8b\x21\xcf\x7d\x4a\x56\xfe\xcd\xbd\x24\x3d\x7c\xbc\x76\x43\x49\xe7\xbb\xd1\x1a\x3d\xaf\x77\x1e\xab\xdc\x79\xb4\x0b\x52\xd8\xd9\x56\xf1\x35\x2a\x17\x0e\x00\xc5\x3e\x56\xa3\xc7\xc2\x7e\x4d\xe4\x9d\xb7\xdc\x18\xa9\xb7\xe5\x46\x71\x82\x1b\x9e\x2b\x5f\x9f\x8f\x41\x79\x2a\x56\x1f\xdb\xe6\x0a\x1d\x1b\xc4\xc0\x8d\x7c\x67\x29\x37\x05\x72\x0c\x51\x34\x00\xb0\xe8\x28\xb7\x02\xab\x35\xd4\x89\x21\xa9\x0b\xb0\x18\x5c\xe9\x99\x72\x62\x28\x29\x3f\x1a\x27\x84\xe9\x0e\xed\xba\xb2\x55\xd2\xf9\xe2\xe3\x0b\xf7\x22\xfd\xbe\xfd\x34\x25\xc7\x30\x5b\xf4\xbf\xc2\xa1\x6f\xa4\x4e\xa4\xde\x76\xfc\xca\xb5\x26\x5f\x98\x57\xce\xed\xc3\xed\xf8\x9b\xe7\x9e\x72\x93\x70\x8f\x0c\x22\x6f\x73\x8c\x7e\xfd\xf3\x90\xc2\x05\x6e\x02\x5c\xed\xb0\x6f\x5c\x78\x00\xf0\x32\x76\x4e\x20\xbb\x7c\xfd\x27\x0a\x5f\xbc\x7d\x6f\xa8\xd7\x76\x3f\x1c\xe0\x4d\xee\x8c\x49\x6f\xe4\xf6\x8e\x9b\x9f\x49\x9b\x5a\x7d\x4c\x16\x37\x52\x21\x83\x7f\x8a\x57\x19\xb2\xab\x4b\xf8\x5a\x7c\x86\x3f\x5a\x4b\xd6\x35\xd3\x14\xb9\xf2\x69\x33\x3d\x3c\x00\x9c\x7d\x1d\x7f\xb8\x5f\xae\xa6\x8b\x87\xc9\xc7\xbb\x9b\xdb\xd9\xf3\x19\x48\x1d\xf3\x24\xb1\x43\x6e\x0d\x07\x69\x5e\x95\x1f\x07\x6c\x28\xc2\x1a\xa4\x76\x28\x72\x8b\xad\xf5\xdc\x38\x6f\x91\x67\xad\xa5\x0d\x57\xca\xa7\x96\xf2\x6d\xda\x0f\xdc\xe8\x3e\x37\x5f\x29\x39\xef\xe0\x1c\xbd\x38\xaf\xfc\x71\x3e\xa3\x04\xdf\x17\xcb\xed\x73\x58\x54\xc4\x13\x18\xb9\xfe\x0d\x7b\xa0\x8d\xa5\x0c\x7d\x8a\xb9\x03\xf6\x7a\x74\x75\xd9\x16\x3c\xed\x61\x58\xee\x1a\x12\x5c\xed\x86\x82\xf4\xa6\x51\x10\x5c\xa4\x08\x97\x17\xcd\x82\x22\x32\xcd\xa4\x3c\x49\x4b\xc6\x93\x35\x57\x5c\x8b\xd2\x3d\xcf\x2f\xa2\x01\x9f\x3c\xea\xc0\x7c\xee\x28\x1d\x27\x68\x14\xed\x33\xfc\x39\x56\x3d\x4a\xb4\x6b\x17\x73\x63\x2a\x95\x32\x5a\x8f\xd3\x2f\x44\x2f\x83\x28\xc4\xd3\x64\xb6\x8c\x06\xce\xa0\x08\xd6\xff\xb3\x68\x94\x14\xdc\x31\x18\x0d\x00\x42\x86\x7a\xdc\xee\x83\x08\xc0\xef\x0d\x32\x58\x90\x52\x52\x6f\xef\x8b\x5c\x2f\xd6\x6d\x7b\x85\x55\xee\xc8\xf8\xd3\xbd\xe6\x3b\x2e\x15\x5f\x87\x80\x2d\xe0\x50\xa1\xf0\x64\x4b\x9d\x2c\x90\xdf\x87\xd6\xc1\xfb\x8f\xee\x31\x33\xaa\x01\x6e\x7b\x07\xa0\x7b\xf1\xd3\x97\xaf\xaf\x17\x86\xeb\x64\xf6\xec\xc8\xc3\x41\xc3\x93\x42\xdb\x26\xbf\x30\x62\x78\xc4\x7d\x70\x99\x95\x5e\x0a\xae\x6e\x92\x84\xb4\xfb\xa8\xd5\x3e\x6a\x74\x00\xc8\x04\x4b\xb2\x0c\xa2\xe9\x93\x74\xde\xd5\xc2\x40\xdf\xcb\xce\xf5\xc3\x3f\x94\xb8\x23\x1e\x25\xc7\x40\x49\x9d\x3f\x55\x4a\x82\xb4\xe7\x52\xa3\x6d\xce\x12\xbf\x08\x8b\x72\xc8\x8c\x6f\x91\xc1\xd9\xd7\xe5\xe7\xe5\x6a\x7a\xf7\x30\x99\xbe\xbd\xb9\xff\xb0\x7a\x58\x4c\xdf\xdd\x2e\x57\x8b\xcf\xcf\x67\x95\x45\x9d\x5f\x6c\x34\xbc\x1c\x1e\x42\xbb\xb0\x9f\xe7\x4a\xcd\x49\x49\xb1\x67\x70\xbb\x99\x91\x9f\x5b\x74\x21\x2c\x6b\xad\x4e\x59\xaa\x87\x92\x99\xf4\x9d\x15\x80\x0c\x33\xb2\x7b\x06\xa3\xdf\x2e\xee\x64\x4b\x62\xf1\xaf\x1c\xdd\xb1\xb6\x30\x39\x83\xd1\xc5\x45\xd6\x8b\xd1\x81\xe0\x76\xeb\x18\xfc\x0e\x51\x1c\x52\x35\xfa\x3f\x44\x1d\xd2\xa8\x39\x32\x82\x3f\x1a\x93\x1d\xa9\x3c\xc3\xbb\xf0\xe0\xad\x7d\x0f\x8e\x0c\xd4\x1c\x97\x4a\x8d\x14\x20\x0b\xfa\x73\xee\x53\xd6\xa1\xa5\x96\x86\x45\x9e\x84\x10\x60\x10\x2a\x5e\x23\x30\x64\xbb\xfb\x34\x8f\x38\x27\xeb\x19\xb4\x18\xa8\xce\xf1\x2e\xae\xb1\xe4\x49\x90\x62\x70\x3f\x99\xff\x28\x4e\xec\x85\xe9\xc5\x5a\x8d\xbf\x81\xf5\x7a\xd4\x83\x96\xa1\xb7\x52\xb8\xff\x44\x2b\x6a\x82\xf4\xfb\x31\x69\x8f\x4f\xfe\x70\x75\x00\xae\x14\x7d\x99\x5b\xb9\x93\x0a\xb7\x38\x75\x82\xab\x22\xb5\x18\x6c\xb8\x72\x6d\x77\x0b\x6e\xf8\x5a\x2a\xe9\x65\x37\xb8\x00\x78\x92\x74\x17\x62\x98\x4d\x57\x0f\x6f\x6e\x67\x93\x87\xe5\x74\xf1\xe9\x76\x3c\xed\x88\x13\x4b\xe6\xd8\x80\x2b\xd5\xf3\x70\x0b\x22\xff\x56\x2a\xac\xfa\x81\xee\x33\x2a\xb9\x43\x8d\xce\xcd\x2d\xad\x1b\x5e\x0b\xff\xd4\x7b\xf3\x0e\x3b\xd7\x04\x30\x65\xa0\x1c\x15\xdd\x3a\x1c\x18\x5c\x5f\x5c\x1f\x72\x2d\x0c\x27\x52\x0c\x4f\xff\x7e\xb5\x3a\x78\x12\x40\x6a\xe9\x25\x57\x13\x54\x7c\xbf\x44\x41\x3a\x71\x0c\x5e\xb5\x4d\xbd\xcc\x90\x72\xdf\x08\xaf\x5a\x32\x97\x0b\x81\xce\xad\x52\x8b\x2e\x25\x95\x94\xc4\x5b\xff\x36\x5c\xaa\xdc\x62\x4b\x5a\xdb\x26\xda\xd5\x69\x3f\x29\xbb\xe4\x4a\x50\x66\xc5\x0f\x64\x8d\xa8\x1b\x9d\xae\x7b\xfa\x39\x2b\x0c\xe9\x31\x3b\x7a\xf0\x8a\x6c\xeb\x54\xee\xc8\x6a\x4f\xf7\x0a\x2b\xc3\xa6\x71\xe8\xb5\x3c\x48\x4f\x76\x6b\x55\xfb\xd7\x53\x8c\x5b\x75\xe5\x64\x35\x7e\xd1\x3d\x1f\x1a\x90\x50\x7c\xcb\x78\x88\x5e\x8f\xae\x2e\xa3\x1e\xb1\x13\x96\x9b\x93\x5d\xf4\x77\x14\x77\x51\xf6\xf6\x71\x55\xe9\x5a\x48\xdf\xdb\x06\xb8\x4e\xa5\xea\xdb\xb3\xda\xe3\x76\xce\xda\xcd\xe4\x6c\xf9\x7c\x36\x68\xf1\x5f\x1d\x2b\xf5\x39\x4d\x9b\xb6\x0e\x54\x52\x92\x5c\xdc\x43\x61\x27\x0c\x56\xe3\xb6\x41\x9b\xa5\x4c\x97\xcc\xba\x26\xff\x0e\x00\xfc\x86\xc3\x5e\xd5\x0e\x00\x00")
 
 func corednsYamlBytes() ([]byte, error) {
 	return bindataRead(
@@ -90,7 +91,27 @@ func corednsYaml() (*asset, error) {
 	return a, nil
 }
 
-var _rolebindingsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\xcf\xbd\x0a\xc2\x40\x10\x04\xe0\xfe\x9e\xe2\x5e\xe0\x22\x76\x72\xa5\x16\xf6\x01\xed\x37\xb9\x55\xd7\xdc\x1f\xbb\x7b\x01\x7d\x7a\x09\x48\x1a\x51\xb0\x1c\x18\xe6\x63\xa0\xd2\x19\x59\xa8\x64\x6f\x79\x80\xb1\x83\xa6\xb7\xc2\xf4\x04\xa5\x92\xbb\x69\x27\x1d\x95\xcd\xbc\x35\x13\xe5\xe0\xed\x21\x36\x51\xe4\xbe\x44\xdc\x53\x0e\x94\xaf\x26\xa1\x42\x00\x05\x6f\xac\xcd\x90\xd0\xdb\xa9\x0d\xe8\xa0\x92\x20\xcf\xc8\x6e\x89\x11\xd5\x41\x48\x94\x0d\x97\x88\x3d\x5e\x96\x36\x54\x3a\x72\x69\xf5\x87\x6c\xac\xfd\x80\x57\x47\x1e\xa2\x98\xfc\xba\x5f\xe9\x6d\x48\x1b\xee\x38\xaa\x78\xe3\xfe\x42\x4e\x82\xfc\xe5\x85\x79\x05\x00\x00\xff\xff\x54\xf2\x55\xe2\x29\x01\x00\x00")
+var _multusYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x9c\x92\x4f\x6f\xdb\x3c\x0c\xc6\xef\xfa\x14\x44\x7a\xad\xfd\xa2\xe8\xe5\x85\x6f\xdd\x06\x0c\x3b\xac\x18\x32\xa0\x97\xa1\x07\x59\x62\x6c\x2e\xb2\x68\x88\x54\xba\xee\xd3\x0f\x92\xeb\xf4\xcf\xba\x66\x18\x74\x08\x22\xfd\xf8\x90\x7c\xfc\xd8\x99\x6e\x30\x09\x71\xec\xc0\xce\x84\x3f\x14\x63\xf9\x27\xed\xfe\x7f\x69\x89\xff\x3b\x5c\xf4\xa8\xf6\xc2\xec\x29\xfa\x0e\xde\x67\x51\x9e\xb6\x28\x9c\x93\xc3\x0f\xb8\xa3\x48\x4a\x1c\xcd\x84\x6a\xbd\x55\xdb\x19\x80\x68\x27\xec\x20\xa2\xde\x71\xda\x37\x56\xd5\xba\x71\xc2\xa8\x8d\x3f\xf2\x8b\xbc\x8b\xd4\xba\xe8\x76\x2d\xb1\x91\x19\x5d\x29\x1e\x12\xe7\xb9\x83\x97\xcf\x00\x87\x75\xcc\xc3\x85\x01\x10\xc7\x33\x76\x70\x6d\x27\x94\xd9\x3a\xf4\x0f\x7d\xa5\x68\x00\xcc\x21\x27\x1b\x4e\x0d\x51\x51\xa1\x38\xe4\x60\xd3\x09\xb8\xb2\x8b\x09\xd7\xcb\x66\x57\x47\xec\x89\x0f\x85\x92\x91\x93\x5e\x3f\x0e\xd3\x14\xe5\x07\xd5\xa2\x68\x00\x0e\x36\x90\xb7\xa5\x62\x99\x97\x67\x8c\x57\x5f\x3e\xdd\x5c\x7e\x75\x23\x4e\xd5\xc5\x72\x3d\x27\x9e\x31\x29\xad\x4a\xe5\xac\x46\x01\xfc\x99\x29\xc7\x71\xdc\xd1\xf0\xfc\x0e\x40\xef\x8b\x6d\xa2\x89\xe2\x60\x9a\xa6\x31\x67\xf0\x39\x07\xcd\x02\x29\x47\x81\x3b\xd2\x11\x74\x44\xd8\xe7\x1e\x03\x2a\xb8\x84\x1e\xa3\x92\x0d\x72\x5e\x1f\x22\x7b\x04\x9b\x75\xe4\x44\x3f\x31\x41\xa0\x89\x54\x40\x47\x16\x04\x65\x73\x56\xa9\x99\xbd\x00\xef\x8e\x15\x2d\x6c\xd1\x7a\x8a\xc3\x72\xb3\x18\x08\x4f\x3e\x05\x90\x80\x0d\x01\x48\x21\x22\x7a\x81\x1e\xef\x39\x7a\xd0\xd1\x6a\x6b\x9e\xa6\x34\xf5\xd6\xb5\xeb\x00\xd5\xc2\xc7\xa8\xae\x29\x0d\x59\x14\xd3\x96\x03\xbe\x12\xcc\xfd\xa5\x34\x53\xdd\xd9\xa4\x1c\x8a\x6d\x4d\x89\xfe\xc7\x12\x3c\xe9\xe0\xdb\xe6\x45\xf6\x36\xb7\x06\x20\x3d\x44\xbe\x02\x6f\x06\x45\x2a\x7f\xc0\xd4\x57\x76\x40\xdd\x9c\xc3\x26\x90\xd4\xdf\x3b\xab\x6e\xdc\xdc\x56\xeb\xff\x7d\xab\x77\x14\x8b\x99\x27\x96\xe3\x80\x5b\xdc\x95\x04\xac\xeb\xbd\xd1\xc7\x00\xfc\xd6\xe6\x35\x55\xc9\xfd\x77\x74\xfa\xcc\xb5\xbf\x91\xad\xe0\x51\x50\xee\x45\x71\xea\x22\x7b\x14\xf3\x6b\x00\x26\x89\x7d\xf8\x84\x04\x00\x00")
+
+func multusYamlBytes() ([]byte, error) {
+	return bindataRead(
+		_multusYaml,
+		"multus.yaml",
+	)
+}
+
+func multusYaml() (*asset, error) {
+	bytes, err := multusYamlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "multus.yaml", size: 0, mode: os.FileMode(0), modTime: time.Unix(0, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _rolebindingsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\xcf\xbd\x0a\xc2\x40\x10\x04\xe0\xfe\x9e\xe2\x5e\xe0\x22\x76\x72\xa5\x16\xf6\x01\xed\x37\xb9\x55\xd7\xdc\x1f\xbb\x7b\x01\x7d\x7a\x09\x48\x1a\x51\xb0\x1c\x18\xe6\x63\xa0\xd2\x19\x59\xa8\x64\x6f\x79\x80\xb1\x83\xa6\xb7\xc2\xf4\x04\xa5\x92\xbb\x69\x27\x1d\x95\xcd\xbc\x35\x13\xe5\xe0\xed\x21\x36\x51\xe4\xbe\x44\xdc\x53\x0e\x94\xaf\x26\xa1\x42\x00\x05\x6f\xac\xcd\x90\xd0\xdb\xa9\x0d\xe8\xa0\x92\x20\xcf\xc8\x6e\x89\x11\xd5\x41\x48\x94\x0d\x97\x88\x3d\x5e\x96\x36\x54\x3a\x72\x69\xf5\x87\x6c\xac\xfd\x80\x57\x47\x1e\xa2\x98\xfc\xba\x5f\xe9\x6d\x48\x1b\xee\x38\xaa\x78\xe3\xfe\x42\x4e\x82\xfc\xe5\x85\x79\x0d\x00\x54\xf2\x55\xe2\x29\x01\x00\x00")
 
 func rolebindingsYamlBytes() ([]byte, error) {
 	return bindataRead(
@@ -110,7 +131,7 @@ func rolebindingsYaml() (*asset, error) {
 	return a, nil
 }
 
-var _traefikYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\xcf\x4f\x4b\xc3\x40\x10\x05\xf0\x7b\x3e\xc5\x50\xe8\xb1\xbb\x16\xc4\xc3\xde\xfc\x13\x50\x04\x29\x56\xbd\xca\x64\xf3\xda\x0c\xdd\x6c\xc2\xce\xa4\xa0\xe2\x77\x97\x94\x1c\x3d\xce\xcc\xe3\xc7\x3c\x1e\xe5\x03\x45\x65\xc8\x81\x3a\xa4\xde\x45\x36\x4b\x70\x32\xf8\xf3\xb6\x3a\x49\x6e\x03\x3d\x22\xf5\xf7\x1d\x17\xab\x7a\x18\xb7\x6c\x1c\x2a\xa2\xcc\x3d\x02\x59\x61\x1c\xe4\xb4\xcc\x3a\x72\x44\xa0\xd3\xd4\x60\xa3\x5f\x6a\xe8\x2b\x1d\x11\xe7\x78\x9c\x81\x40\x9d\xd9\xa8\xc1\xfb\xf5\xcf\xf3\xfb\x5d\xfd\xfa\x52\xbf\xd5\xfb\xcf\xdb\xdd\xd3\xef\xda\xab\xb1\x49\xf4\x97\xa0\xfa\x05\xde\x6c\xdd\xcd\xb5\xbb\x72\x76\xfc\xae\x88\x14\x36\x5b\x44\xa5\xe1\xe8\x90\xb9\x49\x68\x03\xad\xac\x4c\x58\x5d\x0e\xaa\xe9\xdf\xfd\xfc\x52\xc9\x30\xa8\x93\x7c\x2c\x50\xad\x73\x3b\x0e\x92\xcd\x4d\x8a\x07\x1c\x78\x4a\xb6\x9b\x9a\x24\xda\xa1\xdd\xa3\x9c\x65\x6e\xb2\x08\x7f\x01\x00\x00\xff\xff\x90\xbb\x64\x2c\x26\x01\x00\x00")
+var _traefikYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\xce\x4f\x6b\xc2\x40\x10\x05\xf0\x7b\x3e\xc5\x22\xe4\xe8\xa6\x42\xe9\x61\x6f\xb6\x6e\x5b\xe9\x1f\xc4\xc4\x82\x27\x99\x6c\x46\x33\x64\x77\x0d\x3b\x13\xa1\x15\xbf\x7b\x89\x78\x29\xf4\x38\x6f\x1e\x3f\x1e\xf4\xf4\x85\x89\xe9\x18\x8d\x6a\xd1\x07\xed\x40\xc4\xa3\xa6\x63\x71\x9a\x65\x1d\xc5\xc6\xa8\x57\xf4\xe1\xa9\x85\x24\x59\x40\x81\x06\x04\x4c\xa6\x54\x84\x80\x46\x49\x02\xdc\x53\x77\xbb\xb9\x07\x87\x46\x75\x43\x8d\x53\xfe\x66\xc1\x90\x71\x8f\x6e\xac\xbb\x11\x30\xaa\x15\xe9\xd9\x14\x45\x7e\x7e\xdb\x3c\xda\xf5\xa7\xad\x6c\xb9\x9b\xaf\x96\x97\xbc\x60\x01\x21\x57\x5c\x8b\x5c\xdc\xe0\xe9\x4c\x3f\xdc\xeb\x3b\x2d\x87\x9f\x4c\x29\x46\x19\x2d\xa5\x52\x0d\x4e\x63\x84\xda\x63\x63\xd4\x44\xd2\x80\x93\xeb\x83\xd9\xff\x9b\x8f\x93\x52\x44\x41\xd6\x14\x0f\x09\x99\x6d\x6c\xfa\x23\x45\xd1\x03\xe3\x02\xf7\x30\x78\x59\x0d\xb5\x27\x6e\xb1\x29\x31\x9d\xc8\xe1\x1f\x81\x02\x1c\xc6\x24\x3f\x97\xdb\xb2\xb2\x1f\xbb\x85\x7d\x9e\x6f\xde\xab\xdd\xda\xbe\x2c\xcb\x6a\xbd\xbd\xe4\x92\x00\xf7\xd4\x4d\xb2\xdf\x01\x00\x9d\xb1\x5a\x55\x56\x01\x00\x00")
 
 func traefikYamlBytes() ([]byte, error) {
 	return bindataRead(
@@ -183,6 +204,7 @@ func AssetNames() []string {
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
 	"coredns.yaml":      corednsYaml,
+	"multus.yaml":       multusYaml,
 	"rolebindings.yaml": rolebindingsYaml,
 	"traefik.yaml":      traefikYaml,
 }
@@ -191,11 +213,13 @@ var _bindata = map[string]func() (*asset, error){
 // directory embedded in the file by go-bindata.
 // For example if you run go-bindata on data/... and data contains the
 // following hierarchy:
-//     data/
-//       foo.txt
-//       img/
-//         a.png
-//         b.png
+//
+//	data/
+//	  foo.txt
+//	  img/
+//	    a.png
+//	    b.png
+//
 // then AssetDir("data") would return []string{"foo.txt", "img"}
 // AssetDir("data/img") would return []string{"a.png", "b.png"}
 // AssetDir("foo.txt") and AssetDir("notexist") would return an error
@@ -229,6 +253,7 @@ type bintree struct {
 
 var _bintree = &bintree{nil, map[string]*bintree{
 	"coredns.yaml":      &bintree{corednsYaml, map[string]*bintree{}},
+	"multus.yaml":       &bintree{multusYaml, map[string]*bintree{}},
 	"rolebindings.yaml": &bintree{rolebindingsYaml, map[string]*bintree{}},
 	"traefik.yaml":      &bintree{traefikYaml, map[string]*bintree{}},
 }}
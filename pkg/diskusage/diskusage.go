@@ -0,0 +1,207 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rancher/k3s/pkg/snapshot"
+	"golang.org/x/sys/unix"
+)
+
+// Dirs are the directories k3s writes to. Empty directories are the defaults
+// below the data dir.
+type Dirs struct {
+	DataDir        string
+	KubeletRoot    string
+	ContainerdRoot string
+	SnapshotDir    string
+	LogFile        string
+}
+
+// Path is a named path k3s writes to. Paths may contain each other, such as
+// the data dir and the containerd root below it.
+type Path struct {
+	Name string
+	Path string
+}
+
+// Usage is the space used below a path, and the size of the filesystem
+// holding it.
+type Usage struct {
+	Path
+	Bytes int64
+	// Device identifies the filesystem, FSSize and FSFree are in bytes
+	Device uint64
+	FSSize uint64
+	FSFree uint64
+}
+
+// Paths returns the paths to account for, the data dir first.
+func (d Dirs) Paths() []Path {
+	agentDir := filepath.Join(d.DataDir, "agent")
+	serverDir := filepath.Join(d.DataDir, "server")
+	if d.KubeletRoot == "" {
+		d.KubeletRoot = filepath.Join(agentDir, "kubelet")
+	}
+	if d.ContainerdRoot == "" {
+		d.ContainerdRoot = filepath.Join(agentDir, "containerd")
+	}
+	if d.SnapshotDir == "" {
+		d.SnapshotDir = snapshot.DefaultDir(serverDir)
+	}
+
+	paths := []Path{
+		{Name: "data-dir", Path: d.DataDir},
+		{Name: "binaries", Path: filepath.Join(d.DataDir, "data")},
+		{Name: "datastore", Path: filepath.Dir(snapshot.DBFile(serverDir))},
+		{Name: "snapshots", Path: d.SnapshotDir},
+		{Name: "containerd", Path: d.ContainerdRoot},
+		{Name: "containerd-content", Path: filepath.Join(d.ContainerdRoot, "io.containerd.content.v1.content")},
+		{Name: "kubelet", Path: d.KubeletRoot},
+		{Name: "pod-logs", Path: "/var/log/pods"},
+	}
+	if d.LogFile != "" {
+		paths = append(paths, Path{Name: "log-file", Path: d.LogFile})
+	}
+	return paths
+}
+
+// MeasureAll returns the space used below each path that exists, like du -sx.
+// Filesystems mounted below a path, such as pod volumes and container root
+// filesystems, are not counted, and hard links are counted once. Paths may be
+// symlinks, as the datastore is when kept elsewhere. Each tree is walked once,
+// the space of a path includes that of the paths below it on the same
+// filesystem.
+func MeasureAll(paths []Path) ([]Usage, error) {
+	var (
+		usages []*Usage
+		// tracked maps the resolved paths to the first usage measuring them
+		tracked  = map[string]*Usage{}
+		resolved = map[*Usage]string{}
+	)
+	for _, p := range paths {
+		u, root, err := stat(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+		resolved[u] = root
+		if _, ok := tracked[root]; !ok {
+			tracked[root] = u
+		}
+	}
+
+	// the parent of a path is the closest path containing it on the same
+	// filesystem, paths without one are walked
+	parents := map[*Usage]*Usage{}
+	for _, u := range usages {
+		if tracked[resolved[u]] != u {
+			continue
+		}
+		for dir := filepath.Dir(resolved[u]); ; dir = filepath.Dir(dir) {
+			if parent, ok := tracked[dir]; ok && parent.Device == u.Device {
+				parents[u] = parent
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	own := map[*Usage]int64{}
+	for _, u := range usages {
+		if tracked[resolved[u]] != u || parents[u] != nil {
+			continue
+		}
+		if err := walk(resolved[u], u.Device, tracked, own); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range usages {
+		if tracked[resolved[u]] != u {
+			continue
+		}
+		for c := u; c != nil; c = parents[c] {
+			c.Bytes += own[u]
+		}
+	}
+
+	result := make([]Usage, 0, len(usages))
+	for _, u := range usages {
+		u.Bytes = tracked[resolved[u]].Bytes
+		result = append(result, *u)
+	}
+	return result, nil
+}
+
+// stat resolves the path and records the filesystem holding it.
+func stat(p Path) (*Usage, string, error) {
+	u := &Usage{Path: p}
+	root, err := filepath.EvalSymlinks(p.Path)
+	if err != nil {
+		return u, "", err
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err != nil {
+		return u, "", err
+	}
+	u.FSSize = st.Blocks * uint64(st.Bsize)
+	u.FSFree = st.Bavail * uint64(st.Bsize)
+
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return u, "", err
+	}
+	u.Device = uint64(rootInfo.Sys().(*syscall.Stat_t).Dev)
+	return u, root, nil
+}
+
+// walk adds the space of the files below root on the device to the closest
+// tracked path containing them.
+func walk(root string, device uint64, tracked map[string]*Usage, own map[*Usage]int64) error {
+	seen := map[uint64]bool{}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// containers come and go while walking
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if uint64(stat.Dev) != device {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if stat.Nlink > 1 && !info.IsDir() {
+			if seen[stat.Ino] {
+				return nil
+			}
+			seen[stat.Ino] = true
+		}
+		for dir := path; ; dir = filepath.Dir(dir) {
+			if u, ok := tracked[dir]; ok {
+				own[u] += stat.Blocks * 512
+				return nil
+			}
+			if dir == root {
+				return nil
+			}
+		}
+	})
+}
+
+// UsedPercent is how full the filesystem holding the path is.
+func (u Usage) UsedPercent() int {
+	if u.FSSize == 0 {
+		return 0
+	}
+	return int((u.FSSize - u.FSFree) * 100 / u.FSSize)
+}
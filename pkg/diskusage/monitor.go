@@ -0,0 +1,185 @@
+package diskusage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/drift"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	interval = 5 * time.Minute
+
+	highReason   = "DiskUsageHigh"
+	normalReason = "DiskUsageNormal"
+)
+
+var (
+	// Bytes is the space used below each path k3s writes to.
+	Bytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k3s_disk_usage_bytes",
+			Help: "Space used below a path k3s writes to",
+		},
+		[]string{"name", "path"},
+	)
+	// FilesystemUsed is how full the filesystem holding each path is.
+	FilesystemUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k3s_disk_filesystem_used_percent",
+			Help: "Percentage of the filesystem holding a path k3s writes to that is used",
+		},
+		[]string{"name", "path"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(Bytes, FilesystemUsed)
+}
+
+// Run periodically accounts for the space used by k3s on this node, and
+// records an event on the node when a filesystem holding its paths becomes
+// fuller than the threshold percentage, or no longer is. A threshold of 0
+// disables the events.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigNode)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	dirs := Dirs{
+		DataDir:     nodeConfig.DataDir,
+		KubeletRoot: nodeConfig.AgentConfig.RootDir,
+		SnapshotDir: nodeConfig.SnapshotDir,
+		LogFile:     nodeConfig.LogFile,
+	}
+	if !nodeConfig.Docker && nodeConfig.ContainerRuntimeEndpoint == "" {
+		dirs.ContainerdRoot = nodeConfig.Containerd.Root
+	}
+
+	start(ctx, &monitor{
+		recorder:  drift.NewRecorder(client, "k3s-agent"),
+		ref:       drift.NodeRef(nodeConfig.AgentConfig.NodeName),
+		paths:     dirs.Paths(),
+		threshold: nodeConfig.DiskUsageThreshold,
+		full:      map[uint64]bool{},
+	})
+	return nil
+}
+
+// RunServer does what Run does for a server that runs no agent, accounting for
+// the datastore and snapshots below dirs. The events are recorded for the host
+// under nodeName, although no node of that name is registered.
+func RunServer(ctx context.Context, kubeConfig string, dirs Dirs, nodeName string, threshold int) error {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	start(ctx, &monitor{
+		recorder:  drift.NewRecorder(client, "k3s-server"),
+		ref:       drift.NodeRef(nodeName),
+		paths:     dirs.Paths(),
+		threshold: threshold,
+		full:      map[uint64]bool{},
+	})
+	return nil
+}
+
+func start(ctx context.Context, m *monitor) {
+	go func() {
+		for {
+			m.check()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+type monitor struct {
+	recorder  record.EventRecorder
+	ref       *corev1.ObjectReference
+	paths     []Path
+	threshold int
+	// full is keyed by the device of filesystems over the threshold
+	full map[uint64]bool
+}
+
+func (m *monitor) check() {
+	usages, err := MeasureAll(m.paths)
+	if err != nil {
+		logrus.Warnf("Failed to measure disk usage: %v", err)
+		return
+	}
+	measured := map[Path]bool{}
+	for _, u := range usages {
+		Bytes.WithLabelValues(u.Name, u.Path.Path).Set(float64(u.Bytes))
+		FilesystemUsed.WithLabelValues(u.Name, u.Path.Path).Set(float64(u.UsedPercent()))
+		measured[u.Path] = true
+	}
+	for _, p := range m.paths {
+		if !measured[p] {
+			Bytes.DeleteLabelValues(p.Name, p.Path)
+			FilesystemUsed.DeleteLabelValues(p.Name, p.Path)
+		}
+	}
+
+	if m.threshold <= 0 {
+		return
+	}
+
+	byDevice := map[uint64][]Usage{}
+	var devices []uint64
+	for _, u := range usages {
+		if _, ok := byDevice[u.Device]; !ok {
+			devices = append(devices, u.Device)
+		}
+		byDevice[u.Device] = append(byDevice[u.Device], u)
+	}
+
+	for _, dev := range devices {
+		on := byDevice[dev]
+		used := on[0].UsedPercent()
+		switch {
+		case used >= m.threshold && !m.full[dev]:
+			msg := fmt.Sprintf("The filesystem holding %s is %d%% full, k3s uses %s", on[0].Path.Path, used, breakdown(on))
+			logrus.Warn(msg)
+			m.recorder.Event(m.ref, corev1.EventTypeWarning, highReason, msg)
+			m.full[dev] = true
+		case used < m.threshold && m.full[dev]:
+			msg := fmt.Sprintf("The filesystem holding %s is %d%% full, below the threshold of %d%% again", on[0].Path.Path, used, m.threshold)
+			logrus.Info(msg)
+			m.recorder.Event(m.ref, corev1.EventTypeNormal, normalReason, msg)
+			delete(m.full, dev)
+		}
+	}
+}
+
+func breakdown(usages []Usage) string {
+	var parts []string
+	for _, u := range usages {
+		parts = append(parts, fmt.Sprintf("%s for %s", units.BytesSize(float64(u.Bytes)), u.Name))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,43 @@
+package drift
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Run watches the files the agent wrote on startup for changes, reporting them
+// as events on this node.
+func Run(ctx context.Context, nodeConfig *config.Node) error {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", nodeConfig.AgentConfig.KubeConfigNode)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{
+		nodeConfig.AgentConfig.KubeConfigKubelet,
+		nodeConfig.AgentConfig.KubeConfigKubeProxy,
+	}
+	if !nodeConfig.Docker && nodeConfig.ContainerRuntimeEndpoint == "" {
+		paths = append(paths, nodeConfig.Containerd.Config)
+	}
+	if !nodeConfig.NoFlannel {
+		paths = append(paths, nodeConfig.FlannelConf, filepath.Join(nodeConfig.AgentConfig.CNIConfDir, "10-flannel.conflist"))
+	}
+	if nodeConfig.Multus {
+		paths = append(paths, filepath.Join(nodeConfig.AgentConfig.CNIConfDir, flannel.MultusConf))
+	}
+
+	w := New(NewRecorder(client, "k3s-agent"), nodeConfig.HealConfigDrift)
+	w.Track(NodeRef(nodeConfig.AgentConfig.NodeName), paths...)
+	w.Start(ctx)
+	return nil
+}
@@ -0,0 +1,184 @@
+package drift
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	interval = 30 * time.Second
+
+	driftReason    = "ConfigDrift"
+	restoredReason = "ConfigRestored"
+)
+
+// Modified is set to 1 for each file written by k3s whose content was changed
+// or removed outside of k3s.
+var Modified = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "k3s_managed_file_modified",
+		Help: "Whether a file written by k3s was modified outside of k3s",
+	},
+	[]string{"file"},
+)
+
+func init() {
+	prometheus.MustRegister(Modified)
+}
+
+// Watcher periodically compares the files k3s wrote with what it wrote, and
+// reports drift as a log message, a metric and an event on the object the file
+// configures. With heal set the content written by k3s is restored.
+type Watcher struct {
+	recorder record.EventRecorder
+	heal     bool
+
+	lock  sync.Mutex
+	files map[string]*file
+}
+
+type file struct {
+	ref      *corev1.ObjectReference
+	content  []byte
+	mode     os.FileMode
+	checksum string
+	drifted  bool
+}
+
+func New(recorder record.EventRecorder, heal bool) *Watcher {
+	return &Watcher{
+		recorder: recorder,
+		heal:     heal,
+		files:    map[string]*file{},
+	}
+}
+
+// NewRecorder returns an event recorder for watchers outside of the server.
+func NewRecorder(k8s kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8s.CoreV1().Events("")})
+	return broadcaster.NewRecorder(runtime.NewScheme(), corev1.EventSource{Component: component})
+}
+
+// NodeRef refers to a node the way the kubelet does in its events.
+func NodeRef(nodeName string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+		UID:  types.UID(nodeName),
+	}
+}
+
+// Track records the current content of the files as written by k3s, replacing
+// what was recorded before. Files that do not exist are ignored.
+func (w *Watcher) Track(ref *corev1.ObjectReference, paths ...string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logrus.Warnf("Failed to read %s to detect changes: %v", path, err)
+			}
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		w.files[path] = &file{
+			ref:      ref,
+			content:  content,
+			mode:     info.Mode().Perm(),
+			checksum: checksum(content),
+		}
+		Modified.WithLabelValues(path).Set(0)
+	}
+}
+
+// Forget stops tracking the files.
+func (w *Watcher) Forget(paths ...string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, path := range paths {
+		delete(w.files, path)
+		Modified.DeleteLabelValues(path)
+	}
+}
+
+// Start checks the tracked files until ctx is done.
+func (w *Watcher) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			w.check()
+		}
+	}()
+}
+
+func (w *Watcher) check() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for path, f := range w.files {
+		current := "removed"
+		if content, err := ioutil.ReadFile(path); err == nil {
+			current = checksum(content)
+		} else if !os.IsNotExist(err) {
+			continue
+		}
+
+		if current == f.checksum {
+			if f.drifted {
+				logrus.Infof("%s matches what k3s wrote again", path)
+				Modified.WithLabelValues(path).Set(0)
+				f.drifted = false
+			}
+			continue
+		}
+
+		if !f.drifted {
+			logrus.Warnf("%s was modified outside of k3s", path)
+			w.recorder.Eventf(f.ref, corev1.EventTypeWarning, driftReason, "%s was modified outside of k3s", path)
+			Modified.WithLabelValues(path).Set(1)
+			f.drifted = true
+		}
+
+		if w.heal {
+			if err := ioutil.WriteFile(path, f.content, f.mode); err != nil {
+				logrus.Errorf("Failed to restore %s: %v", path, err)
+				continue
+			}
+			logrus.Infof("Restored %s", path)
+			w.recorder.Eventf(f.ref, corev1.EventTypeNormal, restoredReason, "Restored %s as written by k3s", path)
+			Modified.WithLabelValues(path).Set(0)
+			f.drifted = false
+		}
+	}
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+// Package embedded runs a k3s server or agent inside another Go process.
+//
+// The configuration types of this package are kept compatible across
+// releases, unlike the internal packages they are translated for. Settings
+// that are not covered can still be passed as command line flags through
+// ExtraArgs, without that guarantee. k3s keeps process wide state, so only
+// one server or agent can run per process.
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/rancher/k3s/pkg/cli/agent"
+	"github.com/rancher/k3s/pkg/cli/cmds"
+	"github.com/rancher/k3s/pkg/cli/server"
+	"github.com/urfave/cli"
+)
+
+var running int32
+
+// ServerConfig configures a server. Zero values use the defaults of
+// k3s server.
+type ServerConfig struct {
+	DataDir          string
+	BindAddress      string
+	HTTPSPort        int
+	AdvertiseAddress string
+	TLSSANs          []string
+	ClusterCIDR      string
+	ServiceCIDR      string
+	ClusterDNS       string
+	ClusterDomain    string
+	ClusterSecret    string
+	KubeConfigOutput string
+	Disable          []string
+	NoDeploy         []string
+	DisableAgent     bool
+	Debug            bool
+
+	// Node configures the agent that runs as part of the server, its
+	// ServerURL, Token and DataDir are ignored.
+	Node AgentConfig
+
+	// OnReady is called once the server is up and, unless DisableAgent is
+	// set, the node of its agent is ready.
+	OnReady func(ServerInfo)
+
+	// ExtraArgs are further k3s server flags.
+	ExtraArgs []string
+}
+
+// ServerInfo describes a running server.
+type ServerInfo struct {
+	// URL is the address agents join.
+	URL string
+	// Token is the node token agents join with.
+	Token string
+	// KubeConfig is the path of the admin kubeconfig.
+	KubeConfig string
+}
+
+// AgentConfig configures an agent. Zero values use the defaults of
+// k3s agent.
+type AgentConfig struct {
+	ServerURL string
+	Token     string
+	DataDir   string
+	NodeName  string
+	NodeIP    string
+	Docker    bool
+	NoFlannel bool
+	Labels    []string
+	Taints    []string
+	Debug     bool
+
+	// ExtraArgs are further k3s agent flags.
+	ExtraArgs []string
+}
+
+// RunServer runs a server until ctx is done.
+func RunServer(ctx context.Context, config ServerConfig) error {
+	var ready server.ReadyFunc
+	if config.OnReady != nil {
+		ready = func(url, token, kubeConfig string) {
+			config.OnReady(ServerInfo{
+				URL:        url,
+				Token:      token,
+				KubeConfig: kubeConfig,
+			})
+		}
+	}
+	return run(config.Debug, cmds.NewServerCommand(server.RunContext(ctx, ready)), config.args())
+}
+
+// RunAgent runs an agent until ctx is done.
+func RunAgent(ctx context.Context, config AgentConfig) error {
+	return run(config.Debug, cmds.NewAgentCommand(agent.RunContext(ctx)), config.args())
+}
+
+func run(debug bool, command cli.Command, args []string) error {
+	if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+		return fmt.Errorf("a k3s server or agent is already running in this process")
+	}
+	defer atomic.StoreInt32(&running, 0)
+
+	// the flags of the commands parse into these, string slices would keep
+	// the values of the previous run
+	cmds.ServerConfig = cmds.Server{}
+	cmds.AgentConfig = cmds.Agent{}
+
+	app := cmds.NewApp()
+	app.Commands = []cli.Command{command}
+
+	argv := []string{app.Name}
+	if debug {
+		argv = append(argv, "--debug")
+	}
+	argv = append(argv, command.Name)
+	return app.Run(append(argv, args...))
+}
+
+func (c ServerConfig) args() []string {
+	var args []string
+	args = appendString(args, "data-dir", c.DataDir)
+	args = appendString(args, "bind-address", c.BindAddress)
+	if c.HTTPSPort != 0 {
+		args = appendString(args, "https-listen-port", strconv.Itoa(c.HTTPSPort))
+	}
+	args = appendString(args, "advertise-address", c.AdvertiseAddress)
+	args = appendStrings(args, "tls-san", c.TLSSANs)
+	args = appendString(args, "cluster-cidr", c.ClusterCIDR)
+	args = appendString(args, "service-cidr", c.ServiceCIDR)
+	args = appendString(args, "cluster-dns", c.ClusterDNS)
+	args = appendString(args, "cluster-domain", c.ClusterDomain)
+	args = appendString(args, "cluster-secret", c.ClusterSecret)
+	args = appendString(args, "write-kubeconfig", c.KubeConfigOutput)
+	args = appendStrings(args, "disable", append(c.Disable, c.NoDeploy...))
+	if c.DisableAgent {
+		args = append(args, "--disable-agent")
+	}
+	args = append(args, c.Node.nodeArgs()...)
+	return append(args, c.ExtraArgs...)
+}
+
+func (c AgentConfig) args() []string {
+	var args []string
+	args = appendString(args, "server", c.ServerURL)
+	args = appendString(args, "token", c.Token)
+	args = appendString(args, "data-dir", c.DataDir)
+	args = append(args, c.nodeArgs()...)
+	return append(args, c.ExtraArgs...)
+}
+
+func (c AgentConfig) nodeArgs() []string {
+	var args []string
+	args = appendString(args, "node-name", c.NodeName)
+	args = appendString(args, "node-ip", c.NodeIP)
+	if c.Docker {
+		args = append(args, "--docker")
+	}
+	if c.NoFlannel {
+		args = append(args, "--no-flannel")
+	}
+	args = appendStrings(args, "node-label", c.Labels)
+	args = appendStrings(args, "node-taint", c.Taints)
+	return args
+}
+
+func appendString(args []string, flag, value string) []string {
+	if value == "" {
+		return args
+	}
+	return append(args, "--"+flag+"="+value)
+}
+
+func appendStrings(args []string, flag string, values []string) []string {
+	for _, value := range values {
+		args = appendString(args, flag, value)
+	}
+	return args
+}
@@ -0,0 +1,108 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PreStart      = "pre-start"
+	PostBootstrap = "post-bootstrap"
+
+	// bootstrappedFile marks a data dir whose node has completed bootstrap,
+	// hooks see K3S_FIRST_BOOT=false once it exists
+	bootstrappedFile = "bootstrapped"
+)
+
+// Hooks runs the site hooks of a server or agent.
+type Hooks struct {
+	PreStart      string
+	PostBootstrap string
+	Role          string
+	DataDir       string
+	Env           map[string]string
+	// OnReady is called once the node is ready, before the post-bootstrap
+	// hook runs.
+	OnReady func()
+}
+
+// RunPreStart runs the pre-start hook, failing startup if it fails.
+func (h *Hooks) RunPreStart(ctx context.Context) error {
+	return h.run(ctx, PreStart, h.PreStart)
+}
+
+// RunPostBootstrap runs the post-bootstrap hook in the background once ready
+// returns, so that a hook waiting on the cluster does not hold up the node it
+// waits on. A nil ready runs the hook right away.
+func (h *Hooks) RunPostBootstrap(ctx context.Context, ready func(context.Context) error) {
+	go func() {
+		if ready != nil {
+			if err := ready(ctx); err != nil {
+				logrus.Errorf("Not running %s hook: %v", PostBootstrap, err)
+				return
+			}
+		}
+		if h.OnReady != nil {
+			h.OnReady()
+		}
+		h.runPostBootstrap(ctx)
+	}()
+}
+
+// runPostBootstrap runs the post-bootstrap hook and records that the node has
+// bootstrapped. A failed hook is logged and the node is left marked as not
+// bootstrapped, so that the next start is still reported as the first boot.
+func (h *Hooks) runPostBootstrap(ctx context.Context) {
+	if err := h.run(ctx, PostBootstrap, h.PostBootstrap); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if !h.firstBoot() {
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(h.DataDir, bootstrappedFile), nil, 0600); err != nil {
+		logrus.Errorf("Failed to record bootstrap of %s: %v", h.DataDir, err)
+	}
+}
+
+func (h *Hooks) firstBoot() bool {
+	_, err := os.Stat(filepath.Join(h.DataDir, bootstrappedFile))
+	return os.IsNotExist(err)
+}
+
+func (h *Hooks) run(ctx context.Context, stage, script string) error {
+	if script == "" {
+		return nil
+	}
+
+	logrus.Infof("Running %s hook %s", stage, script)
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// the environment of k3s holds K3S_TOKEN and other secrets the hooks
+	// have no use for
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"K3S_HOOK=" + stage,
+		"K3S_ROLE=" + h.Role,
+		"K3S_DATA_DIR=" + h.DataDir,
+		"K3S_FIRST_BOOT=" + strconv.FormatBool(h.firstBoot()),
+	}
+
+	for k, v := range h.Env {
+		if v != "" {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("K3S_%s=%s", strings.ToUpper(k), v))
+		}
+	}
+	return errors.Wrapf(cmd.Run(), "%s hook %s failed", stage, script)
+}
@@ -0,0 +1,85 @@
+package images
+
+import (
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pinned is a comma separated list of image@digest references for the packaged
+// component images, set at build time from scripts/airgap/image-digests-$ARCH.txt
+var pinned string
+
+// DigestMismatch is set to 1 for each pinned image whose digest in the container
+// runtime does not match the digest pinned in the binary.
+var DigestMismatch = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "k3s_image_digest_mismatch",
+		Help: "Whether a packaged component image differs from the digest pinned at build time",
+	},
+	[]string{"image"},
+)
+
+// Pinned returns the packaged component images mapped to the digests they are
+// expected to have. It is empty if the binary was built without pinned digests.
+func Pinned() map[string]string {
+	result := map[string]string{}
+	for _, ref := range strings.Split(pinned, ",") {
+		parts := strings.SplitN(strings.TrimSpace(ref), "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result
+}
+
+// Reference returns image, moved to registry if one is set, and pinned to the
+// digest recorded at build time if there is one.
+func Reference(registry, image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+
+	ref := image
+	if registry != "" {
+		ref = strings.TrimSuffix(registry, "/") + "/" + reference.Path(named)
+		if tagged, ok := named.(reference.Tagged); ok {
+			ref += ":" + tagged.Tag()
+		}
+	}
+	if digest, ok := Pinned()[named.String()]; ok {
+		ref += "@" + digest
+	}
+	return ref
+}
+
+// Pin returns ref pinned to the digest recorded at build time for the image
+// with the same repository path and tag, whatever registry ref is moved to.
+// Other references are returned as they are.
+func Pin(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	tagged, ok := named.(reference.Tagged)
+	if !ok {
+		return ref
+	}
+	if _, digested := named.(reference.Digested); digested {
+		return ref
+	}
+
+	for image, digest := range Pinned() {
+		pinned, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			continue
+		}
+		if pinnedTagged, ok := pinned.(reference.Tagged); ok && reference.Path(pinned) == reference.Path(named) && pinnedTagged.Tag() == tagged.Tag() {
+			return ref + "@" + digest
+		}
+	}
+	return ref
+}
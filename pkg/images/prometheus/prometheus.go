@@ -0,0 +1,12 @@
+// Package prometheus registers the packaged image metrics as prometheus
+// metrics.
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rancher/k3s/pkg/images"
+)
+
+func init() {
+	prometheus.MustRegister(images.DigestMismatch)
+}
@@ -55,3 +55,36 @@ func getIPFromInterface(ifaceName string) (string, error) {
 
 	return "", fmt.Errorf("can't find ip for interface %s", ifaceName)
 }
+
+// FirstAssigned returns the first of ips that is assigned to an interface of
+// this host that is up, and false if none of them is.
+func FirstAssigned(ips []string) (string, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logrus.Warnf("Unable to list network interfaces: %v", err)
+		return "", false
+	}
+
+	assigned := map[string]bool{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ip, _, err := net.ParseCIDR(addr.String()); err == nil {
+				assigned[ip.String()] = true
+			}
+		}
+	}
+
+	for _, ip := range ips {
+		if assigned[net.ParseIP(ip).String()] {
+			return ip, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,126 @@
+package netutil
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Port is a port a k3s component listens on.
+type Port struct {
+	Component string
+	// Network is tcp or udp.
+	Network string
+	// Host is the address bound, empty for all addresses.
+	Host string
+	Port int
+}
+
+func (p Port) String() string {
+	return fmt.Sprintf("%s %s", p.Network, net.JoinHostPort(p.Host, strconv.Itoa(p.Port)))
+}
+
+// CheckPorts tries to bind every port and returns a single error listing
+// all ports that are taken, with the processes holding them where known.
+func CheckPorts(ports []Port) error {
+	var conflicts []string
+	for _, port := range ports {
+		if err := bind(port); err == nil {
+			continue
+		}
+		conflict := fmt.Sprintf("  %s (%s)", port, port.Component)
+		if owner := portOwner(port); owner != "" {
+			conflict += " is used by " + owner
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("ports needed by k3s are already in use:\n%s", strings.Join(conflicts, "\n"))
+}
+
+func bind(port Port) error {
+	addr := net.JoinHostPort(port.Host, strconv.Itoa(port.Port))
+	if port.Network == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// portOwner finds the process holding a port through the socket inodes in
+// /proc/net and the file descriptors of every process.
+func portOwner(port Port) string {
+	inodes := map[string]bool{}
+	for _, suffix := range []string{"", "6"} {
+		for _, inode := range socketInodes("/proc/net/"+port.Network+suffix, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, _ := filepath.Glob(filepath.Join(proc, "fd", "*"))
+		for _, fd := range fds {
+			link, err := os.Readlink(fd)
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				pid := filepath.Base(proc)
+				comm, _ := ioutil.ReadFile(filepath.Join(proc, "comm"))
+				return fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(comm)), pid)
+			}
+		}
+	}
+	return ""
+}
+
+// socketInodes returns the inodes of the listening sockets on a port in a
+// /proc/net table.
+func socketInodes(table string, port Port) []string {
+	f, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var inodes []string
+	scan := bufio.NewScanner(f)
+	scan.Scan() // header
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		// 0A is TCP_LISTEN, unconnected UDP sockets are 07
+		if fields[3] != "0A" && fields[3] != "07" {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		if p, err := strconv.ParseUint(fields[1][i+1:], 16, 16); err != nil || int(p) != port.Port {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
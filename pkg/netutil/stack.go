@@ -0,0 +1,16 @@
+package netutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SingleStack rejects the comma separated dual-stack form of a flag value.
+// Dual-stack needs the IPv6DualStack feature of Kubernetes 1.16, and the
+// embedded flannel only allocates IPv4 subnets.
+func SingleStack(flag, value string) error {
+	if !strings.Contains(value, ",") {
+		return nil
+	}
+	return fmt.Errorf("invalid --%s %s: dual-stack IPv4/IPv6 is not supported by this version of k3s, give a single value", flag, value)
+}
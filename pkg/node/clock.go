@@ -0,0 +1,112 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ClockOffsetAnnotation is set by agents to the offset of the node clock from
+	// the server clock, as a duration string.
+	ClockOffsetAnnotation = "k3s.io/clock-offset"
+
+	ClockSkewCondition = core.NodeConditionType("ClockSkew")
+	ClockSkewThreshold = 2 * time.Second
+
+	clockSkewReason   = "ClockSkewDetected"
+	clockSyncedReason = "ClockSynchronized"
+)
+
+// RegisterClockSkew watches the clock offsets reported by agents and maintains a
+// ClockSkew condition on each node, recording an event whenever it changes.
+func RegisterClockSkew(ctx context.Context, nodes coreclient.NodeController, recorder record.EventRecorder) error {
+	h := &clockHandler{
+		nodes:    nodes,
+		recorder: recorder,
+	}
+	nodes.OnChange(ctx, "node-clock", h.onChange)
+
+	return nil
+}
+
+type clockHandler struct {
+	nodes    coreclient.NodeClient
+	recorder record.EventRecorder
+}
+
+func (h *clockHandler) onChange(key string, node *core.Node) (*core.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	value, ok := node.Annotations[ClockOffsetAnnotation]
+	if !ok {
+		return node, nil
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.Warnf("Invalid %s annotation %q on node %s: %v", ClockOffsetAnnotation, value, node.Name, err)
+		return node, nil
+	}
+
+	skewed := offset > ClockSkewThreshold || offset < -ClockSkewThreshold
+	status := core.ConditionFalse
+	reason := clockSyncedReason
+	message := fmt.Sprintf("Node clock is within %v of the server clock", ClockSkewThreshold)
+	if skewed {
+		status = core.ConditionTrue
+		reason = clockSkewReason
+		message = fmt.Sprintf("Node clock is offset %v from the server clock, exceeding %v", offset, ClockSkewThreshold)
+	}
+
+	existing := getCondition(node, ClockSkewCondition)
+	if existing != nil && existing.Status == status {
+		return node, nil
+	}
+	if existing == nil && !skewed {
+		return node, nil
+	}
+
+	node = node.DeepCopy()
+	now := metav1.Now()
+	setCondition(node, core.NodeCondition{
+		Type:               ClockSkewCondition,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	})
+
+	if skewed {
+		h.recorder.Event(node, core.EventTypeWarning, reason, message)
+	} else {
+		h.recorder.Event(node, core.EventTypeNormal, reason, message)
+	}
+
+	return h.nodes.UpdateStatus(node)
+}
+
+func getCondition(node *core.Node, conditionType core.NodeConditionType) *core.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == conditionType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func setCondition(node *core.Node, condition core.NodeCondition) {
+	if existing := getCondition(node, condition.Type); existing != nil {
+		*existing = condition
+		return
+	}
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"context"
+
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// MaintenanceAnnotation marks a node as under maintenance, its value is the
+	// reason given. The node is kept cordoned until the annotation is removed.
+	MaintenanceAnnotation = "k3s.io/maintenance"
+	// MaintenanceCordonedAnnotation records that the node was cordoned for the
+	// maintenance, so that it is only uncordoned afterwards if it was
+	// schedulable before.
+	MaintenanceCordonedAnnotation = "k3s.io/maintenance-cordoned"
+
+	maintenanceCordonReason = "MaintenanceCordoned"
+)
+
+// RegisterMaintenance keeps nodes under maintenance cordoned, whether they were
+// uncordoned by hand or by another controller after a reboot or power on.
+func RegisterMaintenance(ctx context.Context, nodes coreclient.NodeController, recorder record.EventRecorder) error {
+	h := &maintenanceHandler{
+		nodes:    nodes,
+		recorder: recorder,
+	}
+	nodes.OnChange(ctx, "node-maintenance", h.onChange)
+
+	return nil
+}
+
+type maintenanceHandler struct {
+	nodes    coreclient.NodeController
+	recorder record.EventRecorder
+}
+
+func (h *maintenanceHandler) onChange(key string, node *core.Node) (*core.Node, error) {
+	if node == nil || !InMaintenance(node) || node.Spec.Unschedulable {
+		return node, nil
+	}
+
+	node = node.DeepCopy()
+	if _, ok := node.Annotations[MaintenanceCordonedAnnotation]; !ok {
+		node.Annotations[MaintenanceCordonedAnnotation] = "true"
+	}
+	node.Spec.Unschedulable = true
+	h.recorder.Event(node, core.EventTypeNormal, maintenanceCordonReason, "Node is under maintenance, cordoning it until maintenance is turned off")
+	return h.nodes.Update(node)
+}
+
+// InMaintenance returns true if the node is under maintenance.
+func InMaintenance(node *core.Node) bool {
+	_, ok := node.Annotations[MaintenanceAnnotation]
+	return ok
+}
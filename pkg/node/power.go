@@ -0,0 +1,220 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/kubectl/drain"
+)
+
+const (
+	// PowerSecretPrefix followed by the node name is the Secret in kube-system
+	// that selects how the machine is powered on and off. It is kept out of
+	// the node object, whose annotations agents may write. Its keys are:
+	//   driver: one of wol, ipmi or redfish
+	//   address: the MAC address for wol, the BMC host for ipmi or the
+	//     system URL for redfish
+	//   username, password: the BMC credentials
+	//   ca.crt: the CA of the redfish BMC certificate, if not a system CA
+	//   insecure-skip-verify: "true" accepts any redfish BMC certificate
+	PowerSecretPrefix = "k3s-power-"
+	// PowerStateAnnotation is the desired power state of the node, on or off. It
+	// may be set by an administrator, an autoscaler or the power schedule.
+	PowerStateAnnotation = "k3s.io/power-state"
+	// PowerScheduleLabel marks nodes that are powered off during the power off
+	// window.
+	PowerScheduleLabel = "k3s.io/power-schedule"
+
+	powerAppliedAnnotation  = "k3s.io/power-state-applied"
+	powerCordonedAnnotation = "k3s.io/power-cordoned"
+
+	powerOn  = "on"
+	powerOff = "off"
+
+	powerRetry = 30 * time.Second
+
+	powerFailedReason   = "PowerFailed"
+	powerOnReason       = "PoweredOn"
+	powerOffReason      = "PoweredOff"
+	powerDrainingReason = "PowerOffDraining"
+)
+
+// RegisterPower powers nodes on and off out of band to match their power-state
+// annotation, draining nodes before turning them off. While offWindow holds a
+// window, nodes with the power-schedule label are turned off during it and on
+// outside of it.
+func RegisterPower(ctx context.Context, k8s kubernetes.Interface, nodes coreclient.NodeController, secrets coreclient.SecretController, recorder record.EventRecorder, offWindow *Window) error {
+	h := &powerHandler{
+		nodes:       nodes,
+		secretCache: secrets.Cache(),
+		recorder:    recorder,
+		drainer:     newDrainer(k8s),
+	}
+	nodes.OnChange(ctx, "node-power", h.onChange)
+
+	go h.schedule(ctx, nodes.Cache(), offWindow)
+
+	return nil
+}
+
+type powerHandler struct {
+	nodes       coreclient.NodeController
+	secretCache coreclient.SecretCache
+	recorder    record.EventRecorder
+	drainer     *drain.Helper
+}
+
+func (h *powerHandler) onChange(key string, node *core.Node) (*core.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	desired := node.Annotations[PowerStateAnnotation]
+	if desired != powerOn && desired != powerOff {
+		return node, nil
+	}
+	if node.Annotations[powerAppliedAnnotation] == desired {
+		return node, nil
+	}
+	if desired == powerOn && node.Annotations[powerAppliedAnnotation] == "" {
+		// never powered off by k3s, so it is already on
+		return h.setApplied(node, powerOn)
+	}
+
+	if desired == powerOff {
+		return h.powerOff(node)
+	}
+	return h.powerOn(node)
+}
+
+func (h *powerHandler) powerOff(node *core.Node) (*core.Node, error) {
+	if _, cordoned := node.Annotations[powerCordonedAnnotation]; !cordoned {
+		node = node.DeepCopy()
+		// remember whether the node was schedulable before powering it off
+		node.Annotations[powerCordonedAnnotation] = fmt.Sprint(!node.Spec.Unschedulable)
+		node.Spec.Unschedulable = true
+		h.recorder.Event(node, core.EventTypeNormal, powerDrainingReason, "Cordoning and draining node to power it off")
+		return h.nodes.Update(node)
+	}
+
+	remaining, err := evictPods(h.drainer, node.Name)
+	if err != nil {
+		return node, err
+	}
+	if remaining > 0 {
+		h.enqueueAfter(node.Name, powerRetry)
+		return node, nil
+	}
+
+	if err := h.apply(node, powerDriver.Off); err != nil {
+		return node, nil
+	}
+	h.recorder.Event(node, core.EventTypeNormal, powerOffReason, "Node powered off")
+	return h.setApplied(node, powerOff)
+}
+
+func (h *powerHandler) powerOn(node *core.Node) (*core.Node, error) {
+	if err := h.apply(node, powerDriver.On); err != nil {
+		return node, nil
+	}
+	h.recorder.Event(node, core.EventTypeNormal, powerOnReason, "Node powered on")
+
+	node = node.DeepCopy()
+	if node.Annotations[powerCordonedAnnotation] == "true" && !InMaintenance(node) {
+		node.Spec.Unschedulable = false
+	}
+	delete(node.Annotations, powerCordonedAnnotation)
+	node.Annotations[powerAppliedAnnotation] = powerOn
+	return h.nodes.Update(node)
+}
+
+// apply runs a driver action, recording an event and retrying later on failure.
+func (h *powerHandler) apply(node *core.Node, action func(powerDriver) error) error {
+	driver, err := h.driver(node)
+	if err == nil {
+		err = action(driver)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to change power state of node %s: %v", node.Name, err)
+		h.recorder.Event(node, core.EventTypeWarning, powerFailedReason, err.Error())
+		h.enqueueAfter(node.Name, powerRetry)
+	}
+	return err
+}
+
+func (h *powerHandler) driver(node *core.Node) (powerDriver, error) {
+	name := PowerSecretPrefix + node.Name
+	secret, err := h.secretCache.Get("kube-system", name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get power secret kube-system/%s", name)
+	}
+	return newPowerDriver(powerConfig{
+		driver:   string(secret.Data["driver"]),
+		address:  string(secret.Data["address"]),
+		username: string(secret.Data["username"]),
+		password: string(secret.Data["password"]),
+		caCert:   secret.Data["ca.crt"],
+		insecure: string(secret.Data["insecure-skip-verify"]) == "true",
+	})
+}
+
+func (h *powerHandler) setApplied(node *core.Node, state string) (*core.Node, error) {
+	node = node.DeepCopy()
+	node.Annotations[powerAppliedAnnotation] = state
+	return h.nodes.Update(node)
+}
+
+func (h *powerHandler) enqueueAfter(name string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		h.nodes.Enqueue(name)
+	})
+}
+
+// schedule sets the desired power state of nodes with the power-schedule label
+// from the off window.
+func (h *powerHandler) schedule(ctx context.Context, nodeCache coreclient.NodeCache, offWindow *Window) {
+	selector := labels.SelectorFromSet(labels.Set{PowerScheduleLabel: "true"})
+	for {
+		var nodes []*core.Node
+		window := offWindow.Get()
+		state := powerOn
+		if window.Contains(time.Now()) {
+			state = powerOff
+		}
+
+		if window != nil {
+			var err error
+			nodes, err = nodeCache.List(selector)
+			if err != nil {
+				logrus.Errorf("Failed to list nodes for power schedule: %v", err)
+			}
+		}
+		for _, node := range nodes {
+			if node.Annotations[PowerStateAnnotation] == state {
+				continue
+			}
+			node = node.DeepCopy()
+			if node.Annotations == nil {
+				node.Annotations = map[string]string{}
+			}
+			node.Annotations[PowerStateAnnotation] = state
+			if _, err := h.nodes.Update(node); err != nil {
+				logrus.Errorf("Failed to set power state of node %s: %v", node.Name, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
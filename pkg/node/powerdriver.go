@@ -0,0 +1,166 @@
+package node
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// powerDriver turns a machine on or off out of band.
+type powerDriver interface {
+	On() error
+	Off() error
+}
+
+// powerConfig is read from the power Secret of a node.
+type powerConfig struct {
+	driver   string
+	address  string
+	username string
+	password string
+	caCert   []byte
+	insecure bool
+}
+
+func newPowerDriver(cfg powerConfig) (powerDriver, error) {
+	if cfg.address == "" {
+		return nil, fmt.Errorf("the power secret has no address")
+	}
+	switch cfg.driver {
+	case "wol":
+		return &wolDriver{mac: cfg.address}, nil
+	case "ipmi":
+		return &ipmiDriver{host: cfg.address, cfg: cfg}, nil
+	case "redfish":
+		return &redfishDriver{systemURL: cfg.address, cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unknown power driver %q (valid items: wol, ipmi, redfish)", cfg.driver)
+	}
+}
+
+// wolDriver sends Wake-on-LAN magic packets. It cannot power machines off.
+type wolDriver struct {
+	mac string
+}
+
+func (w *wolDriver) On() error {
+	mac, err := net.ParseMAC(w.mac)
+	if err != nil {
+		return err
+	}
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, mac...)
+	}
+
+	conn, err := net.Dial("udp", "255.255.255.255:9")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+func (w *wolDriver) Off() error {
+	return fmt.Errorf("the wol power driver cannot power machines off")
+}
+
+// ipmiDriver uses ipmitool against the machine's BMC.
+type ipmiDriver struct {
+	host string
+	cfg  powerConfig
+}
+
+func (i *ipmiDriver) On() error {
+	return i.chassisPower("on")
+}
+
+func (i *ipmiDriver) Off() error {
+	return i.chassisPower("soft")
+}
+
+func (i *ipmiDriver) chassisPower(action string) error {
+	cmd := exec.Command("ipmitool", "-I", "lanplus", "-H", i.host, "-U", i.cfg.username, "-E", "chassis", "power", action)
+	// -E reads the password from the environment rather than the command line
+	cmd.Env = []string{"IPMI_PASSWORD=" + i.cfg.password}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "ipmitool: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// redfishDriver calls the ComputerSystem.Reset action of a Redfish system, such
+// as https://bmc/redfish/v1/Systems/1.
+type redfishDriver struct {
+	systemURL string
+	cfg       powerConfig
+}
+
+func (r *redfishDriver) On() error {
+	return r.reset("On")
+}
+
+func (r *redfishDriver) Off() error {
+	return r.reset("GracefulShutdown")
+}
+
+func (r *redfishDriver) reset(resetType string) error {
+	body, err := json.Marshal(map[string]string{"ResetType": resetType})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(r.systemURL, "/") + "/Actions/ComputerSystem.Reset"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(r.cfg.username, r.cfg.password)
+
+	tlsConfig, err := r.tlsConfig()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("redfish reset %s at %s: %s", resetType, url, resp.Status)
+	}
+	return nil
+}
+
+// tlsConfig verifies the BMC certificate against ca.crt or the system CAs.
+// BMCs often use self-signed certificates, which are only accepted without
+// verification when the operator opts in for the node.
+func (r *redfishDriver) tlsConfig() (*tls.Config, error) {
+	if r.cfg.insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if len(r.cfg.caCert) == 0 {
+		return &tls.Config{}, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(r.cfg.caCert) {
+		return nil, fmt.Errorf("the ca.crt of the power secret holds no PEM certificates")
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
@@ -0,0 +1,317 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/kubectl/drain"
+)
+
+const (
+	// RebootRequiredAnnotation is set by agents when the node needs to be rebooted.
+	RebootRequiredAnnotation = "k3s.io/reboot-required"
+	// RebootApprovedAnnotation is set by the server once the node has been drained
+	// and may reboot.
+	RebootApprovedAnnotation = "k3s.io/reboot-approved"
+	// rebootCordonedAnnotation records that the node was cordoned for the reboot,
+	// so that nodes cordoned by an administrator are left cordoned afterwards.
+	rebootCordonedAnnotation = "k3s.io/reboot-cordoned"
+
+	rebootRetry = 15 * time.Second
+
+	rebootDrainingReason = "RebootDraining"
+	rebootApprovedReason = "RebootApproved"
+	rebootDoneReason     = "RebootCompleted"
+)
+
+// RegisterReboot coordinates reboots requested by agents, cordoning and draining
+// one node at a time per zone during the maintenance window before allowing the
+// agent to reboot, and uncordoning the node once it is back.
+func RegisterReboot(ctx context.Context, k8s kubernetes.Interface, nodes coreclient.NodeController, recorder record.EventRecorder, w *Window) error {
+	h := &rebootHandler{
+		nodes:     nodes,
+		nodeCache: nodes.Cache(),
+		recorder:  recorder,
+		window:    w,
+		active:    map[string]string{},
+		drainer:   newDrainer(k8s),
+	}
+	nodes.OnChange(ctx, "node-reboot", h.onChange)
+	nodes.OnRemove(ctx, "node-reboot", h.onRemove)
+
+	return nil
+}
+
+type rebootHandler struct {
+	sync.Mutex
+
+	nodes     coreclient.NodeController
+	nodeCache coreclient.NodeCache
+	recorder  record.EventRecorder
+	window    *Window
+	drainer   *drain.Helper
+
+	// active maps a zone to the node currently rebooting in it
+	active map[string]string
+}
+
+func (h *rebootHandler) onChange(key string, node *core.Node) (*core.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	required := node.Annotations[RebootRequiredAnnotation] == "true"
+	approved := node.Annotations[RebootApprovedAnnotation] == "true"
+	_, cordoned := node.Annotations[rebootCordonedAnnotation]
+
+	switch {
+	case required && !approved:
+		return h.prepare(node)
+	case !required && (approved || cordoned):
+		return h.complete(node)
+	case required && approved:
+		// the agent is rebooting, keep the zone reserved
+		h.acquire(node)
+	}
+	return node, nil
+}
+
+func (h *rebootHandler) onRemove(key string, node *core.Node) (*core.Node, error) {
+	h.release(node)
+	return node, nil
+}
+
+// prepare cordons and drains the node, approving the reboot once no evictable
+// pods are left.
+func (h *rebootHandler) prepare(node *core.Node) (*core.Node, error) {
+	if _, cordoned := node.Annotations[rebootCordonedAnnotation]; !cordoned {
+		if window := h.window.Get(); !window.Contains(time.Now()) {
+			h.enqueueAfter(node.Name, window.Until(time.Now()))
+			return node, nil
+		}
+		if !h.acquire(node) {
+			h.enqueueAfter(node.Name, rebootRetry)
+			return node, nil
+		}
+
+		node = node.DeepCopy()
+		// remember whether the node was schedulable before the reboot
+		node.Annotations[rebootCordonedAnnotation] = fmt.Sprint(!node.Spec.Unschedulable)
+		node.Spec.Unschedulable = true
+		h.recorder.Event(node, core.EventTypeNormal, rebootDrainingReason, "Cordoning and draining node for reboot")
+		return h.nodes.Update(node)
+	}
+
+	h.acquire(node)
+
+	remaining, err := evictPods(h.drainer, node.Name)
+	if err != nil {
+		return node, err
+	}
+	if remaining > 0 {
+		h.enqueueAfter(node.Name, rebootRetry)
+		return node, nil
+	}
+
+	node = node.DeepCopy()
+	node.Annotations[RebootApprovedAnnotation] = "true"
+	h.recorder.Event(node, core.EventTypeNormal, rebootApprovedReason, "Node drained, reboot approved")
+	return h.nodes.Update(node)
+}
+
+// complete uncordons the node after the agent has cleared its reboot request.
+func (h *rebootHandler) complete(node *core.Node) (*core.Node, error) {
+	node = node.DeepCopy()
+	if node.Annotations[rebootCordonedAnnotation] == "true" && !InMaintenance(node) {
+		node.Spec.Unschedulable = false
+	}
+	approved := node.Annotations[RebootApprovedAnnotation] == "true"
+	delete(node.Annotations, RebootApprovedAnnotation)
+	delete(node.Annotations, rebootCordonedAnnotation)
+
+	node, err := h.nodes.Update(node)
+	if err != nil {
+		return nil, err
+	}
+	if approved {
+		h.recorder.Event(node, core.EventTypeNormal, rebootDoneReason, "Node rebooted and uncordoned")
+	}
+	h.release(node)
+	return node, nil
+}
+
+// acquire reserves the node's zone for its reboot, returning false if another
+// node in the zone is already rebooting.
+func (h *rebootHandler) acquire(node *core.Node) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	zone := node.Labels[core.LabelZoneFailureDomain]
+	if current, ok := h.active[zone]; ok {
+		return current == node.Name
+	}
+
+	// the reservation is only held in memory, so check for a reboot in
+	// progress before this server took over
+	nodes, err := h.nodeCache.List(labels.Everything())
+	if err != nil {
+		return false
+	}
+	for _, other := range nodes {
+		if other.Name == node.Name || other.Labels[core.LabelZoneFailureDomain] != zone {
+			continue
+		}
+		if _, ok := other.Annotations[rebootCordonedAnnotation]; ok {
+			return false
+		}
+	}
+
+	h.active[zone] = node.Name
+	return true
+}
+
+func (h *rebootHandler) release(node *core.Node) {
+	h.Lock()
+	defer h.Unlock()
+
+	zone := node.Labels[core.LabelZoneFailureDomain]
+	if h.active[zone] == node.Name {
+		delete(h.active, zone)
+	}
+}
+
+func (h *rebootHandler) enqueueAfter(name string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		h.nodes.Enqueue(name)
+	})
+}
+
+func newDrainer(k8s kubernetes.Interface) *drain.Helper {
+	return &drain.Helper{
+		Client:              k8s,
+		Force:               true,
+		IgnoreAllDaemonSets: true,
+		DeleteLocalData:     true,
+		GracePeriodSeconds:  -1,
+	}
+}
+
+// evictPods requests eviction of the pods that have to leave the node before it
+// goes down, returning how many of them are still on the node.
+func evictPods(drainer *drain.Helper, nodeName string) (int, error) {
+	list, errs := drainer.GetPodsForDeletion(nodeName)
+	if len(errs) > 0 {
+		return 0, errors.Wrapf(errs[0], "failed to list pods to drain on %s", nodeName)
+	}
+	pods := list.Pods()
+	for _, pod := range pods {
+		if err := drainer.EvictPod(pod, "policy/v1beta1"); err != nil {
+			logrus.Infof("Waiting to evict pod %s/%s from %s: %v", pod.Namespace, pod.Name, nodeName, err)
+		}
+	}
+	return len(pods), nil
+}
+
+// MaintenanceWindow is a daily time range, in local time, during which nodes may
+// be rebooted. A nil window allows reboots at any time.
+type MaintenanceWindow struct {
+	start, end time.Duration
+}
+
+// Window holds a maintenance window that can be changed while in use.
+type Window struct {
+	sync.RWMutex
+	window *MaintenanceWindow
+}
+
+// NewWindow returns a Window holding the parsed window.
+func NewWindow(window string) (*Window, error) {
+	w := &Window{}
+	return w, w.Set(window)
+}
+
+// Set replaces the window, leaving it unchanged if window is invalid.
+func (w *Window) Set(window string) error {
+	parsed, err := ParseMaintenanceWindow(window)
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.window = parsed
+	return nil
+}
+
+// Get returns the current window, nil if there is no restriction.
+func (w *Window) Get() *MaintenanceWindow {
+	w.RLock()
+	defer w.RUnlock()
+	return w.window
+}
+
+// ParseMaintenanceWindow parses a window of the form HH:MM-HH:MM, which may wrap
+// past midnight. An empty string means no restriction.
+func ParseMaintenanceWindow(window string) (*MaintenanceWindow, error) {
+	if window == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected HH:MM-HH:MM", window)
+	}
+	var bounds []time.Duration
+	for _, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid maintenance window %q", window)
+		}
+		bounds = append(bounds, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	if bounds[0] == bounds[1] {
+		return nil, fmt.Errorf("invalid maintenance window %q, start and end are equal", window)
+	}
+
+	return &MaintenanceWindow{
+		start: bounds[0],
+		end:   bounds[1],
+	}, nil
+}
+
+// Contains returns true if t falls within the window.
+func (m *MaintenanceWindow) Contains(t time.Time) bool {
+	if m == nil {
+		return true
+	}
+	now := sinceMidnight(t)
+	if m.start < m.end {
+		return now >= m.start && now < m.end
+	}
+	return now >= m.start || now < m.end
+}
+
+// Until returns the time from t until the window next opens.
+func (m *MaintenanceWindow) Until(t time.Time) time.Duration {
+	if m.Contains(t) {
+		return 0
+	}
+	d := m.start - sinceMidnight(t)
+	if d < 0 {
+		d += 24 * time.Hour
+	}
+	return d
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
@@ -0,0 +1,96 @@
+package node
+
+import (
+	"context"
+	"sync"
+
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	nodev1beta1 "k8s.io/api/node/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RuntimeLabelPrefix is followed by the name of an extra runtime handler in
+	// the labels agents set on nodes that have the handler.
+	RuntimeLabelPrefix = "runtime.k3s.io/"
+	// RuntimeNodeSelectorAnnotation is set on the RuntimeClasses created for
+	// labelled nodes to the node selector pods of the class need, as
+	// node.k8s.io/v1beta1 cannot schedule them itself.
+	RuntimeNodeSelectorAnnotation = "k3s.io/node-selector"
+
+	runtimeClassGroupVersion = "node.k8s.io/v1beta1"
+)
+
+// RuntimeHandlers are the extra runtime handlers agents detect, the server only
+// creates RuntimeClasses for these.
+var RuntimeHandlers = []string{"nvidia", "wasmtime", "spin"}
+
+// RegisterRuntimeClasses creates the RuntimeClass of a runtime handler once a
+// node is labelled as having it, so that pods are not given classes no node can
+// run. Nothing is created if the apiserver does not serve node.k8s.io/v1beta1.
+func RegisterRuntimeClasses(ctx context.Context, k8s kubernetes.Interface, nodes coreclient.NodeController) error {
+	if _, err := k8s.Discovery().ServerResourcesForGroupVersion(runtimeClassGroupVersion); errors.IsNotFound(err) {
+		logrus.Infof("Not creating RuntimeClasses, %s is not served", runtimeClassGroupVersion)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	h := &runtimeClassHandler{
+		k8s:     k8s,
+		created: map[string]bool{},
+	}
+	nodes.OnChange(ctx, "node-runtimeclasses", h.onChange)
+
+	return nil
+}
+
+type runtimeClassHandler struct {
+	sync.Mutex
+
+	k8s     kubernetes.Interface
+	created map[string]bool
+}
+
+func (h *runtimeClassHandler) onChange(key string, node *core.Node) (*core.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for _, handler := range RuntimeHandlers {
+		if h.created[handler] || node.Labels[RuntimeLabelPrefix+handler] != "true" {
+			continue
+		}
+		if err := h.create(handler); err != nil {
+			return node, err
+		}
+		h.created[handler] = true
+	}
+	return node, nil
+}
+
+func (h *runtimeClassHandler) create(handler string) error {
+	_, err := h.k8s.NodeV1beta1().RuntimeClasses().Create(&nodev1beta1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: handler,
+			Annotations: map[string]string{
+				RuntimeNodeSelectorAnnotation: RuntimeLabelPrefix + handler + "=true",
+			},
+		},
+		Handler: handler,
+	})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	logrus.Infof("Created RuntimeClass %s for nodes labelled %s%s", handler, RuntimeLabelPrefix, handler)
+	return nil
+}
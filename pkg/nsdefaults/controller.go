@@ -0,0 +1,163 @@
+package nsdefaults
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/deploy"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// AppliedAnnotation marks namespaces the defaults have been applied to. Set
+	// it to any value to keep a new namespace from getting the defaults.
+	AppliedAnnotation = "k3s.io/namespace-defaults"
+
+	// cutoffConfigMapName records when the defaults were first enabled, only
+	// namespaces created since then get them.
+	cutoffConfigMapName = "k3s-namespace-defaults"
+	cutoffKey           = "since"
+)
+
+var (
+	allowedKinds = map[string]bool{
+		"ResourceQuota": true,
+		"LimitRange":    true,
+		"NetworkPolicy": true,
+	}
+	systemNamespaces = map[string]bool{
+		"kube-system":     true,
+		"kube-public":     true,
+		"kube-node-lease": true,
+	}
+)
+
+// Register applies the ResourceQuota, LimitRange and NetworkPolicy templates
+// in file to namespaces created since the defaults were first enabled on the
+// cluster, whichever server was running then.
+func Register(ctx context.Context, k8s kubernetes.Interface, apply apply.Apply, namespaces coreclient.NamespaceController, recorder record.EventRecorder, file string) error {
+	templates, err := readTemplates(file)
+	if err != nil {
+		return err
+	}
+	since, err := cutoff(k8s)
+	if err != nil {
+		return err
+	}
+
+	h := &handler{
+		apply:      apply,
+		namespaces: namespaces,
+		recorder:   recorder,
+		templates:  templates,
+		since:      since,
+	}
+	namespaces.OnChange(ctx, "namespace-defaults", h.onChange)
+	return nil
+}
+
+// cutoff returns when the defaults were first enabled, recording now if they
+// never were.
+func cutoff(k8s kubernetes.Interface) (time.Time, error) {
+	configMaps := k8s.CoreV1().ConfigMaps(metav1.NamespaceSystem)
+	cm, err := configMaps.Get(cutoffConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, err = configMaps.Create(&core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cutoffConfigMapName,
+				Namespace: metav1.NamespaceSystem,
+			},
+			Data: map[string]string{
+				cutoffKey: time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+		if apierrors.IsAlreadyExists(err) {
+			cm, err = configMaps.Get(cutoffConfigMapName, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	since, err := time.Parse(time.RFC3339, cm.Data[cutoffKey])
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid %s in configmap %s/%s", cutoffKey, metav1.NamespaceSystem, cutoffConfigMapName)
+	}
+	return since, nil
+}
+
+func readTemplates(file string) ([]runtime.Object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	objs, err := deploy.YAMLToObjects(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid namespace defaults %s", file)
+	}
+	for _, obj := range objs {
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if !allowedKinds[kind] {
+			return nil, fmt.Errorf("namespace defaults %s: %s is not one of ResourceQuota, LimitRange or NetworkPolicy", file, kind)
+		}
+	}
+	return objs, nil
+}
+
+type handler struct {
+	apply      apply.Apply
+	namespaces coreclient.NamespaceClient
+	recorder   record.EventRecorder
+	templates  []runtime.Object
+	since      time.Time
+}
+
+func (h *handler) onChange(key string, ns *core.Namespace) (*core.Namespace, error) {
+	if ns == nil || ns.DeletionTimestamp != nil || systemNamespaces[ns.Name] {
+		return ns, nil
+	}
+	if _, ok := ns.Annotations[AppliedAnnotation]; ok {
+		return ns, nil
+	}
+	// namespaces that existed before the defaults were enabled are left alone
+	if ns.CreationTimestamp.Time.Before(h.since) {
+		return ns, nil
+	}
+
+	var objs []runtime.Object
+	for _, template := range h.templates {
+		obj := template.DeepCopyObject()
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return ns, err
+		}
+		m.SetNamespace(ns.Name)
+		objs = append(objs, obj)
+	}
+	if err := h.apply.WithOwner(ns).WithDefaultNamespace(ns.Name).ApplyObjects(objs...); err != nil {
+		return ns, err
+	}
+	logrus.Infof("Applied namespace defaults to %s", ns.Name)
+	h.recorder.Event(ns, core.EventTypeNormal, "NamespaceDefaultsApplied", "Applied namespace defaults")
+
+	ns = ns.DeepCopy()
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	ns.Annotations[AppliedAnnotation] = "applied"
+	return h.namespaces.Update(ns)
+}
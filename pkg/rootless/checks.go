@@ -0,0 +1,27 @@
+package rootless
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkHost verifies that unprivileged user namespaces are enabled and the
+// helpers rootlesskit runs are installed, so rootless mode fails with a hint
+// rather than halfway through starting the namespace.
+func checkHost() error {
+	if value, err := ioutil.ReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && strings.TrimSpace(string(value)) == "0" {
+		return errors.New("unprivileged user namespaces are disabled, set kernel.unprivileged_userns_clone=1")
+	}
+	if value, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(value)) == "0" {
+		return errors.New("user namespaces are disabled, set user.max_user_namespaces to a non-zero value")
+	}
+	for _, binary := range []string{"newuidmap", "newgidmap", "slirp4netns"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return errors.Wrapf(err, "%s is required to run rootless", binary)
+		}
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -50,9 +49,12 @@ func Rootless(stateDir string) error {
 	}
 
 	logrus.Debug("Running rootless parent")
+	if err := checkHost(); err != nil {
+		return err
+	}
 	parentOpt, err := createParentOpt(filepath.Join(stateDir, "rootless"))
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
 
 	os.Setenv(childEnv, filepath.Join(parentOpt.StateDir, parent.StateFileAPISock))
@@ -99,9 +101,6 @@ func createParentOpt(stateDir string) (*parent.Opt, error) {
 	}
 	disableHostLoopback := true
 	binary := "slirp4netns"
-	if _, err := exec.LookPath(binary); err != nil {
-		return nil, err
-	}
 	opt.NetworkDriver = slirp4netns.NewParentDriver(binary, mtu, ipnet, disableHostLoopback, "")
 	opt.PortDriver, err = portbuiltin.NewParentDriver(&logrusDebugWriter{}, stateDir)
 	if err != nil {
@@ -0,0 +1,41 @@
+package secretsencrypt
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Reencrypt rewrites every secret so that the apiserver stores it encrypted
+// with the current key. Secrets read with any other key, or stored before
+// encryption was enabled, are written again even though they are unchanged.
+func Reencrypt(client kubernetes.Interface) (int, error) {
+	count := 0
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		secrets, err := client.CoreV1().Secrets("").List(opts)
+		if err != nil {
+			return count, err
+		}
+
+		for _, secret := range secrets.Items {
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				current, err := client.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				_, err = client.CoreV1().Secrets(secret.Namespace).Update(current)
+				return err
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if secrets.Continue == "" {
+			return count, nil
+		}
+		opts.Continue = secrets.Continue
+	}
+}
@@ -0,0 +1,208 @@
+package secretsencrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+)
+
+// keyPrefix prefixes the names of the aescbc keys, which end in the unix time
+// they were created at.
+const keyPrefix = "aescbckey-"
+
+// Stage is where a key rotation stands.
+type Stage string
+
+const (
+	// StageStart means a single key is in use.
+	StageStart Stage = "start"
+	// StagePrepared means a new key can decrypt but is not yet used to encrypt.
+	StagePrepared Stage = "prepared"
+	// StageRotated means the new key encrypts, secrets may still use the old key.
+	StageRotated Stage = "rotated"
+)
+
+// File returns the encryption config of a server data dir.
+func File(serverDataDir string) string {
+	return filepath.Join(serverDataDir, "cred", "encryption-config.json")
+}
+
+// Ensure writes an encryption config with a new key for secrets, unless one
+// exists already.
+func Ensure(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	return write(file, &apiserverconfigv1.EncryptionConfiguration{
+		TypeMeta: typeMeta(),
+		Resources: []apiserverconfigv1.ResourceConfiguration{{
+			Resources: []string{"secrets"},
+			Providers: []apiserverconfigv1.ProviderConfiguration{
+				{AESCBC: &apiserverconfigv1.AESConfiguration{Keys: []apiserverconfigv1.Key{key}}},
+				{Identity: &apiserverconfigv1.IdentityConfiguration{}},
+			},
+		}},
+	})
+}
+
+// Keys returns the names of the keys in the encryption config, the first
+// encrypts and all decrypt.
+func Keys(file string) ([]string, error) {
+	config, err := read(file)
+	if err != nil {
+		return nil, err
+	}
+	aes, err := aescbc(config)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range aes.Keys {
+		names = append(names, key.Name)
+	}
+	return names, nil
+}
+
+// CurrentStage infers the rotation stage from the order of the keys.
+func CurrentStage(file string) (Stage, error) {
+	names, err := Keys(file)
+	if err != nil {
+		return "", err
+	}
+	if len(names) < 2 {
+		return StageStart, nil
+	}
+	if keyTime(names[0]) > keyTime(names[len(names)-1]) {
+		return StageRotated, nil
+	}
+	return StagePrepared, nil
+}
+
+// Prepare adds a new key that can decrypt but is not used to encrypt.
+func Prepare(file string) error {
+	return update(file, StageStart, func(aes *apiserverconfigv1.AESConfiguration) error {
+		key, err := newKey()
+		if err != nil {
+			return err
+		}
+		aes.Keys = append(aes.Keys, key)
+		return nil
+	})
+}
+
+// Rotate makes the key added by Prepare the one used to encrypt.
+func Rotate(file string) error {
+	return update(file, StagePrepared, func(aes *apiserverconfigv1.AESConfiguration) error {
+		last := len(aes.Keys) - 1
+		aes.Keys = append([]apiserverconfigv1.Key{aes.Keys[last]}, aes.Keys[:last]...)
+		return nil
+	})
+}
+
+// DropOldKeys removes every key but the one used to encrypt, once all secrets
+// have been rewritten with it.
+func DropOldKeys(file string) error {
+	return update(file, StageRotated, func(aes *apiserverconfigv1.AESConfiguration) error {
+		aes.Keys = aes.Keys[:1]
+		return nil
+	})
+}
+
+func update(file string, expected Stage, f func(aes *apiserverconfigv1.AESConfiguration) error) error {
+	stage, err := CurrentStage(file)
+	if err != nil {
+		return err
+	}
+	if stage != expected {
+		return fmt.Errorf("secrets encryption key rotation is at stage %s, expected %s", stage, expected)
+	}
+
+	config, err := read(file)
+	if err != nil {
+		return err
+	}
+	aes, err := aescbc(config)
+	if err != nil {
+		return err
+	}
+	if err := f(aes); err != nil {
+		return err
+	}
+	return write(file, config)
+}
+
+func aescbc(config *apiserverconfigv1.EncryptionConfiguration) (*apiserverconfigv1.AESConfiguration, error) {
+	for _, resource := range config.Resources {
+		for _, provider := range resource.Providers {
+			if provider.AESCBC != nil {
+				return provider.AESCBC, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("encryption config has no aescbc provider")
+}
+
+func read(file string) (*apiserverconfigv1.EncryptionConfiguration, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &apiserverconfigv1.EncryptionConfiguration{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption config %s", file)
+	}
+	return config, nil
+}
+
+func write(file string, config *apiserverconfigv1.EncryptionConfiguration) error {
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func newKey() (apiserverconfigv1.Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return apiserverconfigv1.Key{}, err
+	}
+	return apiserverconfigv1.Key{
+		Name:   keyPrefix + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Secret: base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+func keyTime(name string) int64 {
+	t, _ := strconv.ParseInt(strings.TrimPrefix(name, keyPrefix), 10, 64)
+	return t
+}
+
+func typeMeta() metav1.TypeMeta {
+	return metav1.TypeMeta{
+		APIVersion: apiserverconfigv1.SchemeGroupVersion.String(),
+		Kind:       "EncryptionConfiguration",
+	}
+}
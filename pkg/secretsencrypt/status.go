@@ -0,0 +1,102 @@
+package secretsencrypt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// statusConfigMapName records the key each server encrypts with, keyed by
+	// the address its apiserver advertises, and the key all secrets were last
+	// rewritten with.
+	statusConfigMapName = "k3s-secrets-encryption"
+	reencryptedKey      = "reencrypted"
+)
+
+// ReportLoadedKey records the key the apiserver of the server advertising
+// address encrypts with.
+func ReportLoadedKey(client kubernetes.Interface, address, key string) error {
+	return setStatus(client, address, key)
+}
+
+// RecordReencrypted records that every secret was rewritten with key.
+func RecordReencrypted(client kubernetes.Interface, key string) error {
+	return setStatus(client, reencryptedKey, key)
+}
+
+// VerifyLoaded checks that the apiserver of every server, as listed in the
+// endpoints of the kubernetes service, encrypts with key.
+func VerifyLoaded(client kubernetes.Interface, key string) error {
+	status, err := getStatus(client)
+	if err != nil {
+		return err
+	}
+	endpoints, err := client.CoreV1().Endpoints(metav1.NamespaceDefault).Get("kubernetes", metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if status[address.IP] != key {
+				pending = append(pending, address.IP)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return fmt.Errorf("the servers at %s do not encrypt with key %s yet, restart them with the current encryption config", strings.Join(pending, ", "), key)
+	}
+	return nil
+}
+
+// VerifyReencrypted checks that every secret was rewritten with key.
+func VerifyReencrypted(client kubernetes.Interface, key string) error {
+	status, err := getStatus(client)
+	if err != nil {
+		return err
+	}
+	if status[reencryptedKey] != key {
+		return fmt.Errorf("secrets were not rewritten with key %s yet, run reencrypt first", key)
+	}
+	return nil
+}
+
+func getStatus(client kubernetes.Interface) (map[string]string, error) {
+	cm, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(statusConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// setStatus patches a single key, so that servers reporting at the same time
+// do not conflict.
+func setStatus(client kubernetes.Interface, name, value string) error {
+	configMaps := client.CoreV1().ConfigMaps(metav1.NamespaceSystem)
+	patch := fmt.Sprintf(`{"data":{%q:%q}}`, name, value)
+	_, err := configMaps.Patch(statusConfigMapName, types.MergePatchType, []byte(patch))
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      statusConfigMapName,
+				Namespace: metav1.NamespaceSystem,
+			},
+			Data: map[string]string{name: value},
+		})
+		if errors.IsAlreadyExists(err) {
+			_, err = configMaps.Patch(statusConfigMapName, types.MergePatchType, []byte(patch))
+		}
+	}
+	return err
+}
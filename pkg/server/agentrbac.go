@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	"github.com/rancher/k3s/pkg/agent/prepull"
+	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/wrangler/pkg/apply"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const agentRoleName = "k3s:agent"
+
+// applyAgentRBAC grants the agent user what the agent components running on
+// each node need, and nothing more.
+func applyAgentRBAC(apply apply.Apply) error {
+	return apply.WithSetID("k3s-agent-rbac").ApplyObjects(agentRBAC()...)
+}
+
+func agentRBAC() []runtime.Object {
+	subjects := []rbacv1.Subject{{
+		Kind:     rbacv1.GroupKind,
+		APIGroup: rbacv1.GroupName,
+		Name:     control.AgentGroup,
+	}}
+
+	return []runtime.Object{
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: agentRoleName},
+			Rules: []rbacv1.PolicyRule{
+				{
+					// agents share this user, so nodes are modified with the
+					// node identity of the kubelet, limited to its own node
+					APIGroups: []string{""},
+					Resources: []string{"nodes"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"endpoints"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"events"},
+					Verbs:     []string{"create", "patch", "update"},
+				},
+				{
+					// network policy controller
+					APIGroups: []string{""},
+					Resources: []string{"pods", "namespaces"},
+					Verbs:     []string{"list", "watch"},
+				},
+				{
+					APIGroups: []string{"networking.k8s.io"},
+					Resources: []string{"networkpolicies"},
+					Verbs:     []string{"list", "watch"},
+				},
+			},
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: agentRoleName},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     agentRoleName,
+			},
+			Subjects: subjects,
+		},
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: agentRoleName, Namespace: metav1.NamespaceSystem},
+			Rules: []rbacv1.PolicyRule{
+				{
+					// image prepull configuration, agents report their progress
+					// on their node
+					APIGroups:     []string{""},
+					Resources:     []string{"configmaps"},
+					ResourceNames: []string{prepull.ConfigMapName},
+					Verbs:         []string{"get"},
+				},
+				{
+					APIGroups:     []string{""},
+					Resources:     []string{"secrets"},
+					ResourceNames: []string{flannel.IPSecSecretName},
+					Verbs:         []string{"get"},
+				},
+			},
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: agentRoleName, Namespace: metav1.NamespaceSystem},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     agentRoleName,
+			},
+			Subjects: subjects,
+		},
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/wrangler/pkg/slice"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apiserver/pkg/endpoints/request"
 )
@@ -15,6 +17,12 @@ func doAuth(serverConfig *config.Control, next http.Handler, rw http.ResponseWri
 		return
 	}
 
+	// join tokens were already authenticated by joinTokenExchange
+	if _, ok := request.UserFrom(req.Context()); ok {
+		next.ServeHTTP(rw, req)
+		return
+	}
+
 	resp, ok, err := serverConfig.Runtime.Authenticator.AuthenticateRequest(req)
 	if err != nil {
 		logrus.Errorf("failed to authenticate request: %v", err)
@@ -22,7 +30,7 @@ func doAuth(serverConfig *config.Control, next http.Handler, rw http.ResponseWri
 		return
 	}
 
-	if !ok || resp.User.GetName() != "node" {
+	if !ok || (resp.User.GetName() != "node" && resp.User.GetName() != control.AgentUser) {
 		rw.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -39,3 +47,34 @@ func authMiddleware(serverConfig *config.Control) mux.MiddlewareFunc {
 		})
 	}
 }
+
+func doAdminAuth(serverConfig *config.Control, next http.Handler, rw http.ResponseWriter, req *http.Request) {
+	if serverConfig == nil || serverConfig.Runtime.Authenticator == nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	resp, ok, err := serverConfig.Runtime.Authenticator.AuthenticateRequest(req)
+	if err != nil {
+		logrus.Errorf("failed to authenticate request: %v", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ok || resp.User.GetName() == "node" || resp.User.GetName() == control.AgentUser || !slice.ContainsString(resp.User.GetGroups(), "system:masters") {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := request.WithUser(req.Context(), resp.User)
+	req = req.WithContext(ctx)
+	next.ServeHTTP(rw, req)
+}
+
+func adminMiddleware(serverConfig *config.Control) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			doAdminAuth(serverConfig, next, rw, req)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/token"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+// Versioned endpoints consumed by Cluster API bootstrap and control plane providers.
+const capiPrefix = "/v1-k3s/capi/v1"
+
+// TokensPath is served by the supervisor for minting join tokens, also used by `k3s token create`
+const TokensPath = capiPrefix + "/tokens"
+
+type capiToken struct {
+	Token   string     `json:"token"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type capiCertificates struct {
+	ServerCA string `json:"serverCA"`
+	ClientCA string `json:"clientCA"`
+	CAHash   string `json:"caHash"`
+}
+
+func capiTokens(server *config.Control, secrets coreclient.SecretClient, cacertsGetter CACertsGetter) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		t, err := mintJoinToken(server, secrets, cacertsGetter, req)
+		if err != nil {
+			sendError(err, resp, http.StatusBadRequest)
+			return
+		}
+
+		resp.Header().Set("content-type", "application/json")
+		json.NewEncoder(resp).Encode(t)
+	})
+}
+
+func capiBootstrapConfig(server *config.Control, secrets coreclient.SecretClient, cacertsGetter CACertsGetter) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if role := req.URL.Query().Get("role"); role != "" && role != "agent" {
+			sendError(fmt.Errorf("unsupported role %q, only agent is supported", role), resp, http.StatusBadRequest)
+			return
+		}
+
+		var t *capiToken
+		if req.URL.Query().Get("ttl") != "" {
+			var err error
+			if t, err = mintJoinToken(server, secrets, cacertsGetter, req); err != nil {
+				sendError(err, resp, http.StatusBadRequest)
+				return
+			}
+		} else {
+			certs, err := cacertsGetter()
+			if err != nil {
+				sendError(err, resp)
+				return
+			}
+			t = &capiToken{Token: FormatToken(server.Runtime.NodeToken, certs)}
+		}
+
+		resp.Header().Set("content-type", "text/plain")
+		fmt.Fprintf(resp, "K3S_URL=https://%s\n", req.Host)
+		fmt.Fprintf(resp, "K3S_TOKEN=%s\n", t.Token)
+	})
+}
+
+func capiCerts(server *config.Control, cacertsGetter CACertsGetter) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		certs, err := cacertsGetter()
+		if err != nil {
+			sendError(err, resp)
+			return
+		}
+		clientCA, err := ioutil.ReadFile(server.Runtime.ClientCA)
+		if err != nil {
+			sendError(err, resp)
+			return
+		}
+
+		digest := sha256.Sum256([]byte(certs))
+		resp.Header().Set("content-type", "application/json")
+		json.NewEncoder(resp).Encode(capiCertificates{
+			ServerCA: certs,
+			ClientCA: string(clientCA),
+			CAHash:   hex.EncodeToString(digest[:]),
+		})
+	})
+}
+
+// mintJoinToken creates a join token, which agents exchange for the agent token.
+// Without an agent token set there is nothing a join token could be exchanged for.
+func mintJoinToken(server *config.Control, secrets coreclient.SecretClient, cacertsGetter CACertsGetter, req *http.Request) (*capiToken, error) {
+	if server.Runtime.AgentToken == "" {
+		return nil, errors.New("join tokens require an agent token, start the servers with --agent-token")
+	}
+
+	ttl := 24 * time.Hour
+	if s := req.URL.Query().Get("ttl"); s != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid ttl %q: %v", s, err)
+		}
+	}
+
+	joinToken, err := token.NewJoinToken(ttl, req.URL.Query().Get("description"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := secrets.Create(joinToken.ToSecret()); err != nil {
+		return nil, err
+	}
+
+	certs, err := cacertsGetter()
+	if err != nil {
+		return nil, err
+	}
+
+	return &capiToken{
+		Token:   FormatToken(joinToken.String(), certs),
+		Expires: &joinToken.Expires,
+	}, nil
+}
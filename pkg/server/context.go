@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/rancher/helm-controller/pkg/generated/controllers/helm.cattle.io"
+	k3sscheme "github.com/rancher/k3s/pkg/generated/clientset/versioned/scheme"
 	"github.com/rancher/k3s/pkg/generated/controllers/k3s.cattle.io"
 	"github.com/rancher/wrangler-api/pkg/generated/controllers/apps"
 	"github.com/rancher/wrangler-api/pkg/generated/controllers/batch"
@@ -12,7 +13,14 @@ import (
 	"github.com/rancher/wrangler/pkg/apply"
 	"github.com/rancher/wrangler/pkg/crd"
 	"github.com/rancher/wrangler/pkg/start"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubernetes/staging/src/k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/staging/src/k8s.io/client-go/tools/clientcmd"
 )
@@ -26,6 +34,7 @@ type Context struct {
 	Core  *core.Factory
 	K8s   kubernetes.Interface
 	Apply apply.Apply
+	Event record.EventRecorder
 }
 
 func (c *Context) Start(ctx context.Context) error {
@@ -52,9 +61,21 @@ func newContext(ctx context.Context, cfg string) (*Context, error) {
 		Batch: batch.NewFactoryFromConfigOrDie(restConfig),
 		Core:  core.NewFactoryFromConfigOrDie(restConfig),
 		Apply: apply.New(k8s, apply.NewClientFactory(restConfig)),
+		Event: newEventRecorder(k8s),
 	}, nil
 }
 
+func newEventRecorder(k8s kubernetes.Interface) record.EventRecorder {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(k3sscheme.AddToScheme(scheme))
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8s.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, v1.EventSource{Component: "k3s"})
+}
+
 func crds(ctx context.Context, config *rest.Config) error {
 	factory, err := crd.NewFactoryFromClient(config)
 	if err != nil {
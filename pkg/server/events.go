@@ -0,0 +1,24 @@
+package server
+
+import (
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	nodePasswordRejectedReason = "NodePasswordValidationFailed"
+)
+
+// nodeReference returns a reference for events about a node. The UID is only
+// set once the node has registered, events about nodes that have not are
+// still listed under the node name.
+func nodeReference(nodes coreclient.NodeCache, nodeName string) *v1.ObjectReference {
+	ref := &v1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+	}
+	if node, err := nodes.Get(nodeName); err == nil {
+		ref.UID = node.UID
+	}
+	return ref
+}
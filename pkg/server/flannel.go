@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/rancher/k3s/pkg/agent/flannel"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ensureIPSecPSK creates the pre-shared key of the ipsec flannel backend. It
+// is kept in a secret, so that every server hands out the same key and it
+// survives the loss of a server.
+func ensureIPSecPSK(secrets coreclient.SecretController) error {
+	_, err := secrets.Get(metav1.NamespaceSystem, flannel.IPSecSecretName, metav1.GetOptions{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	psk := make([]byte, 32)
+	if _, err := rand.Read(psk); err != nil {
+		return err
+	}
+	_, err = secrets.Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      flannel.IPSecSecretName,
+			Namespace: metav1.NamespaceSystem,
+		},
+		Data: map[string][]byte{
+			flannel.IPSecSecretKey: []byte(hex.EncodeToString(psk)),
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err == nil {
+		logrus.Infof("Created the ipsec pre-shared key in secret %s/%s", metav1.NamespaceSystem, flannel.IPSecSecretName)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+
+	containerdversion "github.com/containerd/containerd/version"
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/version"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/json"
+	kubeversion "k8s.io/client-go/pkg/version"
+)
+
+const infoPath = "/k3s"
+
+type clusterInfo struct {
+	Version    string            `json:"version"`
+	GitCommit  string            `json:"gitCommit"`
+	Components map[string]string `json:"components"`
+	Datastore  datastoreInfo     `json:"datastore"`
+	Nodes      []nodeInfo        `json:"nodes"`
+}
+
+type datastoreInfo struct {
+	Type    string `json:"type"`
+	Healthy bool   `json:"healthy"`
+	Status  string `json:"status,omitempty"`
+}
+
+type nodeInfo struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+}
+
+// sessionChecker is implemented by the remotedialer tunnel server
+type sessionChecker interface {
+	HasSession(clientKey string) bool
+}
+
+// clusterInfoHandler serves k3s specific state so that tooling can use
+// `kubectl get --raw /k3s` instead of logging into the servers.
+func clusterInfoHandler(server *config.Control, nodes coreclient.NodeCache) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		info := clusterInfo{
+			Version:   version.Version,
+			GitCommit: version.GitCommit,
+			Components: map[string]string{
+				"kubernetes": kubeversion.Get().GitVersion,
+				"containerd": containerdversion.Version,
+			},
+			Datastore: datastoreStatus(server, req),
+		}
+
+		nodeList, err := nodes.List(labels.Everything())
+		if err != nil {
+			sendError(err, resp)
+			return
+		}
+		sessions, _ := server.Runtime.Tunnel.(sessionChecker)
+		for _, node := range nodeList {
+			info.Nodes = append(info.Nodes, nodeInfo{
+				Name:      node.Name,
+				Connected: sessions != nil && sessions.HasSession(node.Name),
+			})
+		}
+		sort.Slice(info.Nodes, func(i, j int) bool {
+			return info.Nodes[i].Name < info.Nodes[j].Name
+		})
+
+		resp.Header().Set("content-type", jsonMediaType)
+		json.NewEncoder(resp).Encode(info)
+	})
+}
+
+func datastoreType(server *config.Control) string {
+	if server.StorageBackend == "etcd3" {
+		return "etcd3"
+	}
+	if i := strings.Index(server.StorageEndpoint, "://"); i > 0 {
+		return server.StorageEndpoint[:i]
+	}
+	return "sqlite"
+}
+
+// datastoreStatus runs the apiserver storage health check on behalf of the caller.
+func datastoreStatus(server *config.Control, req *http.Request) datastoreInfo {
+	status := datastoreInfo{
+		Type: datastoreType(server),
+	}
+
+	u := *req.URL
+	check := req.WithContext(req.Context())
+	check.URL = &u
+	check.URL.Path = "/healthz/etcd"
+	check.URL.RawQuery = ""
+	check.RequestURI = check.URL.RequestURI()
+
+	recorder := httptest.NewRecorder()
+	server.Runtime.Handler.ServeHTTP(recorder, check)
+	status.Healthy = recorder.Code == http.StatusOK
+	if !status.Healthy {
+		status.Status = strings.TrimSpace(recorder.Body.String())
+	}
+	return status
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/control"
+	"github.com/rancher/k3s/pkg/token"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// joinUserPrefix prefixes the ID of a join token to name the user it authenticates as.
+const joinUserPrefix = "k3s-join:"
+
+// joinTokenExchange authenticates valid join token credentials as a user of their
+// own in the agent group. Join tokens are only accepted for exchanging them for
+// the agent token, the apiserver and every other supervisor endpoint reject them.
+func joinTokenExchange(secrets coreclient.SecretCache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			username, password, ok := req.BasicAuth()
+			if ok && token.IsJoinTokenID(username) {
+				if !validJoinToken(secrets, username, password) {
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if req.URL.Path != nodeTokenPath {
+					rw.WriteHeader(http.StatusForbidden)
+					return
+				}
+				ctx := request.WithUser(req.Context(), &user.DefaultInfo{
+					Name:   joinUserPrefix + username,
+					Groups: []string{control.AgentGroup},
+				})
+				req = req.WithContext(ctx)
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func validJoinToken(secrets coreclient.SecretCache, id, password string) bool {
+	secret, err := secrets.Get(token.Namespace, token.SecretName(id))
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logrus.Errorf("Failed to look up join token %s: %v", id, err)
+		}
+		return false
+	}
+
+	joinToken, err := token.FromSecret(secret)
+	if err != nil {
+		logrus.Errorf("Invalid join token: %v", err)
+		return false
+	}
+
+	return !joinToken.Expired() && subtle.ConstantTimeCompare([]byte(joinToken.Secret), []byte(password)) == 1
+}
+
+// cleanupJoinTokens periodically removes expired join tokens.
+func cleanupJoinTokens(ctx context.Context, secrets coreclient.SecretController) {
+	for {
+		list, err := secrets.List(token.Namespace, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("type", string(token.SecretType)).String(),
+		})
+		if err != nil {
+			logrus.Errorf("Failed to list join tokens: %v", err)
+		} else {
+			for i := range list.Items {
+				joinToken, err := token.FromSecret(&list.Items[i])
+				if err != nil || !joinToken.Expired() {
+					continue
+				}
+				if err := secrets.Delete(token.Namespace, list.Items[i].Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+					logrus.Errorf("Failed to delete expired join token %s: %v", joinToken.ID, err)
+					continue
+				}
+				logrus.Infof("Deleted expired join token %s", joinToken.ID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
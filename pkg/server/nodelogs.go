@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/agent/logs"
+	"github.com/rancher/k3s/pkg/daemons/config"
+)
+
+// NodeLogsPath is served by the supervisor for `k3s node logs`
+const NodeLogsPath = "/v1-k3s/node-logs"
+
+// nodeLogsHandler proxies requests to the log server of a node through its
+// agent tunnel, which allows connecting to the log server socket.
+func nodeLogsHandler(server *config.Control) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		node := query.Get("node")
+		component := query.Get("component")
+		if node == "" || component == "" || strings.Contains(component, "/") {
+			sendError(fmt.Errorf("node and component are required"), resp, http.StatusBadRequest)
+			return
+		}
+
+		dialer, ok := server.Runtime.Tunnel.(tunnelDialer)
+		if !ok {
+			sendError(fmt.Errorf("tunnel server is not available"), resp, http.StatusServiceUnavailable)
+			return
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				query.Del("node")
+				query.Del("component")
+				req.URL.Scheme = "http"
+				req.URL.Host = "logs"
+				req.URL.Path = "/logs/" + component
+				req.URL.RawQuery = query.Encode()
+				req.Header.Del("Authorization")
+			},
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return dialer.Dial(node, 15*time.Second, "unix", logs.Socket)
+				},
+				DisableKeepAlives: true,
+			},
+			FlushInterval: 100 * time.Millisecond,
+		}
+		proxy.ServeHTTP(resp, req)
+	})
+}
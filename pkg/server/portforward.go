@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PortForwardPath = "/v1-k3s/port-forward"
+	// PortForwardProtocol is the Upgrade protocol used by `k3s tunnel port-forward`
+	PortForwardProtocol = "k3s-port-forward"
+)
+
+// tunnelDialer is implemented by the remotedialer tunnel server
+type tunnelDialer interface {
+	Dial(clientKey string, deadline time.Duration, proto, address string) (net.Conn, error)
+}
+
+// portForwardHandler upgrades the request to a raw stream connected to
+// address on node, dialed through the node's agent tunnel.
+func portForwardHandler(server *config.Control) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !strings.EqualFold(req.Header.Get("Upgrade"), PortForwardProtocol) {
+			sendError(fmt.Errorf("expected upgrade to %s", PortForwardProtocol), resp, http.StatusBadRequest)
+			return
+		}
+
+		node := req.URL.Query().Get("node")
+		address := req.URL.Query().Get("address")
+		if node == "" || address == "" {
+			sendError(fmt.Errorf("node and address are required"), resp, http.StatusBadRequest)
+			return
+		}
+
+		dialer, ok := server.Runtime.Tunnel.(tunnelDialer)
+		if !ok {
+			sendError(fmt.Errorf("tunnel server is not available"), resp, http.StatusServiceUnavailable)
+			return
+		}
+
+		hijacker, ok := resp.(http.Hijacker)
+		if !ok {
+			sendError(fmt.Errorf("connection does not support upgrade"), resp)
+			return
+		}
+
+		remote, err := dialer.Dial(node, 15*time.Second, "tcp", address)
+		if err != nil {
+			sendError(fmt.Errorf("failed to dial %s on node %s: %v", address, node, err), resp, http.StatusBadGateway)
+			return
+		}
+		defer remote.Close()
+
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			logrus.Errorf("Failed to hijack port-forward connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\nConnection: Upgrade\r\n\r\n", PortForwardProtocol)
+		if err := buf.Flush(); err != nil {
+			return
+		}
+
+		logrus.Debugf("Forwarding %s to %s on node %s", conn.RemoteAddr(), address, node)
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(remote, buf)
+			done <- struct{}{}
+		}()
+		go func() {
+			io.Copy(conn, remote)
+			done <- struct{}{}
+		}()
+		<-done
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rancher/k3s/pkg/agent/prepull"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// registerPrepullStatus copies the image prepull progress agents report on
+// their node into the status ConfigMap, so that each node only sets its own entry.
+func registerPrepullStatus(ctx context.Context, configMaps coreclient.ConfigMapController, nodes coreclient.NodeController) error {
+	if err := ensurePrepullStatus(configMaps); err != nil {
+		return err
+	}
+
+	h := &prepullStatusHandler{
+		configMaps: configMaps,
+	}
+	nodes.OnChange(ctx, "prepull-status", h.onChange)
+
+	return nil
+}
+
+type prepullStatusHandler struct {
+	configMaps coreclient.ConfigMapController
+}
+
+func (h *prepullStatusHandler) onChange(key string, node *corev1.Node) (*corev1.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	status, ok := node.Annotations[prepull.StatusAnnotation]
+	if !ok {
+		return node, nil
+	}
+	cm, err := h.configMaps.Cache().Get(metav1.NamespaceSystem, prepull.StatusConfigMapName)
+	if err == nil && cm.Data[node.Name] == status {
+		return node, nil
+	}
+
+	patch := fmt.Sprintf(`{"data":{%q:%q}}`, node.Name, status)
+	_, err = h.configMaps.Patch(metav1.NamespaceSystem, prepull.StatusConfigMapName, types.MergePatchType, []byte(patch))
+	return node, err
+}
+
+// ensurePrepullStatus creates the ConfigMap holding the image prepull progress of
+// each node.
+func ensurePrepullStatus(configMaps coreclient.ConfigMapController) error {
+	_, err := configMaps.Create(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prepull.StatusConfigMapName,
+			Namespace: metav1.NamespaceSystem,
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3s/pkg/deploy"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadSafeFlags are the server flags that take effect without a restart when
+// they are changed in the config file.
+var ReloadSafeFlags = map[string]bool{
+	"log-level":        true,
+	"disable":          true,
+	"no-deploy":        true,
+	"pin-addon":        true,
+	"reboot-window":    true,
+	"power-off-window": true,
+}
+
+// DisableFlags are --disable and its deprecated name --no-deploy, the
+// components given to either are disabled.
+var DisableFlags = []string{"disable", "no-deploy"}
+
+// MultusManifest is only deployed with --multus.
+const MultusManifest = "multus.yaml"
+
+// Disable splits --disable values, which may be comma separated, into the
+// manifests to skip and whether the service load balancer is disabled.
+func Disable(values []string) ([]string, bool) {
+	var (
+		skips            []string
+		disableServiceLB bool
+	)
+	for _, value := range values {
+		for _, component := range strings.Split(value, ",") {
+			component = strings.TrimSpace(component)
+			switch {
+			case component == "":
+				continue
+			case component == "servicelb":
+				disableServiceLB = true
+				continue
+			case !strings.HasSuffix(component, ".yaml"):
+				component = component + ".yaml"
+			}
+			skips = append(skips, component)
+		}
+	}
+	return skips, disableServiceLB
+}
+
+// PinAddons parses --pin-addon values of the form name=version into the
+// versions addons are pinned to.
+func PinAddons(values []string) (map[string]string, error) {
+	pinned := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --pin-addon %q, expected name=version", value)
+		}
+		pinned[strings.TrimSuffix(parts[0], ".yaml")] = parts[1]
+	}
+	return pinned, nil
+}
+
+// SetLogLevel sets the level of the k3s log.
+func SetLogLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(l)
+	return nil
+}
+
+// DisableValues returns the components given to --disable or --no-deploy,
+// warning when the deprecated name is used.
+func DisableValues(get func(flag string) []string) []string {
+	var values []string
+	for _, flag := range DisableFlags {
+		if v := get(flag); len(v) > 0 {
+			if flag != DisableFlags[0] {
+				logrus.Warnf("--%s is deprecated and will be removed, use --%s", flag, DisableFlags[0])
+			}
+			values = append(values, v...)
+		}
+	}
+	return values
+}
+
+// Reload applies the new value of a reload-safe flag in current, the values of
+// the flags in the config file, to the running server.
+func Reload(config *Config, flag string, current map[string][]string) error {
+	values := current[flag]
+	var value string
+	if len(values) > 0 {
+		value = values[len(values)-1]
+	}
+
+	switch flag {
+	case "log-level":
+		if value == "" {
+			value = logrus.InfoLevel.String()
+		}
+		return SetLogLevel(value)
+	case "reboot-window":
+		if err := config.rebootWindow.Set(value); err != nil {
+			return err
+		}
+		config.RebootWindow = value
+	case "power-off-window":
+		if err := config.powerOffWindow.Set(value); err != nil {
+			return err
+		}
+		config.PowerOffWindow = value
+	case "disable", "no-deploy":
+		skips, disableServiceLB := Disable(DisableValues(func(flag string) []string {
+			return current[flag]
+		}))
+		if disableServiceLB != config.DisableServiceLB {
+			return fmt.Errorf("enabling or disabling servicelb requires a restart")
+		}
+		controlConfig := &config.ControlConfig
+		if !controlConfig.Multus {
+			skips = append(skips, MultusManifest)
+		}
+		dataDir := filepath.Join(controlConfig.DataDir, "manifests")
+		if err := deploy.Stage(dataDir, templateVars(controlConfig), skips); err != nil {
+			return err
+		}
+		controlConfig.Skips = skips
+		trackManifests(config.driftWatcher, dataDir, skips)
+		config.deployWatcher.SetDisabled(skips)
+	case "pin-addon":
+		pinned, err := PinAddons(values)
+		if err != nil {
+			return err
+		}
+		config.ControlConfig.PinnedAddons = pinned
+		config.deployWatcher.SetPinned(pinned)
+	default:
+		return fmt.Errorf("--%s can not be changed without a restart", flag)
+	}
+	return nil
+}
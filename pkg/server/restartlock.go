@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RestartLockPath is served by the supervisor for `k3s restart-lock`
+	RestartLockPath = "/v1-k3s/restart-lock"
+
+	restartLockName            = "k3s-restart"
+	defaultRestartLockDuration = 10 * time.Minute
+)
+
+type restartLockInfo struct {
+	Holder  string    `json:"holder,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// RestartLockHolder identifies this server as the holder of the restart lock.
+func RestartLockHolder() string {
+	hostname, _ := os.Hostname()
+	return strings.ToLower(hostname)
+}
+
+// restartLockHandler grants a lease to restart one server at a time. POST
+// acquires the lease for the holder in the query, if the datastore is healthy
+// and no other server holds it. DELETE releases it, GET shows the holder.
+func restartLockHandler(server *config.Control, k8s kubernetes.Interface) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		leases := k8s.CoordinationV1().Leases(metav1.NamespaceSystem)
+		holder := req.URL.Query().Get("holder")
+
+		switch req.Method {
+		case http.MethodGet:
+			lease, err := leases.Get(restartLockName, metav1.GetOptions{})
+			info := restartLockInfo{}
+			if err == nil && !leaseExpired(lease) {
+				info = leaseInfo(lease)
+			} else if err != nil && !errors.IsNotFound(err) {
+				sendError(err, resp)
+				return
+			}
+			resp.Header().Set("content-type", jsonMediaType)
+			json.NewEncoder(resp).Encode(info)
+
+		case http.MethodPost:
+			if holder == "" {
+				sendError(fmt.Errorf("holder is required"), resp, http.StatusBadRequest)
+				return
+			}
+			duration := defaultRestartLockDuration
+			if value := req.URL.Query().Get("duration"); value != "" {
+				d, err := time.ParseDuration(value)
+				if err != nil || d <= 0 {
+					sendError(fmt.Errorf("invalid duration %q", value), resp, http.StatusBadRequest)
+					return
+				}
+				duration = d
+			}
+			if status := datastoreStatus(server, req); !status.Healthy {
+				sendError(fmt.Errorf("the %s datastore is not healthy: %s", status.Type, status.Status), resp, http.StatusServiceUnavailable)
+				return
+			}
+			lease, err := acquireRestartLock(k8s, holder, duration)
+			if err != nil {
+				sendError(err, resp, http.StatusConflict)
+				return
+			}
+			logrus.Infof("Restart lock acquired by %s until %s", holder, leaseInfo(lease).Expires.Format(time.RFC3339))
+			resp.Header().Set("content-type", jsonMediaType)
+			json.NewEncoder(resp).Encode(leaseInfo(lease))
+
+		case http.MethodDelete:
+			if holder == "" {
+				sendError(fmt.Errorf("holder is required"), resp, http.StatusBadRequest)
+				return
+			}
+			if err := releaseRestartLock(k8s, holder); err != nil {
+				sendError(err, resp, http.StatusConflict)
+				return
+			}
+			resp.WriteHeader(http.StatusNoContent)
+
+		default:
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func acquireRestartLock(k8s kubernetes.Interface, holder string, duration time.Duration) (*coordinationv1.Lease, error) {
+	leases := k8s.CoordinationV1().Leases(metav1.NamespaceSystem)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(duration.Seconds())
+
+	lease, err := leases.Get(restartLockName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return leases.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      restartLockName,
+				Namespace: metav1.NamespaceSystem,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+	} else if err != nil {
+		return nil, err
+	}
+
+	if current := leaseInfo(lease); current.Holder != holder && !leaseExpired(lease) {
+		return nil, fmt.Errorf("%s is restarting, the restart lock is held until %s", current.Holder, current.Expires.Format(time.RFC3339))
+	}
+
+	lease = lease.DeepCopy()
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	// a conflict means another server took the lock since it was read
+	return leases.Update(lease)
+}
+
+func releaseRestartLock(k8s kubernetes.Interface, holder string) error {
+	leases := k8s.CoordinationV1().Leases(metav1.NamespaceSystem)
+	lease, err := leases.Get(restartLockName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if current := leaseInfo(lease); current.Holder != holder {
+		if leaseExpired(lease) {
+			return nil
+		}
+		return fmt.Errorf("the restart lock is held by %s", current.Holder)
+	}
+	return leases.Delete(restartLockName, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID},
+	})
+}
+
+// releaseOwnRestartLock releases the restart lock taken before this server was
+// restarted, once the datastore can be reached again.
+func releaseOwnRestartLock(ctx context.Context, k8s kubernetes.Interface) {
+	holder := RestartLockHolder()
+	for {
+		lease, err := k8s.CoordinationV1().Leases(metav1.NamespaceSystem).Get(restartLockName, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && leaseInfo(lease).Holder != holder) {
+			return
+		}
+		if err == nil {
+			if err = releaseRestartLock(k8s, holder); err == nil {
+				logrus.Infof("Released the restart lock held by %s", holder)
+				return
+			}
+		}
+		logrus.Debugf("Failed to release the restart lock: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func leaseInfo(lease *coordinationv1.Lease) restartLockInfo {
+	info := restartLockInfo{}
+	if lease.Spec.HolderIdentity != nil {
+		info.Holder = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.RenewTime != nil && lease.Spec.LeaseDurationSeconds != nil {
+		info.Expires = lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	}
+	return info
+}
+
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	info := leaseInfo(lease)
+	return info.Holder == "" || time.Now().After(info.Expires)
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -14,14 +16,21 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	certutil "github.com/rancher/dynamiclistener/cert"
+	"github.com/rancher/k3s/pkg/chaos"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/k3s/pkg/daemons/control"
 	"github.com/rancher/k3s/pkg/openapi"
+	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/slice"
 	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -30,34 +39,50 @@ const (
 	pbMediaType     = "application/com.github.proto-openapi.spec.v2@v1.0+protobuf"
 	openapiPrefix   = "openapi."
 	staticURL       = "/static/"
+	nodeTokenPath   = "/v1-k3s/node-token"
 )
 
 type CACertsGetter func() (string, error)
 
-func router(serverConfig *config.Control, tunnel http.Handler, cacertsGetter CACertsGetter) http.Handler {
+func router(serverConfig *config.Control, tunnel http.Handler, sc *Context, cacertsGetter CACertsGetter) http.Handler {
+	secrets := sc.Core.Core().V1().Secret()
+	nodes := sc.Core.Core().V1().Node().Cache()
+
 	authed := mux.NewRouter()
 	authed.Use(authMiddleware(serverConfig))
-	authed.NotFoundHandler = serverConfig.Runtime.Handler
+	authed.NotFoundHandler = chaos.DelayProxiedWrites(serverConfig.Runtime.Handler)
 	authed.Path("/v1-k3s/connect").Handler(tunnel)
-	authed.Path("/v1-k3s/serving-kubelet.crt").Handler(servingKubeletCert(serverConfig))
+	authed.Path("/v1-k3s/serving-kubelet.crt").Handler(servingKubeletCert(serverConfig, nodes, sc.Event))
 	authed.Path("/v1-k3s/serving-kubelet.key").Handler(fileHandler(serverConfig.Runtime.ServingKubeletKey))
-	authed.Path("/v1-k3s/client-kubelet.crt").Handler(clientKubeletCert(serverConfig))
+	authed.Path("/v1-k3s/client-kubelet.crt").Handler(clientKubeletCert(serverConfig, nodes, sc.Event))
 	authed.Path("/v1-k3s/client-kubelet.key").Handler(fileHandler(serverConfig.Runtime.ClientKubeletKey))
 	authed.Path("/v1-k3s/client-kube-proxy.crt").Handler(fileHandler(serverConfig.Runtime.ClientKubeProxyCert))
 	authed.Path("/v1-k3s/client-kube-proxy.key").Handler(fileHandler(serverConfig.Runtime.ClientKubeProxyKey))
 	authed.Path("/v1-k3s/client-ca.crt").Handler(fileHandler(serverConfig.Runtime.ClientCA))
 	authed.Path("/v1-k3s/server-ca.crt").Handler(fileHandler(serverConfig.Runtime.ServerCA))
 	authed.Path("/v1-k3s/config").Handler(configHandler(serverConfig))
+	authed.Path(nodeTokenPath).Handler(nodeTokenHandler(serverConfig, cacertsGetter))
+
+	admin := mux.NewRouter()
+	admin.Use(adminMiddleware(serverConfig))
+	admin.NotFoundHandler = authed
+	admin.Path(infoPath).Handler(clusterInfoHandler(serverConfig, nodes))
+	admin.Path(TokensPath).Handler(capiTokens(serverConfig, secrets, cacertsGetter))
+	admin.Path(capiPrefix + "/bootstrap-config").Handler(capiBootstrapConfig(serverConfig, secrets, cacertsGetter))
+	admin.Path(capiPrefix + "/certificates").Handler(capiCerts(serverConfig, cacertsGetter))
+	admin.Path(PortForwardPath).Handler(portForwardHandler(serverConfig))
+	admin.Path(NodeLogsPath).Handler(nodeLogsHandler(serverConfig))
+	admin.Path(RestartLockPath).Handler(restartLockHandler(serverConfig, sc.K8s))
 
 	staticDir := filepath.Join(serverConfig.DataDir, "static")
 	router := mux.NewRouter()
-	router.NotFoundHandler = authed
+	router.NotFoundHandler = admin
 	router.PathPrefix(staticURL).Handler(serveStatic(staticURL, staticDir))
 	router.Path("/cacerts").Handler(cacerts(cacertsGetter))
 	router.Path("/openapi/v2").Handler(serveOpenapi())
 	router.Path("/ping").Handler(ping())
 
-	return router
+	return joinTokenExchange(secrets.Cache())(router)
 }
 
 func cacerts(getter CACertsGetter) http.Handler {
@@ -72,18 +97,18 @@ func cacerts(getter CACertsGetter) http.Handler {
 	})
 }
 
-func getNodeInfo(req *http.Request) (string, string, error) {
+func getNodeInfo(req *http.Request) (string, string, string, error) {
 	nodeNames := req.Header["K3s-Node-Name"]
 	if len(nodeNames) != 1 || nodeNames[0] == "" {
-		return "", "", errors.New("node name not set")
+		return "", "", "", errors.New("node name not set")
 	}
 
 	nodePasswords := req.Header["K3s-Node-Password"]
 	if len(nodePasswords) != 1 || nodePasswords[0] == "" {
-		return "", "", errors.New("node password not set")
+		return "", "", "", errors.New("node password not set")
 	}
 
-	return strings.ToLower(nodeNames[0]), nodePasswords[0], nil
+	return strings.ToLower(nodeNames[0]), nodePasswords[0], req.Header.Get("K3s-Node-ID"), nil
 }
 
 func getCACertAndKeys(caCertFile, caKeyFile, signingKeyFile string) ([]*x509.Certificate, crypto.Signer, crypto.Signer, error) {
@@ -120,19 +145,29 @@ func getCACertAndKeys(caCertFile, caKeyFile, signingKeyFile string) ([]*x509.Cer
 	return caCert, caKey.(crypto.Signer), key.(crypto.Signer), nil
 }
 
-func servingKubeletCert(server *config.Control) http.Handler {
+// certChain encodes cert followed by the CA bundle it was signed with.
+func certChain(cert *x509.Certificate, caCerts []*x509.Certificate) []byte {
+	chain := certutil.EncodeCertPEM(cert)
+	for _, caCert := range caCerts {
+		chain = append(chain, certutil.EncodeCertPEM(caCert)...)
+	}
+	return chain
+}
+
+func servingKubeletCert(server *config.Control, nodes coreclient.NodeCache, events record.EventRecorder) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		if req.TLS == nil {
 			resp.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		nodeName, nodePassword, err := getNodeInfo(req)
+		nodeName, nodePassword, nodeID, err := getNodeInfo(req)
 		if err != nil {
 			sendError(err, resp)
 		}
 
-		if err := ensureNodePassword(server.Runtime.NodePasswdFile, nodeName, nodePassword); err != nil {
+		if err := ensureNodePassword(server.Runtime.NodePasswdFile, nodeName, nodePassword, nodeID); err != nil {
+			events.Eventf(nodeReference(nodes, nodeName), v1.EventTypeWarning, nodePasswordRejectedReason, "Node password rejected: %v", err)
 			sendError(err, resp, http.StatusForbidden)
 			return
 		}
@@ -156,23 +191,24 @@ func servingKubeletCert(server *config.Control) http.Handler {
 			return
 		}
 
-		resp.Write(append(certutil.EncodeCertPEM(cert), certutil.EncodeCertPEM(caCert[0])...))
+		resp.Write(certChain(cert, caCert))
 	})
 }
 
-func clientKubeletCert(server *config.Control) http.Handler {
+func clientKubeletCert(server *config.Control, nodes coreclient.NodeCache, events record.EventRecorder) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		if req.TLS == nil {
 			resp.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		nodeName, nodePassword, err := getNodeInfo(req)
+		nodeName, nodePassword, nodeID, err := getNodeInfo(req)
 		if err != nil {
 			sendError(err, resp)
 		}
 
-		if err := ensureNodePassword(server.Runtime.NodePasswdFile, nodeName, nodePassword); err != nil {
+		if err := ensureNodePassword(server.Runtime.NodePasswdFile, nodeName, nodePassword, nodeID); err != nil {
+			events.Eventf(nodeReference(nodes, nodeName), v1.EventTypeWarning, nodePasswordRejectedReason, "Node password rejected: %v", err)
 			sendError(err, resp, http.StatusForbidden)
 			return
 		}
@@ -193,7 +229,7 @@ func clientKubeletCert(server *config.Control) http.Handler {
 			return
 		}
 
-		resp.Write(append(certutil.EncodeCertPEM(cert), certutil.EncodeCertPEM(caCert[0])...))
+		resp.Write(certChain(cert, caCert))
 	})
 }
 
@@ -213,11 +249,50 @@ func configHandler(server *config.Control) http.Handler {
 			resp.WriteHeader(http.StatusNotFound)
 			return
 		}
+		cfg := server
+		if isAgent(req) {
+			// agents joined with the agent token must not learn the
+			// credentials of the cluster
+			agentConfig := *server
+			agentConfig.ClusterSecret = ""
+			agentConfig.StorageEndpoint = ""
+			cfg = &agentConfig
+		}
 		resp.Header().Set("content-type", "application/json")
-		json.NewEncoder(resp).Encode(server)
+		json.NewEncoder(resp).Encode(cfg)
+	})
+}
+
+func nodeTokenHandler(server *config.Control, cacertsGetter CACertsGetter) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		certs, err := cacertsGetter()
+		if err != nil {
+			sendError(err, resp)
+			return
+		}
+		nodeToken := server.Runtime.NodeToken
+		if isAgent(req) {
+			// agents, including those exchanging a join token, never get the node token
+			nodeToken = server.Runtime.AgentToken
+			if nodeToken == "" {
+				sendError(errors.New("no agent token is set"), resp, http.StatusForbidden)
+				return
+			}
+		}
+		resp.Header().Set("content-type", "text/plain")
+		resp.Write([]byte(FormatToken(nodeToken, certs)))
 	})
 }
 
+func isAgent(req *http.Request) bool {
+	user, ok := request.UserFrom(req.Context())
+	return ok && slice.ContainsString(user.GetGroups(), control.AgentGroup)
+}
+
 func serveOpenapi() http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
 		suffix := "json"
@@ -245,6 +320,7 @@ func ping() http.Handler {
 		data := []byte("pong")
 		resp.Header().Set("Content-Type", "text/plain")
 		resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		resp.Header().Set("K3s-Server-Time", time.Now().UTC().Format(time.RFC3339Nano))
 		resp.Write(data)
 	})
 }
@@ -264,8 +340,9 @@ func sendError(err error, resp http.ResponseWriter, status ...int) {
 	resp.Write([]byte(err.Error()))
 }
 
-func ensureNodePassword(passwdFile, nodeName, passwd string) error {
+func ensureNodePassword(passwdFile, nodeName, passwd, nodeID string) error {
 	records := [][]string{}
+	found := false
 
 	if _, err := os.Stat(passwdFile); !os.IsNotExist(err) {
 		f, err := os.Open(passwdFile)
@@ -274,6 +351,7 @@ func ensureNodePassword(passwdFile, nodeName, passwd string) error {
 		}
 		defer f.Close()
 		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
 		for {
 			record, err := reader.Read()
 			if err == io.EOF {
@@ -286,16 +364,42 @@ func ensureNodePassword(passwdFile, nodeName, passwd string) error {
 				return fmt.Errorf("password file '%s' must have at least 2 columns (password, nodeName), found %d", passwdFile, len(record))
 			}
 			if record[1] == nodeName {
-				if record[0] == passwd {
-					return nil
+				found = true
+				switch {
+				case record[0] == passwd:
+					// a node with a new node id replaces the recorded one
+					if nodeID == "" || (len(record) >= 3 && record[2] == hashNodeID(nodeID)) {
+						return nil
+					}
+					record = append(record[:2], hashNodeID(nodeID))
+				case nodeID != "" && len(record) >= 3 && record[2] == hashNodeID(nodeID):
+					// A re-imaged machine presenting the node id recorded for this node reclaims it
+					logrus.Infof("Node %s reclaimed by machine with matching node id, updating password", nodeName)
+					record[0] = passwd
+				case nodeID != "" && len(record) >= 3:
+					return fmt.Errorf("Node password validation failed for '%s', the node id does not match the one it registered with, restore /etc/rancher/node/node-id on the agent", nodeName)
+				default:
+					return fmt.Errorf("Node password validation failed for '%s', using passwd file '%s'", nodeName, passwdFile)
 				}
-				return fmt.Errorf("Node password validation failed for '%s', using passwd file '%s'", nodeName, passwdFile)
 			}
 			records = append(records, record)
 		}
 		f.Close()
 	}
 
-	records = append(records, []string{passwd, nodeName})
+	if !found {
+		record := []string{passwd, nodeName}
+		if nodeID != "" {
+			record = append(record, hashNodeID(nodeID))
+		}
+		records = append(records, record)
+	}
 	return control.WritePasswords(passwdFile, records)
 }
+
+// hashNodeID returns the form node ids are recorded in, so that the passwd
+// file does not hold the secret.
+func hashNodeID(nodeID string) string {
+	digest := sha256.Sum256([]byte(nodeID))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/secretsencrypt"
+	"github.com/sirupsen/logrus"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reportEncryptionKey records the key the apiserver of this server loaded, so
+// that secrets-encrypt only drops old keys once every server stopped using them.
+// Servers are identified by the address the apiserver advertises in the
+// endpoints of the kubernetes service.
+func reportEncryptionKey(ctx context.Context, server *config.Control, k8s kubernetes.Interface) {
+	address := server.AdvertiseIP
+	if address == "" {
+		// the apiserver binds to localhost, so it advertises the address of the default interface
+		ip, err := utilnet.ChooseHostInterface()
+		if err != nil {
+			logrus.Errorf("Failed to determine the apiserver address to report the secrets encryption key for: %v", err)
+			return
+		}
+		address = ip.String()
+	}
+	address = net.ParseIP(address).String()
+
+	for {
+		err := secretsencrypt.ReportLoadedKey(k8s, address, server.Runtime.EncryptionKey)
+		if err == nil {
+			return
+		}
+		logrus.Debugf("Failed to report the secrets encryption key: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -16,16 +16,20 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rancher/dynamiclistener"
 	"github.com/rancher/helm-controller/pkg/helm"
+	"github.com/rancher/k3s/pkg/agent/flannel"
 	"github.com/rancher/k3s/pkg/clientaccess"
 	"github.com/rancher/k3s/pkg/daemons/config"
 	"github.com/rancher/k3s/pkg/daemons/control"
 	"github.com/rancher/k3s/pkg/datadir"
 	"github.com/rancher/k3s/pkg/deploy"
+	"github.com/rancher/k3s/pkg/drift"
 	"github.com/rancher/k3s/pkg/node"
+	"github.com/rancher/k3s/pkg/nsdefaults"
 	"github.com/rancher/k3s/pkg/rootlessports"
 	"github.com/rancher/k3s/pkg/servicelb"
 	"github.com/rancher/k3s/pkg/static"
 	"github.com/rancher/k3s/pkg/tls"
+	"github.com/rancher/k3s/pkg/token"
 	"github.com/rancher/wrangler/pkg/leader"
 	"github.com/rancher/wrangler/pkg/resolvehome"
 	"github.com/sirupsen/logrus"
@@ -46,10 +50,22 @@ func StartServer(ctx context.Context, config *Config) (string, error) {
 		return "", err
 	}
 
+	var err error
+	if config.rebootWindow, err = node.NewWindow(config.RebootWindow); err != nil {
+		return "", err
+	}
+	if config.powerOffWindow, err = node.NewWindow(config.PowerOffWindow); err != nil {
+		return "", err
+	}
+
 	if err := control.Server(ctx, &config.ControlConfig); err != nil {
 		return "", errors.Wrap(err, "starting kubernetes")
 	}
 
+	if err := startSnapshots(ctx, config); err != nil {
+		return "", err
+	}
+
 	certs, err := startWrangler(ctx, config)
 	if err != nil {
 		return "", errors.Wrap(err, "starting tls server")
@@ -89,30 +105,39 @@ func startWrangler(ctx context.Context, config *Config) (string, error) {
 	tlsConfig.CACerts = string(caBytes)
 	tlsConfig.CAKey = string(caKeyBytes)
 
-	tlsConfig.Handler = router(controlConfig, controlConfig.Runtime.Tunnel, func() (string, error) {
+	sc, err := newContext(ctx, controlConfig.Runtime.KubeConfigAdmin)
+	if err != nil {
+		return "", err
+	}
+
+	tlsConfig.Handler = router(controlConfig, controlConfig.Runtime.Tunnel, sc, func() (string, error) {
 		if tlsServer == nil {
 			return "", nil
 		}
 		return tlsServer.CACert()
 	})
 
-	sc, err := newContext(ctx, controlConfig.Runtime.KubeConfigAdmin)
-	if err != nil {
+	if err := stageFiles(ctx, sc, config); err != nil {
 		return "", err
 	}
 
-	if err := stageFiles(ctx, sc, controlConfig); err != nil {
+	regenerateFile := filepath.Join(controlConfig.DataDir, "tls", control.RegenerateListenerFile)
+	_, statErr := os.Stat(regenerateFile)
+	tlsServer, err = tls.NewServer(ctx, sc.K3s.K3s().V1().ListenerConfig(), *tlsConfig, statErr == nil)
+	if err != nil {
 		return "", err
 	}
-
-	tlsServer, err = tls.NewServer(ctx, sc.K3s.K3s().V1().ListenerConfig(), *tlsConfig)
-	if err != nil {
+	if err := os.Remove(regenerateFile); err != nil && !os.IsNotExist(err) {
 		return "", err
 	}
 
 	if err := sc.Start(ctx); err != nil {
 		return "", err
 	}
+	go releaseOwnRestartLock(ctx, sc.K8s)
+	if controlConfig.EncryptSecrets {
+		go reportEncryptionKey(ctx, controlConfig, sc.K8s)
+	}
 
 	certs := ""
 	for certs == "" {
@@ -141,6 +166,48 @@ func masterControllers(ctx context.Context, sc *Context, config *Config) error {
 		return err
 	}
 
+	if err := node.RegisterClockSkew(ctx, sc.Core.Core().V1().Node(), sc.Event); err != nil {
+		return err
+	}
+
+	if err := node.RegisterReboot(ctx, sc.K8s, sc.Core.Core().V1().Node(), sc.Event, config.rebootWindow); err != nil {
+		return err
+	}
+
+	if err := node.RegisterMaintenance(ctx, sc.Core.Core().V1().Node(), sc.Event); err != nil {
+		return err
+	}
+
+	if err := node.RegisterPower(ctx, sc.K8s, sc.Core.Core().V1().Node(), sc.Core.Core().V1().Secret(), sc.Event, config.powerOffWindow); err != nil {
+		return err
+	}
+
+	if err := node.RegisterRuntimeClasses(ctx, sc.K8s, sc.Core.Core().V1().Node()); err != nil {
+		return err
+	}
+
+	go cleanupJoinTokens(ctx, sc.Core.Core().V1().Secret())
+
+	if config.ControlConfig.FlannelBackend == flannel.IPSecBackend {
+		if err := ensureIPSecPSK(sc.Core.Core().V1().Secret()); err != nil {
+			return err
+		}
+	}
+
+	if err := applyAgentRBAC(sc.Apply); err != nil {
+		return err
+	}
+
+	if err := registerPrepullStatus(ctx, sc.Core.Core().V1().ConfigMap(), sc.Core.Core().V1().Node()); err != nil {
+		return err
+	}
+
+	if config.NamespaceDefaults != "" {
+		if err := nsdefaults.Register(ctx, sc.K8s, sc.Apply, sc.Core.Core().V1().Namespace(), sc.Event, config.NamespaceDefaults); err != nil {
+			return err
+		}
+	}
+
 	helm.Register(ctx, sc.Apply,
 		sc.Helm.Helm().V1().HelmChart(),
 		sc.Batch.Batch().V1().Job(),
@@ -156,7 +223,8 @@ func masterControllers(ctx context.Context, sc *Context, config *Config) error {
 		sc.Core.Core().V1().Pod(),
 		sc.Core.Core().V1().Service(),
 		sc.Core.Core().V1().Endpoints(),
-		!config.DisableServiceLB, config.Rootless); err != nil {
+		!config.DisableServiceLB, config.Rootless,
+		config.ControlConfig.SystemDefaultRegistry); err != nil {
 		return err
 	}
 
@@ -167,23 +235,50 @@ func masterControllers(ctx context.Context, sc *Context, config *Config) error {
 	return nil
 }
 
-func stageFiles(ctx context.Context, sc *Context, controlConfig *config.Control) error {
+func stageFiles(ctx context.Context, sc *Context, config *Config) error {
+	controlConfig := &config.ControlConfig
 	dataDir := filepath.Join(controlConfig.DataDir, "static")
 	if err := static.Stage(dataDir); err != nil {
 		return err
 	}
 
 	dataDir = filepath.Join(controlConfig.DataDir, "manifests")
-	templateVars := map[string]string{
-		"%{CLUSTER_DNS}%":    controlConfig.ClusterDNS.String(),
-		"%{CLUSTER_DOMAIN}%": controlConfig.ClusterDomain,
+	if err := deploy.Stage(dataDir, templateVars(controlConfig), controlConfig.Skips); err != nil {
+		return err
 	}
 
-	if err := deploy.Stage(dataDir, templateVars, controlConfig.Skips); err != nil {
-		return err
+	config.driftWatcher = drift.New(sc.Event, controlConfig.HealConfigDrift)
+	trackManifests(config.driftWatcher, dataDir, controlConfig.Skips)
+	config.driftWatcher.Start(ctx)
+
+	var err error
+	config.deployWatcher, err = deploy.WatchFiles(ctx, sc.Apply, sc.K3s.K3s().V1().Addon(), sc.Event, controlConfig.Skips, controlConfig.PinnedAddons, dataDir)
+	return err
+}
+
+// trackManifests reports changes to the packaged manifests that are staged,
+// manifests added by users are theirs to change.
+func trackManifests(watcher *drift.Watcher, dataDir string, skips []string) {
+	watcher.Forget(deploy.Staged(dataDir, nil)...)
+	for _, path := range deploy.Staged(dataDir, skips) {
+		watcher.Track(deploy.AddonRef(path), path)
 	}
+}
 
-	return deploy.WatchFiles(ctx, sc.Apply, sc.K3s.K3s().V1().Addon(), dataDir)
+func templateVars(controlConfig *config.Control) map[string]string {
+	return map[string]string{
+		"%{CLUSTER_DNS}%":             controlConfig.ClusterDNS.String(),
+		"%{CLUSTER_DOMAIN}%":          controlConfig.ClusterDomain,
+		"%{SYSTEM_DEFAULT_REGISTRY}%": registryTemplate(controlConfig.SystemDefaultRegistry),
+	}
+}
+
+// registryTemplate returns the prefix for images in packaged manifests.
+func registryTemplate(registry string) string {
+	if registry == "" {
+		return ""
+	}
+	return strings.TrimSuffix(registry, "/") + "/"
 }
 
 func HomeKubeConfig(write, rootless bool) (string, error) {
@@ -218,6 +313,16 @@ func printTokens(certs, advertiseIP string, tlsConfig *dynamiclistener.UserConfi
 		}
 	}
 
+	if len(config.Runtime.AgentToken) > 0 {
+		p := filepath.Join(config.DataDir, "agent-token")
+		if err := writeToken(config.Runtime.AgentToken, p, certs); err == nil {
+			logrus.Infof("Agent token is available at %s", p)
+			nodeFile = p
+		}
+	} else if err := os.Remove(filepath.Join(config.DataDir, "agent-token")); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to remove agent token: %v", err)
+	}
+
 	if len(nodeFile) > 0 {
 		printToken(tlsConfig.HTTPSPort, advertiseIP, "To join node to cluster:", "agent")
 	}
@@ -229,7 +334,7 @@ func writeKubeConfig(certs string, tlsConfig *dynamiclistener.UserConfig, config
 	if ip == "" {
 		ip = "localhost"
 	}
-	url := fmt.Sprintf("https://%s:%d", ip, tlsConfig.HTTPSPort)
+	url := "https://" + net2.JoinHostPort(ip, strconv.Itoa(tlsConfig.HTTPSPort))
 	kubeConfig, err := HomeKubeConfig(true, config.Rootless)
 	def := true
 	if err != nil {
@@ -307,7 +412,7 @@ func printToken(httpsPort int, advertiseIP, prefix, cmd string) {
 		ip = hostIP.String()
 	}
 
-	logrus.Infof("%s k3s %s -s https://%s:%d -t ${NODE_TOKEN}", prefix, cmd, ip, httpsPort)
+	logrus.Infof("%s k3s %s -s https://%s -t ${NODE_TOKEN}", prefix, cmd, net2.JoinHostPort(ip, strconv.Itoa(httpsPort)))
 }
 
 func FormatToken(token string, certs string) string {
@@ -324,13 +429,14 @@ func FormatToken(token string, certs string) string {
 	return prefix + token
 }
 
-func writeToken(token, file, certs string) error {
-	if len(token) == 0 {
+func writeToken(value, file, certs string) error {
+	if len(value) == 0 {
 		return nil
 	}
 
-	token = FormatToken(token, certs)
-	return ioutil.WriteFile(file, []byte(token+"\n"), 0600)
+	content := []byte(FormatToken(value, certs) + "\n")
+	defer token.Zero(content)
+	return ioutil.WriteFile(file, content, 0600)
 }
 
 func setNoProxyEnv(config *config.Control) error {
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/k3s/pkg/snapshot"
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+)
+
+// scheduledSnapshotName prefixes the names of snapshots taken on the
+// schedule, only those are pruned by the retention limit.
+const scheduledSnapshotName = "scheduled"
+
+// startSnapshots takes snapshots of the embedded datastore on the configured
+// schedule, keeping the newest SnapshotRetention.
+func startSnapshots(ctx context.Context, config *Config) error {
+	if config.DisableSnapshots {
+		return nil
+	}
+	if datastoreType(&config.ControlConfig) != "sqlite" {
+		logrus.Infof("Not scheduling datastore snapshots, they are only supported for the embedded sqlite datastore")
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(config.SnapshotCron)
+	if err != nil {
+		return errors.Wrapf(err, "invalid snapshot schedule %q", config.SnapshotCron)
+	}
+
+	dbFile := snapshot.DBFile(config.ControlConfig.DataDir)
+	dir := config.SnapshotDir
+	if dir == "" {
+		dir = snapshot.DefaultDir(config.ControlConfig.DataDir)
+	}
+
+	c := cron.New()
+	c.Schedule(schedule, cron.FuncJob(func() {
+		s, err := snapshot.Save(dbFile, dir, scheduledSnapshotName, config.SnapshotKey)
+		if err != nil {
+			logrus.Errorf("Failed to take datastore snapshot: %v", err)
+			return
+		}
+		logrus.Infof("Saved datastore snapshot %s", s.Location)
+
+		removed, err := snapshot.Prune(dir, scheduledSnapshotName, config.SnapshotRetention)
+		if err != nil {
+			logrus.Errorf("Failed to prune datastore snapshots: %v", err)
+		}
+		for _, name := range removed {
+			logrus.Infof("Removed datastore snapshot %s", name)
+		}
+	}))
+	c.Start()
+	go func() {
+		<-ctx.Done()
+		c.Stop()
+	}()
+
+	logrus.Infof("Taking datastore snapshots on schedule %q to %s, keeping %d", config.SnapshotCron, dir, config.SnapshotRetention)
+	return nil
+}
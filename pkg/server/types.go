@@ -3,12 +3,28 @@ package server
 import (
 	"github.com/rancher/dynamiclistener"
 	"github.com/rancher/k3s/pkg/daemons/config"
+	"github.com/rancher/k3s/pkg/deploy"
+	"github.com/rancher/k3s/pkg/drift"
+	"github.com/rancher/k3s/pkg/node"
 )
 
 type Config struct {
-	DisableAgent     bool
-	DisableServiceLB bool
-	TLSConfig        dynamiclistener.UserConfig
-	ControlConfig    config.Control
-	Rootless         bool
+	DisableAgent      bool
+	DisableServiceLB  bool
+	TLSConfig         dynamiclistener.UserConfig
+	ControlConfig     config.Control
+	Rootless          bool
+	RebootWindow      string
+	PowerOffWindow    string
+	DisableSnapshots  bool
+	SnapshotCron      string
+	SnapshotRetention int
+	SnapshotDir       string
+	SnapshotKey       []byte
+	NamespaceDefaults string
+
+	rebootWindow   *node.Window
+	powerOffWindow *node.Window
+	deployWatcher  *deploy.Watcher
+	driftWatcher   *drift.Watcher
 }
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strconv"
 
+	"github.com/rancher/k3s/pkg/images"
 	appclient "github.com/rancher/wrangler-api/pkg/generated/controllers/apps/v1"
 	coreclient "github.com/rancher/wrangler-api/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/pkg/apply"
@@ -32,6 +33,15 @@ const (
 	daemonsetNodeLabel = "svccontroller.k3s.cattle.io/enablelb"
 	nodeSelectorLabel  = "svccontroller.k3s.cattle.io/nodeselector"
 	Ready              = condition.Cond("Ready")
+
+	// LoadBalancerClass is the class of services handled by servicelb. This
+	// release has no spec.loadBalancerClass, so the class is set with the
+	// loadbalancerclass annotation, and services without it are handled too.
+	LoadBalancerClass = "svccontroller.k3s.cattle.io/servicelb"
+	classAnnotation   = "svccontroller.k3s.cattle.io/loadbalancerclass"
+	// poolLabel as an annotation on a service limits it to the nodes with the
+	// same label, so that services in different pools may use the same ports.
+	poolLabel = "svccontroller.k3s.cattle.io/lbpool"
 )
 
 var (
@@ -47,8 +57,10 @@ func Register(ctx context.Context,
 	pods coreclient.PodController,
 	services coreclient.ServiceController,
 	endpoints coreclient.EndpointsController,
-	enabled, rootless bool) error {
+	enabled, rootless bool,
+	registry string) error {
 	h := &handler{
+		image:           images.Reference(registry, image),
 		rootless:        rootless,
 		enabled:         enabled,
 		nodeCache:       nodes.Cache(),
@@ -56,10 +68,11 @@ func Register(ctx context.Context,
 		deploymentCache: deployments.Cache(),
 		processor: apply.WithSetID("svccontroller").
 			WithCacheTypes(daemonSetController),
-		serviceCache: services.Cache(),
-		services:     kubernetes.CoreV1(),
-		daemonsets:   kubernetes.AppsV1(),
-		deployments:  kubernetes.AppsV1(),
+		serviceCache:  services.Cache(),
+		svcController: services,
+		dsCache:       daemonSetController.Cache(),
+		services:      kubernetes.CoreV1(),
+		deployments:   kubernetes.AppsV1(),
 	}
 
 	services.OnChange(ctx, "svccontroller", h.onChangeService)
@@ -74,6 +87,7 @@ func Register(ctx context.Context,
 }
 
 type handler struct {
+	image           string
 	rootless        bool
 	enabled         bool
 	nodeCache       coreclient.NodeCache
@@ -81,8 +95,9 @@ type handler struct {
 	deploymentCache appclient.DeploymentCache
 	processor       apply.Apply
 	serviceCache    coreclient.ServiceCache
+	svcController   coreclient.ServiceController
+	dsCache         appclient.DaemonSetCache
 	services        coregetter.ServicesGetter
-	daemonsets      v1getter.DaemonSetsGetter
 	deployments     v1getter.DeploymentsGetter
 }
 
@@ -128,6 +143,12 @@ func (h *handler) onChangeService(key string, svc *core.Service) (*core.Service,
 		return svc, nil
 	}
 
+	// leave services of other load balancers, such as MetalLB, alone, removing
+	// the daemonset if the class was changed
+	if class := svc.Annotations[classAnnotation]; class != "" && class != LoadBalancerClass {
+		return svc, h.processor.WithOwner(svc).Apply(objectset.NewObjectSet())
+	}
+
 	if err := h.deployPod(svc); err != nil {
 		return svc, err
 	}
@@ -137,21 +158,53 @@ func (h *handler) onChangeService(key string, svc *core.Service) (*core.Service,
 	return nil, err
 }
 
+// onChangeNode updates the daemonsets when the first node is labeled for
+// servicelb, or the label is removed from the last one. Pods on nodes whose
+// label changes are replaced by the daemonsets, which updates the services.
+// Services in pools are updated on any node change, so that nodes leaving
+// the pool are removed from them right away.
 func (h *handler) onChangeNode(key string, node *core.Node) (*core.Node, error) {
-	if node == nil {
-		return nil, nil
-	}
-	if _, ok := node.Labels[daemonsetNodeLabel]; !ok {
-		return node, nil
+	selected, err := h.nodesSelected()
+	if err != nil {
+		return node, err
 	}
 
-	if err := h.updateDaemonSets(); err != nil {
+	daemonsets, err := h.dsCache.List("", labels.SelectorFromSet(map[string]string{
+		nodeSelectorLabel: strconv.FormatBool(!selected),
+	}))
+	if err != nil {
+		return node, err
+	}
+	pooled, err := h.dsCache.List("", labels.SelectorFromSet(map[string]string{
+		nodeSelectorLabel: "pool",
+	}))
+	if err != nil {
 		return node, err
 	}
+	for _, ds := range append(daemonsets, pooled...) {
+		if svcName := ds.Spec.Template.Labels[svcNameLabel]; svcName != "" {
+			h.svcController.Enqueue(ds.Namespace, svcName)
+		}
+	}
 
 	return node, nil
 }
 
+// nodesSelected returns whether servicelb is limited to the nodes labeled
+// svccontroller.k3s.cattle.io/enablelb=true, which it is once any node has
+// the label.
+func (h *handler) nodesSelected() (bool, error) {
+	selector, err := labels.Parse(daemonsetNodeLabel)
+	if err != nil {
+		return false, err
+	}
+	nodes, err := h.nodeCache.List(selector)
+	if err != nil {
+		return false, err
+	}
+	return len(nodes) > 0, nil
+}
+
 func (h *handler) updateService(svc *core.Service) (runtime.Object, error) {
 	if !h.enabled {
 		return svc, nil
@@ -166,7 +219,7 @@ func (h *handler) updateService(svc *core.Service) (runtime.Object, error) {
 	}
 
 	existingIPs := serviceIPs(svc)
-	expectedIPs, err := h.podIPs(pods)
+	expectedIPs, err := h.podIPs(svc, pods)
 	if err != nil {
 		return svc, err
 	}
@@ -202,8 +255,15 @@ func serviceIPs(svc *core.Service) []string {
 	return ips
 }
 
-func (h *handler) podIPs(pods []*core.Pod) ([]string, error) {
+// podIPs returns the addresses of the nodes running ready servicelb pods,
+// except nodes no longer selected or in the pool of the service, whose pods
+// are yet to be removed.
+func (h *handler) podIPs(svc *core.Service, pods []*core.Pod) ([]string, error) {
 	ips := map[string]bool{}
+	selected, err := h.nodesSelected()
+	if err != nil {
+		return nil, err
+	}
 
 	for _, pod := range pods {
 		if pod.Spec.NodeName == "" || pod.Status.PodIP == "" {
@@ -219,6 +279,13 @@ func (h *handler) podIPs(pods []*core.Pod) ([]string, error) {
 		} else if err != nil {
 			return nil, err
 		}
+		if pool := svc.Annotations[poolLabel]; pool != "" {
+			if node.Labels[poolLabel] != pool {
+				continue
+			}
+		} else if selected && node.Labels[daemonsetNodeLabel] != "true" {
+			continue
+		}
 
 		for _, addr := range node.Status.Addresses {
 			if addr.Type == core.NodeInternalIP {
@@ -310,7 +377,7 @@ func (h *handler) newDaemonSet(svc *core.Service) (*apps.DaemonSet, error) {
 		portName := fmt.Sprintf("lb-port-%d", port.Port)
 		container := core.Container{
 			Name:            portName,
-			Image:           image,
+			Image:           h.image,
 			ImagePullPolicy: core.PullIfNotPresent,
 			Ports: []core.ContainerPort{
 				{
@@ -348,44 +415,26 @@ func (h *handler) newDaemonSet(svc *core.Service) (*apps.DaemonSet, error) {
 
 		ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, container)
 	}
-	// Add node selector only if label "svccontroller.k3s.cattle.io/enablelb" exists on the nodes
-	selector, err := labels.Parse(daemonsetNodeLabel)
-	if err != nil {
-		return nil, err
-	}
-	nodesWithLabel, err := h.nodeCache.List(selector)
-	if err != nil {
-		return nil, err
-	}
-	if len(nodesWithLabel) > 0 {
+	if pool := svc.Annotations[poolLabel]; pool != "" {
 		ds.Spec.Template.Spec.NodeSelector = map[string]string{
-			daemonsetNodeLabel: "true",
+			poolLabel: pool,
 		}
-		ds.Labels[nodeSelectorLabel] = "true"
+		ds.Labels[nodeSelectorLabel] = "pool"
+		return ds, nil
 	}
-	return ds, nil
-}
 
-func (h *handler) updateDaemonSets() error {
-	daemonsets, err := h.daemonsets.DaemonSets("").List(meta.ListOptions{
-		LabelSelector: nodeSelectorLabel + "=false",
-	})
+	// Add node selector only if label "svccontroller.k3s.cattle.io/enablelb" exists on the nodes
+	selected, err := h.nodesSelected()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	for _, ds := range daemonsets.Items {
+	if selected {
 		ds.Spec.Template.Spec.NodeSelector = map[string]string{
 			daemonsetNodeLabel: "true",
 		}
 		ds.Labels[nodeSelectorLabel] = "true"
-		if _, err := h.daemonsets.DaemonSets(ds.Namespace).Update(&ds); err != nil {
-			return err
-		}
-
 	}
-
-	return nil
+	return ds, nil
 }
 
 func (h *handler) deleteOldDeployments(svc *core.Service) error {
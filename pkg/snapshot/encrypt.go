@@ -0,0 +1,245 @@
+package snapshot
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptedSuffix is appended to the names of encrypted snapshots
+	encryptedSuffix = ".enc"
+	encryptedMagic  = "k3s-snapshot-encrypted-v1\n"
+	algorithm       = "AES-256-GCM"
+	chunkSize       = 1024 * 1024
+	// maxChunkSize bounds the chunk size read from the metadata, which is
+	// not authenticated
+	maxChunkSize = 64 * 1024 * 1024
+)
+
+// metadata describes how a snapshot is encrypted. It is stored as a line of
+// json after the magic at the start of encrypted snapshots.
+type metadata struct {
+	Algorithm      string `json:"algorithm"`
+	KeyFingerprint string `json:"keyFingerprint"`
+	Nonce          []byte `json:"nonce"`
+	ChunkSize      int    `json:"chunkSize"`
+}
+
+// ParseKey decodes a base64 encoded 32 byte encryption key.
+func ParseKey(key string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("snapshot encryption key must be 32 bytes encoded as base64")
+	}
+	return b, nil
+}
+
+// LoadKey returns the encryption key given directly or in keyFile, or nil if
+// snapshots are not encrypted.
+func LoadKey(key, keyFile string) ([]byte, error) {
+	if key != "" && keyFile != "" {
+		return nil, fmt.Errorf("only one of the snapshot encryption key and key file may be given")
+	}
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(b)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return ParseKey(key)
+}
+
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// encrypt writes source to target encrypted with key, in chunks sealed with
+// AES-GCM. The last chunk is marked so that truncation is detected.
+func encrypt(source, target string, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	md := metadata{
+		Algorithm:      algorithm,
+		KeyFingerprint: fingerprint(key),
+		Nonce:          make([]byte, gcm.NonceSize()),
+		ChunkSize:      chunkSize,
+	}
+	if _, err := rand.Read(md.Nonce); err != nil {
+		return err
+	}
+	header, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	w.WriteString(encryptedMagic)
+	w.Write(header)
+	w.WriteString("\n")
+
+	buf := make([]byte, chunkSize)
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(in, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		if _, err := w.Write(gcm.Seal(nil, chunkNonce(md.Nonce, i), buf[:n], chunkAD(final))); err != nil {
+			return err
+		}
+		if final {
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// decrypt reverses encrypt.
+func decrypt(source, target string, key []byte) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	md, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	if md == nil {
+		return fmt.Errorf("%s is not encrypted", source)
+	}
+	if md.Algorithm != algorithm {
+		return fmt.Errorf("%s is encrypted with unsupported algorithm %s", source, md.Algorithm)
+	}
+	if md.KeyFingerprint != fingerprint(key) {
+		return fmt.Errorf("%s is encrypted with key %s, not the given key %s", source, md.KeyFingerprint, fingerprint(key))
+	}
+	if md.ChunkSize <= 0 || md.ChunkSize > maxChunkSize {
+		return fmt.Errorf("%s has invalid chunk size %d", source, md.ChunkSize)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := make([]byte, md.ChunkSize+gcm.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(r, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		plain, err := gcm.Open(nil, chunkNonce(md.Nonce, i), buf[:n], chunkAD(final))
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt %s", source)
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			break
+		}
+	}
+	return out.Close()
+}
+
+// readMetadata returns the encryption metadata at the start of r, or nil if
+// the snapshot is not encrypted.
+func readMetadata(r *bufio.Reader) (*metadata, error) {
+	magic, err := r.Peek(len(encryptedMagic))
+	if err != nil || string(magic) != encryptedMagic {
+		return nil, nil
+	}
+	r.Discard(len(encryptedMagic))
+
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	md := &metadata{}
+	if err := json.Unmarshal(line, md); err != nil {
+		return nil, errors.Wrap(err, "invalid snapshot encryption metadata")
+	}
+	return md, nil
+}
+
+func isEncrypted(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	md, err := readMetadata(bufio.NewReader(f))
+	return md != nil, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of chunk i from the random base nonce.
+func chunkNonce(base []byte, i uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, i)
+	for j := range counter {
+		nonce[len(nonce)-8+j] ^= counter[j]
+	}
+	return nonce
+}
+
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,272 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 stores snapshots in an S3 compatible bucket, using path style requests
+// signed with AWS signature version 4.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Folder    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+
+	Client *http.Client
+}
+
+// Upload copies a local snapshot to the bucket.
+func (s *S3) Upload(ctx context.Context, snapshot *Snapshot) error {
+	f, err := os.Open(snapshot.Location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := s.request(ctx, http.MethodPut, s.key(snapshot.Name), nil, f, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = snapshot.Size
+	_, err = s.do(req)
+	return err
+}
+
+// List returns the snapshots in the bucket folder, oldest first.
+func (s *S3) List(ctx context.Context) ([]Snapshot, error) {
+	var (
+		snapshots []Snapshot
+		token     string
+	)
+	prefix := s.key("")
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", query, nil, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		result := struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}{}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, errors.Wrap(err, "invalid bucket listing")
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			snapshots = append(snapshots, Snapshot{
+				Name:     name,
+				Location: s.Location(name),
+				Size:     c.Size,
+				Created:  created(name, c.LastModified),
+			})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sortByCreated(snapshots)
+	return snapshots, nil
+}
+
+// Delete removes the named snapshots from the bucket.
+func (s *S3) Delete(ctx context.Context, names ...string) error {
+	for _, name := range names {
+		req, err := s.request(ctx, http.MethodDelete, s.key(name), nil, nil, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+		if _, err := s.do(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes all but the newest retention snapshots in the bucket folder
+// whose name starts with prefix, returning the names of those removed.
+func (s *S3) Prune(ctx context.Context, prefix string, retention int) ([]string, error) {
+	snapshots, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := expired(snapshots, prefix, retention)
+	return names, s.Delete(ctx, names...)
+}
+
+func (s *S3) key(name string) string {
+	folder := strings.Trim(s.Folder, "/")
+	if folder == "" {
+		return name
+	}
+	return folder + "/" + name
+}
+
+// Location returns the URL of a snapshot in the bucket.
+func (s *S3) Location(name string) string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.key(name))
+}
+
+func (s *S3) request(ctx context.Context, method, key string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	scheme := "https"
+	if s.Insecure {
+		scheme = "http"
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   s.Endpoint,
+		Path:   "/" + path.Join(s.Bucket, key),
+	}
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	s.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+func (s *S3) do(req *http.Request) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// sign adds an AWS signature version 4 authorization header to req.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query sorted by key with spaces as %20, as
+// signature version 4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// DefaultName prefixes the names of snapshots taken on demand.
+const DefaultName = "on-demand"
+
+// Snapshot is a copy of the datastore, stored locally or in S3.
+type Snapshot struct {
+	Name     string
+	Location string
+	Size     int64
+	Created  time.Time
+}
+
+// DBFile returns the embedded sqlite datastore of a server data dir.
+func DBFile(serverDataDir string) string {
+	return filepath.Join(serverDataDir, "db", "state.db")
+}
+
+// DefaultDir returns where snapshots of a server data dir are kept by default.
+func DefaultDir(serverDataDir string) string {
+	return filepath.Join(serverDataDir, "db", "snapshots")
+}
+
+// Save copies the datastore to dir as <name>-<unix time>. It uses the sqlite
+// online backup API, so it is safe to run while the server is up. If key is
+// set the snapshot is encrypted and named with an .enc suffix.
+func Save(dbFile, dir, name string, key []byte) (*Snapshot, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, errors.Wrap(err, "snapshots are only supported for the embedded sqlite datastore")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	snapshotName := fmt.Sprintf("%s-%d", name, now.Unix())
+	tmp := filepath.Join(dir, snapshotName+".tmp")
+	defer os.Remove(tmp)
+	if err := backup(dbFile, tmp); err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		snapshotName += encryptedSuffix
+		encrypted := filepath.Join(dir, snapshotName+".tmp")
+		defer os.Remove(encrypted)
+		if err := encrypt(tmp, encrypted, key); err != nil {
+			return nil, err
+		}
+		tmp = encrypted
+	}
+
+	target := filepath.Join(dir, snapshotName)
+	if err := os.Rename(tmp, target); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Name:     snapshotName,
+		Location: target,
+		Size:     info.Size(),
+		Created:  now,
+	}, nil
+}
+
+func backup(source, target string) error {
+	driver := &sqlite3.SQLiteDriver{}
+	src, err := driver.Open(source)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", source)
+	}
+	defer src.Close()
+
+	dst, err := driver.Open(target)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", target)
+	}
+	defer dst.Close()
+
+	b, err := dst.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return err
+	}
+	if _, err := b.Step(-1); err != nil {
+		b.Close()
+		return err
+	}
+	return b.Finish()
+}
+
+// List returns the snapshots in dir, oldest first.
+func List(dir string) ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".tmp") {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:     f.Name(),
+			Location: filepath.Join(dir, f.Name()),
+			Size:     f.Size(),
+			Created:  created(f.Name(), f.ModTime()),
+		})
+	}
+	sortByCreated(snapshots)
+	return snapshots, nil
+}
+
+// Delete removes the named snapshots from dir.
+func Delete(dir string, names ...string) error {
+	for _, name := range names {
+		if name != filepath.Base(name) {
+			return fmt.Errorf("invalid snapshot name %s", name)
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes all but the newest retention snapshots in dir whose name
+// starts with prefix, returning the names of those removed.
+func Prune(dir, prefix string, retention int) ([]string, error) {
+	snapshots, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := expired(snapshots, prefix, retention)
+	return names, Delete(dir, names...)
+}
+
+// expired returns the names of the snapshots beyond the newest retention
+// matching prefix. snapshots must be sorted oldest first.
+func expired(snapshots []Snapshot, prefix string, retention int) []string {
+	var matched []string
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.Name, prefix+"-") {
+			matched = append(matched, s.Name)
+		}
+	}
+	if len(matched) <= retention {
+		return nil
+	}
+	return matched[:len(matched)-retention]
+}
+
+// created returns the time encoded in a snapshot name, or def if there is none.
+func created(name string, def time.Time) time.Time {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return def
+	}
+	sec, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return def
+	}
+	return time.Unix(sec, 0)
+}
+
+func sortByCreated(snapshots []Snapshot) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Created.Equal(snapshots[j].Created) {
+			return snapshots[i].Name < snapshots[j].Name
+		}
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+}
+
+// Restore replaces the datastore with a snapshot, decrypting it with key if
+// it is encrypted. The current datastore is kept next to it as
+// <db>.pre-restore-<unix time>. The server must not be running.
+func Restore(snapshotFile, dbFile string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dbFile), 0700); err != nil {
+		return err
+	}
+
+	encrypted, err := isEncrypted(snapshotFile)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		if key == nil {
+			return fmt.Errorf("%s is encrypted, an encryption key is required to restore it", snapshotFile)
+		}
+		decrypted := dbFile + ".restore.tmp"
+		defer os.Remove(decrypted)
+		if err := decrypt(snapshotFile, decrypted, key); err != nil {
+			return err
+		}
+		snapshotFile = decrypted
+	}
+
+	header := make([]byte, 16)
+	f, err := os.Open(snapshotFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil || string(header) != "SQLite format 3\x00" {
+		return fmt.Errorf("%s is not a datastore snapshot", snapshotFile)
+	}
+
+	if _, err := os.Stat(dbFile); err == nil {
+		if err := backup(dbFile, fmt.Sprintf("%s.pre-restore-%d", dbFile, time.Now().Unix())); err != nil {
+			return errors.Wrap(err, "failed to keep the current datastore")
+		}
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(dbFile + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return backup(snapshotFile, dbFile)
+}
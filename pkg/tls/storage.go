@@ -2,10 +2,17 @@ package tls
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rancher/dynamiclistener"
 	v1 "github.com/rancher/k3s/pkg/apis/k3s.cattle.io/v1"
+	"github.com/rancher/k3s/pkg/daemons/control"
 	k3sclient "github.com/rancher/k3s/pkg/generated/controllers/k3s.cattle.io/v1"
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -15,11 +22,14 @@ const (
 	name = "tls-config"
 )
 
-func NewServer(ctx context.Context, listenerConfigs k3sclient.ListenerConfigController, config dynamiclistener.UserConfig) (dynamiclistener.ServerInterface, error) {
+// NewServer starts the dynamic listener. The stored certificate is replaced
+// when it is close to expiry, or on the first read if regenerate is set.
+func NewServer(ctx context.Context, listenerConfigs k3sclient.ListenerConfigController, config dynamiclistener.UserConfig, regenerate bool) (dynamiclistener.ServerInterface, error) {
 	storage := &listenerConfigStorage{
-		client: listenerConfigs,
-		cache:  listenerConfigs.Cache(),
-		config: config,
+		client:     listenerConfigs,
+		cache:      listenerConfigs.Cache(),
+		config:     config,
+		regenerate: regenerate,
 	}
 
 	server, err := dynamiclistener.NewServer(storage, config)
@@ -41,6 +51,9 @@ type listenerConfigStorage struct {
 	cache  k3sclient.ListenerConfigCache
 	client k3sclient.ListenerConfigClient
 	config dynamiclistener.UserConfig
+
+	lock       sync.Mutex
+	regenerate bool
 }
 
 func (l *listenerConfigStorage) Set(config *dynamiclistener.ListenerStatus) (*dynamiclistener.ListenerStatus, error) {
@@ -92,6 +105,7 @@ func (l *listenerConfigStorage) fromStorage(obj *v1.ListenerConfig) *dynamiclist
 
 	copy := obj.DeepCopy()
 	copy.Status.Revision = obj.ResourceVersion
+	l.dropExpiring(copy.Status.GeneratedCerts)
 
 	if l.config.CACerts != "" && l.config.CAKey != "" {
 		copy.Status.CACert = l.config.CACerts
@@ -100,3 +114,42 @@ func (l *listenerConfigStorage) fromStorage(obj *v1.ListenerConfig) *dynamiclist
 
 	return &copy.Status
 }
+
+// dropExpiring removes certificates that expire soon, so that the listener
+// generates new ones.
+func (l *listenerConfigStorage) dropExpiring(certs map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for cn, certString := range certs {
+		if l.regenerate {
+			logrus.Infof("Regenerating listener certificate for %s", cn)
+			delete(certs, cn)
+			continue
+		}
+		notAfter, err := certExpiry(certString)
+		if err != nil {
+			logrus.Warnf("Regenerating listener certificate for %s, it could not be parsed: %v", cn, err)
+			delete(certs, cn)
+		} else if time.Until(notAfter) < control.CertRenewBefore {
+			logrus.Infof("Regenerating listener certificate for %s, it expires %s", cn, notAfter.Format(time.RFC3339))
+			delete(certs, cn)
+		}
+	}
+	l.regenerate = false
+}
+
+// certExpiry returns the expiry of a certificate stored by the dynamic
+// listener as base64 DER and key separated by #.
+func certExpiry(certString string) (time.Time, error) {
+	parts := strings.SplitN(certString, "#", 2)
+	der, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,97 @@
+package token
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const fdPrefix = "fd:"
+
+// ReadFile reads a token from path, waiting for the file to be created. A path of
+// the form fd:N reads the token from the inherited file descriptor N instead, so
+// that it never has to be stored on disk.
+func ReadFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	if strings.HasPrefix(path, fdPrefix) {
+		fd, err := strconv.Atoi(strings.TrimPrefix(path, fdPrefix))
+		if err != nil {
+			return "", fmt.Errorf("invalid token file descriptor %q", path)
+		}
+		f := os.NewFile(uintptr(fd), path)
+		defer f.Close()
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return "", err
+		}
+		return trim(content), nil
+	}
+
+	for {
+		content, err := ioutil.ReadFile(path)
+		if err == nil {
+			return trim(content), nil
+		} else if os.IsNotExist(err) {
+			logrus.Infof("Waiting for %s to be available\n", path)
+			time.Sleep(2 * time.Second)
+		} else {
+			return "", err
+		}
+	}
+}
+
+// ReadCredential returns the contents of the systemd credential with the given
+// name, as passed with LoadCredential= in the unit, or an empty string if the
+// credential was not passed to this process.
+func ReadCredential(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return trim(content), nil
+}
+
+// LoadCredentials sets each empty value to the contents of the systemd credential
+// with the matching name, if one was passed to this process.
+func LoadCredentials(values map[string]*string) error {
+	for name, value := range values {
+		if *value != "" {
+			continue
+		}
+		content, err := ReadCredential(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read credential %s", name)
+		}
+		*value = content
+	}
+	return nil
+}
+
+// Zero overwrites b so that secrets do not linger in memory after use.
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func trim(content []byte) string {
+	defer Zero(content)
+	return string(bytes.TrimSpace(content))
+}
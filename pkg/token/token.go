@@ -0,0 +1,125 @@
+package token
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	Namespace = "kube-system"
+
+	// SecretType marks Secrets holding join tokens minted by the supervisor
+	SecretType = v1.SecretType("k3s.cattle.io/join-token")
+
+	secretPrefix   = "k3s-join-token-"
+	idPrefix       = "join-"
+	keySecret      = "token-secret"
+	keyExpiration  = "expiration"
+	keyDescription = "description"
+)
+
+// Random returns a hex encoded string built from size random bytes.
+func Random(size int) (string, error) {
+	token := make([]byte, size, size)
+	_, err := cryptorand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), err
+}
+
+// JoinToken is a credential that can be exchanged for node access until it expires.
+type JoinToken struct {
+	ID          string
+	Secret      string
+	Description string
+	Expires     time.Time
+}
+
+// NewJoinToken generates a new join token valid for ttl, which must be positive.
+func NewJoinToken(ttl time.Duration, description string) (*JoinToken, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("join token ttl must be positive, got %s", ttl)
+	}
+	id, err := Random(3)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := Random(8)
+	if err != nil {
+		return nil, err
+	}
+	return &JoinToken{
+		ID:          idPrefix + id,
+		Secret:      secret,
+		Description: description,
+		Expires:     time.Now().Add(ttl).UTC(),
+	}, nil
+}
+
+// IsJoinTokenID returns true if the given username could belong to a join token.
+func IsJoinTokenID(id string) bool {
+	return strings.HasPrefix(id, idPrefix)
+}
+
+// SecretName returns the name of the Secret storing the join token with the given ID.
+func SecretName(id string) string {
+	return secretPrefix + id
+}
+
+// String returns the credentials portion of the token, without the K10 prefix or CA hash.
+func (t *JoinToken) String() string {
+	return t.ID + ":" + t.Secret
+}
+
+// Expired returns true if the expiration time of the token has passed. Tokens
+// without an expiration time are no longer accepted and count as expired.
+func (t *JoinToken) Expired() bool {
+	return t.Expires.IsZero() || time.Now().After(t.Expires)
+}
+
+// ToSecret converts the join token into a Secret for storage in the cluster.
+func (t *JoinToken) ToSecret() *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SecretName(t.ID),
+			Namespace: Namespace,
+		},
+		Type: SecretType,
+		StringData: map[string]string{
+			keySecret:      t.Secret,
+			keyDescription: t.Description,
+			keyExpiration:  t.Expires.Format(time.RFC3339),
+		},
+	}
+}
+
+// FromSecret converts a stored Secret back into a join token.
+func FromSecret(secret *v1.Secret) (*JoinToken, error) {
+	if secret.Type != SecretType || !strings.HasPrefix(secret.Name, secretPrefix) {
+		return nil, fmt.Errorf("secret %s/%s is not a join token", secret.Namespace, secret.Name)
+	}
+
+	t := &JoinToken{
+		ID:          strings.TrimPrefix(secret.Name, secretPrefix),
+		Secret:      string(secret.Data[keySecret]),
+		Description: string(secret.Data[keyDescription]),
+	}
+	if expiration := string(secret.Data[keyExpiration]); expiration != "" {
+		expires, err := time.Parse(time.RFC3339, expiration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiration for join token %s: %v", t.ID, err)
+		}
+		t.Expires = expires
+	}
+	if t.Secret == "" {
+		return nil, fmt.Errorf("join token %s has no secret", t.ID)
+	}
+	return t, nil
+}
@@ -0,0 +1,12 @@
+#!/bin/bash
+set -e -x
+
+cd $(dirname $0)
+
+ARCH=$(go env GOARCH)
+
+# Run on a node that has imported the airgap images for $ARCH
+k3s ctr -n k8s.io images ls \
+    | awk 'NR > 1 { print $1 "@" $3 }' \
+    | grep -F -f image-list.txt \
+    | tee image-digests-${ARCH}.txt